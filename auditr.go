@@ -1,10 +1,12 @@
 package agent
 
 import (
-	"log"
+	"context"
 	"os"
 	"strings"
 
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/lambda"
 )
 
@@ -14,13 +16,19 @@ var agentInstance *lambda.Agent
 // and record events
 func Audit(handler interface{}) interface{} {
 	if agentInstance == nil {
-		log.Println("auditr.go: agentInstance is nil")
+		config.Warnf("auditr.go: agentInstance is nil")
 		return handler
 	}
 
 	return agentInstance.Wrap(handler)
 }
 
+// WithMetadata returns a copy of the context carrying app specific
+// metadata to attach to the event of the request
+func WithMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return collect.WithMetadata(ctx, metadata)
+}
+
 // init initializes the auditr agent
 func init() {
 	if strings.HasSuffix(os.Args[0], ".test") {
@@ -115,6 +115,8 @@ func TestAudit(t *testing.T) {
 
 		expectedResponse := collect.Response{
 			StatusCode: 200,
+			Sink:       collect.SinkHTTP,
+			Sequence:   1,
 		}
 		assert.Equal(t, expectedResponse, res)
 	}()
@@ -0,0 +1,98 @@
+package collect
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minBatchAgeCheckInterval is the min interval between checks of the
+// age of the oldest pending event
+const minBatchAgeCheckInterval time.Duration = time.Millisecond
+
+// pendingBatches tracks when the oldest event of each batch that hasn't
+// fired yet was enqueued
+type pendingBatches struct {
+	lock    sync.Mutex
+	batches map[*batchList]time.Time
+}
+
+// newPendingBatches creates a new set of pending batches
+func newPendingBatches() *pendingBatches {
+	return &pendingBatches{
+		batches: map[*batchList]time.Time{},
+	}
+}
+
+// add tracks the batch by the enqueue time of its first event
+func (p *pendingBatches) add(b *batchList, enqueuedAt time.Time) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.batches[b]; !ok {
+		p.batches[b] = enqueuedAt
+	}
+}
+
+// remove stops tracking the batch once it fires
+func (p *pendingBatches) remove(b *batchList) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.batches, b)
+}
+
+// oldest returns the enqueue time of the oldest pending event.
+// Returns false if no events are pending.
+func (p *pendingBatches) oldest() (time.Time, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var oldest time.Time
+	for _, enqueuedAt := range p.batches {
+		if oldest.IsZero() || enqueuedAt.Before(oldest) {
+			oldest = enqueuedAt
+		}
+	}
+
+	return oldest, !oldest.IsZero()
+}
+
+// watchBatchAge flushes pending batches once their oldest event is older
+// than the max batch age, regardless of how many events were added since.
+// Stops once the max batch age is disabled.
+func (p *EventPublisher) watchBatchAge() {
+	if !atomic.CompareAndSwapInt32(&p.watchingBatchAge, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&p.watchingBatchAge, 0)
+
+		for {
+			maxAge := p.configuration.MaxBatchAge
+			if maxAge <= 0 {
+				return
+			}
+
+			interval := maxAge / 4
+			if interval < minBatchAgeCheckInterval {
+				interval = minBatchAgeCheckInterval
+			}
+			time.Sleep(interval)
+
+			oldest, ok := p.pending.oldest()
+			if ok && time.Since(oldest) >= maxAge {
+				p.Flush()
+			}
+		}
+	}()
+}
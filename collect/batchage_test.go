@@ -0,0 +1,94 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingBatches_TracksOldestEvent(t *testing.T) {
+	p := newPendingBatches()
+	_, ok := p.oldest()
+	assert.False(t, ok)
+
+	now := time.Now()
+	b1 := &batchList{}
+	b2 := &batchList{}
+	p.add(b1, now)
+	p.add(b2, now.Add(-time.Second))
+
+	// Later events don't make the batch younger
+	p.add(b2, now)
+
+	oldest, ok := p.oldest()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(-time.Second), oldest)
+
+	p.remove(b2)
+	oldest, ok = p.oldest()
+	assert.True(t, ok)
+	assert.Equal(t, now, oldest)
+
+	p.remove(b1)
+	_, ok = p.oldest()
+	assert.False(t, ok)
+}
+
+func TestPublisher_FlushesBatchByMaxAge(t *testing.T) {
+	sent := make(chan struct{}, 1)
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			sent <- struct{}{}
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+			}, nil
+		},
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 100,
+				"send_interval": 60000,
+				"block_on_send": false,
+				"block_on_response": true,
+				"max_batch_age": 50
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, configurer.Refresh(context.Background()))
+
+	p, err := NewEventPublisher(configurer.Configuration, []EventBuilder{})
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, configurer.Configuration.MaxBatchAge)
+
+	p.Add(&EventRaw{})
+
+	// Sent well before the send interval
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "batch wasn't flushed by max age")
+	}
+}
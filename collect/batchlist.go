@@ -6,10 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/auditr-io/auditr-agent-go/config"
@@ -17,22 +16,43 @@ import (
 )
 
 const (
-	// max bytes allowed per event
-	maxEventBytes int = 25000 // 25kb
-
-	// max bytes allowed per batch
-	maxBatchBytes int = 5000000 - 2 - 199 // 5MB
-
 	// number of batches to hold events exceeding maxBatchBytes
 	// Overflow exceeding this will not be processed.
 	maxOverflowBatches int = 10
+
+	// DefaultDeliveryTimeout is the max duration to deliver a batch
+	DefaultDeliveryTimeout time.Duration = 30 * time.Second
 )
 
+// ErrEventExpired is returned for events that were queued longer than
+// the max event age
+var ErrEventExpired = errors.New("Event exceeds max event age")
+
 // Response is the result of processing an event
 type Response struct {
 	Err        error
 	StatusCode int
 	Body       []byte
+
+	// Sink is the name of the sink the event was sent to, e.g. SinkHTTP.
+	// Empty if the event never reached a sink, e.g. when dropped.
+	Sink string
+
+	// Sequence and RequestID identify the event the response is for.
+	// Zero if the event couldn't be built.
+	Sequence  uint64
+	RequestID string
+}
+
+// forEvent returns a copy of the response identifying the event
+func (r Response) forEvent(event *EventRaw) Response {
+	if event == nil {
+		return r
+	}
+
+	r.Sequence = event.Sequence
+	r.RequestID = event.RequestID
+	return r
 }
 
 // UnmarshalJSON deserializes response from processing an event
@@ -61,17 +81,46 @@ type batchList struct {
 	maxEventsPerBatch    uint
 	maxConcurrentBatches uint
 
-	// max bytes allowed per batch
-	// maxBatchBytes int
+	// max bytes allowed per event and per batch
+	maxEventBytes int
+	maxBatchBytes int
 
 	// batches of events
 	batches map[int][]*EventRaw
 
 	// holds batches exceeding maxBatchSize
 	overflowBatches map[int][]*EventRaw
+	overflowLock    sync.Mutex
 
 	responses chan Response
 	client    *http.Client
+	stats     *statsAggregator
+	breaker   *circuitBreaker
+	encoder   EventEncoder
+	bus       *eventBus
+	pending   *pendingBatches
+
+	responseDecoder ResponseDecoder
+
+	// log logs the messages of the batches
+	log logger
+
+	// sinks receive every batch of events. The first is the event sink,
+	// the events API or stdout as configured.
+	sinks []Sink
+
+	// eventsURL is the events endpoint when the batch list was created,
+	// so its batches are sent to the same endpoint across refreshes
+	eventsURL string
+}
+
+// deliveryTimeout returns the max duration to deliver a batch
+func (b *batchList) deliveryTimeout() time.Duration {
+	if b.configuration.DeliveryTimeout > 0 {
+		return b.configuration.DeliveryTimeout
+	}
+
+	return DefaultDeliveryTimeout
 }
 
 // newBatchList creates a new batch list
@@ -81,20 +130,45 @@ func newBatchList(
 	maxEventsPerBatch uint,
 	maxConcurrentBatches uint,
 ) *batchList {
+	// Zero values are an easy misconfiguration and would
+	// otherwise panic when picking a batch
+	if maxEventsPerBatch == 0 {
+		maxEventsPerBatch = 1
+	}
+
+	if maxConcurrentBatches == 0 {
+		maxConcurrentBatches = 1
+	}
+
 	b := &batchList{
 		configuration:        configuration,
+		eventsURL:            configuration.EventsURL,
 		client:               configuration.GetEventsClient(),
 		batches:              map[int][]*EventRaw{},
 		overflowBatches:      map[int][]*EventRaw{},
 		responses:            responses,
 		maxEventsPerBatch:    maxEventsPerBatch,
 		maxConcurrentBatches: maxConcurrentBatches,
+		maxEventBytes:        configuration.MaxEventBytes,
+		maxBatchBytes:        configuration.MaxBatchBytes,
+		encoder:              newEventEncoder(configuration.EventEncoding),
+		responseDecoder:      arrayResponseDecoder{},
 	}
 
-	// b.maxBatchBytes = int(maxEventsPerBatch) * maxEventBytes
-	// if b.maxBatchBytes < maxEventBytes+2 {
-	// 	b.maxBatchBytes = maxEventBytes + 2
-	// }
+	if b.maxEventBytes <= 0 {
+		b.maxEventBytes = config.DefaultMaxEventBytes
+	}
+
+	if b.maxBatchBytes <= 0 {
+		b.maxBatchBytes = config.DefaultMaxBatchBytes
+	}
+
+	if b.maxEventBytes > b.maxBatchBytes {
+		// An event that fits no batch would overflow forever
+		b.maxEventBytes = b.maxBatchBytes
+	}
+
+	b.sinks = []Sink{b.eventSink()}
 
 	return b
 }
@@ -102,6 +176,8 @@ func newBatchList(
 // Add adds an event to a batch
 func (b *batchList) Add(event interface{}) {
 	e := event.(*EventRaw)
+	b.pending.add(b, e.enqueuedAt)
+
 	batchID := b.getBatchID()
 	b.batches[batchID] = append(b.batches[batchID], e)
 }
@@ -109,43 +185,67 @@ func (b *batchList) Add(event interface{}) {
 // Fire informs muster the batch is done
 func (b *batchList) Fire(notifier muster.Notifier) {
 	defer notifier.Done()
+	b.pending.remove(b)
 
 	for _, events := range b.batches {
 		b.send(events)
 	}
 
-	// Batches exceeding maxBatchBytes will overflow. Process
+	// Batches exceeding the max batch bytes will overflow. Process
 	// overflow batches until complete.
 	overflowProcessed := 0
-	for len(b.overflowBatches) > 0 {
+	for {
 		if overflowProcessed > maxOverflowBatches {
 			// Should never happen because once the batch is processing
 			// the overflows dwindle and you can't add more to the batch.
 			break
 		}
 
+		// Take the current snapshot of overflow batches. Sending them may
+		// overflow again, which we'll process in the next round.
+		batches := b.takeOverflowBatches()
+		if len(batches) == 0 {
+			break
+		}
+
 		overflowProcessed++
+		b.sendConcurrently(batches)
+	}
+}
 
-		// Get the current snapshot of batch IDs in overflow. This could change
-		// as we process the overflow batches.
-		batchIDs := make([]int, len(b.overflowBatches))
-		i := 0
-		for batchID := range b.overflowBatches {
-			batchIDs[i] = batchID
-			i++
-		}
+// takeOverflowBatches removes and returns the overflow batches
+func (b *batchList) takeOverflowBatches() [][]*EventRaw {
+	b.overflowLock.Lock()
+	defer b.overflowLock.Unlock()
+
+	batches := make([][]*EventRaw, 0, len(b.overflowBatches))
+	for batchID, events := range b.overflowBatches {
+		batches = append(batches, events)
+		delete(b.overflowBatches, batchID)
+	}
 
-		// Send the overflow batch.
-		for _, batchID := range batchIDs {
-			events := b.overflowBatches[batchID]
+	return batches
+}
+
+// sendConcurrently sends the batches with up to maxConcurrentBatches
+// sends in flight and waits for all of them to complete
+func (b *batchList) sendConcurrently(batches [][]*EventRaw) {
+	inFlight := make(chan struct{}, b.maxConcurrentBatches)
+	var wg sync.WaitGroup
+	for _, events := range batches {
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(events []*EventRaw) {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
 
-			// Remove the current overflow batch from the list before sending
-			// so if there are more overflow events with the same batch ID as
-			// a result of this send, we'll process them in the next round.
-			delete(b.overflowBatches, batchID)
 			b.send(events)
-		}
+		}(events)
 	}
+
+	wg.Wait()
 }
 
 // getBatchID determines the batchID given an item ID
@@ -168,14 +268,29 @@ func (b *batchList) getOverflowBatchID() int {
 func (b *batchList) enqueueResponseForEvents(res Response, events []*EventRaw) {
 	for _, event := range events {
 		if event != nil {
-			b.enqueueResponse(res)
+			b.enqueueResponse(res, event)
 		}
 	}
 }
 
-// enqueueResponse writes the response to the response channel
-func (b *batchList) enqueueResponse(res Response) {
-	if writeToChannel(b.responses, res, b.configuration.BlockOnResponse) {
+// enqueueResponse writes the response for the event to the response channel.
+// The event's response full policy determines whether to block or drop
+// when the channel is full.
+func (b *batchList) enqueueResponse(res Response, event *EventRaw) {
+	block := b.configuration.BlockOnResponse
+	if event != nil {
+		block = event.responseFullPolicy.Blocks(block)
+	}
+
+	res = res.forEvent(event)
+
+	b.bus.publishResponse(res)
+	if b.configuration.DiscardOldestResponses {
+		b.stats.responsesDiscarded(writeDiscardingOldest(b.responses, res))
+		return
+	}
+
+	if writeToChannel(b.responses, res, block) {
 		// no-op
 	}
 }
@@ -199,8 +314,32 @@ func writeToChannel(responses chan Response, res Response, block bool) bool {
 	return false
 }
 
+// writeDiscardingOldest writes a response to a given channel. If the
+// channel is full, the oldest responses are discarded to make room.
+// Returns the number of responses discarded.
+func writeDiscardingOldest(responses chan Response, res Response) int {
+	discarded := 0
+	for {
+		select {
+		case responses <- res:
+			return discarded
+		default:
+		}
+
+		select {
+		case <-responses:
+			discarded++
+		default:
+			// A reader made room
+		}
+	}
+}
+
 // reenqueue reenqueues events for processing
 func (b *batchList) reenqueue(events []*EventRaw) {
+	b.overflowLock.Lock()
+	defer b.overflowLock.Unlock()
+
 	for _, e := range events {
 		batchID := b.getOverflowBatchID()
 		b.overflowBatches[batchID] = append(b.overflowBatches[batchID], e)
@@ -211,144 +350,149 @@ type httpError interface {
 	Timeout() bool
 }
 
-// send sends a batch of events to the target URL
+// send encodes a batch of events and writes the events that passed
+// encoding to every sink
 func (b *batchList) send(events []*EventRaw) {
 	if len(events) == 0 {
 		// should never happen, but just in case
 		return
 	}
 
-	eventsJSON, numEncoded := b.encodeJSON(events)
+	payload, numEncoded := b.encode(events)
+	defer putBuffer(payload)
 	if numEncoded == 0 {
 		// nothing encoded
 		return
 	}
 
-	ctx := context.Background()
-	method := http.MethodPost
-	var req *http.Request
-	var res *http.Response
-	var err error
-
-	// retry once in case of timeouts
-	for n := 0; n < 2; n++ {
-		eventsReader := ioutil.NopCloser(bytes.NewReader(eventsJSON))
-
-		req, err = http.NewRequestWithContext(
-			ctx,
-			method,
-			b.configuration.EventsURL,
-			eventsReader,
-		)
-		if err != nil {
-			b.enqueueResponseForEvents(Response{Err: err}, events)
-			return
+	encoded := make([]*EventRaw, 0, numEncoded)
+	for _, e := range events {
+		if e != nil {
+			encoded = append(encoded, e)
 		}
+	}
 
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", fmt.Sprintf("auditr-agent-go/%s", version))
+	for i, sink := range b.sinks {
+		// The first sink is the event sink, whose deliveries are
+		// tracked by the stats
+		b.write(sink, i == 0, payload.Bytes(), encoded)
+	}
+}
 
-		res, err = b.client.Do(req)
-		if err != nil {
-			log.Printf("Retrying due to error posting: %+v", err)
-			continue
+// write writes the batch of events to the sink, enqueueing a response
+// per event tagged with the sink's name
+func (b *batchList) write(
+	sink Sink,
+	eventSink bool,
+	payload []byte,
+	events []*EventRaw,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.deliveryTimeout())
+	defer cancel()
+
+	if s, ok := sink.(batchSink); ok {
+		for i, res := range s.writeBatch(ctx, payload, events) {
+			var event *EventRaw
+			if i < len(events) {
+				event = events[i]
+			}
+
+			res.Sink = sink.Name()
+			b.enqueueResponse(res, event)
 		}
 
-		break
-	}
-
-	if err != nil {
-		b.enqueueResponseForEvents(Response{Err: err}, events)
 		return
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		errRes := Response{
-			Err: fmt.Errorf(
-				"Error sending %s %s: status %d",
-				method,
-				b.configuration.EventsURL,
-				res.StatusCode,
-			),
-			StatusCode: res.StatusCode,
-		}
 
-		if res.StatusCode == http.StatusBadRequest {
-			log.Printf("eventsJSON: %s", string(eventsJSON))
+	res := Response{
+		StatusCode: http.StatusOK,
+		Sink:       sink.Name(),
+	}
+	if err := sink.Write(ctx, events); err != nil {
+		res = Response{
+			Err:  err,
+			Sink: sink.Name(),
 		}
+	}
 
-		// todo: retry on 5xx
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			errRes.Err = err
+	if eventSink {
+		if res.Err != nil {
+			b.stats.batchFailed()
 		} else {
-			errRes.Body = body
+			b.stats.batchSent(len(events), len(payload), b.maxEventsPerBatch)
 		}
-
-		b.enqueueResponseForEvents(errRes, events)
-		return
 	}
 
-	var batchResponses []Response
-	err = json.NewDecoder(res.Body).Decode(&batchResponses)
-	if err != nil {
-		b.enqueueResponseForEvents(Response{Err: err}, events)
-		return
-	}
-
-	// i := 0
-	for _, eventRes := range batchResponses {
-		// Find index of matching event for this response
-		// for i < len(events) && events[i] == nil {
-		// 	i++
-		// }
-
-		// if i >= len(events) {
-		// 	break
-		// }
-
-		b.enqueueResponse(eventRes)
-		// i++
-	}
+	b.enqueueResponseForEvents(res, events)
 }
 
-// encodeJSON encodes a batch of events to JSON
-func (b *batchList) encodeJSON(events []*EventRaw) ([]byte, int) {
-	buf := bytes.Buffer{}
-	buf.WriteByte('[')
+// encode encodes a batch of events with the configured encoder.
+// The returned buffer must be returned to the pool with putBuffer.
+func (b *batchList) encode(events []*EventRaw) (*bytes.Buffer, int) {
+	encoded := getBuffer()
+	defer putBuffer(encoded)
+	batch := newEventBatch(b.encoder, encoded)
+	defer batch.release()
+
 	numEncoded := 0
+	// account for the batch envelope
+	numBytes := 2
 	for i, e := range events {
-		if i > 0 {
-			buf.WriteByte(',')
+		if b.isExpired(e) {
+			// Stale events are worse than dropped ones for some use cases
+			b.stats.eventExpired()
+			b.enqueueResponse(Response{
+				Err: ErrEventExpired,
+			}, e)
+			events[i] = nil
+			continue
 		}
 
-		payload, err := json.Marshal(e)
+		mark := encoded.Len()
+		size, err := batch.encode(e)
 		if err != nil {
 			b.enqueueResponse(Response{
 				Err: err,
-			})
+			}, e)
 			events[i] = nil
 			continue
 		}
 
-		if len(payload) > maxEventBytes {
+		if size > b.maxEventBytes {
+			encoded.Truncate(mark)
+			b.stats.eventOversized()
 			b.enqueueResponse(Response{
-				Err: fmt.Errorf("Event exceeds max size of %d bytes", maxEventBytes),
-			})
+				Err: fmt.Errorf("Event exceeds max size of %d bytes", b.maxEventBytes),
+			}, e)
 			events[i] = nil
 			continue
 		}
-		numBytes := buf.Len() + len(payload) + 1
-		if numBytes > maxBatchBytes {
+
+		if numBytes+size+1 > b.maxBatchBytes {
+			encoded.Truncate(mark)
+			b.stats.eventsOverflowed(len(events) - i)
 			b.reenqueue(events[i:])
+			for j := i; j < len(events); j++ {
+				events[j] = nil
+			}
 			break
 		}
 
-		buf.Write(payload)
+		numBytes += size + 1
 		numEncoded++
 	}
 
-	buf.WriteByte(']')
-	return buf.Bytes(), numEncoded
+	payload := getBuffer()
+	b.encoder.Batch(payload, encoded.Bytes(), numEncoded)
+
+	return payload, numEncoded
+}
+
+// isExpired returns true if the event was queued longer than the max event age
+func (b *batchList) isExpired(event *EventRaw) bool {
+	if b.configuration.MaxEventAge <= 0 || event.enqueuedAt.IsZero() {
+		return false
+	}
+
+	return time.Since(event.enqueuedAt) > b.configuration.MaxEventAge
 }
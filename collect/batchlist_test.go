@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/test"
@@ -264,6 +266,7 @@ func TestBatchListFire_ProcessesOverflow(t *testing.T) {
 		Request: "",
 	}
 	payloadExclReqContent, _ := json.Marshal(event)
+	maxEventBytes := configurer.Configuration.MaxEventBytes
 	// This will cause the batch to overflow
 	event.Request = randomString(maxEventBytes - len(payloadExclReqContent) + 1)
 
@@ -274,7 +277,7 @@ func TestBatchListFire_ProcessesOverflow(t *testing.T) {
 		uint(maxEventsPerBatch),
 		uint(maxConcurrentBatches),
 	)
-	l := int(maxBatchBytes / maxEventBytes)
+	l := int(configurer.Configuration.MaxBatchBytes / maxEventBytes)
 	for i := 0; i <= l; i++ {
 		b.Add(event)
 	}
@@ -285,6 +288,65 @@ func TestBatchListFire_ProcessesOverflow(t *testing.T) {
 	assert.True(t, n.AssertExpectations(t))
 }
 
+func TestBatchListFire_SendsOverflowConcurrently(t *testing.T) {
+	var lock sync.Mutex
+	inFlight := 0
+	maxInFlight := 0
+	sent := 0
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			lock.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			lock.Lock()
+			inFlight--
+			sent++
+			lock.Unlock()
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	configuration := &config.Configuration{
+		EventsURL: "https://dev-api.auditr.io/v1/events",
+		GetEventsClient: func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		},
+	}
+
+	maxConcurrentBatches := 2
+	b := newBatchList(
+		configuration,
+		make(chan Response, 10),
+		10,
+		uint(maxConcurrentBatches),
+	)
+	for batchID := 0; batchID < 5; batchID++ {
+		b.overflowBatches[batchID] = []*EventRaw{{}}
+	}
+
+	n := &notifier{}
+	n.On("Done").Once()
+
+	b.Fire(n)
+
+	assert.Equal(t, 5, sent)
+	assert.Equal(t, maxConcurrentBatches, maxInFlight)
+	assert.Empty(t, b.overflowBatches)
+	assert.True(t, n.AssertExpectations(t))
+}
+
 func TestSend(t *testing.T) {
 	m := &test.MockTransport{
 		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
@@ -351,6 +413,52 @@ func TestSend(t *testing.T) {
 	assert.True(t, m.AssertExpectations(t))
 }
 
+func TestSend_KeepsEventsURLAcrossRefreshes(t *testing.T) {
+	var sentTo string
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			sentTo = req.URL.String()
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(""))),
+			}, nil
+		},
+	}
+
+	configurer, _ := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": []
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+
+	configurer.Refresh(context.Background())
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		configurer.Configuration,
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+
+	// A refresh changes the events endpoint while the batch is pending
+	configurer.Configuration.EventsURL = "https://other-api.auditr.io/v1/events"
+
+	b.send([]*EventRaw{{}})
+	assert.Equal(t, "https://dev-api.auditr.io/v1/events", sentTo)
+}
+
 func TestSend_GetResponseOnError(t *testing.T) {
 	expectedErr := fmt.Errorf("random error")
 
@@ -405,9 +513,10 @@ func TestSend_GetResponseOnError(t *testing.T) {
 	expectedErrRes := Response{
 		Err: &url.Error{
 			Op:  "Post",
-			URL: config.EventsURL,
+			URL: configurer.Configuration.EventsURL,
 			Err: expectedErr,
 		},
+		Sink: SinkHTTP,
 	}
 
 	r := make(chan Response, DefaultPendingWorkCapacity*2)
@@ -432,6 +541,64 @@ func TestSend_GetResponseOnError(t *testing.T) {
 	wg.Wait()
 }
 
+func TestSend_BoundsDeliveryByTimeout(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			// Hang until the send gives up
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		},
+	}
+
+	configurer, _ := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": true,
+				"delivery_timeout": 50
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+
+	configurer.Refresh(context.Background())
+	assert.Equal(t, 50*time.Millisecond, configurer.Configuration.DeliveryTimeout)
+
+	events := make([]*EventRaw, 3)
+	for i := 0; i < len(events); i++ {
+		events[i] = &EventRaw{}
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		configurer.Configuration,
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+
+	start := time.Now()
+	b.send(events)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	res := <-r
+	assert.True(t, errors.Is(res.Err, context.DeadlineExceeded))
+}
+
 func TestSend_GetResponseOnNotOK(t *testing.T) {
 	expectedEventStatusCode := 400
 	expectedEventBody := []byte(`[
@@ -498,11 +665,12 @@ func TestSend_GetResponseOnNotOK(t *testing.T) {
 		Err: fmt.Errorf(
 			"Error sending %s %s: status %d",
 			http.MethodPost,
-			config.EventsURL,
+			configurer.Configuration.EventsURL,
 			expectedEventStatusCode,
 		),
 		StatusCode: expectedEventStatusCode,
 		Body:       expectedEventBody,
+		Sink:       SinkHTTP,
 	}
 
 	r := make(chan Response, DefaultPendingWorkCapacity*2)
@@ -566,11 +734,11 @@ func TestEncodeJSON(t *testing.T) {
 		DefaultMaxEventsPerBatch,
 		DefaultMaxConcurrentBatches,
 	)
-	eventsJSON, numEncoded := b.encodeJSON(events)
+	eventsJSON, numEncoded := b.encode(events)
 	assert.Equal(t, len(events), numEncoded)
 
 	expectedJSON, _ := json.Marshal(events)
-	assert.Equal(t, expectedJSON, eventsJSON)
+	assert.Equal(t, expectedJSON, eventsJSON.Bytes())
 }
 
 func TestEncodeJSON_FailsOnInvalidEvent(t *testing.T) {
@@ -628,7 +796,7 @@ func TestEncodeJSON_FailsOnInvalidEvent(t *testing.T) {
 		DefaultMaxEventsPerBatch,
 		DefaultMaxConcurrentBatches,
 	)
-	b.encodeJSON(events)
+	b.encode(events)
 
 	wg.Wait()
 }
@@ -639,6 +807,7 @@ func TestEncodeJSON_FailsOnOversizedEvent(t *testing.T) {
 		Request: "",
 	}
 	payloadExclReqContent, _ := json.Marshal(event)
+	maxEventBytes := config.DefaultMaxEventBytes
 	event.Request = randomString(maxEventBytes - len(payloadExclReqContent) + 1)
 
 	events := []*EventRaw{
@@ -687,7 +856,7 @@ func TestEncodeJSON_FailsOnOversizedEvent(t *testing.T) {
 		DefaultMaxEventsPerBatch,
 		DefaultMaxConcurrentBatches,
 	)
-	b.encodeJSON(events)
+	b.encode(events)
 
 	wg.Wait()
 }
@@ -697,6 +866,7 @@ func TestEncodeJSON_ReenqueuesOnOversizedBatch(t *testing.T) {
 		Request: "",
 	}
 	payloadExclReqContent, _ := json.Marshal(event)
+	maxEventBytes := config.DefaultMaxEventBytes
 	event.Request = randomString(maxEventBytes - len(payloadExclReqContent) + 1)
 
 	maxEventsPerBatch := 10
@@ -736,12 +906,13 @@ func TestEncodeJSON_ReenqueuesOnOversizedBatch(t *testing.T) {
 		uint(maxConcurrentBatches),
 	)
 
-	l := int(maxBatchBytes / maxEventBytes)
+	assert.Equal(t, maxEventBytes, b.maxEventBytes)
+	l := int(b.maxBatchBytes / maxEventBytes)
 	events := make([]*EventRaw, l+1)
 	for i := range events {
 		events[i] = event
 	}
-	b.encodeJSON(events)
+	b.encode(events)
 
 	overflowEvents := 0
 	for _, batch := range b.overflowBatches {
@@ -760,3 +931,244 @@ func randomString(length int) string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
+
+func TestEncodeJSON_DropsExpiredEvents(t *testing.T) {
+	events := []*EventRaw{
+		{enqueuedAt: time.Now().Add(-time.Minute)},
+		{enqueuedAt: time.Now()},
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		&config.Configuration{
+			MaxEventAge:     time.Second,
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	b.stats = newStatsAggregator()
+
+	eventsJSON, numEncoded := b.encode(events)
+	assert.Equal(t, 1, numEncoded)
+	assert.Nil(t, events[0])
+
+	expectedJSON, _ := json.Marshal(events[1:])
+	assert.Equal(t, expectedJSON, eventsJSON.Bytes())
+
+	res := <-r
+	assert.Equal(t, ErrEventExpired, res.Err)
+	assert.Equal(t, uint64(1), b.stats.snapshot().EventsExpired)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	events := make([]*EventRaw, DefaultMaxEventsPerBatch)
+	for i := range events {
+		events[i] = &EventRaw{
+			Organization: &EventOrganization{
+				ID: "org-id",
+			},
+			Route: &EventRoute{
+				Type:   RouteTypeTarget,
+				Method: http.MethodGet,
+				Path:   "/person/:id",
+			},
+			User: &EventUser{
+				ID:   "user-id",
+				Name: "username",
+			},
+			Client: &EventClient{
+				IP: "1.2.3.4",
+			},
+			RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
+			Request:     json.RawMessage(`{"path":"/person/xyz","headers":{"Accept":"application/json"}}`),
+			Response:    json.RawMessage(`{"statusCode":200,"body":"{\"id\":\"xyz\"}"}`),
+		}
+	}
+
+	bl := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		make(chan Response, DefaultPendingWorkCapacity*2),
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bl.encode(events)
+	}
+}
+
+func TestNewBatchList_ClampsZeroValues(t *testing.T) {
+	r := make(chan Response, 1)
+	b := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		0,
+		0,
+	)
+	assert.Equal(t, uint(1), b.maxEventsPerBatch)
+	assert.Equal(t, uint(1), b.maxConcurrentBatches)
+
+	assert.NotPanics(t, func() {
+		b.Add(&EventRaw{})
+	})
+	assert.Len(t, b.batches[0], 1)
+}
+
+func TestSend_CorrelatesResponsesToEvents(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body: ioutil.NopCloser(bytes.NewBufferString(`[
+					{"status": 200},
+					{"status": 400, "error": "event is missing y"}
+				]`)),
+			}, nil
+		},
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client {
+				return &http.Client{Transport: m}
+			},
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+
+	// Dropped events leave gaps that are skipped when pairing responses
+	b.send([]*EventRaw{
+		nil,
+		{RequestID: "request-1", Sequence: 1},
+		nil,
+		{RequestID: "request-2", Sequence: 2},
+	})
+	close(r)
+
+	var responses []Response
+	for res := range r {
+		responses = append(responses, res)
+	}
+
+	assert.Equal(t, []Response{
+		{
+			StatusCode: 200,
+			Sink:       SinkHTTP,
+			Sequence:   1,
+			RequestID:  "request-1",
+		},
+		{
+			Err:        errors.New("event is missing y"),
+			StatusCode: 400,
+			Sink:       SinkHTTP,
+			Sequence:   2,
+			RequestID:  "request-2",
+		},
+	}, responses)
+}
+
+func TestEncode_UsesConfiguredMaxBytes(t *testing.T) {
+	small := &EventRaw{RequestID: "small"}
+	size, err := newEventEncoder(EncodingJSON).Encode(&bytes.Buffer{}, small)
+	assert.NoError(t, err)
+
+	// Room for 3 small events in a batch, including the envelope
+	maxEventBytes := size + 10
+	maxBatchBytes := 2 + 3*(size+1)
+
+	r := make(chan Response, 10)
+	b := newBatchList(
+		&config.Configuration{
+			MaxEventBytes:   maxEventBytes,
+			MaxBatchBytes:   maxBatchBytes,
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		1,
+	)
+
+	large := &EventRaw{RequestID: randomString(20) + "small"}
+	events := []*EventRaw{large, small, small, small, small}
+
+	payload, numEncoded := b.encode(events)
+	defer putBuffer(payload)
+
+	// The large event is dropped
+	res := <-r
+	assert.EqualError(
+		t,
+		res.Err,
+		fmt.Sprintf("Event exceeds max size of %d bytes", maxEventBytes),
+	)
+	assert.Nil(t, events[0])
+
+	// The small event that doesn't fit in the batch overflows
+	assert.Equal(t, 3, numEncoded)
+	assert.LessOrEqual(t, payload.Len(), maxBatchBytes)
+
+	overflowed := 0
+	for _, batch := range b.overflowBatches {
+		overflowed += len(batch)
+	}
+	assert.Equal(t, 1, overflowed)
+}
+
+func TestNewBatchList_DefaultsMaxBytes(t *testing.T) {
+	r := make(chan Response, 1)
+	b := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	assert.Equal(t, config.DefaultMaxEventBytes, b.maxEventBytes)
+	assert.Equal(t, config.DefaultMaxBatchBytes, b.maxBatchBytes)
+
+	// Events never exceed the batch
+	b = newBatchList(
+		&config.Configuration{
+			MaxEventBytes:   300,
+			MaxBatchBytes:   200,
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	assert.Equal(t, 200, b.maxEventBytes)
+}
+
+func TestNewBatchList_ClampsZeroBatchSizes(t *testing.T) {
+	r := make(chan Response, 1)
+	b := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		r,
+		0,
+		0,
+	)
+	assert.Equal(t, uint(1), b.maxEventsPerBatch)
+	assert.Equal(t, uint(1), b.maxConcurrentBatches)
+
+	// Picking a batch would otherwise divide by zero
+	assert.NotPanics(t, func() {
+		b.Add(&EventRaw{})
+		b.Add(&EventRaw{})
+	})
+	assert.Len(t, b.batches[0], 2)
+}
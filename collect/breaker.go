@@ -0,0 +1,128 @@
+package collect
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error returned when sends are short-circuited
+// because the events endpoint has been failing
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker short-circuits sends after consecutive failures.
+// Once the cooldown elapses, a single probe is allowed through to
+// test whether the events endpoint has recovered.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	threshold uint
+	cooldown  time.Duration
+	state     circuitState
+	failures  uint
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+// newCircuitBreaker creates a new circuit breaker.
+// A threshold of 0 disables the circuit breaker.
+func newCircuitBreaker(threshold uint, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// configure updates the threshold and cooldown
+func (c *circuitBreaker) configure(threshold uint, cooldown time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.threshold = threshold
+	c.cooldown = cooldown
+}
+
+// allow determines whether a send may go through
+func (c *circuitBreaker) allow() bool {
+	if c == nil {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.threshold == 0 {
+		return true
+	}
+
+	switch c.state {
+	case circuitOpen:
+		if c.now().Sub(c.openedAt) < c.cooldown {
+			return false
+		}
+
+		// Let a single probe through
+		c.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// Probe in flight
+		return false
+	}
+
+	return true
+}
+
+// success records a successful send and closes the circuit
+func (c *circuitBreaker) success() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failures = 0
+	c.state = circuitClosed
+}
+
+// failure records a failed send and opens the circuit if the
+// threshold is reached or the probe failed
+func (c *circuitBreaker) failure() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failures++
+	if c.threshold == 0 {
+		return
+	}
+
+	if c.state == circuitHalfOpen || c.failures >= c.threshold {
+		c.state = circuitOpen
+		c.openedAt = c.now()
+	}
+}
+
+// consecutiveFailures returns the number of failed sends since the
+// last successful send, even if the circuit breaker is disabled
+func (c *circuitBreaker) consecutiveFailures() uint {
+	if c == nil {
+		return 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.failures
+}
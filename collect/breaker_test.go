@@ -0,0 +1,125 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	c := newCircuitBreaker(2, time.Second)
+	c.now = func() time.Time {
+		return now
+	}
+
+	assert.True(t, c.allow())
+	c.failure()
+	assert.True(t, c.allow())
+	c.failure()
+
+	// Open
+	assert.False(t, c.allow())
+
+	// Half-open after cooldown lets a single probe through
+	now = now.Add(time.Second)
+	assert.True(t, c.allow())
+	assert.False(t, c.allow())
+
+	// Failed probe reopens
+	c.failure()
+	assert.False(t, c.allow())
+
+	// Successful probe closes
+	now = now.Add(time.Second)
+	assert.True(t, c.allow())
+	c.success()
+	assert.True(t, c.allow())
+	assert.True(t, c.allow())
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	c := newCircuitBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		c.failure()
+	}
+
+	assert.True(t, c.allow())
+
+	var nilBreaker *circuitBreaker
+	assert.True(t, nilBreaker.allow())
+}
+
+func TestSend_ShortCircuitsWhenCircuitOpen(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(""))),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil).Once()
+
+	configurer, _ := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": false
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+
+	configurer.Refresh(context.Background())
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		configurer.Configuration,
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	b.stats = newStatsAggregator()
+	b.breaker = newCircuitBreaker(1, time.Minute)
+
+	b.send([]*EventRaw{{}})
+	res := <-r
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	b.send([]*EventRaw{{}})
+	res = <-r
+	assert.Equal(t, ErrCircuitOpen, res.Err)
+
+	stats := b.stats.snapshot()
+	assert.Equal(t, uint64(1), stats.BatchFailures)
+	assert.Equal(t, uint64(1), stats.BatchesShortCircuited)
+	assert.True(t, m.AssertExpectations(t))
+}
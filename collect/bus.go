@@ -0,0 +1,139 @@
+package collect
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriptionBufferSize is the default number of responses and
+// events buffered per subscriber
+const DefaultSubscriptionBufferSize uint = 100
+
+// SubscriptionOptions are options to override default subscription settings
+type SubscriptionOptions struct {
+	// BufferSize is the number of responses and events buffered before
+	// the subscriber starts missing them
+	BufferSize uint
+
+	// Events subscribes to every published event as well as responses
+	Events bool
+}
+
+// Subscription receives a copy of every response, and optionally every
+// published event, independent of the publisher's response channel and
+// other subscriptions. A slow subscriber misses responses and events
+// once its buffer is full rather than holding up the publisher.
+type Subscription struct {
+	responses chan Response
+	events    chan *EventRaw
+	missed    uint64
+}
+
+// Responses returns the subscription's response channel.
+// The channel is closed on unsubscribe.
+func (s *Subscription) Responses() <-chan Response {
+	return s.responses
+}
+
+// Events returns the subscription's event channel.
+// Nil unless subscribed to events. The channel is closed on unsubscribe.
+// Events are shared with the publisher and must not be modified.
+func (s *Subscription) Events() <-chan *EventRaw {
+	return s.events
+}
+
+// Missed returns the number of responses and events missed
+// due to a full buffer
+func (s *Subscription) Missed() uint64 {
+	return atomic.LoadUint64(&s.missed)
+}
+
+// eventBus fans out responses and events to subscribers
+type eventBus struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// newEventBus creates a new event bus
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscriptions: map[*Subscription]struct{}{},
+	}
+}
+
+// subscribe adds a new subscription
+func (b *eventBus) subscribe(options SubscriptionOptions) *Subscription {
+	size := options.BufferSize
+	if size == 0 {
+		size = DefaultSubscriptionBufferSize
+	}
+
+	s := &Subscription{
+		responses: make(chan Response, size),
+	}
+	if options.Events {
+		s.events = make(chan *EventRaw, size)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subscriptions[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes the subscription and closes its channels
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.subscriptions[s]; !ok {
+		return
+	}
+
+	delete(b.subscriptions, s)
+	close(s.responses)
+	if s.events != nil {
+		close(s.events)
+	}
+}
+
+// publishResponse sends a copy of the response to every subscriber
+func (b *eventBus) publishResponse(res Response) {
+	if b == nil {
+		return
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for s := range b.subscriptions {
+		select {
+		case s.responses <- res:
+		default:
+			atomic.AddUint64(&s.missed, 1)
+		}
+	}
+}
+
+// publishEvent sends the event to every subscriber of events
+func (b *eventBus) publishEvent(event *EventRaw) {
+	if b == nil {
+		return
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for s := range b.subscriptions {
+		if s.events == nil {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			atomic.AddUint64(&s.missed, 1)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package collect
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/muster"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBus_FansOut(t *testing.T) {
+	b := newEventBus()
+	metrics := b.subscribe(SubscriptionOptions{})
+	sink := b.subscribe(SubscriptionOptions{
+		BufferSize: 1,
+		Events:     true,
+	})
+	assert.Nil(t, metrics.Events())
+
+	res := Response{StatusCode: 200}
+	b.publishResponse(res)
+	assert.Equal(t, res, <-metrics.Responses())
+	assert.Equal(t, res, <-sink.Responses())
+
+	event := &EventRaw{}
+	b.publishEvent(event)
+	assert.Equal(t, event, <-sink.Events())
+
+	// A full buffer misses rather than blocks
+	b.publishResponse(res)
+	b.publishResponse(res)
+	assert.Equal(t, uint64(1), sink.Missed())
+	assert.Equal(t, uint64(0), metrics.Missed())
+
+	b.unsubscribe(sink)
+	b.unsubscribe(sink)
+	<-sink.Responses()
+	_, ok := <-sink.Responses()
+	assert.False(t, ok)
+	_, ok = <-sink.Events()
+	assert.False(t, ok)
+
+	// Unsubscribed subscribers no longer receive
+	b.publishResponse(res)
+	assert.Len(t, metrics.Responses(), 3)
+
+	var nilBus *eventBus
+	nilBus.publishResponse(res)
+	nilBus.publishEvent(event)
+}
+
+func TestPublisher_Subscribe(t *testing.T) {
+	work := make(chan interface{}, 1)
+	p := &EventPublisher{
+		muster: &muster.Client{
+			Work: work,
+		},
+		responses: make(chan Response, 1),
+		stats:     newStatsAggregator(),
+		bus:       newEventBus(),
+	}
+
+	s := p.Subscribe(SubscriptionOptions{
+		Events: true,
+	})
+
+	event := &EventRaw{}
+	p.Add(event)
+	assert.Equal(t, event, <-s.Events())
+
+	// Dropped events are not published but their responses are
+	p.Add(&EventRaw{})
+	select {
+	case <-s.Events():
+		assert.Fail(t, "dropped event was published")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	res := <-s.Responses()
+	assert.EqualError(t, res.Err, "Queue overflow")
+
+	// The response channel still receives its own copy
+	res = <-p.Responses()
+	assert.EqualError(t, res.Err, "Queue overflow")
+
+	p.writeResponse(Response{Err: errors.New("bla")}, false)
+	res = <-s.Responses()
+	assert.EqualError(t, res.Err, "bla")
+
+	p.Unsubscribe(s)
+	_, ok := <-s.Responses()
+	assert.False(t, ok)
+}
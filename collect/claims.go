@@ -0,0 +1,82 @@
+package collect
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ClaimValues collects the values of the named claims in order.
+// Claims may be a list or a space or comma delimited string such as
+// an OAuth scope. Duplicate values are only collected once.
+func ClaimValues(claims map[string]interface{}, names []string) []string {
+	var values []string
+	seen := map[string]bool{}
+	add := func(v string) {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			return
+		}
+
+		seen[v] = true
+		values = append(values, v)
+	}
+
+	for _, name := range names {
+		claim, ok := claims[name]
+		if !ok {
+			continue
+		}
+
+		switch c := claim.(type) {
+		case string:
+			// API Gateway flattens lists into strings like "[a b]"
+			c = strings.Trim(c, "[]")
+			for _, v := range strings.FieldsFunc(c, func(r rune) bool {
+				return r == ' ' || r == ','
+			}) {
+				add(v)
+			}
+		case []string:
+			for _, v := range c {
+				add(v)
+			}
+		case []interface{}:
+			for _, v := range c {
+				if s, ok := v.(string); ok {
+					add(s)
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// DecodeJWTClaims decodes the claims of a JWT without validating its
+// signature. A leading "Bearer " prefix is ignored.
+func DecodeJWTClaims(token string) (map[string]interface{}, error) {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt: expected 3 parts")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt payload: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed jwt claims: %w", err)
+	}
+
+	return claims, nil
+}
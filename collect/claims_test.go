@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimValues(t *testing.T) {
+	claims := map[string]interface{}{
+		"scope":          "read:person write:person",
+		"cognito:groups": "[admin users]",
+		"roles":          []interface{}{"admin", "auditor", 1},
+		"scp":            []string{"read:person"},
+	}
+
+	assert.Equal(
+		t,
+		[]string{"admin", "users", "auditor"},
+		ClaimValues(claims, []string{"cognito:groups", "roles"}),
+	)
+	assert.Equal(
+		t,
+		[]string{"read:person", "write:person"},
+		ClaimValues(claims, []string{"scope", "scp"}),
+	)
+	assert.Nil(t, ClaimValues(claims, []string{"missing"}))
+}
+
+func TestDecodeJWTClaims(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-id","org_id":"org-id"}`))
+	token := header + "." + payload + ".sig"
+
+	claims, err := DecodeJWTClaims("Bearer " + token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", claims["sub"])
+	assert.Equal(t, "org-id", claims["org_id"])
+
+	_, err = DecodeJWTClaims("not-a-jwt")
+	assert.Error(t, err)
+
+	_, err = DecodeJWTClaims(header + ".!!!." + "sig")
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package collect
+
+import (
+	"net"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// ClientIPResolver is implemented by event builders that can resolve
+// the client IP of a request before its event is built
+type ClientIPResolver interface {
+	// ClientIP returns the client IP of the request, if resolved
+	ClientIP(request interface{}) (net.IP, bool)
+}
+
+// clientIPMatch is how the client IP of a request matched
+// the audited and skipped IP ranges
+type clientIPMatch int
+
+const (
+	clientIPUnmatched clientIPMatch = iota
+	clientIPAudited
+	clientIPSkipped
+)
+
+// matchClientIP matches the client IP of the request resolved by the
+// builders against the audited and skipped IP ranges.
+// Audited ranges win when the IP is in both.
+func matchClientIP(
+	configuration *config.Configuration,
+	builders []EventBuilder,
+	request interface{},
+) clientIPMatch {
+	if len(configuration.AuditNetworks) == 0 && len(configuration.SkipNetworks) == 0 {
+		return clientIPUnmatched
+	}
+
+	for _, b := range builders {
+		resolver, ok := b.(ClientIPResolver)
+		if !ok {
+			continue
+		}
+
+		ip, ok := resolver.ClientIP(request)
+		if !ok {
+			continue
+		}
+
+		if containsIP(configuration.AuditNetworks, ip) {
+			return clientIPAudited
+		}
+
+		if containsIP(configuration.SkipNetworks, ip) {
+			return clientIPSkipped
+		}
+
+		return clientIPUnmatched
+	}
+
+	return clientIPUnmatched
+}
+
+// containsIP determines whether any of the networks contains the IP
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package collect
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type ipBuilder struct{}
+
+func (b *ipBuilder) Build(
+	configuration *config.Configuration,
+	routeType RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*EventRaw, error) {
+	return &EventRaw{}, nil
+}
+
+func (b *ipBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	ip := net.ParseIP(request.(string))
+	return ip, ip != nil
+}
+
+func TestMatchClientIP(t *testing.T) {
+	auditNetworks, err := config.ParseCIDRs([]string{"0.0.0.0/0"})
+	assert.NoError(t, err)
+	skipNetworks, err := config.ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+
+	configuration := &config.Configuration{
+		SkipNetworks: skipNetworks,
+	}
+	builders := []EventBuilder{
+		&mockBuilder{},
+		&ipBuilder{},
+	}
+
+	assert.Equal(t, clientIPSkipped, matchClientIP(configuration, builders, "10.1.2.3"))
+	assert.Equal(t, clientIPSkipped, matchClientIP(configuration, builders, "192.168.1.1"))
+	assert.Equal(t, clientIPUnmatched, matchClientIP(configuration, builders, "192.168.1.2"))
+	assert.Equal(t, clientIPUnmatched, matchClientIP(configuration, builders, "unknown"))
+
+	// Audited ranges win
+	configuration.AuditNetworks = auditNetworks
+	assert.Equal(t, clientIPAudited, matchClientIP(configuration, builders, "10.1.2.3"))
+
+	// Builders that can't resolve the IP never match
+	assert.Equal(t, clientIPUnmatched, matchClientIP(configuration, []EventBuilder{&mockBuilder{}}, "10.1.2.3"))
+}
@@ -3,8 +3,10 @@ package collect
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 
 	"github.com/auditr-io/auditr-agent-go/config"
 )
@@ -16,17 +18,132 @@ type Collector struct {
 	routerLock    sync.Mutex
 	publisher     Publisher
 
+	// builders are the event builders of the publisher, to resolve
+	// client IPs and build events without publishing them
+	builders []EventBuilder
+
+	// routerConfigured is set once the router is built from
+	// an applied configuration
+	routerConfigured int32
+
 	routerRefreshedc chan struct{}
+
+	// random returns a number in [0, 1) to sample by rate
+	random func() float64
+
+	// sampleLimiter limits sample events per route to the sample window
+	sampleLimiter *sampleLimiter
+
+	// sampledRouteStore persists sampled routes; nil keeps them in memory.
+	// storedSampleRoutes are the routes loaded from the store.
+	sampledRouteStore  SampledRouteStore
+	storedSampleRoutes []config.Route
+
+	// agentType identifies the integration producing the events
+	agentType string
+
+	// eventsURL overrides the events endpoint of the configuration
+	eventsURL string
+
+	publisherOptions []PublisherOption
+
+	// eventBuilders are evaluated after the builders of the agent
+	eventBuilders []EventBuilder
+
+	// optionErr is the first error of the options, returned by NewCollector
+	optionErr error
+
+	// log logs the messages of the collector and its publisher
+	log logger
+}
+
+// CollectorOption is an option to override defaults
+type CollectorOption func(c *Collector)
+
+// WithPublisherOptions overrides the defaults of the event publisher
+func WithPublisherOptions(options ...PublisherOption) CollectorOption {
+	return func(c *Collector) {
+		c.publisherOptions = append(c.publisherOptions, options...)
+	}
+}
+
+// WithEventBuilders adds event builders to the builders of the agent,
+// e.g. to build events from requests of another event source.
+// They are evaluated in order, after the builders of the agent.
+func WithEventBuilders(builders ...EventBuilder) CollectorOption {
+	return func(c *Collector) {
+		c.eventBuilders = append(c.eventBuilders, builders...)
+	}
+}
+
+// WithLogger routes the messages of the collector, its publisher and
+// batches to the logger, e.g. config.NoopLogger to silence them. Other
+// collectors keep their own loggers. Messages of the rest of the agent,
+// e.g. the config fetcher, go to the logger set with config.SetLogger.
+func WithLogger(l config.Logger) CollectorOption {
+	return func(c *Collector) {
+		if l == nil {
+			c.setOptionErr(errors.New("logger must not be nil"))
+			return
+		}
+
+		c.log = logger{logger: l}
+	}
+}
+
+// setOptionErr keeps the first error of the options
+func (c *Collector) setOptionErr(err error) {
+	if c.optionErr == nil {
+		c.optionErr = err
+	}
+}
+
+// WithAgentType sets the agent type of the events, e.g. AgentTypeHTTP,
+// to attribute them to the integration that produced them
+func WithAgentType(agentType string) CollectorOption {
+	return func(c *Collector) {
+		c.agentType = agentType
+	}
+}
+
+// WithEventsURL overrides the events endpoint derived from the configuration,
+// e.g. to send events to a local mock server. The override is kept on the
+// collector, so the configuration and other collectors sharing it still
+// use the derived endpoint.
+func WithEventsURL(eventsURL string) CollectorOption {
+	return func(c *Collector) {
+		c.eventsURL = eventsURL
+	}
+}
+
+// withPublisherEventsURL sends the batches of the publisher to eventsURL
+// instead of the events endpoint of the configuration
+func withPublisherEventsURL(eventsURL string) PublisherOption {
+	return func(p *EventPublisher) error {
+		p.eventsURL = eventsURL
+		return nil
+	}
 }
 
 // NewCollector creates a new collector instance
 func NewCollector(
 	builders []EventBuilder,
 	configuration *config.Configuration, // can be nil
+	options ...CollectorOption,
 ) (*Collector, error) {
 	c := &Collector{
 		configuration:    configuration,
 		routerRefreshedc: make(chan struct{}),
+		random:           rand.Float64,
+		sampleLimiter:    newSampleLimiter(),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.optionErr != nil {
+		return nil, c.optionErr
 	}
 
 	if configuration == nil {
@@ -34,37 +151,77 @@ func NewCollector(
 		c.configuration = config.GetConfig()
 	}
 
-	c.router = NewRouter(
-		c.configuration.TargetRoutes,
-		c.configuration.SampleRoutes,
-	)
-
+	c.loadSampledRoutes()
+	c.refreshRouter()
 	c.configuration.Configurer.OnRefresh(c.refreshRouter)
 
+	if len(c.eventBuilders) > 0 {
+		builders = append(
+			append([]EventBuilder{}, builders...),
+			c.eventBuilders...,
+		)
+	}
+
+	publisherOptions := append(
+		append([]PublisherOption{}, c.publisherOptions...),
+		withPublisherLog(c.log),
+	)
+	if c.eventsURL != "" {
+		publisherOptions = append(
+			publisherOptions,
+			withPublisherEventsURL(c.eventsURL),
+		)
+	}
+
 	p, err := NewEventPublisher(
 		c.configuration,
 		builders,
+		publisherOptions...,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if c.agentType != "" {
+		p.agent = &EventAgent{
+			Name:    AgentName,
+			Type:    c.agentType,
+			Version: version,
+		}
+	}
+
 	c.publisher = p
+	c.builders = builders
 
 	return c, nil
 }
 
 // refreshRouter refreshes the routes upon a config refresh
-// not thread safe
 func (c *Collector) refreshRouter() {
-	log.Printf("refreshRouter %+v", c.configuration)
-	r := NewRouter(
-		c.configuration.TargetRoutes,
-		c.configuration.SampleRoutes,
-	)
+	c.log.debugf("refreshRouter %+v", c.configuration)
+
+	// Check before building so a configuration applied mid-build
+	// triggers another refresh
+	configured := c.configuration.Configurer.IsConfigured()
+
+	var options []RouterOption
+	if c.configuration.CaseSensitiveMethods {
+		options = append(options, WithCaseSensitiveMethods())
+	}
+
+	if c.configuration.ProxyRouteTemplate != "" {
+		options = append(options, WithProxyTemplate(c.configuration.ProxyRouteTemplate))
+	}
 
 	c.routerLock.Lock()
-	c.router = r
+	c.router = NewRouter(
+		c.configuration.Targets(),
+		c.sampleRoutes(),
+		options...,
+	)
+	if configured {
+		atomic.StoreInt32(&c.routerConfigured, 1)
+	}
 	c.routerLock.Unlock()
 
 	select {
@@ -73,6 +230,21 @@ func (c *Collector) refreshRouter() {
 	}
 }
 
+// ensureRouter builds the router if the configuration has been applied
+// since the router was last built. Refresh listeners run asynchronously,
+// so this prevents early requests from being matched against an empty router.
+func (c *Collector) ensureRouter() {
+	if atomic.LoadInt32(&c.routerConfigured) == 1 {
+		return
+	}
+
+	if !c.configuration.Configurer.IsConfigured() {
+		return
+	}
+
+	c.refreshRouter()
+}
+
 // Collect captures the request as an audit event or a sample
 func (c *Collector) Collect(
 	ctx context.Context,
@@ -83,31 +255,50 @@ func (c *Collector) Collect(
 	response json.RawMessage,
 	errorValue json.RawMessage,
 ) {
+	if c.publisher.DropPaused() {
+		return
+	}
+
 	c.configuration.Configurer.Refresh(ctx)
+	c.ensureRouter()
 
-	log.Printf("config: %+v", c.configuration)
+	c.log.debugf("config: %+v", c.configuration)
 
-	c.routerLock.Lock()
-	route, err := c.router.FindRoute(RouteTypeTarget, httpMethod, path)
-	c.routerLock.Unlock()
-	if err != nil {
-		panic(err)
+	path = NormalizePath(path, c.configuration.StripTrailingSlash)
+	resource = NormalizePath(resource, c.configuration.StripTrailingSlash)
+
+	ipMatch := matchClientIP(
+		c.configuration,
+		c.builders,
+		request,
+	)
+	if ipMatch == clientIPSkipped {
+		c.log.debugf("request to %s %s skipped by client IP", httpMethod, path)
+		return
 	}
 
+	route := c.findTargetRoute(httpMethod, path, resource, ipMatch)
+
 	defer func() {
 		if c.configuration.Flush {
-			c.Flush()
+			// Back off while the backend is failing
+			c.publisher.FlushWithBackoff()
 		}
 	}()
 
 	if route != nil {
-		c.publisher.Publish(RouteTypeTarget, route, request, response, errorValue)
-		log.Printf("route: %#v is targeted", route)
+		c.publish(ctx, RouteTypeTarget, route, request, response, errorValue)
+		c.log.debugf("route: %#v is targeted", route)
+		return
+	}
+
+	if !c.configuration.SamplingEnabled {
+		// Only targeted routes are audited
 		return
 	}
 
 	c.routerLock.Lock()
-	route, err = c.router.FindRoute(RouteTypeSample, httpMethod, path)
+	route, err := c.router.FindRoute(RouteTypeSample, httpMethod, path)
 	c.routerLock.Unlock()
 	if err != nil {
 		panic(err)
@@ -115,17 +306,23 @@ func (c *Collector) Collect(
 
 	if route == nil {
 		c.routerLock.Lock()
-		log.Printf("route is nil when finding method %s path %s\n", httpMethod, path)
-		log.Printf("sampled %#v\n", c.router.sample)
+		c.log.debugf("route is nil when finding method %s path %s", httpMethod, path)
+		c.log.debugf("sampled %#v", c.router.sample)
 		root, ok := c.router.sample[httpMethod]
 		c.routerLock.Unlock()
 		if ok {
-			log.Printf("sampled[GET] %#v\n", root)
+			c.log.debugf("sampled[%s] %#v", httpMethod, root)
 		}
 	}
 
 	if route != nil {
-		log.Printf("route: %#v is already sampled", route)
+		if c.resample(route) && c.allowSample(route) {
+			c.log.debugf("route: %#v is sampled again", route)
+			c.publish(ctx, RouteTypeSample, route, request, response, errorValue)
+			return
+		}
+
+		c.log.debugf("route: %#v is already sampled", route)
 		return
 	}
 
@@ -134,18 +331,247 @@ func (c *Collector) Collect(
 	route = c.router.SampleRoute(httpMethod, path, resource)
 	c.routerLock.Unlock()
 	if route != nil {
-		log.Printf("route: %#v is sampled", route)
-		c.publisher.Publish(RouteTypeSample, route, request, response, errorValue)
+		c.log.debugf("route: %#v is sampled", route)
+		c.saveSampledRoute(ctx, route)
+		if c.allowSample(route) {
+			c.publish(ctx, RouteTypeSample, route, request, response, errorValue)
+		}
 		return
 	}
 }
 
+// CollectReceived captures a request to a targeted route as soon as it is
+// received, before the handler runs. Only collected if two phase events
+// are enabled. Sampling is decided once the request completes.
+func (c *Collector) CollectReceived(
+	ctx context.Context,
+	httpMethod string,
+	path string,
+	request interface{},
+) {
+	if !c.configuration.TwoPhaseEvents {
+		return
+	}
+
+	if c.publisher.DropPaused() {
+		return
+	}
+
+	c.configuration.Configurer.Refresh(ctx)
+	c.ensureRouter()
+
+	path = NormalizePath(path, c.configuration.StripTrailingSlash)
+
+	ipMatch := matchClientIP(
+		c.configuration,
+		c.builders,
+		request,
+	)
+	if ipMatch == clientIPSkipped {
+		return
+	}
+
+	route := c.findTargetRoute(httpMethod, path, path, ipMatch)
+	if route == nil {
+		return
+	}
+
+	c.publisher.PublishPhase(
+		EventPhaseReceived,
+		MetadataFromContext(ctx),
+		RouteTypeTarget,
+		route,
+		request,
+		nil,
+		nil,
+	)
+	c.log.debugf("route: %#v is targeted on receipt", route)
+
+	if c.configuration.Flush {
+		// Back off while the backend is failing
+		c.publisher.FlushWithBackoff()
+	}
+}
+
+// BuildEvent maps the request to an event with the event builders without
+// publishing it, e.g. to unit test the org, user and redaction mapping.
+// Requests to routes that aren't targeted are built as samples, but the
+// route isn't recorded as sampled.
+func (c *Collector) BuildEvent(
+	httpMethod string,
+	path string,
+	resource string,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*EventRaw, error) {
+	c.ensureRouter()
+
+	path = NormalizePath(path, c.configuration.StripTrailingSlash)
+	resource = NormalizePath(resource, c.configuration.StripTrailingSlash)
+
+	ipMatch := matchClientIP(
+		c.configuration,
+		c.builders,
+		request,
+	)
+
+	routeType := RouteTypeTarget
+	route := c.findTargetRoute(httpMethod, path, resource, ipMatch)
+	if route == nil {
+		routeType = RouteTypeSample
+
+		c.routerLock.Lock()
+		route = &config.Route{
+			HTTPMethod: c.router.normalizeMethod(httpMethod),
+			Path:       c.router.samplePath(path, resource),
+		}
+		c.routerLock.Unlock()
+	}
+
+	return buildEvent(
+		c.configuration,
+		c.builders,
+		routeType,
+		route,
+		request,
+		response,
+		errorValue,
+	)
+}
+
+// findTargetRoute finds the targeted route of the request. Requests from
+// audited client IP ranges are targeted even if their route isn't.
+func (c *Collector) findTargetRoute(
+	httpMethod string,
+	path string,
+	resource string,
+	ipMatch clientIPMatch,
+) *config.Route {
+	c.routerLock.Lock()
+	defer c.routerLock.Unlock()
+
+	route, err := c.router.FindRoute(RouteTypeTarget, httpMethod, path)
+	if err != nil {
+		panic(err)
+	}
+
+	if route == nil && ipMatch == clientIPAudited {
+		route = &config.Route{
+			HTTPMethod: c.router.normalizeMethod(httpMethod),
+			Path:       c.router.samplePath(path, resource),
+		}
+	}
+
+	return route
+}
+
+// publish publishes the completed request with the metadata of the
+// context, marking its phase if two phase events are enabled
+func (c *Collector) publish(
+	ctx context.Context,
+	routeType RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) {
+	var phase EventPhase
+	if c.configuration.TwoPhaseEvents {
+		phase = EventPhaseCompleted
+	}
+
+	c.publisher.PublishPhase(
+		phase,
+		MetadataFromContext(ctx),
+		routeType,
+		route,
+		request,
+		response,
+		errorValue,
+	)
+}
+
+// resample determines whether to sample a request to an already sampled
+// route by the route's sample rate
+func (c *Collector) resample(route *config.Route) bool {
+	rate := c.configuration.SampleRateFor(route)
+	if rate <= 0 {
+		return false
+	}
+
+	return rate >= 1 || c.random() < rate
+}
+
+// allowSample determines whether a sample event of the route may be
+// published within the sample window
+func (c *Collector) allowSample(route *config.Route) bool {
+	if c.sampleLimiter.allow(route.HTTPMethod, route.Path, c.configuration.SampleWindow) {
+		return true
+	}
+
+	c.log.debugf("route: %#v was sampled within the sample window", route)
+	return false
+}
+
+// Configuration returns the configuration used by the collector
+func (c *Collector) Configuration() *config.Configuration {
+	return c.configuration
+}
+
 // Responses return a response channel
 func (c *Collector) Responses() <-chan Response {
-	return c.publisher.(*EventPublisher).Responses()
+	return c.publisher.Responses()
+}
+
+// Stats returns a snapshot of the batch send results
+func (c *Collector) Stats() Stats {
+	return c.publisher.Stats()
+}
+
+// Subscribe adds a subscriber that receives a copy of every response,
+// and optionally every published event
+func (c *Collector) Subscribe(options SubscriptionOptions) *Subscription {
+	return c.publisher.Subscribe(options)
+}
+
+// Unsubscribe removes the subscriber and closes its channels
+func (c *Collector) Unsubscribe(s *Subscription) {
+	c.publisher.Unsubscribe(s)
+}
+
+// Warmup establishes the connection to the events endpoint
+func (c *Collector) Warmup(ctx context.Context) error {
+	return c.publisher.Warmup(ctx)
+}
+
+// Pause pauses collection. Pending events are sent and events
+// collected while paused are dropped and counted.
+func (c *Collector) Pause() error {
+	return c.publisher.Pause()
+}
+
+// Resume resumes collection after a pause
+func (c *Collector) Resume() error {
+	return c.publisher.Resume()
 }
 
 // Flush sends anything pending in queue
 func (c *Collector) Flush() error {
-	return c.publisher.(*EventPublisher).Flush()
+	return c.publisher.Flush()
+}
+
+// Close stops watching the config file, sends anything pending in queue
+// and stops collecting. Returns the context's error if the pending
+// events aren't sent before the context is done.
+func (c *Collector) Close(ctx context.Context) error {
+	c.configuration.Configurer.Stop()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown sends anything pending in queue and stops collecting.
+// Events collected afterwards are dropped. Returns the context's error
+// if the pending events aren't sent before the context is done.
+func (c *Collector) Shutdown(ctx context.Context) error {
+	return c.publisher.Shutdown(ctx)
 }
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/auditr-io/auditr-agent-go/config"
@@ -162,3 +165,1073 @@ func TestRefreshRouter(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestCollect_BuildsRouterOnFirstConfiguration(t *testing.T) {
+	configBytes := []byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"target": [
+			{
+				"method": "GET",
+				"path": "/person/:id"
+			}
+		],
+		"sample": [],
+		"cache_duration": 2
+	}`)
+
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return configBytes, nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	// Not configured yet
+	route, err := collector.router.FindRoute(RouteTypeTarget, http.MethodGet, "/person/xyz")
+	assert.NoError(t, err)
+	assert.Nil(t, route)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	collector.routerLock.Lock()
+	route, err = collector.router.FindRoute(RouteTypeTarget, http.MethodGet, "/person/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, route)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&collector.routerConfigured))
+}
+
+func TestNewCollector_TargetsStaticRoutesBeforeConfiguration(t *testing.T) {
+	staticTargetRoutes := config.StaticTargetRoutes
+	defer func() {
+		config.StaticTargetRoutes = staticTargetRoutes
+	}()
+
+	config.StaticTargetRoutes = []config.Route{
+		{
+			HTTPMethod: http.MethodPost,
+			Path:       "/login",
+		},
+	}
+
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return nil, errors.New("config outage")
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+	)
+	assert.NoError(t, err)
+
+	// Never configured, so the events client isn't set either
+	c.Configuration.GetEventsClient = func() *http.Client {
+		return &http.Client{
+			Transport: &test.MockTransport{},
+		}
+	}
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeTarget, http.MethodPost, "/login")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, route)
+}
+
+// recordingLogger records the errors it receives
+type recordingLogger struct {
+	lock   sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(format string, v ...interface{}) {}
+
+func (l *recordingLogger) Errorf(format string, v ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+
+func TestWithLogger_SetsCollectorLogger(t *testing.T) {
+	newCollector := func(logger config.Logger, loadErr error) {
+		c, err := config.NewConfigurer(
+			config.WithConfigProvider(func() ([]byte, error) {
+				return nil, errors.New("config outage")
+			}),
+			config.WithFileEventChan(make(chan fsnotify.Event)),
+		)
+		assert.NoError(t, err)
+
+		c.Configuration.GetEventsClient = func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}
+
+		_, err = NewCollector(
+			[]EventBuilder{},
+			c.Configuration,
+			WithLogger(logger),
+			WithSampledRouteStore(&memorySampledRouteStore{
+				loadErr: loadErr,
+			}),
+		)
+		assert.NoError(t, err)
+	}
+
+	first := &recordingLogger{}
+	second := &recordingLogger{}
+	newCollector(first, errors.New("first outage"))
+	newCollector(second, errors.New("second outage"))
+
+	// Each collector logs to its own logger, leaving the agent's as is
+	assert.Equal(t, []string{"Error loading sampled routes: first outage"}, first.errors)
+	assert.Equal(t, []string{"Error loading sampled routes: second outage"}, second.errors)
+	assert.Equal(t, config.DefaultLogger, config.GetLogger())
+}
+
+func TestWithLogger_RejectsNilLogger(t *testing.T) {
+	defer config.SetLogger(nil)
+	config.SetLogger(config.NoopLogger)
+
+	_, err := NewCollector(
+		[]EventBuilder{},
+		&config.Configuration{},
+		WithLogger(nil),
+	)
+	assert.EqualError(t, err, "logger must not be nil")
+	assert.Equal(t, config.NoopLogger, config.GetLogger())
+}
+
+func TestWithEventsURL_OverridesEventsURL(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": []
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Refresh(context.Background()))
+
+	var mu sync.Mutex
+	var urls []string
+	c.Configuration.GetEventsClient = func() *http.Client {
+		return &http.Client{
+			Transport: &test.MockTransport{
+				Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+					mu.Lock()
+					urls = append(urls, req.URL.String())
+					mu.Unlock()
+
+					return &http.Response{
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(nil)),
+					}, nil
+				},
+			},
+		}
+	}
+
+	overridden, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+		WithEventsURL("http://localhost:8080/events"),
+	)
+	assert.NoError(t, err)
+
+	// Collectors sharing the configuration keep the derived endpoint
+	derived, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "https://dev-api.auditr.io/v1/events", c.Configuration.EventsURL)
+	assert.Empty(t, c.Configuration.EventsURLOverride)
+
+	assert.NoError(t, overridden.Warmup(context.Background()))
+	assert.NoError(t, derived.Warmup(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"http://localhost:8080/events",
+		"https://dev-api.auditr.io/v1/events",
+	}, urls)
+}
+
+func TestCollect_SkipsSamplingWhenDisabled(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2,
+				"sampling_enabled": false
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeSample, http.MethodGet, "/person/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.Nil(t, route)
+
+	select {
+	case res := <-collector.Responses():
+		assert.Fail(t, "unexpected response", "%+v", res)
+	default:
+	}
+}
+
+func TestCollect_DropsWhilePaused(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	assert.NoError(t, collector.Pause())
+	// Pausing twice is a no-op
+	assert.NoError(t, collector.Pause())
+	// Flushing while paused is a no-op
+	assert.NoError(t, collector.Flush())
+
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.Equal(t, uint64(1), collector.Stats().EventsPaused)
+
+	select {
+	case res := <-collector.Responses():
+		assert.Fail(t, "unexpected response", "%+v", res)
+	default:
+	}
+
+	assert.NoError(t, collector.Resume())
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	// No builders, so the resumed event fails to build
+	res := <-collector.Responses()
+	assert.Error(t, res.Err)
+	assert.Equal(t, uint64(1), collector.Stats().EventsPaused)
+}
+
+func TestResample_UsesRouteRate(t *testing.T) {
+	always := 1.0
+	never := 0.0
+	r := NewRouter(
+		[]config.Route{},
+		[]config.Route{
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/person/:id",
+				Rate:       &always,
+			},
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/health",
+				Rate:       &never,
+			},
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/order/:id",
+			},
+		},
+	)
+
+	random := 0.3
+	c := &Collector{
+		configuration: &config.Configuration{
+			SampleRate: 0.5,
+		},
+		router: r,
+		random: func() float64 {
+			return random
+		},
+	}
+
+	route, err := r.FindRoute(RouteTypeSample, http.MethodGet, "/person/xyz")
+	assert.NoError(t, err)
+	assert.True(t, c.resample(route))
+
+	route, err = r.FindRoute(RouteTypeSample, http.MethodGet, "/health")
+	assert.NoError(t, err)
+	assert.False(t, c.resample(route))
+
+	// Routes without a rate use the global sample rate
+	route, err = r.FindRoute(RouteTypeSample, http.MethodGet, "/order/123")
+	assert.NoError(t, err)
+	assert.True(t, c.resample(route))
+
+	random = 0.7
+	assert.False(t, c.resample(route))
+
+	// Sampled once by default
+	c.configuration.SampleRate = 0
+	random = 0
+	assert.False(t, c.resample(route))
+}
+
+func TestCollect_TwoPhaseEvents(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2,
+				"sampling_enabled": false,
+				"two_phase_events": true
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{
+					Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: 200,
+							Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+						}, nil
+					},
+				},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	builder := &mockBuilder{
+		fn: func(
+			m *mockBuilder,
+			parentOrgID string,
+			orgIDField string,
+			routeType RouteType,
+			route *config.Route,
+			request interface{},
+			response json.RawMessage,
+			errorValue json.RawMessage,
+		) (*EventRaw, error) {
+			return &EventRaw{
+				RequestID: request.(string),
+				Response:  response,
+			}, nil
+		},
+	}
+
+	collector, err := NewCollector(
+		[]EventBuilder{builder},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	s := collector.Subscribe(SubscriptionOptions{
+		BufferSize: 10,
+		Events:     true,
+	})
+	defer collector.Unsubscribe(s)
+
+	collector.CollectReceived(ctx, http.MethodGet, "/person/xyz", "request-id")
+	event := <-s.Events()
+	assert.Equal(t, EventPhaseReceived, event.Phase)
+	assert.Equal(t, "request-id", event.RequestID)
+	assert.Nil(t, event.Response)
+
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		"request-id",
+		json.RawMessage(`{}`),
+		nil,
+	)
+	event = <-s.Events()
+	assert.Equal(t, EventPhaseCompleted, event.Phase)
+	assert.Equal(t, "request-id", event.RequestID)
+
+	// Untargeted routes are not collected on receipt
+	collector.CollectReceived(ctx, http.MethodGet, "/order/xyz", "other-id")
+
+	// Nor is anything collected on receipt once disabled
+	c.Configuration.TwoPhaseEvents = false
+	collector.CollectReceived(ctx, http.MethodGet, "/person/xyz", "other-id")
+
+	select {
+	case event := <-s.Events():
+		assert.Fail(t, "unexpected event", "%+v", event)
+	default:
+	}
+}
+
+func TestCollect_FiltersByClientIP(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2,
+				"sampling_enabled": false,
+				"audit_cidrs": ["203.0.113.0/24"],
+				"skip_cidrs": ["10.0.0.0/8"]
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{
+					Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: 200,
+							Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+						}, nil
+					},
+				},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	collector, err := NewCollector(
+		[]EventBuilder{&ipBuilder{}},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	s := collector.Subscribe(SubscriptionOptions{
+		BufferSize: 10,
+		Events:     true,
+	})
+	defer collector.Unsubscribe(s)
+
+	// Skipped even though the route is targeted
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		"10.1.2.3",
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	select {
+	case event := <-s.Events():
+		assert.Fail(t, "unexpected event", "%+v", event)
+	default:
+	}
+
+	// Targeted even though the route isn't
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/order/xyz",
+		"/order/{id}",
+		"203.0.113.7",
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	select {
+	case <-s.Events():
+	default:
+		assert.Fail(t, "expected an event")
+	}
+}
+
+func TestBuildEvent_BuildsWithoutPublishing(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Refresh(context.Background()))
+
+	builder := &mockBuilder{
+		fn: func(
+			m *mockBuilder,
+			parentOrgID string,
+			orgIDField string,
+			routeType RouteType,
+			route *config.Route,
+			request interface{},
+			response json.RawMessage,
+			errorValue json.RawMessage,
+		) (*EventRaw, error) {
+			if request == nil {
+				return nil, errors.New("missing request")
+			}
+
+			return &EventRaw{
+				Route: &EventRoute{
+					Type:   routeType,
+					Method: route.HTTPMethod,
+					Path:   route.Path,
+				},
+				Request: request,
+			}, nil
+		},
+	}
+
+	collector, err := NewCollector(
+		[]EventBuilder{builder},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	event, err := collector.BuildEvent(
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		"homer",
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, RouteTypeTarget, event.Route.Type)
+	assert.Equal(t, "/person/:id", event.Route.Path)
+	assert.Equal(t, "homer", event.Request)
+
+	event, err = collector.BuildEvent(
+		http.MethodGet,
+		"/account/xyz",
+		"/account/{id}",
+		"marge",
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, RouteTypeSample, event.Route.Type)
+	assert.Equal(t, "/account/:id", event.Route.Path)
+
+	// The route isn't recorded as sampled
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeSample, http.MethodGet, "/account/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.Nil(t, route)
+
+	_, err = collector.BuildEvent(http.MethodGet, "/person/xyz", "/person/{id}", nil, nil, nil)
+	assert.Error(t, err)
+
+	// Nothing was published
+	assert.Equal(t, uint64(0), event.Sequence)
+	assert.Len(t, collector.Responses(), 0)
+}
+
+func TestWithEventBuilders_FallsThroughToAddedBuilders(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Refresh(context.Background()))
+
+	// Builds string requests only
+	newBuilder := func(name string) *mockBuilder {
+		return &mockBuilder{
+			fn: func(
+				m *mockBuilder,
+				parentOrgID string,
+				orgIDField string,
+				routeType RouteType,
+				route *config.Route,
+				request interface{},
+				response json.RawMessage,
+				errorValue json.RawMessage,
+			) (*EventRaw, error) {
+				if _, ok := request.(string); !ok && name == "string" {
+					return nil, errors.New("request is not a string")
+				}
+
+				return &EventRaw{
+					Route: &EventRoute{
+						Type: routeType,
+						Name: name,
+					},
+				}, nil
+			},
+		}
+	}
+
+	builders := []EventBuilder{newBuilder("string")}
+	collector, err := NewCollector(
+		builders,
+		c.Configuration,
+		WithEventBuilders(newBuilder("any")),
+	)
+	assert.NoError(t, err)
+
+	event, err := collector.BuildEvent(http.MethodGet, "/person/xyz", "/person/{id}", "homer", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "string", event.Route.Name)
+
+	event, err = collector.BuildEvent(http.MethodGet, "/person/xyz", "/person/{id}", 42, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "any", event.Route.Name)
+
+	// The builders of the agent aren't modified
+	assert.Len(t, builders, 1)
+}
+
+func TestCollect_SamplesNormalizedPaths(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2,
+				"strip_trailing_slash": true
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	// Query strings and trailing slashes don't create distinct routes
+	paths := []string{
+		"/search?q=homer",
+		"/search?q=marge",
+		"/search/",
+		"/search/?q=bart",
+	}
+	for _, path := range paths {
+		collector.Collect(
+			ctx,
+			http.MethodGet,
+			path,
+			path,
+			nil,
+			json.RawMessage(`{}`),
+			nil,
+		)
+	}
+
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeSample, http.MethodGet, "/search")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, route)
+	assert.Equal(t, "/search", route.Path)
+
+	// Sampled once; there are no builders so the sample fails to build
+	<-collector.Responses()
+	select {
+	case res := <-collector.Responses():
+		assert.Fail(t, "unexpected response", "%+v", res)
+	default:
+	}
+}
+
+func TestCollect_StampsContextMetadata(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2,
+				"sampling_enabled": false
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{
+					Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: 200,
+							Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+						}, nil
+					},
+				},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	builder := &mockBuilder{
+		fn: func(
+			m *mockBuilder,
+			parentOrgID string,
+			orgIDField string,
+			routeType RouteType,
+			route *config.Route,
+			request interface{},
+			response json.RawMessage,
+			errorValue json.RawMessage,
+		) (*EventRaw, error) {
+			return &EventRaw{
+				RequestID: request.(string),
+			}, nil
+		},
+	}
+
+	collector, err := NewCollector(
+		[]EventBuilder{builder},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	s := collector.Subscribe(SubscriptionOptions{
+		BufferSize: 10,
+		Events:     true,
+	})
+	defer collector.Unsubscribe(s)
+
+	collector.Collect(
+		WithMetadata(ctx, map[string]string{"plan": "pro"}),
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		"request-id",
+		json.RawMessage(`{}`),
+		nil,
+	)
+	event := <-s.Events()
+	assert.Equal(t, map[string]string{"plan": "pro"}, event.Metadata)
+
+	// Events without metadata keep their shape
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		"request-id",
+		json.RawMessage(`{}`),
+		nil,
+	)
+	event = <-s.Events()
+	assert.Nil(t, event.Metadata)
+}
+
+func TestCollect_SamplesNewRouteOnceUnderConcurrency(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "new route",
+			config: `"sample_rate": 0`,
+		},
+		{
+			name:   "sampled again within window",
+			config: `"sample_rate": 1, "sample_window": 60000`,
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := config.NewConfigurer(
+			config.WithConfigProvider(func() ([]byte, error) {
+				return []byte(`{
+					"base_url": "https://dev-api.auditr.io/v1",
+					"events_path": "/events",
+					"target": [],
+					"sample": [],
+					"cache_duration": 2,
+					` + tt.config + `
+				}`), nil
+			}),
+			config.WithFileEventChan(make(chan fsnotify.Event)),
+			config.WithHTTPClient(func() *http.Client {
+				return &http.Client{
+					Transport: &test.MockTransport{},
+				}
+			}),
+		)
+		assert.NoError(t, err, tt.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		assert.NoError(t, c.Refresh(ctx), tt.name)
+
+		collector, err := NewCollector(
+			[]EventBuilder{},
+			c.Configuration,
+		)
+		assert.NoError(t, err, tt.name)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collector.Collect(
+					ctx,
+					http.MethodGet,
+					"/person/xyz",
+					"/person/{id}",
+					nil,
+					json.RawMessage(`{}`),
+					nil,
+				)
+			}()
+		}
+		wg.Wait()
+
+		// Sampled once; there are no builders so the sample fails to build
+		<-collector.Responses()
+		select {
+		case res := <-collector.Responses():
+			assert.Fail(t, "unexpected response", "%s: %+v", tt.name, res)
+		default:
+		}
+
+		cancel()
+	}
+}
+
+// pausingPublisher records pauses and drops of the publisher it wraps
+type pausingPublisher struct {
+	Publisher
+	pauses int
+	drops  int
+}
+
+func (p *pausingPublisher) Pause() error {
+	p.pauses++
+	return p.Publisher.Pause()
+}
+
+func (p *pausingPublisher) DropPaused() bool {
+	p.drops++
+	return p.Publisher.DropPaused()
+}
+
+func TestCollector_UsesPublisherInterface(t *testing.T) {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Refresh(ctx))
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+	)
+	assert.NoError(t, err)
+
+	p := &pausingPublisher{Publisher: collector.publisher}
+	collector.publisher = p
+
+	assert.NoError(t, collector.Pause())
+	collector.Collect(
+		ctx,
+		http.MethodGet,
+		"/person/xyz",
+		"/person/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	assert.Equal(t, 1, p.pauses)
+	assert.Equal(t, 1, p.drops)
+	assert.Equal(t, uint64(1), collector.Stats().EventsPaused)
+}
@@ -0,0 +1,47 @@
+package collect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// ContentEncodingGzip is the content encoding of compressed batches
+const ContentEncodingGzip string = "gzip"
+
+// gzipWriterPool holds gzip writers reused across batches
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// compressesEvents returns true if batches are compressed
+func compressesEvents(configuration *config.Configuration) bool {
+	return configuration.CompressEvents || configuration.CompressionMinBytes > 0
+}
+
+// compress gzips the payload into buf if it exceeds minBytes.
+// Returns the payload to send and its content encoding, which is empty
+// when the payload is sent uncompressed.
+func compress(buf *bytes.Buffer, payload []byte, minBytes int) ([]byte, string) {
+	if len(payload) <= minBytes {
+		return payload, ""
+	}
+
+	w := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(w)
+
+	w.Reset(buf)
+	if _, err := w.Write(payload); err != nil {
+		return payload, ""
+	}
+
+	if err := w.Close(); err != nil {
+		return payload, ""
+	}
+
+	return buf.Bytes(), ContentEncodingGzip
+}
@@ -0,0 +1,188 @@
+package collect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress_Threshold(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"a":1},`), 16)
+
+	tests := []struct {
+		name     string
+		minBytes int
+		encoding string
+	}{
+		{
+			name:     "no min",
+			minBytes: 0,
+			encoding: ContentEncodingGzip,
+		},
+		{
+			name:     "above size",
+			minBytes: len(payload) + 1,
+		},
+		{
+			name:     "at size",
+			minBytes: len(payload),
+		},
+		{
+			name:     "below size",
+			minBytes: len(payload) - 1,
+			encoding: ContentEncodingGzip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			body, encoding := compress(&buf, payload, tt.minBytes)
+			assert.Equal(t, tt.encoding, encoding)
+
+			if encoding == "" {
+				assert.Equal(t, payload, body)
+				return
+			}
+
+			r, err := gzip.NewReader(bytes.NewReader(body))
+			assert.NoError(t, err)
+			decompressed, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, decompressed)
+		})
+	}
+}
+
+func TestSend_CompressesLargeBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		minBytes int
+		encoding string
+	}{
+		{
+			name:     "small batch",
+			minBytes: 1 << 20,
+		},
+		{
+			name:     "large batch",
+			minBytes: 1,
+			encoding: ContentEncodingGzip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoding string
+			var body []byte
+			m := &test.MockTransport{
+				Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+					encoding = req.Header.Get("Content-Encoding")
+
+					reader := req.Body
+					if encoding == ContentEncodingGzip {
+						gz, err := gzip.NewReader(req.Body)
+						assert.NoError(t, err)
+						reader = gz
+					}
+
+					var err error
+					body, err = ioutil.ReadAll(reader)
+					assert.NoError(t, err)
+
+					return &http.Response{
+						StatusCode: 200,
+						Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+					}, nil
+				},
+			}
+
+			r := make(chan Response, DefaultPendingWorkCapacity*2)
+			b := newBatchList(
+				&config.Configuration{
+					CompressionMinBytes: tt.minBytes,
+					GetEventsClient: func() *http.Client {
+						return &http.Client{Transport: m}
+					},
+				},
+				r,
+				DefaultMaxEventsPerBatch,
+				DefaultMaxConcurrentBatches,
+			)
+			b.send([]*EventRaw{
+				{Organization: &EventOrganization{ID: "org-1"}},
+			})
+
+			assert.Equal(t, tt.encoding, encoding)
+			assert.Contains(t, string(body), `"org-1"`)
+
+			res := <-r
+			assert.NoError(t, res.Err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		})
+	}
+}
+
+func TestSend_CompressesEvents(t *testing.T) {
+	var contentTypes []string
+	var encodings []string
+	var bodies [][]byte
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			contentTypes = append(contentTypes, req.Header.Get("Content-Type"))
+			encodings = append(encodings, req.Header.Get("Content-Encoding"))
+
+			reader := req.Body
+			if req.Header.Get("Content-Encoding") == ContentEncodingGzip {
+				gz, err := gzip.NewReader(req.Body)
+				assert.NoError(t, err)
+				reader = gz
+			}
+
+			body, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			bodies = append(bodies, body)
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	configuration := &config.Configuration{
+		GetEventsClient: func() *http.Client {
+			return &http.Client{Transport: m}
+		},
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		configuration,
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+
+	event := &EventRaw{
+		Organization: &EventOrganization{ID: "org-1"},
+		Request:      json.RawMessage(`{"name":"homer"}`),
+	}
+
+	// Uncompressed by default
+	b.send([]*EventRaw{event})
+
+	configuration.CompressEvents = true
+	b.send([]*EventRaw{event})
+
+	assert.Equal(t, []string{"", ContentEncodingGzip}, encodings)
+	assert.Equal(t, []string{"application/json", "application/json"}, contentTypes)
+	assert.Equal(t, string(bodies[0]), string(bodies[1]))
+}
@@ -0,0 +1,243 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	// EncodingJSON encodes batches as a JSON array
+	EncodingJSON string = "json"
+
+	// EncodingMsgpack encodes batches as a msgpack array
+	EncodingMsgpack string = "msgpack"
+)
+
+// EventEncoder encodes events into a batch payload
+type EventEncoder interface {
+	// ContentType is the content type of the batch payload
+	ContentType() string
+
+	// Encode appends a single encoded event to buf.
+	// Returns the size of the encoded event, excluding any delimiter.
+	// buf is left unchanged on error.
+	Encode(buf *bytes.Buffer, event *EventRaw) (int, error)
+
+	// Batch writes the batch payload of n encoded events to w
+	Batch(w *bytes.Buffer, encoded []byte, n int)
+}
+
+// batchEncoder is an encoder that reuses its state across the events
+// of a batch rather than setting it up for each event
+type batchEncoder interface {
+	// newBatch returns an eventBatch appending events to buf
+	newBatch(buf *bytes.Buffer) eventBatch
+}
+
+// eventBatch appends the events of a batch to its buffer as Encode does.
+// It isn't safe for concurrent use, and is released once the batch is encoded.
+type eventBatch interface {
+	encode(event *EventRaw) (int, error)
+	release()
+}
+
+// newEventBatch returns an eventBatch appending events to buf with the
+// encoder, reusing its state across the events if it's a batchEncoder
+func newEventBatch(encoder EventEncoder, buf *bytes.Buffer) eventBatch {
+	if e, ok := encoder.(batchEncoder); ok {
+		return e.newBatch(buf)
+	}
+
+	return &encoderBatch{
+		encoder: encoder,
+		buf:     buf,
+	}
+}
+
+// encoderBatch encodes each event of a batch with Encode
+type encoderBatch struct {
+	encoder EventEncoder
+	buf     *bytes.Buffer
+}
+
+func (b *encoderBatch) encode(event *EventRaw) (int, error) {
+	return b.encoder.Encode(b.buf, event)
+}
+
+func (b *encoderBatch) release() {}
+
+// bufferPool holds buffers reused across batches
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer gets an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to the pool
+func putBuffer(buf *bytes.Buffer) {
+	bufferPool.Put(buf)
+}
+
+// newEventEncoder creates the encoder for the given encoding.
+// Defaults to JSON when the encoding is unknown.
+func newEventEncoder(encoding string) EventEncoder {
+	switch strings.ToLower(encoding) {
+	case EncodingMsgpack:
+		return &msgpackEncoder{}
+	default:
+		return &jsonEncoder{}
+	}
+}
+
+// jsonEncoder encodes events as JSON
+type jsonEncoder struct{}
+
+// ContentType is the content type of the batch payload
+func (e *jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode appends a single event to buf as JSON, comma delimited
+func (e *jsonEncoder) Encode(buf *bytes.Buffer, event *EventRaw) (int, error) {
+	batch := e.newBatch(buf)
+	defer batch.release()
+
+	return batch.encode(event)
+}
+
+// newBatch returns a jsonBatch appending events to buf
+func (e *jsonEncoder) newBatch(buf *bytes.Buffer) eventBatch {
+	batch := jsonBatchPool.Get().(*jsonBatch)
+	batch.buf = buf
+	return batch
+}
+
+// jsonBatchPool holds JSON encoders reused across batches
+var jsonBatchPool = sync.Pool{
+	New: func() interface{} {
+		batch := &jsonBatch{}
+		batch.enc = json.NewEncoder(batch)
+		return batch
+	},
+}
+
+// jsonBatch appends events to its buffer as JSON, comma delimited.
+// One json.Encoder writes every event of the batch straight into the
+// buffer rather than allocating a payload or encoder per event.
+type jsonBatch struct {
+	buf *bytes.Buffer
+	enc *json.Encoder
+}
+
+// Write writes the output of the encoder to the buffer
+func (b *jsonBatch) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+func (b *jsonBatch) encode(event *EventRaw) (int, error) {
+	mark := b.buf.Len()
+	if mark > 0 {
+		b.buf.WriteByte(',')
+	}
+	start := b.buf.Len()
+
+	if err := b.enc.Encode(event); err != nil {
+		b.buf.Truncate(mark)
+		return 0, err
+	}
+
+	// drop the newline written by the encoder
+	b.buf.Truncate(b.buf.Len() - 1)
+
+	return b.buf.Len() - start, nil
+}
+
+// release returns the encoder to the pool
+func (b *jsonBatch) release() {
+	b.buf = nil
+	jsonBatchPool.Put(b)
+}
+
+// Batch writes the encoded events as a JSON array
+func (e *jsonEncoder) Batch(w *bytes.Buffer, encoded []byte, n int) {
+	w.Grow(len(encoded) + 2)
+	w.WriteByte('[')
+	w.Write(encoded)
+	w.WriteByte(']')
+}
+
+// msgpackEncoder encodes events as msgpack.
+// Events are encoded with the same field names as JSON.
+type msgpackEncoder struct{}
+
+// ContentType is the content type of the batch payload
+func (e *msgpackEncoder) ContentType() string {
+	return "application/x-msgpack"
+}
+
+// Encode appends a single event to buf as msgpack
+func (e *msgpackEncoder) Encode(buf *bytes.Buffer, event *EventRaw) (int, error) {
+	// Round trip through JSON so field names, omitempty and
+	// raw JSON requests/responses are encoded as they are in JSON
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return 0, err
+	}
+
+	payload, err = msgpack.Marshal(normalizeNumbers(v))
+	if err != nil {
+		return 0, err
+	}
+
+	return buf.Write(payload)
+}
+
+// Batch writes the encoded events as a msgpack array
+func (e *msgpackEncoder) Batch(w *bytes.Buffer, encoded []byte, n int) {
+	enc := msgpack.NewEncoder(w)
+	// writing to a bytes.Buffer doesn't fail
+	_ = enc.EncodeArrayLen(n)
+	w.Write(encoded)
+}
+
+// normalizeNumbers converts JSON numbers to integers where possible,
+// otherwise to floats
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalizeNumbers(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = normalizeNumbers(val)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,121 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNewEventEncoder(t *testing.T) {
+	assert.IsType(t, &jsonEncoder{}, newEventEncoder(""))
+	assert.IsType(t, &jsonEncoder{}, newEventEncoder(EncodingJSON))
+	assert.IsType(t, &msgpackEncoder{}, newEventEncoder("MsgPack"))
+}
+
+func TestJSONEncoder_Batch(t *testing.T) {
+	e := &jsonEncoder{}
+	events := []*EventRaw{
+		{RequestedAt: 1},
+		{RequestedAt: 2},
+	}
+
+	encoded := bytes.Buffer{}
+	for _, event := range events {
+		_, err := e.Encode(&encoded, event)
+		assert.NoError(t, err)
+	}
+
+	payload := bytes.Buffer{}
+	e.Batch(&payload, encoded.Bytes(), len(events))
+	expected, _ := json.Marshal(events)
+	assert.Equal(t, expected, payload.Bytes())
+
+	empty := bytes.Buffer{}
+	e.Batch(&empty, nil, 0)
+	assert.Equal(t, []byte("[]"), empty.Bytes())
+}
+
+func TestNewEventBatch_ReusesEncoderAcrossEvents(t *testing.T) {
+	events := []*EventRaw{
+		{RequestedAt: 1},
+		{RequestedAt: 2, Response: json.RawMessage(`{"bad"`)},
+		{RequestedAt: 3},
+	}
+
+	for _, e := range []EventEncoder{&jsonEncoder{}, &msgpackEncoder{}} {
+		encoded := bytes.Buffer{}
+		batch := newEventBatch(e, &encoded)
+
+		var sizes []int
+		for _, event := range events {
+			size, err := batch.encode(event)
+			if event.RequestedAt == 2 {
+				// the buffer is left unchanged on error
+				assert.Error(t, err)
+				continue
+			}
+
+			assert.NoError(t, err)
+			sizes = append(sizes, size)
+		}
+		batch.release()
+
+		single := bytes.Buffer{}
+		size, err := e.Encode(&single, events[0])
+		assert.NoError(t, err)
+		assert.Equal(t, size, sizes[0])
+
+		payload := bytes.Buffer{}
+		e.Batch(&payload, encoded.Bytes(), 2)
+		if _, ok := e.(*jsonEncoder); ok {
+			expected, _ := json.Marshal([]*EventRaw{events[0], events[2]})
+			assert.Equal(t, expected, payload.Bytes())
+		}
+	}
+}
+
+func TestMsgpackEncoder_Batch(t *testing.T) {
+	e := &msgpackEncoder{}
+	events := []*EventRaw{
+		{
+			Route: &EventRoute{
+				Type:   RouteTypeTarget,
+				Method: "GET",
+				Path:   "/person/:id",
+			},
+			RequestedAt: 1640995200000,
+			Response:    json.RawMessage(`{"statusCode":200}`),
+		},
+		{
+			RequestedAt: 2,
+		},
+	}
+
+	encoded := bytes.Buffer{}
+	for _, event := range events {
+		_, err := e.Encode(&encoded, event)
+		assert.NoError(t, err)
+	}
+
+	payload := bytes.Buffer{}
+	e.Batch(&payload, encoded.Bytes(), len(events))
+
+	var batch []map[string]interface{}
+	err := msgpack.Unmarshal(payload.Bytes(), &batch)
+	assert.NoError(t, err)
+	assert.Len(t, batch, 2)
+
+	assert.Equal(t, int64(1640995200000), batch[0]["requested_at"])
+	assert.Equal(t, map[string]interface{}{
+		"type":   "target",
+		"method": "GET",
+		"path":   "/person/:id",
+	}, batch[0]["route"])
+	assert.Equal(t, map[string]interface{}{
+		"statusCode": int64(200),
+	}, batch[0]["response"])
+	assert.Equal(t, int64(2), batch[1]["requested_at"])
+}
@@ -0,0 +1,52 @@
+package collect
+
+import (
+	"encoding/json"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrorBody returns the body of a failed response, normalized to JSON.
+// A response fails if its status is at or above the threshold.
+// Returns nil if the threshold is 0, the response didn't fail or
+// it has no body. Bodies that aren't JSON are returned as JSON strings.
+func ErrorBody(
+	response json.RawMessage,
+	statusField string,
+	bodyField string,
+	threshold int,
+) json.RawMessage {
+	if threshold <= 0 || len(response) == 0 {
+		return nil
+	}
+
+	status := gjson.GetBytes(response, statusField)
+	if !status.Exists() || int(status.Int()) < threshold {
+		return nil
+	}
+
+	body := gjson.GetBytes(response, bodyField)
+	if !body.Exists() || body.String() == "" {
+		return nil
+	}
+
+	if body.Type == gjson.String && gjson.Valid(body.String()) {
+		return json.RawMessage(body.String())
+	}
+
+	if body.Type != gjson.String {
+		return json.RawMessage(body.Raw)
+	}
+
+	encoded, err := json.Marshal(body.String())
+	if err != nil {
+		return nil
+	}
+
+	return encoded
+}
+
+// IsEmptyJSON determines whether a raw message is empty or null
+func IsEmptyJSON(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
@@ -0,0 +1,61 @@
+package collect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		threshold int
+		want      json.RawMessage
+	}{
+		{
+			name:      "disabled",
+			response:  `{"statusCode":500,"body":"{\"message\":\"oops\"}"}`,
+			threshold: 0,
+			want:      nil,
+		},
+		{
+			name:      "success",
+			response:  `{"statusCode":200,"body":"{\"id\":1}"}`,
+			threshold: 400,
+			want:      nil,
+		},
+		{
+			name:      "json body",
+			response:  `{"statusCode":500,"body":"{\"message\":\"oops\"}"}`,
+			threshold: 400,
+			want:      json.RawMessage(`{"message":"oops"}`),
+		},
+		{
+			name:      "text body",
+			response:  `{"statusCode":404,"body":"not found"}`,
+			threshold: 400,
+			want:      json.RawMessage(`"not found"`),
+		},
+		{
+			name:      "empty body",
+			response:  `{"statusCode":500,"body":""}`,
+			threshold: 400,
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ErrorBody(json.RawMessage(tt.response), "statusCode", "body", tt.threshold)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIsEmptyJSON(t *testing.T) {
+	assert.True(t, IsEmptyJSON(nil))
+	assert.True(t, IsEmptyJSON(json.RawMessage(`null`)))
+	assert.False(t, IsEmptyJSON(json.RawMessage(`{}`)))
+}
@@ -1,5 +1,11 @@
 package collect
 
+import (
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
 // todo: mv params and responses out of model and ref that here instead
 
 // Event is an audit event
@@ -28,12 +34,43 @@ type EventRaw struct {
 	Organization *EventOrganization `json:"organization"`
 	Agent        *EventAgent        `json:"agent,omitempty"`
 	Route        *EventRoute        `json:"route"`
+	Host         string             `json:"host,omitempty"`
 	User         *EventUser         `json:"user,omitempty"`
 	Client       *EventClient       `json:"client"`
 	RequestedAt  int64              `json:"requested_at"`
 	Request      interface{}        `json:"request"`
+	QueryParams  map[string]string  `json:"query_params,omitempty"`
 	Response     interface{}        `json:"response"`
 	Error        interface{}        `json:"error,omitempty"`
+
+	// ResponseBytes is the size of the response body sent to the client.
+	// The size of the request body is the client's bytes.
+	ResponseBytes int64 `json:"response_bytes,omitempty"`
+
+	// Metadata is app specific context attached to the event
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// InstanceID identifies the agent instance that sent the event
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// Sequence is the order of the event within the agent instance.
+	// Gaps in the sequence indicate dropped events.
+	Sequence uint64 `json:"sequence,omitempty"`
+
+	// Phase is the phase of the request the event was emitted in.
+	// Only set when two phase events are enabled.
+	Phase EventPhase `json:"phase,omitempty"`
+
+	// RequestID correlates the events of each phase of a request
+	RequestID string `json:"request_id,omitempty"`
+
+	// enqueuedAt is when the event was added to the publish queue
+	enqueuedAt time.Time
+
+	// queueFullPolicy and responseFullPolicy override the publisher's
+	// policies for the event's route
+	queueFullPolicy    config.OverflowPolicy
+	responseFullPolicy config.OverflowPolicy
 }
 
 // RouteType describes the type of route; either target or sample
@@ -47,12 +84,28 @@ const (
 	RouteTypeSample RouteType = "sample"
 )
 
+// EventPhase describes the phase of the request an event was emitted in
+type EventPhase string
+
+const (
+	// EventPhaseReceived is emitted when the request is received,
+	// before the handler runs. The event has no response.
+	EventPhaseReceived EventPhase = "received"
+
+	// EventPhaseCompleted is emitted once the handler completes
+	EventPhaseCompleted EventPhase = "completed"
+)
+
 // EventRoute is the route where the event occurred
 // todo: drop "Event"?
 type EventRoute struct {
 	Type   RouteType `json:"type"`
 	Method string    `json:"method"`
 	Path   string    `json:"path"`
+	Name   string    `json:"name,omitempty"`
+
+	// RawPath is the requested path, with masked path params hidden
+	RawPath string `json:"raw_path,omitempty"`
 }
 
 // EventOrganization is the organization of the client
@@ -62,6 +115,32 @@ type EventOrganization struct {
 	Name string `json:"name,omitempty"`
 }
 
+const (
+	// AgentName is the name of this agent
+	AgentName = "auditr-agent-go"
+
+	// AgentTypeLambda is the agent type of the AWS Lambda wrapper
+	AgentTypeLambda = "aws-lambda"
+
+	// AgentTypeGorilla is the agent type of the gorilla/mux wrapper
+	AgentTypeGorilla = "gorilla"
+
+	// AgentTypeHTTP is the agent type of the net/http wrapper
+	AgentTypeHTTP = "net-http"
+
+	// AgentTypeGin is the agent type of the gin-gonic/gin wrapper
+	AgentTypeGin = "gin"
+
+	// AgentTypeEcho is the agent type of the labstack/echo wrapper
+	AgentTypeEcho = "echo"
+
+	// AgentTypeChi is the agent type of the go-chi/chi wrapper
+	AgentTypeChi = "chi"
+
+	// AgentTypeGRPC is the agent type of the gRPC interceptor
+	AgentTypeGRPC = "grpc"
+)
+
 // EventAgent is the agent sending the event
 // https://github.com/elastic/ecs/blob/1.9/code/go/ecs/agent.go
 type EventAgent struct {
@@ -78,8 +157,37 @@ type EventUser struct {
 	FullName string `json:"full_name,omitempty"`
 	Name     string `json:"name,omitempty"`
 	Domain   string `json:"domain,omitempty"`
+
+	// Roles are the groups or roles the user belongs to
+	Roles []string `json:"roles,omitempty"`
+
+	// Scopes are the scopes granted to the user's token
+	Scopes []string `json:"scopes,omitempty"`
+
+	// AuthType is how the user authenticated
+	AuthType AuthType `json:"auth_type,omitempty"`
 }
 
+// AuthType describes how the user authenticated
+type AuthType string
+
+const (
+	// AuthTypeCognito is a Cognito user pool token
+	AuthTypeCognito AuthType = "cognito"
+
+	// AuthTypeCustom is a custom authorizer principal
+	AuthTypeCustom AuthType = "custom"
+
+	// AuthTypeIAM is an IAM user or role
+	AuthTypeIAM AuthType = "iam"
+
+	// AuthTypeJWT is a JWT from any other identity provider
+	AuthTypeJWT AuthType = "jwt"
+
+	// AuthTypeNone is an unauthenticated request
+	AuthTypeNone AuthType = "none"
+)
+
 // EventClient is the client originating the event
 // https://github.com/elastic/ecs/blob/1.9/code/go/ecs/client.go
 type EventClient struct {
@@ -89,4 +197,12 @@ type EventClient struct {
 	Domain  string `json:"domain,omitempty"`
 	IP      string `json:"ip,omitempty"`
 	Port    int    `json:"port,omitempty"`
+
+	// TLSVersion and TLSCipher describe the TLS connection of the client
+	TLSVersion string `json:"tls_version,omitempty"`
+	TLSCipher  string `json:"tls_cipher,omitempty"`
+
+	// ClientCertSubject is the subject of the client certificate
+	// of a mutual TLS connection
+	ClientCertSubject string `json:"client_cert_subject,omitempty"`
 }
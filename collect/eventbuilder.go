@@ -6,12 +6,16 @@ import (
 	"github.com/auditr-io/auditr-agent-go/config"
 )
 
-// EventBuilder builds an event from the given parameters
+// EventBuilder builds an event from the given parameters.
+//
+// Build takes the configuration rather than the parent org ID and org ID
+// field, so builders can read any setting of the configuration. Builders
+// written against the previous signature migrate by reading
+// configuration.ParentOrgID and configuration.OrgIDField instead.
 type EventBuilder interface {
 	// Build builds an event from the given parameters
 	Build(
-		parentOrgID string,
-		orgIDField string,
+		configuration *config.Configuration,
 		routeType RouteType,
 		route *config.Route,
 		request interface{},
@@ -0,0 +1,73 @@
+package collect
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFlushBackoff is the default initial delay between flushes
+	// once sends start failing
+	DefaultFlushBackoff time.Duration = 100 * time.Millisecond
+
+	// DefaultFlushBackoffMax is the default max delay between flushes
+	DefaultFlushBackoffMax time.Duration = 10 * time.Second
+)
+
+// flushBackoff spaces out flushes on each request while sends are
+// failing, so a down backend doesn't cause a flush on every request.
+// Skipped events stay queued and are sent on the send interval.
+type flushBackoff struct {
+	lock        sync.Mutex
+	lastFlushed time.Time
+	now         func() time.Time
+}
+
+// newFlushBackoff creates a new flush backoff
+func newFlushBackoff() *flushBackoff {
+	return &flushBackoff{
+		now: time.Now,
+	}
+}
+
+// allow determines whether to flush given the number of consecutive
+// failed sends. Records the flush if allowed.
+func (f *flushBackoff) allow(
+	failures uint,
+	initial time.Duration,
+	max time.Duration,
+) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	now := f.now()
+	if failures > 0 && now.Sub(f.lastFlushed) < backoffDelay(failures, initial, max) {
+		return false
+	}
+
+	f.lastFlushed = now
+	return true
+}
+
+// backoffDelay doubles the initial delay for every consecutive failure
+// after the first, up to the max
+func backoffDelay(failures uint, initial time.Duration, max time.Duration) time.Duration {
+	if initial <= 0 {
+		initial = DefaultFlushBackoff
+	}
+
+	if max <= 0 {
+		max = DefaultFlushBackoffMax
+	}
+
+	delay := initial
+	for i := uint(1); i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		return max
+	}
+
+	return delay
+}
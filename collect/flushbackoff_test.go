@@ -0,0 +1,42 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushBackoff(t *testing.T) {
+	now := time.Now()
+	f := newFlushBackoff()
+	f.now = func() time.Time {
+		return now
+	}
+
+	initial := 100 * time.Millisecond
+	max := time.Second
+
+	// Always flushes while sends succeed
+	assert.True(t, f.allow(0, initial, max))
+	assert.True(t, f.allow(0, initial, max))
+
+	// Skips flushes within the delay once sends fail
+	assert.False(t, f.allow(1, initial, max))
+	now = now.Add(initial)
+	assert.True(t, f.allow(1, initial, max))
+
+	// The delay doubles on consecutive failures
+	now = now.Add(initial)
+	assert.False(t, f.allow(2, initial, max))
+	now = now.Add(initial)
+	assert.True(t, f.allow(2, initial, max))
+}
+
+func TestBackoffDelay(t *testing.T) {
+	assert.Equal(t, DefaultFlushBackoff, backoffDelay(1, 0, 0))
+	assert.Equal(t, 100*time.Millisecond, backoffDelay(1, 100*time.Millisecond, time.Second))
+	assert.Equal(t, 400*time.Millisecond, backoffDelay(3, 100*time.Millisecond, time.Second))
+	assert.Equal(t, time.Second, backoffDelay(10, 100*time.Millisecond, time.Second))
+	assert.Equal(t, DefaultFlushBackoffMax, backoffDelay(1000, 0, 0))
+}
@@ -0,0 +1,160 @@
+package collect_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// receivedBatch is a batch of events received by the events server
+type receivedBatch struct {
+	header http.Header
+	events []map[string]interface{}
+}
+
+func TestCollect_SendsToEventsServer(t *testing.T) {
+	var lock sync.Mutex
+	batches := []receivedBatch{}
+	newConns := 0
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			defer r.Body.Close()
+
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+
+			var events []map[string]interface{}
+			assert.NoError(t, json.Unmarshal(body, &events))
+
+			lock.Lock()
+			batches = append(batches, receivedBatch{
+				header: r.Header.Clone(),
+				events: events,
+			})
+			lock.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"status":200}]`))
+		},
+	))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			newConns++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(fmt.Sprintf(`{
+				"base_url": "%s/v1",
+				"events_path": "/events",
+				"parent_org_id": "parent-org-id",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"flush": true,
+				"cache_duration": 60,
+				"block_on_response": true
+			}`, server.URL)), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return server.Client()
+		}),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, configurer.Refresh(ctx))
+
+	collector, err := collect.NewCollector(
+		[]collect.EventBuilder{
+			&lambda.APIGatewayEventBuilder{},
+		},
+		configurer.Configuration,
+	)
+	assert.NoError(t, err)
+
+	for _, id := range []string{"abc", "xyz"} {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod: http.MethodGet,
+			Resource:   "/person/{id}",
+			Path:       "/person/" + id,
+			RequestContext: events.APIGatewayProxyRequestContext{
+				Identity: events.APIGatewayRequestIdentity{
+					SourceIP: "1.2.3.4",
+				},
+			},
+		}
+
+		collector.Collect(
+			ctx,
+			req.HTTPMethod,
+			req.Path,
+			req.Resource,
+			req,
+			json.RawMessage(`{"statusCode":200}`),
+			nil,
+		)
+
+		res := <-collector.Responses()
+		assert.NoError(t, res.Err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Len(t, batches, 2)
+	for i, id := range []string{"abc", "xyz"} {
+		batch := batches[i]
+
+		assert.Equal(t, "application/json", batch.header.Get("Content-Type"))
+		assert.Contains(t, batch.header.Get("User-Agent"), "auditr-agent-go/")
+		// batches are sent uncompressed
+		assert.Empty(t, batch.header.Get("Content-Encoding"))
+
+		assert.Len(t, batch.events, 1)
+		event := batch.events[0]
+		assert.Equal(t, map[string]interface{}{
+			"id": "parent-org-id",
+		}, event["organization"])
+		assert.Equal(t, map[string]interface{}{
+			"type":     "target",
+			"method":   http.MethodGet,
+			"path":     "/person/:id",
+			"raw_path": "/person/" + id,
+		}, event["route"])
+		assert.Equal(t, map[string]interface{}{
+			"ip": "1.2.3.4",
+		}, event["client"])
+		assert.Equal(t, "/person/"+id, event["request"].(map[string]interface{})["path"])
+		assert.Equal(t, map[string]interface{}{
+			"statusCode": float64(200),
+		}, event["response"])
+	}
+
+	// The connection to the events server is reused across batches
+	assert.Equal(t, 1, newConns)
+}
@@ -0,0 +1,319 @@
+package collect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// DefaultJWKSCacheDuration is how long fetched signing keys are cached
+	DefaultJWKSCacheDuration time.Duration = time.Hour
+
+	// DefaultJWKSTimeout is the max duration to wait for the signing keys
+	DefaultJWKSTimeout time.Duration = 5 * time.Second
+
+	// jwksMinRefresh is the min interval between fetches of the signing
+	// keys when a token is signed by an unknown key
+	jwksMinRefresh time.Duration = time.Minute
+)
+
+// ErrInvalidJWT is returned when a JWT fails validation
+var ErrInvalidJWT = errors.New("invalid jwt")
+
+// jwtAlgorithms are the accepted signature algorithms. Only asymmetric
+// algorithms are accepted since the keys are public.
+var jwtAlgorithms = map[string]struct{}{
+	string(jose.RS256): {},
+	string(jose.RS384): {},
+	string(jose.RS512): {},
+	string(jose.PS256): {},
+	string(jose.PS384): {},
+	string(jose.PS512): {},
+	string(jose.ES256): {},
+	string(jose.ES384): {},
+	string(jose.ES512): {},
+	string(jose.EdDSA): {},
+}
+
+// JWKS validates JWTs against the signing keys published at a JWKS URL.
+// The keys are cached and fetched again once expired, or when a token is
+// signed by an unknown key.
+type JWKS struct {
+	url           string
+	audiences     []string
+	client        *http.Client
+	cacheDuration time.Duration
+
+	lock      sync.Mutex
+	keys      map[string]jose.JSONWebKey
+	fetchedAt time.Time
+
+	// fetching is closed once the fetch in flight completes
+	fetching chan struct{}
+
+	now func() time.Time
+}
+
+// NewJWKS creates a validator for the signing keys published at the URL.
+// If audiences are set, tokens must be issued to one of the audiences.
+func NewJWKS(url string, audiences []string) *JWKS {
+	return &JWKS{
+		url:       url,
+		audiences: audiences,
+		client: &http.Client{
+			Timeout: DefaultJWKSTimeout,
+		},
+		cacheDuration: DefaultJWKSCacheDuration,
+		now:           time.Now,
+	}
+}
+
+// URL returns the JWKS URL the signing keys are fetched from
+func (j *JWKS) URL() string {
+	return j.url
+}
+
+// Claims validates the signature, audience, expiry and not before time
+// of the JWT and returns its claims. A leading "Bearer " prefix is ignored.
+func (j *JWKS) Claims(token string) (map[string]interface{}, error) {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+	}
+
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w: expected a single signature", ErrInvalidJWT)
+	}
+
+	header := tok.Headers[0]
+	if _, ok := jwtAlgorithms[header.Algorithm]; !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidJWT, header.Algorithm)
+	}
+
+	key, err := j.key(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var registered jwt.Claims
+	var claims map[string]interface{}
+	if err := tok.Claims(key.Key, &registered, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+	}
+
+	if err := registered.ValidateWithLeeway(jwt.Expected{Time: j.now()}, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+	}
+
+	if !j.acceptsAudience(registered.Audience) {
+		return nil, fmt.Errorf("%w: invalid audience", ErrInvalidJWT)
+	}
+
+	return claims, nil
+}
+
+// acceptsAudience returns true if no audiences are set, or the token
+// is issued to one of the audiences
+func (j *JWKS) acceptsAudience(audience jwt.Audience) bool {
+	if len(j.audiences) == 0 {
+		return true
+	}
+
+	for _, aud := range j.audiences {
+		if audience.Contains(aud) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// key returns the signing key of the key ID, fetching the keys
+// if they're expired or the key ID is unknown. The keys are fetched
+// outside the lock, so tokens of cached keys are validated meanwhile.
+func (j *JWKS) key(kid string) (jose.JSONWebKey, error) {
+	j.lock.Lock()
+
+	now := j.now()
+	expired := j.keys == nil || now.Sub(j.fetchedAt) >= j.cacheDuration
+	key, ok := j.keys[kid]
+	if !expired && ok {
+		j.lock.Unlock()
+		return key, nil
+	}
+
+	if expired || now.Sub(j.fetchedAt) >= jwksMinRefresh {
+		if j.fetching == nil {
+			fetching := make(chan struct{})
+			j.fetching = fetching
+			j.lock.Unlock()
+
+			keys, err := j.fetch()
+
+			j.lock.Lock()
+			if err != nil {
+				// Keep validating with the previous keys
+				config.Warnf("error fetching jwks: %v", err)
+			} else {
+				j.keys = keys
+				j.fetchedAt = now
+			}
+			j.fetching = nil
+			close(fetching)
+		} else if !ok {
+			// Wait for the keys being fetched
+			fetching := j.fetching
+			j.lock.Unlock()
+			<-fetching
+			j.lock.Lock()
+		}
+	}
+
+	key, ok = j.keys[kid]
+	j.lock.Unlock()
+	if !ok {
+		return jose.JSONWebKey{}, fmt.Errorf("%w: unknown signing key %s", ErrInvalidJWT, kid)
+	}
+
+	return key, nil
+}
+
+// fetch fetches the signing keys by key ID
+func (j *JWKS) fetch() (map[string]jose.JSONWebKey, error) {
+	res, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected jwks status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jose.JSONWebKey, len(set.Keys))
+	for _, raw := range set.Keys {
+		var k jose.JSONWebKey
+		if err := k.UnmarshalJSON(raw); err != nil {
+			// Skip keys we can't use rather than failing the whole set
+			continue
+		}
+
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		if !k.Valid() || !k.IsPublic() {
+			continue
+		}
+
+		keys[k.KeyID] = k
+	}
+
+	return keys, nil
+}
+
+// IssuerJWKS validates JWTs against the signing keys published by their
+// issuer at <iss>/.well-known/jwks.json, as OIDC providers such as Cognito
+// do. Only tokens of the trusted issuers are validated, since anyone can
+// publish keys for an issuer of their own.
+type IssuerJWKS struct {
+	issuers   map[string]struct{}
+	audiences []string
+	client    *http.Client
+
+	lock    sync.Mutex
+	keySets map[string]*JWKS
+
+	now func() time.Time
+}
+
+// NewIssuerJWKS creates a validator for tokens of the trusted issuers,
+// e.g. https://cognito-idp.us-west-2.amazonaws.com/us-west-2_abc.
+// If audiences are set, tokens must be issued to one of the audiences.
+func NewIssuerJWKS(issuers []string, audiences []string) *IssuerJWKS {
+	j := &IssuerJWKS{
+		issuers:   make(map[string]struct{}, len(issuers)),
+		audiences: audiences,
+		client: &http.Client{
+			Timeout: DefaultJWKSTimeout,
+		},
+		keySets: make(map[string]*JWKS),
+		now:     time.Now,
+	}
+
+	for _, issuer := range issuers {
+		j.issuers[strings.TrimSuffix(issuer, "/")] = struct{}{}
+	}
+
+	return j
+}
+
+// Trusts returns true if the issuers are exactly the trusted issuers
+func (j *IssuerJWKS) Trusts(issuers []string) bool {
+	trusted := make(map[string]struct{}, len(issuers))
+	for _, issuer := range issuers {
+		issuer = strings.TrimSuffix(issuer, "/")
+		if _, ok := j.issuers[issuer]; !ok {
+			return false
+		}
+
+		trusted[issuer] = struct{}{}
+	}
+
+	return len(trusted) == len(j.issuers)
+}
+
+// Claims validates the JWT against the signing keys of its issuer
+// and returns its claims. A leading "Bearer " prefix is ignored.
+func (j *IssuerJWKS) Claims(token string) (map[string]interface{}, error) {
+	claims, err := DecodeJWTClaims(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWT, err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	iss = strings.TrimSuffix(iss, "/")
+	if _, ok := j.issuers[iss]; !ok {
+		return nil, fmt.Errorf("%w: untrusted issuer %s", ErrInvalidJWT, iss)
+	}
+
+	return j.keySet(iss).Claims(token)
+}
+
+// keySet returns the signing keys of the issuer. The keys of each issuer
+// are cached and refetched like those of a JWKS URL.
+func (j *IssuerJWKS) keySet(iss string) *JWKS {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	keySet, ok := j.keySets[iss]
+	if !ok {
+		keySet = NewJWKS(iss+"/.well-known/jwks.json", j.audiences)
+		keySet.client = j.client
+		keySet.now = j.now
+		j.keySets[iss] = keySet
+	}
+
+	return keySet
+}
@@ -0,0 +1,330 @@
+package collect
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func signJWT(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{
+		"alg": alg,
+		"kid": kid,
+	})
+	assert.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestJWKS_Claims(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": "rsa",
+					"kty": "RSA",
+					"use": "sig",
+					"n":   encodeBigInt(rsaKey.N),
+					"e":   encodeBigInt(big.NewInt(int64(rsaKey.E))),
+				},
+				{
+					"kid": "ec",
+					"kty": "EC",
+					"crv": "P-256",
+					"x":   encodeBigInt(ecKey.X),
+					"y":   encodeBigInt(ecKey.Y),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	j := NewJWKS(server.URL, nil)
+	j.now = func() time.Time {
+		return now
+	}
+
+	claims := map[string]interface{}{
+		"sub": "user-id",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	got, err := j.Claims(signJWT(t, "RS256", "rsa", rsaKey, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", got["sub"])
+
+	got, err = j.Claims("Bearer " + signJWT(t, "ES256", "ec", ecKey, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", got["sub"])
+
+	// Keys are cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// Signed by the wrong key
+	_, err = j.Claims(signJWT(t, "RS256", "rsa", otherKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	// Unknown keys are only fetched again after a while
+	_, err = j.Claims(signJWT(t, "RS256", "other", otherKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	now = now.Add(jwksMinRefresh)
+	_, err = j.Claims(signJWT(t, "RS256", "other", otherKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// Unsigned tokens are never valid
+	_, err = j.Claims(signJWT(t, "none", "rsa", rsaKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	_, err = j.Claims("not-a-jwt")
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	// Expired
+	now = now.Add(2 * time.Hour)
+	_, err = j.Claims(signJWT(t, "RS256", "rsa", rsaKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestIssuerJWKS_Claims(t *testing.T) {
+	issuer := "https://cognito-idp.us-west-2.amazonaws.com/us-west-2_abc"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rotatedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	keys := map[string]*rsa.PrivateKey{
+		"key": key,
+	}
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			var set []map[string]string
+			for kid, k := range keys {
+				set = append(set, map[string]string{
+					"kid": kid,
+					"kty": "RSA",
+					"n":   encodeBigInt(k.N),
+					"e":   encodeBigInt(big.NewInt(int64(k.E))),
+				})
+			}
+
+			body, err := json.Marshal(map[string]interface{}{
+				"keys": set,
+			})
+			assert.NoError(t, err)
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.MatchedBy(func(req *http.Request) bool {
+			return req.URL.String() == issuer+"/.well-known/jwks.json"
+		})).
+		Return(mock.AnythingOfType("*http.Response"), nil)
+
+	now := time.Now()
+	j := NewIssuerJWKS([]string{issuer + "/"}, nil)
+	j.client = &http.Client{
+		Transport: m,
+	}
+	j.now = func() time.Time {
+		return now
+	}
+
+	claims := map[string]interface{}{
+		"sub": "user-id",
+		"iss": issuer,
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	got, err := j.Claims(signJWT(t, "RS256", "key", key, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", got["sub"])
+
+	// Keys are cached
+	_, err = j.Claims(signJWT(t, "RS256", "key", key, claims))
+	assert.NoError(t, err)
+	m.AssertNumberOfCalls(t, "RoundTrip", 1)
+
+	// Rotated keys are fetched once the unknown key may be refetched
+	keys["rotated"] = rotatedKey
+	now = now.Add(jwksMinRefresh)
+	claims["exp"] = now.Add(time.Hour).Unix()
+	_, err = j.Claims(signJWT(t, "RS256", "rotated", rotatedKey, claims))
+	assert.NoError(t, err)
+	m.AssertNumberOfCalls(t, "RoundTrip", 2)
+
+	// Keys of other issuers are never fetched
+	claims["iss"] = "https://attacker.example.com"
+	_, err = j.Claims(signJWT(t, "RS256", "key", key, claims))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+	m.AssertNumberOfCalls(t, "RoundTrip", 2)
+
+	assert.True(t, j.Trusts([]string{issuer}))
+	assert.False(t, j.Trusts([]string{issuer, "https://attacker.example.com"}))
+}
+
+func TestJWKS_ValidatesAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": "key",
+					"kty": "RSA",
+					"n":   encodeBigInt(key.N),
+					"e":   encodeBigInt(big.NewInt(int64(key.E))),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	j := NewJWKS(server.URL, []string{"api", "admin"})
+
+	got, err := j.Claims(signJWT(t, "RS256", "key", key, map[string]interface{}{
+		"sub": "user-id",
+		"aud": []string{"web", "admin"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", got["sub"])
+
+	_, err = j.Claims(signJWT(t, "RS256", "key", key, map[string]interface{}{
+		"sub": "user-id",
+		"aud": "web",
+	}))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+
+	_, err = j.Claims(signJWT(t, "RS256", "key", key, map[string]interface{}{
+		"sub": "user-id",
+	}))
+	assert.ErrorIs(t, err, ErrInvalidJWT)
+}
+
+func TestJWKS_ValidatesCachedKeysWhileFetching(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var fetches int32
+	fetching := make(chan struct{})
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			close(fetching)
+			<-released
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kid": "key",
+					"kty": "RSA",
+					"n":   encodeBigInt(key.N),
+					"e":   encodeBigInt(big.NewInt(int64(key.E))),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	j := NewJWKS(server.URL, nil)
+	j.now = func() time.Time {
+		return now
+	}
+
+	claims := map[string]interface{}{
+		"sub": "user-id",
+	}
+	_, err = j.Claims(signJWT(t, "RS256", "key", key, claims))
+	assert.NoError(t, err)
+
+	// An unknown key refetches the keys, which hangs
+	now = now.Add(jwksMinRefresh)
+	unknown := make(chan error, 1)
+	go func() {
+		_, err := j.Claims(signJWT(t, "RS256", "other", otherKey, claims))
+		unknown <- err
+	}()
+	<-fetching
+
+	// Tokens of cached keys don't wait for the fetch
+	done := make(chan error, 1)
+	go func() {
+		_, err := j.Claims(signJWT(t, "RS256", "key", key, claims))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "validation waited for the jwks fetch")
+	}
+
+	close(released)
+	assert.ErrorIs(t, <-unknown, ErrInvalidJWT)
+}
@@ -0,0 +1,30 @@
+package collect
+
+import (
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// logger logs the messages of a collector at the agent's log level.
+// The zero value logs to the agent's logger, as set with config.SetLogger.
+type logger struct {
+	logger config.Logger
+}
+
+// debugf logs a debug message
+func (l logger) debugf(format string, v ...interface{}) {
+	config.Logf(l.logger, config.LogLevelDebug, format, v...)
+}
+
+// warnf logs a warning
+func (l logger) warnf(format string, v ...interface{}) {
+	config.Logf(l.logger, config.LogLevelWarn, format, v...)
+}
+
+// withPublisherLog logs the messages of the publisher and its batches
+// with the log of the collector
+func withPublisherLog(log logger) PublisherOption {
+	return func(p *EventPublisher) error {
+		p.log = log
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package collect
+
+import "context"
+
+// metadataKey is the context key of the event metadata
+type metadataKey struct{}
+
+// WithMetadata returns a copy of the context carrying app specific
+// metadata, e.g. feature flags or experiment IDs, to attach to the event
+// of the request. Metadata already in the context is kept unless
+// overwritten by the same key.
+func WithMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	existing := MetadataFromContext(ctx)
+	merged := make(map[string]string, len(existing)+len(metadata))
+	for k, v := range existing {
+		merged[k] = v
+	}
+
+	for k, v := range metadata {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the event metadata carried by the context
+func MetadataFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+
+	metadata, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return metadata
+}
+
+// stampMetadata adds the metadata to the event. Metadata mapped by the
+// event builder, e.g. from an authorizer context, takes precedence.
+func stampMetadata(event *EventRaw, metadata map[string]string) {
+	if len(metadata) == 0 {
+		return
+	}
+
+	if event.Metadata == nil {
+		event.Metadata = make(map[string]string, len(metadata))
+	}
+
+	for k, v := range metadata {
+		if _, ok := event.Metadata[k]; !ok {
+			event.Metadata[k] = v
+		}
+	}
+}
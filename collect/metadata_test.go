@@ -0,0 +1,49 @@
+package collect
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMetadata_Merges(t *testing.T) {
+	assert.Nil(t, MetadataFromContext(context.Background()))
+
+	ctx := WithMetadata(context.Background(), map[string]string{
+		"plan":       "free",
+		"experiment": "a",
+	})
+	child := WithMetadata(ctx, map[string]string{
+		"plan": "pro",
+	})
+
+	assert.Equal(t, map[string]string{
+		"plan":       "pro",
+		"experiment": "a",
+	}, MetadataFromContext(child))
+
+	// The parent context is unchanged
+	assert.Equal(t, map[string]string{
+		"plan":       "free",
+		"experiment": "a",
+	}, MetadataFromContext(ctx))
+}
+
+func TestStampMetadata_KeepsMappedValues(t *testing.T) {
+	event := &EventRaw{}
+	stampMetadata(event, nil)
+	assert.Nil(t, event.Metadata)
+
+	event.Metadata = map[string]string{
+		"tenant": "mapped",
+	}
+	stampMetadata(event, map[string]string{
+		"tenant": "context",
+		"flag":   "on",
+	})
+	assert.Equal(t, map[string]string{
+		"tenant": "mapped",
+		"flag":   "on",
+	}, event.Metadata)
+}
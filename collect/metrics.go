@@ -0,0 +1,51 @@
+package collect
+
+import "errors"
+
+// Metric names a counter reported to Metrics
+type Metric string
+
+const (
+	// MetricEventsQueued counts events added to the publish queue
+	MetricEventsQueued Metric = "events_queued"
+
+	// MetricEventsDropped counts events dropped before they were sent,
+	// e.g. due to a full queue, their age, size or org's rate limit
+	MetricEventsDropped Metric = "events_dropped"
+
+	// MetricBatchesSent counts batches successfully sent
+	MetricBatchesSent Metric = "batches_sent"
+
+	// MetricBatchSendErrors counts batches that failed to send
+	MetricBatchSendErrors Metric = "batch_send_errors"
+
+	// MetricOverflowEvents counts events reenqueued because their
+	// batch exceeded the max batch bytes
+	MetricOverflowEvents Metric = "overflow_events"
+)
+
+// Metrics receives the counters of the publisher as they change,
+// e.g. to export them to a metrics backend. Count is called from the
+// publishing goroutines, so it must be safe for concurrent use and
+// should not block.
+type Metrics interface {
+	Count(metric Metric, delta uint64)
+}
+
+// noopMetrics discards the counters
+type noopMetrics struct{}
+
+// Count discards the counter
+func (noopMetrics) Count(Metric, uint64) {}
+
+// WithMetrics reports the publisher's counters to the metrics
+func WithMetrics(metrics Metrics) PublisherOption {
+	return func(p *EventPublisher) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		p.stats.metrics = metrics
+		return nil
+	}
+}
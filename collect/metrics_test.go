@@ -0,0 +1,108 @@
+package collect
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/facebookgo/muster"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingMetrics struct {
+	lock   sync.Mutex
+	counts map[Metric]uint64
+}
+
+func (m *countingMetrics) Count(metric Metric, delta uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.counts == nil {
+		m.counts = map[Metric]uint64{}
+	}
+	m.counts[metric] += delta
+}
+
+func (m *countingMetrics) count(metric Metric) uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.counts[metric]
+}
+
+func TestWithMetrics_CountsQueueOverflow(t *testing.T) {
+	work := make(chan interface{}, 1)
+	p := &EventPublisher{
+		muster: &muster.Client{
+			Work: work,
+		},
+		responses: make(chan Response, 1),
+		stats:     newStatsAggregator(),
+	}
+
+	metrics := &countingMetrics{}
+	assert.NoError(t, WithMetrics(metrics)(p))
+	assert.Error(t, WithMetrics(nil)(p))
+
+	p.Add(&EventRaw{})
+	assert.Equal(t, uint64(1), metrics.count(MetricEventsQueued))
+	assert.Equal(t, uint64(0), metrics.count(MetricEventsDropped))
+
+	// The queue is full
+	p.Add(&EventRaw{})
+	assert.Equal(t, uint64(1), metrics.count(MetricEventsQueued))
+	assert.Equal(t, uint64(1), metrics.count(MetricEventsDropped))
+	assert.Equal(t, uint64(1), p.Stats().EventsDropped)
+
+	res := <-p.responses
+	assert.EqualError(t, res.Err, "Queue overflow")
+}
+
+func TestWithMetrics_CountsOverflowEvents(t *testing.T) {
+	small := &EventRaw{RequestID: "small"}
+	size, err := newEventEncoder(EncodingJSON).Encode(&bytes.Buffer{}, small)
+	assert.NoError(t, err)
+
+	// Room for 2 small events in a batch, including the envelope
+	b := newBatchList(
+		&config.Configuration{
+			MaxEventBytes:   size,
+			MaxBatchBytes:   2 + 2*(size+1),
+			GetEventsClient: func() *http.Client { return &http.Client{} },
+		},
+		make(chan Response, 10),
+		DefaultMaxEventsPerBatch,
+		1,
+	)
+
+	metrics := &countingMetrics{}
+	b.stats = newStatsAggregator()
+	b.stats.metrics = metrics
+
+	payload, numEncoded := b.encode([]*EventRaw{small, small, small, small})
+	defer putBuffer(payload)
+
+	assert.Equal(t, 2, numEncoded)
+	assert.Equal(t, uint64(2), metrics.count(MetricOverflowEvents))
+	assert.Equal(t, uint64(2), b.stats.snapshot().EventsOverflowed)
+}
+
+func TestStatsAggregator_ReportsMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+	s := newStatsAggregator()
+	s.metrics = metrics
+
+	s.batchSent(5, 100, 10)
+	s.batchFailed()
+	s.batchShortCircuited()
+	s.eventExpired()
+	s.eventRateLimited()
+	s.eventOversized()
+
+	assert.Equal(t, uint64(1), metrics.count(MetricBatchesSent))
+	assert.Equal(t, uint64(2), metrics.count(MetricBatchSendErrors))
+	assert.Equal(t, uint64(3), metrics.count(MetricEventsDropped))
+}
@@ -0,0 +1,59 @@
+package collect
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MinifyJSON compacts a JSON string by removing insignificant whitespace.
+// Strings that aren't valid JSON are returned untouched.
+func MinifyJSON(s string) string {
+	if s == "" {
+		return s
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		return s
+	}
+
+	return buf.String()
+}
+
+// MinifyJSONField compacts the JSON string held in the given field
+// of a JSON object. If the object or the field can't be parsed,
+// the original raw message is returned untouched.
+func MinifyJSONField(raw json.RawMessage, field string) json.RawMessage {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return raw
+	}
+
+	fieldRaw, ok := obj[field]
+	if !ok {
+		return raw
+	}
+
+	var value string
+	if err := json.Unmarshal(fieldRaw, &value); err != nil {
+		return raw
+	}
+
+	minified := MinifyJSON(value)
+	if minified == value {
+		return raw
+	}
+
+	fieldRaw, err := json.Marshal(minified)
+	if err != nil {
+		return raw
+	}
+	obj[field] = fieldRaw
+
+	minifiedRaw, err := json.Marshal(obj)
+	if err != nil {
+		return raw
+	}
+
+	return minifiedRaw
+}
@@ -0,0 +1,37 @@
+package collect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinifyJSON(t *testing.T) {
+	assert.Equal(t, `{"hi":"you","n":[1,2]}`, MinifyJSON(`{
+		"hi": "you",
+		"n": [1, 2]
+	}`))
+	assert.Equal(t, "not json", MinifyJSON("not json"))
+	assert.Equal(t, "", MinifyJSON(""))
+}
+
+func TestMinifyJSONField(t *testing.T) {
+	raw := json.RawMessage(`{"body":"{\n  \"hi\": \"you\"\n}","statusCode":200}`)
+	minified := MinifyJSONField(raw, "body")
+
+	var res struct {
+		Body       string `json:"body"`
+		StatusCode int    `json:"statusCode"`
+	}
+	err := json.Unmarshal(minified, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hi":"you"}`, res.Body)
+	assert.Equal(t, 200, res.StatusCode)
+
+	notJSONBody := json.RawMessage(`{"body":"hi you"}`)
+	assert.Equal(t, notJSONBody, MinifyJSONField(notJSONBody, "body"))
+
+	notObject := json.RawMessage(`"hi"`)
+	assert.Equal(t, notObject, MinifyJSONField(notObject, "body"))
+}
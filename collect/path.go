@@ -0,0 +1,21 @@
+package collect
+
+import "strings"
+
+// NormalizePath returns the path without its query string or fragment,
+// so query params don't create distinct sampled routes. The trailing
+// slash is also stripped if set, except from the root path.
+func NormalizePath(path string, stripTrailingSlash bool) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+
+	if stripTrailingSlash && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,30 @@
+package collect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path               string
+		stripTrailingSlash bool
+		expected           string
+	}{
+		{"/person/1", false, "/person/1"},
+		{"/person/1?fields=name&sort=asc", false, "/person/1"},
+		{"/person/1#profile", false, "/person/1"},
+		{"/person/1/", false, "/person/1/"},
+		{"/person/1/", true, "/person/1"},
+		{"/person/1//?fields=name", true, "/person/1"},
+		{"/", true, "/"},
+		{"//", true, "/"},
+		{"/?q=1", true, "/"},
+		{"", true, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NormalizePath(tt.path, tt.stripTrailingSlash), tt.path)
+	}
+}
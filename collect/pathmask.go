@@ -0,0 +1,47 @@
+package collect
+
+import "strings"
+
+// maskedPathParam replaces the value of a masked path param
+const maskedPathParam = "*"
+
+// MaskPathParams masks the values of the named params in the path
+// matched by the route template, e.g. /users/:email matching
+// /users/jo@auditr.io is masked as /users/*. A catch-all param
+// masks the rest of the path.
+func MaskPathParams(template string, path string, names []string) string {
+	if len(names) == 0 {
+		return path
+	}
+
+	masked := make(map[string]bool, len(names))
+	for _, name := range names {
+		masked[name] = true
+	}
+
+	templateSegments := strings.Split(template, "/")
+	segments := strings.Split(path, "/")
+	for i, t := range templateSegments {
+		if i >= len(segments) {
+			break
+		}
+
+		if len(t) < 2 || (t[0] != ':' && t[0] != '*') {
+			continue
+		}
+
+		if !masked[t[1:]] {
+			continue
+		}
+
+		if t[0] == '*' {
+			// Catch-all params span the rest of the path
+			segments = append(segments[:i], maskedPathParam)
+			break
+		}
+
+		segments[i] = maskedPathParam
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,58 @@
+package collect
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskPathParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		path     string
+		names    []string
+		want     string
+	}{
+		{
+			name:     "no masked params",
+			template: "/users/:email",
+			path:     "/users/jo@auditr.io",
+			want:     "/users/jo@auditr.io",
+		},
+		{
+			name:     "masked param",
+			template: "/users/:email/orders/:id",
+			path:     "/users/jo@auditr.io/orders/123",
+			names:    []string{"email"},
+			want:     "/users/*/orders/123",
+		},
+		{
+			name:     "multiple masked params",
+			template: "/users/:email/orders/:id",
+			path:     "/users/jo@auditr.io/orders/123",
+			names:    []string{"email", "id"},
+			want:     "/users/*/orders/*",
+		},
+		{
+			name:     "catch-all param",
+			template: "/files/*path",
+			path:     "/files/jo/secret.txt",
+			names:    []string{"path"},
+			want:     "/files/*",
+		},
+		{
+			name:     "static segments are never masked",
+			template: "/users/email",
+			path:     "/users/email",
+			names:    []string{"email"},
+			want:     "/users/email",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MaskPathParams(tt.template, tt.path, tt.names))
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package collect
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"sync"
 	"time"
 
@@ -21,6 +25,56 @@ type Publisher interface {
 		response json.RawMessage,
 		errorValue json.RawMessage,
 	)
+
+	// PublishPhase creates an audit event for the phase of the request
+	// with the metadata attached and sends it to a listener
+	PublishPhase(
+		phase EventPhase,
+		metadata map[string]string,
+		routeType RouteType,
+		route *config.Route,
+		request interface{},
+		response json.RawMessage,
+		errorValue json.RawMessage,
+	)
+
+	// DropPaused counts an event as dropped and returns true
+	// if publishing is paused
+	DropPaused() bool
+
+	// Pause stops publishing events until resumed
+	Pause() error
+
+	// Resume resumes publishing events after a pause
+	Resume() error
+
+	// Paused returns true if publishing is paused
+	Paused() bool
+
+	// Flush sends anything pending
+	Flush() error
+
+	// FlushWithBackoff flushes unless recent sends failed.
+	// Returns true if flushed.
+	FlushWithBackoff() (bool, error)
+
+	// Shutdown sends anything pending and stops publishing
+	Shutdown(ctx context.Context) error
+
+	// Warmup establishes the connection to the events endpoint
+	Warmup(ctx context.Context) error
+
+	// Responses returns the response channel to read responses from
+	Responses() <-chan Response
+
+	// Stats returns a snapshot of the publishing results
+	Stats() Stats
+
+	// Subscribe adds a subscriber that receives a copy of every response
+	Subscribe(options SubscriptionOptions) *Subscription
+
+	// Unsubscribe removes the subscriber
+	Unsubscribe(s *Subscription)
 }
 
 const (
@@ -58,11 +112,42 @@ type EventPublisher struct {
 	pendingWorkCapacity  uint
 	blockOnSend          bool
 	blockOnResponse      bool
+	sendTimeout          time.Duration
+
+	// discardOldestResponses discards unread responses once
+	// the response channel is full
+	discardOldestResponses bool
 
 	batchMaker func() muster.Batch
 	muster     *muster.Client
 	musterLock sync.RWMutex
+	paused     bool // guarded by musterLock
 	responses  chan Response
+	stats      *statsAggregator
+	breaker    *circuitBreaker
+	limiter    *orgRateLimiter
+	sequencer  *sequencer
+	bus        *eventBus
+	backoff    *flushBackoff
+
+	// agent is the agent of published events; nil omits it
+	agent *EventAgent
+
+	// pending tracks the batches yet to fire for the max batch age
+	pending          *pendingBatches
+	watchingBatchAge int32
+
+	// responseDecoder decodes batch responses
+	responseDecoder ResponseDecoder
+
+	// sinks receive each batch in addition to the event sink
+	sinks []Sink
+
+	// log logs the messages of the publisher and its batches
+	log logger
+
+	// eventsURL overrides the events endpoint of the configuration
+	eventsURL string
 }
 
 // PublisherOption is an option to override defaults
@@ -76,6 +161,7 @@ type PublisherOptions struct {
 	PendingWorkCapacity  uint
 	BlockOnSend          bool
 	BlockOnResponse      bool
+	SendTimeout          time.Duration
 }
 
 // NewEventPublisher creates a new EventPublisher.
@@ -85,6 +171,7 @@ type PublisherOptions struct {
 func NewEventPublisher(
 	configuration *config.Configuration,
 	eventBuilders []EventBuilder,
+	options ...PublisherOption,
 ) (*EventPublisher, error) {
 	p := &EventPublisher{
 		configuration:        configuration,
@@ -93,29 +180,24 @@ func NewEventPublisher(
 		sendInterval:         DefaultSendInterval,
 		maxConcurrentBatches: DefaultMaxConcurrentBatches,
 		pendingWorkCapacity:  DefaultPendingWorkCapacity,
+		stats:                newStatsAggregator(),
+		breaker:              newCircuitBreaker(0, 0),
+		limiter:              newOrgRateLimiter(0, 0, nil),
+		sequencer:            newSequencer(),
+		bus:                  newEventBus(),
+		backoff:              newFlushBackoff(),
+		pending:              newPendingBatches(),
+		responseDecoder:      arrayResponseDecoder{},
 	}
 
-	p.configuration.Configurer.OnRefresh(func() {
-		if p.configuration.MaxEventsPerBatch > 0 {
-			p.maxEventsPerBatch = p.configuration.MaxEventsPerBatch
-			p.pendingWorkCapacity = p.configuration.MaxEventsPerBatch * PendingWorkToMaxEventsRatio
-		}
-
-		if p.configuration.SendInterval > 0 {
-			p.sendInterval = p.configuration.SendInterval
-		}
-
-		if p.configuration.MaxConcurrentBatches > 0 {
-			p.maxConcurrentBatches = p.configuration.MaxConcurrentBatches
-		}
-
-		if p.configuration.PendingWorkCapacity > 0 {
-			p.pendingWorkCapacity = p.configuration.PendingWorkCapacity
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
 		}
+	}
 
-		p.blockOnSend = p.configuration.BlockOnSend
-		p.blockOnResponse = p.configuration.BlockOnResponse
-	})
+	p.applyConfiguration()
+	p.configuration.Configurer.OnRefresh(p.applyConfiguration)
 
 	// todo: recreate on config refresh?
 	p.responses = make(chan Response, p.pendingWorkCapacity*2)
@@ -129,6 +211,16 @@ func NewEventPublisher(
 			p.maxEventsPerBatch,
 			p.maxConcurrentBatches,
 		)
+		b.stats = p.stats
+		b.breaker = p.breaker
+		b.bus = p.bus
+		b.pending = p.pending
+		b.responseDecoder = p.responseDecoder
+		b.log = p.log
+		if p.eventsURL != "" {
+			b.eventsURL = p.eventsURL
+		}
+		b.sinks = append(b.sinks, p.sinks...)
 		return b
 	}
 	p.muster = p.createMuster()
@@ -140,6 +232,46 @@ func NewEventPublisher(
 	return p, nil
 }
 
+// applyConfiguration applies the configuration to the publisher settings
+func (p *EventPublisher) applyConfiguration() {
+	if p.configuration.MaxEventsPerBatch > 0 {
+		p.maxEventsPerBatch = p.configuration.MaxEventsPerBatch
+		p.pendingWorkCapacity = p.configuration.MaxEventsPerBatch * PendingWorkToMaxEventsRatio
+	}
+
+	if p.configuration.SendInterval > 0 {
+		p.sendInterval = p.configuration.SendInterval
+	}
+
+	if p.configuration.MaxConcurrentBatches > 0 {
+		p.maxConcurrentBatches = p.configuration.MaxConcurrentBatches
+	}
+
+	if p.configuration.PendingWorkCapacity > 0 {
+		p.pendingWorkCapacity = p.configuration.PendingWorkCapacity
+	}
+
+	p.blockOnSend = p.configuration.BlockOnSend
+	p.blockOnResponse = p.configuration.BlockOnResponse
+	p.discardOldestResponses = p.configuration.DiscardOldestResponses
+	p.sendTimeout = p.configuration.SendTimeout
+
+	p.breaker.configure(
+		p.configuration.CircuitBreakerThreshold,
+		p.configuration.CircuitBreakerCooldown,
+	)
+
+	p.limiter.configure(
+		p.configuration.OrgRateLimit,
+		p.configuration.OrgRateBurst,
+		p.configuration.OrgRateLimits,
+	)
+
+	if p.configuration.MaxBatchAge > 0 {
+		p.watchBatchAge()
+	}
+}
+
 // createMuster creates the muster client that coordinates the batch processing
 func (p *EventPublisher) createMuster() *muster.Client {
 	m := new(muster.Client)
@@ -152,28 +284,79 @@ func (p *EventPublisher) createMuster() *muster.Client {
 }
 
 // Add adds an event to the publish queue.
-// Returns true if event was added, false otherwise due to a full queue.
+// If the queue is full, the event's queue full policy determines whether
+// to block or drop, falling back to the publisher's block on send.
+// Flush, Pause and Shutdown wait on an Add blocked on a full queue, up to
+// the send timeout if set, since the queue can't be stopped under it.
 func (p *EventPublisher) Add(event *EventRaw) {
 	p.musterLock.RLock()
 	defer p.musterLock.RUnlock()
 
-	if p.blockOnSend {
+	if p.paused {
+		p.stats.eventPaused()
+		return
+	}
+
+	event.enqueuedAt = time.Now()
+
+	if event.queueFullPolicy.Blocks(p.blockOnSend) {
 		p.muster.Work <- event
 		// Event queued successfully
+		p.stats.eventQueued()
+		p.bus.publishEvent(event)
 		return
 	}
 
 	select {
 	case p.muster.Work <- event:
 		// Event queued successfully
+		p.stats.eventQueued()
+		p.bus.publishEvent(event)
 		return
 	default:
-		// Queue is full
-		res := Response{
-			Err: errors.New("Queue overflow"),
+	}
+
+	if p.sendTimeout > 0 {
+		// Queue is full. Wait for room up to the timeout.
+		timer := time.NewTimer(p.sendTimeout)
+		defer timer.Stop()
+
+		select {
+		case p.muster.Work <- event:
+			// Event queued successfully
+			p.stats.eventQueued()
+			p.bus.publishEvent(event)
+			return
+		case <-timer.C:
 		}
-		writeToChannel(p.responses, res, p.blockOnResponse)
 	}
+
+	// Queue is still full
+	p.dropEvent(event)
+}
+
+// dropEvent records an event dropped due to a full queue
+func (p *EventPublisher) dropEvent(event *EventRaw) {
+	p.stats.eventDropped()
+	res := Response{
+		Err: errors.New("Queue overflow"),
+	}
+	p.writeResponse(
+		res.forEvent(event),
+		event.responseFullPolicy.Blocks(p.blockOnResponse),
+	)
+}
+
+// writeResponse writes the response to the response channel and
+// sends a copy to every subscriber
+func (p *EventPublisher) writeResponse(res Response, block bool) {
+	p.bus.publishResponse(res)
+	if p.discardOldestResponses {
+		p.stats.responsesDiscarded(writeDiscardingOldest(p.responses, res))
+		return
+	}
+
+	writeToChannel(p.responses, res, block)
 }
 
 // Publish creates an audit event and sends it to auditr.
@@ -186,12 +369,75 @@ func (p *EventPublisher) Publish(
 	response json.RawMessage,
 	errorValue json.RawMessage,
 ) {
-	var event *EventRaw
+	p.PublishPhase("", nil, routeType, route, request, response, errorValue)
+}
+
+// PublishPhase creates an audit event for the phase of the request
+// with the metadata attached and sends it to a listener
+func (p *EventPublisher) PublishPhase(
+	phase EventPhase,
+	metadata map[string]string,
+	routeType RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) {
+	event, err := buildEvent(
+		p.configuration,
+		p.eventBuilders,
+		routeType,
+		route,
+		request,
+		response,
+		errorValue,
+	)
+	if err != nil {
+		p.writeResponse(
+			Response{Err: err},
+			route.ResponseFullPolicy.Blocks(p.blockOnResponse),
+		)
+		return
+	}
+
+	stampMetadata(event, metadata)
+
+	// Stamp before any drops so they show up as gaps
+	p.sequencer.stamp(event)
+	event.Phase = phase
+	event.Agent = p.agent
+	event.queueFullPolicy = route.QueueFullPolicy
+	event.responseFullPolicy = route.ResponseFullPolicy
+
+	if !p.limiter.allow(orgID(event)) {
+		// Drop the noisy org's event so other orgs flow normally
+		p.stats.eventRateLimited()
+		p.writeResponse(
+			Response{Err: ErrRateLimited}.forEvent(event),
+			route.ResponseFullPolicy.Blocks(p.blockOnResponse),
+		)
+		return
+	}
+
+	p.Add(event)
+}
+
+// buildEvent maps the parameters to an event with the first event
+// builder that succeeds
+func buildEvent(
+	configuration *config.Configuration,
+	eventBuilders []EventBuilder,
+	routeType RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*EventRaw, error) {
 	var err error
-	for _, b := range p.eventBuilders {
+	for _, b := range eventBuilders {
+		var event *EventRaw
 		event, err = b.Build(
-			p.configuration.ParentOrgID,
-			p.configuration.OrgIDField,
+			configuration,
 			routeType,
 			route,
 			request,
@@ -205,15 +451,20 @@ func (p *EventPublisher) Publish(
 		}
 
 		if event != nil {
-			p.Add(event)
-			return
+			return event, nil
 		}
 	}
 
-	res := Response{
-		Err: fmt.Errorf("Unable to build event: %s, req: %#v", err, request),
+	return nil, fmt.Errorf("Unable to build event: %s, req: %#v", err, request)
+}
+
+// orgID returns the org ID of the event
+func orgID(event *EventRaw) string {
+	if event.Organization == nil {
+		return ""
 	}
-	writeToChannel(p.responses, res, p.blockOnResponse)
+
+	return event.Organization.ID
 }
 
 // Responses returns the response channel to read responses from
@@ -221,13 +472,60 @@ func (p *EventPublisher) Responses() <-chan Response {
 	return p.responses
 }
 
+// Stats returns a snapshot of the batch send results
+func (p *EventPublisher) Stats() Stats {
+	return p.stats.snapshot()
+}
+
+// Subscribe adds a subscriber that receives a copy of every response,
+// and optionally every published event, without taking from the
+// response channel
+func (p *EventPublisher) Subscribe(options SubscriptionOptions) *Subscription {
+	return p.bus.subscribe(options)
+}
+
+// Unsubscribe removes the subscriber and closes its channels
+func (p *EventPublisher) Unsubscribe(s *Subscription) {
+	p.bus.unsubscribe(s)
+}
+
+// Warmup sends a no-op request to the events endpoint so the connection
+// is established before the first batch is sent
+func (p *EventPublisher) Warmup(ctx context.Context) error {
+	eventsURL := p.configuration.EventsURL
+	if p.eventsURL != "" {
+		eventsURL = p.eventsURL
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodHead,
+		eventsURL,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("auditr-agent-go/%s", version))
+
+	res, err := p.configuration.GetEventsClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Drain the body so the connection can be reused
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
 // Flush sends anything pending in muster
 func (p *EventPublisher) Flush() error {
 	// There isn't a way to flush a muster.Client directly, so we have to stop
 	// the old one (which has a side-effect of flushing the data) and make a new
 	// one. We start the new one and swap it with the old one so that we minimize
 	// the time we hold the musterLock for.
-	m := p.muster
 	newMuster := p.createMuster()
 	err := newMuster.Start()
 	if err != nil {
@@ -235,7 +533,115 @@ func (p *EventPublisher) Flush() error {
 	}
 
 	p.musterLock.Lock()
+	if p.paused {
+		// Nothing pending; the muster was stopped on pause
+		p.musterLock.Unlock()
+		return newMuster.Stop()
+	}
+
+	m := p.muster
 	p.muster = newMuster
 	p.musterLock.Unlock()
 	return m.Stop()
 }
+
+// FlushWithBackoff flushes unless recent sends failed, in which case
+// flushes are spaced out with an exponential backoff. Returns true if flushed.
+func (p *EventPublisher) FlushWithBackoff() (bool, error) {
+	if !p.backoff.allow(
+		p.breaker.consecutiveFailures(),
+		p.configuration.FlushBackoff,
+		p.configuration.FlushBackoffMax,
+	) {
+		return false, nil
+	}
+
+	return true, p.Flush()
+}
+
+// Pause stops publishing events. Pending events are sent and
+// events added while paused are dropped.
+func (p *EventPublisher) Pause() error {
+	m := p.pause()
+	if m == nil {
+		return nil
+	}
+
+	return m.Stop()
+}
+
+// pause marks the publisher as paused and returns the muster to stop,
+// or nil if already paused. Stopping waits for in-flight sends, so the
+// muster is stopped after the musterLock is released, like Flush does.
+func (p *EventPublisher) pause() *muster.Client {
+	p.musterLock.Lock()
+	defer p.musterLock.Unlock()
+
+	if p.paused {
+		return nil
+	}
+
+	p.paused = true
+	return p.muster
+}
+
+// Shutdown sends anything pending and stops publishing. Events added
+// afterwards are dropped. Returns the context's error if the pending
+// events aren't sent before the context is done, in which case they
+// continue to be sent in the background without blocking the publisher.
+func (p *EventPublisher) Shutdown(ctx context.Context) error {
+	m := p.pause()
+	if m == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Stop()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resume resumes publishing events after a pause
+func (p *EventPublisher) Resume() error {
+	p.musterLock.Lock()
+	defer p.musterLock.Unlock()
+
+	if !p.paused {
+		return nil
+	}
+
+	m := p.createMuster()
+	if err := m.Start(); err != nil {
+		return err
+	}
+
+	p.muster = m
+	p.paused = false
+	return nil
+}
+
+// Paused returns true if publishing is paused
+func (p *EventPublisher) Paused() bool {
+	p.musterLock.RLock()
+	defer p.musterLock.RUnlock()
+
+	return p.paused
+}
+
+// DropPaused counts an event as dropped and returns true if publishing
+// is paused, so the event can be dropped before it's routed and built
+func (p *EventPublisher) DropPaused() bool {
+	if !p.Paused() {
+		return false
+	}
+
+	p.stats.eventPaused()
+	return true
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/lambda/events"
 	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/facebookgo/muster"
 	"github.com/mitchellh/mapstructure"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -34,15 +35,23 @@ type mockBuilder struct {
 }
 
 func (m *mockBuilder) Build(
-	parentOrgID string,
-	orgIDField string,
+	configuration *config.Configuration,
 	routeType RouteType,
 	route *config.Route,
 	request interface{},
 	response json.RawMessage,
 	errorValue json.RawMessage,
 ) (*EventRaw, error) {
-	return m.fn(m, parentOrgID, orgIDField, routeType, route, request, response, errorValue)
+	return m.fn(
+		m,
+		configuration.ParentOrgID,
+		configuration.OrgIDField,
+		routeType,
+		route,
+		request,
+		response,
+		errorValue,
+	)
 }
 
 func TestPublish_PublishesEvent(t *testing.T) {
@@ -66,6 +75,8 @@ func TestPublish_PublishesEvent(t *testing.T) {
 
 	expectedResponse := Response{
 		StatusCode: 200,
+		Sink:       SinkHTTP,
+		Sequence:   1,
 	}
 
 	type errorMessage struct {
@@ -116,6 +127,8 @@ func TestPublish_PublishesEvent(t *testing.T) {
 			// assert.Equal(t, expectedEvent.RequestID, event.RequestID)
 			assert.GreaterOrEqual(t, time.Now().UTC().Unix(), event.RequestedAt)
 			assert.Equal(t, expectedEvent.Route.Type, event.Route.Type)
+			assert.NotEmpty(t, event.InstanceID)
+			assert.Equal(t, uint64(1), event.Sequence)
 			var eventReq events.APIGatewayProxyRequest
 			mapstructure.Decode(event.Request, &eventReq)
 			assert.Equal(t, expectedEvent.Request, eventReq)
@@ -432,3 +445,229 @@ func TestFlush_PublishesEvent(t *testing.T) {
 	assert.True(t, m.AssertExpectations(t))
 	assert.True(t, b.AssertExpectations(t))
 }
+
+func TestAdd_WaitsForSendTimeout(t *testing.T) {
+	work := make(chan interface{})
+	p := &EventPublisher{
+		muster: &muster.Client{
+			Work: work,
+		},
+		responses:   make(chan Response, 1),
+		stats:       newStatsAggregator(),
+		sendTimeout: 50 * time.Millisecond,
+	}
+
+	event := &EventRaw{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, event, <-work)
+	}()
+
+	p.Add(event)
+	assert.Equal(t, uint64(0), p.Stats().EventsDropped)
+
+	// No one is reading the queue
+	start := time.Now()
+	p.Add(event)
+	assert.GreaterOrEqual(t, time.Since(start), p.sendTimeout)
+	assert.Equal(t, uint64(1), p.Stats().EventsDropped)
+
+	res := <-p.responses
+	assert.EqualError(t, res.Err, "Queue overflow")
+}
+
+func TestAdd_QueuesWithoutWaitingIfRoom(t *testing.T) {
+	work := make(chan interface{}, 200)
+	p := &EventPublisher{
+		muster: &muster.Client{
+			Work: work,
+		},
+		responses:   make(chan Response, 1),
+		stats:       newStatsAggregator(),
+		sendTimeout: time.Hour,
+	}
+
+	event := &EventRaw{}
+	start := time.Now()
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Add(event)
+	})
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Zero(t, allocs)
+	assert.Equal(t, uint64(0), p.Stats().EventsDropped)
+}
+
+func TestAdd_AppliesEventOverflowPolicies(t *testing.T) {
+	work := make(chan interface{})
+	responses := make(chan Response)
+	p := &EventPublisher{
+		muster: &muster.Client{
+			Work: work,
+		},
+		responses:       responses,
+		stats:           newStatsAggregator(),
+		blockOnSend:     true,
+		blockOnResponse: true,
+	}
+
+	// Dropping overrides the global block on send and block on response
+	p.Add(&EventRaw{
+		queueFullPolicy:    config.OverflowPolicyDrop,
+		responseFullPolicy: config.OverflowPolicyDrop,
+	})
+	assert.Equal(t, uint64(1), p.Stats().EventsDropped)
+
+	// Responses block independent of the queue policy
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		res := <-responses
+		assert.EqualError(t, res.Err, "Queue overflow")
+	}()
+
+	start := time.Now()
+	p.Add(&EventRaw{
+		queueFullPolicy: config.OverflowPolicyDrop,
+	})
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, uint64(2), p.Stats().EventsDropped)
+
+	// Blocking overrides the global drop
+	p.blockOnSend = false
+	event := &EventRaw{
+		queueFullPolicy: config.OverflowPolicyBlock,
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, event, <-work)
+	}()
+
+	p.Add(event)
+	assert.Equal(t, uint64(2), p.Stats().EventsDropped)
+}
+
+func TestWriteResponse_DiscardsOldestResponses(t *testing.T) {
+	responses := make(chan Response, 2)
+	p := &EventPublisher{
+		responses:              responses,
+		stats:                  newStatsAggregator(),
+		blockOnResponse:        true,
+		discardOldestResponses: true,
+	}
+
+	for i := 1; i <= 4; i++ {
+		// Never blocks even though no one is reading
+		p.writeResponse(Response{StatusCode: i}, true)
+	}
+
+	assert.Equal(t, uint64(2), p.Stats().ResponsesDiscarded)
+	assert.Equal(t, 3, (<-responses).StatusCode)
+	assert.Equal(t, 4, (<-responses).StatusCode)
+}
+
+// blockingBatch is a batch that doesn't finish firing until released
+type blockingBatch struct {
+	fired    chan struct{}
+	released chan struct{}
+}
+
+func (b *blockingBatch) Add(item interface{}) {}
+
+func (b *blockingBatch) Fire(notifier muster.Notifier) {
+	defer notifier.Done()
+	b.fired <- struct{}{}
+	<-b.released
+}
+
+// newBlockingPublisher creates a publisher whose first batch stays
+// in flight until the batch is released
+func newBlockingPublisher(t *testing.T) (*EventPublisher, *blockingBatch) {
+	batch := &blockingBatch{
+		fired:    make(chan struct{}),
+		released: make(chan struct{}),
+	}
+
+	m := &muster.Client{
+		MaxBatchSize:         1,
+		BatchTimeout:         time.Second,
+		MaxConcurrentBatches: 1,
+		PendingWorkCapacity:  1,
+		BatchMaker: func() muster.Batch {
+			return batch
+		},
+	}
+	assert.NoError(t, m.Start())
+
+	p := &EventPublisher{
+		muster:    m,
+		responses: make(chan Response, 1),
+		stats:     newStatsAggregator(),
+		bus:       newEventBus(),
+	}
+
+	// Send a batch that stays in flight
+	m.Work <- &EventRaw{}
+	<-batch.fired
+
+	return p, batch
+}
+
+func TestPause_ReleasesLockWhileStopping(t *testing.T) {
+	p, batch := newBlockingPublisher(t)
+
+	paused := make(chan error, 1)
+	go func() {
+		paused <- p.Pause()
+	}()
+
+	assert.Eventually(t, p.Paused, time.Second, time.Millisecond)
+
+	// Events are dropped rather than waiting for the in-flight send
+	done := make(chan struct{})
+	go func() {
+		p.Add(&EventRaw{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Add blocked while pausing")
+	}
+	assert.Equal(t, uint64(1), p.Stats().EventsPaused)
+
+	select {
+	case <-paused:
+		assert.Fail(t, "Pause returned before the send finished")
+	default:
+	}
+
+	close(batch.released)
+	assert.NoError(t, <-paused)
+}
+
+func TestShutdown_ReleasesPublisherOnTimeout(t *testing.T) {
+	p, batch := newBlockingPublisher(t)
+	defer close(batch.released)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, p.Shutdown(ctx))
+
+	// The publisher isn't wedged by the send still in flight
+	done := make(chan struct{})
+	go func() {
+		p.Add(&EventRaw{})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Add blocked after shutdown")
+	}
+	assert.True(t, p.Paused())
+	assert.Equal(t, uint64(1), p.Stats().EventsPaused)
+
+	// Shutting down again is a no-op
+	assert.NoError(t, p.Shutdown(context.Background()))
+}
@@ -0,0 +1,107 @@
+package collect
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is the error returned when an org's events are dropped
+// because the org exceeded its rate limit
+var ErrRateLimited = errors.New("org rate limit exceeded")
+
+// tokenBucket holds the tokens available to an org
+type tokenBucket struct {
+	tokens     float64
+	lastFilled time.Time
+}
+
+// orgRateLimiter limits the rate of events per org with a token bucket
+// keyed by org ID, so a noisy org can't crowd out the others.
+type orgRateLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     uint
+	overrides map[string]float64
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+}
+
+// newOrgRateLimiter creates a new org rate limiter.
+// A rate of 0 disables rate limiting, unless overridden for an org.
+func newOrgRateLimiter(
+	rate float64,
+	burst uint,
+	overrides map[string]float64,
+) *orgRateLimiter {
+	return &orgRateLimiter{
+		rate:      rate,
+		burst:     burst,
+		overrides: overrides,
+		buckets:   map[string]*tokenBucket{},
+		now:       time.Now,
+	}
+}
+
+// configure updates the rates and burst.
+// Buckets are reset so the new limits apply immediately.
+func (l *orgRateLimiter) configure(
+	rate float64,
+	burst uint,
+	overrides map[string]float64,
+) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rate = rate
+	l.burst = burst
+	l.overrides = overrides
+	l.buckets = map[string]*tokenBucket{}
+}
+
+// allow determines whether an event for the org may be published
+func (l *orgRateLimiter) allow(orgID string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rate := l.rate
+	if r, ok := l.overrides[orgID]; ok {
+		rate = r
+	}
+
+	if rate <= 0 {
+		return true
+	}
+
+	// Default the burst to a second's worth of events
+	capacity := float64(l.burst)
+	if capacity == 0 {
+		capacity = math.Max(1, math.Ceil(rate))
+	}
+
+	now := l.now()
+	bucket, ok := l.buckets[orgID]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens:     capacity,
+			lastFilled: now,
+		}
+		l.buckets[orgID] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastFilled).Seconds()
+	bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*rate)
+	bucket.lastFilled = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
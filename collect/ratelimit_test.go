@@ -0,0 +1,52 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrgRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newOrgRateLimiter(2, 0, map[string]float64{
+		"vip": 0,
+	})
+	l.now = func() time.Time {
+		return now
+	}
+
+	// Burst defaults to a second's worth of events
+	assert.True(t, l.allow("noisy"))
+	assert.True(t, l.allow("noisy"))
+	assert.False(t, l.allow("noisy"))
+
+	// Other orgs are unaffected
+	assert.True(t, l.allow("quiet"))
+
+	// Overridden orgs are unlimited
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow("vip"))
+	}
+
+	// Refills at the rate
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.allow("noisy"))
+	assert.False(t, l.allow("noisy"))
+}
+
+func TestOrgRateLimiter_Disabled(t *testing.T) {
+	l := newOrgRateLimiter(0, 0, nil)
+	for i := 0; i < 10; i++ {
+		assert.True(t, l.allow("org"))
+	}
+
+	l.configure(1, 3, nil)
+	assert.True(t, l.allow("org"))
+	assert.True(t, l.allow("org"))
+	assert.True(t, l.allow("org"))
+	assert.False(t, l.allow("org"))
+
+	var nilLimiter *orgRateLimiter
+	assert.True(t, nilLimiter.allow("org"))
+}
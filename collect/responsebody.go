@@ -0,0 +1,61 @@
+package collect
+
+import (
+	"encoding/json"
+
+	"github.com/tidwall/gjson"
+)
+
+// statusInRange determines whether the response status is within
+// the min and max statuses, inclusive. A max of 0 has no upper bound.
+// Always true when both min and max are 0.
+func statusInRange(
+	response json.RawMessage,
+	statusField string,
+	min int,
+	max int,
+) bool {
+	if min <= 0 && max <= 0 {
+		return true
+	}
+
+	status := gjson.GetBytes(response, statusField)
+	if !status.Exists() {
+		return true
+	}
+
+	code := int(status.Int())
+	return code >= min && (max <= 0 || code <= max)
+}
+
+// StripResponseBody removes the body from the response unless its status
+// is within the min and max statuses, keeping the status and headers.
+// If the response can't be parsed, it's returned untouched.
+func StripResponseBody(
+	response json.RawMessage,
+	statusField string,
+	bodyField string,
+	min int,
+	max int,
+) json.RawMessage {
+	if statusInRange(response, statusField, min, max) {
+		return response
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(response, &obj); err != nil {
+		return response
+	}
+
+	if _, ok := obj[bodyField]; !ok {
+		return response
+	}
+	delete(obj, bodyField)
+
+	stripped, err := json.Marshal(obj)
+	if err != nil {
+		return response
+	}
+
+	return stripped
+}
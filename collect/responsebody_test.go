@@ -0,0 +1,75 @@
+package collect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripResponseBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		min      int
+		max      int
+		want     string
+	}{
+		{
+			name:     "disabled",
+			response: `{"statusCode":200,"body":"{\"id\":1}"}`,
+			want:     `{"statusCode":200,"body":"{\"id\":1}"}`,
+		},
+		{
+			name:     "below range",
+			response: `{"statusCode":200,"headers":{"a":"b"},"body":"{\"id\":1}"}`,
+			min:      400,
+			want:     `{"headers":{"a":"b"},"statusCode":200}`,
+		},
+		{
+			name:     "in open range",
+			response: `{"statusCode":500,"body":"oops"}`,
+			min:      400,
+			want:     `{"statusCode":500,"body":"oops"}`,
+		},
+		{
+			name:     "in closed range",
+			response: `{"statusCode":404,"body":"not found"}`,
+			min:      400,
+			max:      499,
+			want:     `{"statusCode":404,"body":"not found"}`,
+		},
+		{
+			name:     "above range",
+			response: `{"statusCode":500,"body":"oops"}`,
+			min:      400,
+			max:      499,
+			want:     `{"statusCode":500}`,
+		},
+		{
+			name:     "no status",
+			response: `{"body":"bla"}`,
+			min:      400,
+			want:     `{"body":"bla"}`,
+		},
+		{
+			name:     "not json",
+			response: `bla`,
+			min:      400,
+			want:     `bla`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StripResponseBody(
+				json.RawMessage(tt.response),
+				"statusCode",
+				"body",
+				tt.min,
+				tt.max,
+			)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
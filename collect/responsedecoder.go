@@ -0,0 +1,48 @@
+package collect
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ResponseDecoder decodes the events endpoint's response to a batch
+// into a response per event, in the order the events were sent
+type ResponseDecoder interface {
+	Decode(body io.Reader) ([]Response, error)
+}
+
+// ResponseDecoderFunc adapts a function to a ResponseDecoder
+type ResponseDecoderFunc func(body io.Reader) ([]Response, error)
+
+// Decode decodes the batch response with the function
+func (f ResponseDecoderFunc) Decode(body io.Reader) ([]Response, error) {
+	return f(body)
+}
+
+// arrayResponseDecoder decodes a JSON array of responses,
+// e.g. [{"status": 200}, {"status": 400, "error": "..."}]
+type arrayResponseDecoder struct{}
+
+// Decode decodes the JSON array of responses
+func (arrayResponseDecoder) Decode(body io.Reader) ([]Response, error) {
+	var responses []Response
+	if err := json.NewDecoder(body).Decode(&responses); err != nil {
+		return nil, err
+	}
+
+	return responses, nil
+}
+
+// WithResponseDecoder decodes batch responses with the decoder rather than
+// as a JSON array, to adapt to a backend's response envelope
+func WithResponseDecoder(decoder ResponseDecoder) PublisherOption {
+	return func(p *EventPublisher) error {
+		if decoder == nil {
+			return errors.New("response decoder must not be nil")
+		}
+
+		p.responseDecoder = decoder
+		return nil
+	}
+}
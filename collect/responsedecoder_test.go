@@ -0,0 +1,102 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArrayResponseDecoder_Decode(t *testing.T) {
+	responses, err := arrayResponseDecoder{}.Decode(
+		strings.NewReader(`[{"status": 200}, {"status": 400, "error": "missing id"}]`),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, responses, 2)
+	assert.Equal(t, 200, responses[0].StatusCode)
+	assert.NoError(t, responses[0].Err)
+	assert.Equal(t, 400, responses[1].StatusCode)
+	assert.EqualError(t, responses[1].Err, "missing id")
+
+	_, err = arrayResponseDecoder{}.Decode(strings.NewReader(`{"results": []}`))
+	assert.Error(t, err)
+}
+
+func TestSend_DecodesResponsesWithDecoder(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{
+					"results": [
+						{"code": 201},
+						{"code": 422}
+					]
+				}`)),
+			}, nil
+		},
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, configurer.Refresh(context.Background()))
+
+	p, err := NewEventPublisher(
+		configurer.Configuration,
+		[]EventBuilder{},
+		WithResponseDecoder(ResponseDecoderFunc(func(body io.Reader) ([]Response, error) {
+			var envelope struct {
+				Results []struct {
+					Code int `json:"code"`
+				} `json:"results"`
+			}
+			if err := json.NewDecoder(body).Decode(&envelope); err != nil {
+				return nil, err
+			}
+
+			responses := make([]Response, len(envelope.Results))
+			for i, result := range envelope.Results {
+				responses[i] = Response{StatusCode: result.Code}
+			}
+
+			return responses, nil
+		})),
+	)
+	assert.NoError(t, err)
+
+	b := p.batchMaker().(*batchList)
+	b.send([]*EventRaw{{}, {}})
+
+	assert.Equal(t, 201, (<-p.Responses()).StatusCode)
+	assert.Equal(t, 422, (<-p.Responses()).StatusCode)
+
+	_, err = NewEventPublisher(
+		configurer.Configuration,
+		[]EventBuilder{},
+		WithResponseDecoder(nil),
+	)
+	assert.Error(t, err)
+}
@@ -19,6 +19,12 @@ type Param struct {
 // It is therefore safe to read values by the index.
 type Params []Param
 
+// proxyResource is the greedy path variable of API Gateway proxy integrations
+const proxyResource = "{proxy+}"
+
+// resourceReplacer converts API Gateway path variables to route params
+var resourceReplacer = strings.NewReplacer("{", ":", "}", "")
+
 // MatchedRoutePathParam is the Param name under which the path of the matched
 // route is stored, if Router.SaveMatchedRoutePath is set.
 var MatchedRoutePathParam = "$matchedRoutePath"
@@ -60,21 +66,58 @@ type Router struct {
 	target     map[string]*node
 	sample     map[string]*node
 	sampleLock sync.Mutex
+
+	// configured routes keyed by route type, method and path
+	routes map[string]config.Route
+
+	// caseSensitiveMethods matches methods as is rather than as
+	// uppercased HTTP verbs
+	caseSensitiveMethods bool
+
+	// proxyTemplate replaces the {proxy+} catch-all of sampled resources
+	proxyTemplate string
+}
+
+// RouterOption is an option to override router defaults
+type RouterOption func(r *Router)
+
+// WithCaseSensitiveMethods matches methods as arbitrary case sensitive
+// strings, such as gRPC full method names like "/pkg.Svc/Method",
+// instead of HTTP verbs
+func WithCaseSensitiveMethods() RouterOption {
+	return func(r *Router) {
+		r.caseSensitiveMethods = true
+	}
+}
+
+// WithProxyTemplate samples resources with a {proxy+} catch-all by
+// replacing it with the template, e.g. "*proxy" samples "/api/{proxy+}"
+// once as "/api/*proxy". Without a template, the request path is sampled.
+func WithProxyTemplate(template string) RouterOption {
+	return func(r *Router) {
+		r.proxyTemplate = template
+	}
 }
 
 // NewRouter creates a new router
 func NewRouter(
 	targetRoutes []config.Route,
 	sampleRoutes []config.Route,
+	options ...RouterOption,
 ) *Router {
 	r := &Router{
 		target:    make(map[string]*node),
 		sample:    make(map[string]*node),
+		routes:    make(map[string]config.Route),
 		maxParams: 5,
 	}
 
-	r.addRoutes(r.target, targetRoutes)
-	r.addRoutes(r.sample, sampleRoutes)
+	for _, opt := range options {
+		opt(r)
+	}
+
+	r.addRoutes(RouteTypeTarget, r.target, targetRoutes)
+	r.addRoutes(RouteTypeSample, r.sample, sampleRoutes)
 
 	// If no routes have been added, we need to still initialize
 	// the params pool with a sensible default
@@ -88,17 +131,37 @@ func NewRouter(
 	return r
 }
 
+// routeKey is the key of a route by route type, method and path
+func routeKey(routeType RouteType, method string, path string) string {
+	return string(routeType) + " " + method + " " + path
+}
+
+// normalizeMethod uppercases HTTP verbs unless methods are case sensitive
+func (r *Router) normalizeMethod(method string) string {
+	if r.caseSensitiveMethods {
+		return method
+	}
+
+	return strings.ToUpper(method)
+}
+
 // addRoutes adds routes to a tree of nodes
-func (r *Router) addRoutes(tree map[string]*node, routes []config.Route) {
+func (r *Router) addRoutes(
+	routeType RouteType,
+	tree map[string]*node,
+	routes []config.Route,
+) {
 	for _, route := range routes {
 		varsCount := uint16(0)
-		root := tree[route.HTTPMethod]
+		method := r.normalizeMethod(route.HTTPMethod)
+		root := tree[method]
 		if root == nil {
 			root = new(node)
-			tree[route.HTTPMethod] = root
+			tree[method] = root
 		}
 
 		root.addRoute(route.Path, newHandler(route.Path))
+		r.routes[routeKey(routeType, method, route.Path)] = route
 
 		// Update maxParams
 		if paramsCount := countParams(route.Path); paramsCount+varsCount > r.maxParams {
@@ -154,7 +217,7 @@ func (r *Router) FindRoute(
 		return nil, fmt.Errorf("method cannot be empty")
 	}
 
-	method = strings.ToUpper(method)
+	method = r.normalizeMethod(method)
 
 	root, ok := tree[method]
 	if ok {
@@ -165,10 +228,16 @@ func (r *Router) FindRoute(
 			}
 
 			matchingPath := handler()
+			configured := r.routes[routeKey(routeType, method, matchingPath)]
 
 			return &config.Route{
-				HTTPMethod: method,
-				Path:       matchingPath,
+				HTTPMethod:         method,
+				Path:               matchingPath,
+				Name:               configured.Name,
+				QueueFullPolicy:    configured.QueueFullPolicy,
+				ResponseFullPolicy: configured.ResponseFullPolicy,
+				Rate:               configured.Rate,
+				CaptureResponse:    configured.CaptureResponse,
 			}, nil
 		}
 	}
@@ -182,7 +251,7 @@ func (r *Router) SampleRoute(
 	path string,
 	resource string,
 ) *config.Route {
-	method = strings.ToUpper(method)
+	method = r.normalizeMethod(method)
 
 	r.sampleLock.Lock()
 	defer r.sampleLock.Unlock()
@@ -193,16 +262,9 @@ func (r *Router) SampleRoute(
 		r.sample[method] = root
 	}
 
-	var route *config.Route
-	if resource != "{proxy+}" {
-		r := strings.NewReplacer("{", ":", "}", "")
-		route = &config.Route{
-			HTTPMethod: method,
-			Path:       r.Replace(resource),
-		}
-	} else {
-		// todo: handle {proxy+}
-		return nil
+	route := &config.Route{
+		HTTPMethod: method,
+		Path:       r.samplePath(path, resource),
 	}
 
 	handler, _, _ := root.getValue(path, r.getParams)
@@ -214,3 +276,18 @@ func (r *Router) SampleRoute(
 
 	return route
 }
+
+// samplePath converts the resource to a route path.
+// Resources with a {proxy+} catch-all use the proxy template if set,
+// otherwise the request path.
+func (r *Router) samplePath(path string, resource string) string {
+	if strings.Contains(resource, proxyResource) {
+		if r.proxyTemplate == "" {
+			return path
+		}
+
+		resource = strings.Replace(resource, proxyResource, r.proxyTemplate, 1)
+	}
+
+	return resourceReplacer.Replace(resource)
+}
@@ -19,3 +19,157 @@ func TestSampleRoute_RouteFoundAfterSampling(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, sampleRoute, foundRoute)
 }
+
+func TestFindRoute_ReturnsRouteName(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{
+			{
+				HTTPMethod: http.MethodPut,
+				Path:       "/person/:id",
+				Name:       "update-person",
+			},
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/person/:id",
+			},
+		},
+		[]config.Route{},
+	)
+
+	route, err := r.FindRoute(RouteTypeTarget, http.MethodPut, "/person/xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, "update-person", route.Name)
+
+	route, err = r.FindRoute(RouteTypeTarget, http.MethodGet, "/person/xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, "", route.Name)
+}
+
+func TestFindRoute_ReturnsCaptureResponse(t *testing.T) {
+	captureResponse := false
+	r := NewRouter(
+		[]config.Route{
+			{
+				HTTPMethod:      http.MethodGet,
+				Path:            "/export/:id",
+				CaptureResponse: &captureResponse,
+			},
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/person/:id",
+			},
+		},
+		[]config.Route{},
+	)
+
+	route, err := r.FindRoute(RouteTypeTarget, http.MethodGet, "/export/xyz")
+	assert.NoError(t, err)
+	assert.False(t, route.CapturesResponse())
+
+	route, err = r.FindRoute(RouteTypeTarget, http.MethodGet, "/person/xyz")
+	assert.NoError(t, err)
+	assert.True(t, route.CapturesResponse())
+}
+
+func TestFindRoute_NormalizesHTTPMethods(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{
+			{
+				HTTPMethod: "get",
+				Path:       "/person/:id",
+			},
+		},
+		[]config.Route{},
+	)
+
+	route, err := r.FindRoute(RouteTypeTarget, "Get", "/person/xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodGet, route.HTTPMethod)
+}
+
+func TestFindRoute_CaseSensitiveMethods(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{
+			{
+				HTTPMethod: "/auditr.PersonService/GetPerson",
+				Path:       "/",
+				Name:       "get-person",
+			},
+		},
+		[]config.Route{},
+		WithCaseSensitiveMethods(),
+	)
+
+	route, err := r.FindRoute(RouteTypeTarget, "/auditr.PersonService/GetPerson", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/auditr.PersonService/GetPerson", route.HTTPMethod)
+	assert.Equal(t, "get-person", route.Name)
+
+	route, err = r.FindRoute(RouteTypeTarget, "/AUDITR.PERSONSERVICE/GETPERSON", "/")
+	assert.NoError(t, err)
+	assert.Nil(t, route)
+
+	sampleRoute := r.SampleRoute("/auditr.PersonService/ListPeople", "/", "/")
+	assert.Equal(t, "/auditr.PersonService/ListPeople", sampleRoute.HTTPMethod)
+
+	route, err = r.FindRoute(RouteTypeSample, "/auditr.PersonService/ListPeople", "/")
+	assert.NoError(t, err)
+	assert.Equal(t, sampleRoute, route)
+}
+
+func TestFindRoute_ReturnsRoutePolicies(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{
+			{
+				HTTPMethod:         http.MethodPost,
+				Path:               "/payments",
+				QueueFullPolicy:    config.OverflowPolicyBlock,
+				ResponseFullPolicy: config.OverflowPolicyDrop,
+			},
+		},
+		[]config.Route{},
+	)
+
+	route, err := r.FindRoute(RouteTypeTarget, http.MethodPost, "/payments")
+	assert.NoError(t, err)
+	assert.Equal(t, config.OverflowPolicyBlock, route.QueueFullPolicy)
+	assert.Equal(t, config.OverflowPolicyDrop, route.ResponseFullPolicy)
+}
+
+func TestSampleRoute_ProxyResource(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{},
+		[]config.Route{},
+	)
+
+	// Without a template, the request path is sampled
+	sampleRoute := r.SampleRoute(http.MethodGet, "/api/person/xyz", "/api/{proxy+}")
+	assert.Equal(t, &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/api/person/xyz",
+	}, sampleRoute)
+
+	foundRoute, err := r.FindRoute(RouteTypeSample, http.MethodGet, "/api/person/xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, sampleRoute, foundRoute)
+
+	sampleRoute = r.SampleRoute(http.MethodGet, "/", "{proxy+}")
+	assert.Equal(t, "/", sampleRoute.Path)
+}
+
+func TestSampleRoute_ProxyTemplate(t *testing.T) {
+	r := NewRouter(
+		[]config.Route{},
+		[]config.Route{},
+		WithProxyTemplate("*proxy"),
+	)
+
+	sampleRoute := r.SampleRoute(http.MethodGet, "/api/person/xyz", "/api/{proxy+}")
+	assert.Equal(t, "/api/*proxy", sampleRoute.Path)
+
+	// Other paths under the catch-all are already sampled
+	foundRoute, err := r.FindRoute(RouteTypeSample, http.MethodGet, "/api/order/123")
+	assert.NoError(t, err)
+	assert.Equal(t, sampleRoute, foundRoute)
+	assert.Nil(t, r.SampleRoute(http.MethodGet, "/api/order/123", "/api/{proxy+}"))
+}
@@ -0,0 +1,53 @@
+package collect
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// sampleLimiter limits sample events to one per route within a window
+type sampleLimiter struct {
+	lock    sync.Mutex
+	sampled map[string]time.Time
+
+	// now returns the current time
+	now func() time.Time
+}
+
+// newSampleLimiter creates a sample limiter
+func newSampleLimiter() *sampleLimiter {
+	return &sampleLimiter{
+		sampled: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// allow determines whether a sample event of the route may be published,
+// recording it if so. Always allowed if the window is 0 or less.
+func (l *sampleLimiter) allow(method string, path string, window time.Duration) bool {
+	if window <= 0 {
+		return true
+	}
+
+	key := strings.ToUpper(method) + " " + path
+	now := l.now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if sampledAt, ok := l.sampled[key]; ok && now.Sub(sampledAt) < window {
+		return false
+	}
+
+	// Forget routes sampled outside the window so the map doesn't grow
+	// with every route ever sampled
+	for k, sampledAt := range l.sampled {
+		if now.Sub(sampledAt) >= window {
+			delete(l.sampled, k)
+		}
+	}
+
+	l.sampled[key] = now
+	return true
+}
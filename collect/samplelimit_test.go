@@ -0,0 +1,39 @@
+package collect
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleLimiter_AllowsOncePerWindow(t *testing.T) {
+	now := time.Now()
+	l := newSampleLimiter()
+	l.now = func() time.Time {
+		return now
+	}
+
+	window := time.Minute
+	assert.True(t, l.allow(http.MethodGet, "/person/:id", window))
+	assert.False(t, l.allow("get", "/person/:id", window))
+
+	// Other routes aren't limited
+	assert.True(t, l.allow(http.MethodPost, "/person/:id", window))
+	assert.True(t, l.allow(http.MethodGet, "/order/:id", window))
+
+	now = now.Add(window)
+	assert.True(t, l.allow(http.MethodGet, "/person/:id", window))
+
+	// Routes sampled outside the window are forgotten
+	assert.Len(t, l.sampled, 1)
+}
+
+func TestSampleLimiter_AllowsWithoutWindow(t *testing.T) {
+	l := newSampleLimiter()
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow(http.MethodGet, "/person/:id", 0))
+	}
+	assert.Empty(t, l.sampled)
+}
@@ -0,0 +1,178 @@
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// DefaultSampledRouteLoadTimeout is the max duration to wait for the
+// sampled routes to load from the store
+const DefaultSampledRouteLoadTimeout time.Duration = 2 * time.Second
+
+// SampledRouteStore persists the routes sampled by the agent, so instances
+// of a fleet can share the sampled set rather than each instance sampling
+// every route again after a cold start, e.g. backed by DynamoDB or Redis.
+// By default, sampled routes are only kept in memory. See
+// FileSampledRouteStore to keep them across restarts of an instance.
+type SampledRouteStore interface {
+	// Load returns the sampled routes known to the store
+	Load(ctx context.Context) ([]config.Route, error)
+
+	// Save saves a newly sampled route
+	Save(ctx context.Context, route config.Route) error
+}
+
+// WithSampledRouteStore seeds the sampled routes from the store
+// and saves newly sampled routes to it
+func WithSampledRouteStore(store SampledRouteStore) CollectorOption {
+	return func(c *Collector) {
+		c.sampledRouteStore = store
+	}
+}
+
+// loadSampledRoutes loads the sampled routes from the store, if any
+func (c *Collector) loadSampledRoutes() {
+	if c.sampledRouteStore == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSampledRouteLoadTimeout)
+	defer cancel()
+
+	routes, err := c.sampledRouteStore.Load(ctx)
+	if err != nil {
+		// Sample from scratch rather than fail
+		c.log.warnf("Error loading sampled routes: %v", err)
+		return
+	}
+
+	c.storedSampleRoutes = routes
+}
+
+// saveSampledRoute saves the newly sampled route to the store, if any
+func (c *Collector) saveSampledRoute(ctx context.Context, route *config.Route) {
+	if c.sampledRouteStore == nil {
+		return
+	}
+
+	if err := c.sampledRouteStore.Save(ctx, *route); err != nil {
+		c.log.warnf("Error saving sampled route: %v", err)
+	}
+}
+
+// sampleRoutes returns the configured sample routes along with
+// the routes loaded from the store
+func (c *Collector) sampleRoutes() []config.Route {
+	if len(c.storedSampleRoutes) == 0 {
+		return c.configuration.SampleRoutes
+	}
+
+	routes := make([]config.Route, 0, len(c.configuration.SampleRoutes)+len(c.storedSampleRoutes))
+	seen := map[string]bool{}
+	for _, list := range [][]config.Route{c.configuration.SampleRoutes, c.storedSampleRoutes} {
+		for _, route := range list {
+			key := strings.ToUpper(route.HTTPMethod) + " " + route.Path
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			routes = append(routes, route)
+		}
+	}
+
+	return routes
+}
+
+// FileSampledRouteStore is a sampled route store persisting the routes as
+// a JSON array to a file, so a single instance keeps its sampled routes
+// across restarts, e.g. after a deploy
+type FileSampledRouteStore struct {
+	lock sync.Mutex
+	path string
+}
+
+// NewFileSampledRouteStore creates a sampled route store persisting to the
+// file at the path. The file is created on the first save.
+func NewFileSampledRouteStore(path string) *FileSampledRouteStore {
+	return &FileSampledRouteStore{
+		path: path,
+	}
+}
+
+// Load returns the sampled routes saved to the file.
+// Returns no routes if the file doesn't exist yet.
+func (s *FileSampledRouteStore) Load(ctx context.Context) ([]config.Route, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.load()
+}
+
+// Save adds the route to the routes saved to the file
+func (s *FileSampledRouteStore) Save(ctx context.Context, route config.Route) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	routes, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range routes {
+		if strings.EqualFold(r.HTTPMethod, route.HTTPMethod) && r.Path == route.Path {
+			return nil
+		}
+	}
+
+	b, err := json.Marshal(append(routes, route))
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once so a crash mid-write doesn't corrupt it
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// load reads the routes from the file
+func (s *FileSampledRouteStore) load() ([]config.Route, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var routes []config.Route
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,169 @@
+package collect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySampledRouteStore struct {
+	lock    sync.Mutex
+	routes  []config.Route
+	loadErr error
+}
+
+func (s *memorySampledRouteStore) Load(ctx context.Context) ([]config.Route, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.routes, s.loadErr
+}
+
+func (s *memorySampledRouteStore) Save(ctx context.Context, route config.Route) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.routes = append(s.routes, route)
+	return nil
+}
+
+func newSampledRouteStoreCollector(t *testing.T, store SampledRouteStore) *Collector {
+	c, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithFileEventChan(make(chan fsnotify.Event)),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Refresh(context.Background()))
+
+	collector, err := NewCollector(
+		[]EventBuilder{},
+		c.Configuration,
+		WithSampledRouteStore(store),
+	)
+	assert.NoError(t, err)
+
+	return collector
+}
+
+func TestCollector_SeedsSampledRoutesFromStore(t *testing.T) {
+	store := &memorySampledRouteStore{
+		routes: []config.Route{
+			{
+				HTTPMethod: http.MethodGet,
+				Path:       "/person/:id",
+			},
+			{
+				HTTPMethod: http.MethodPost,
+				Path:       "/order/:id",
+			},
+		},
+	}
+	collector := newSampledRouteStoreCollector(t, store)
+
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeSample, http.MethodPost, "/order/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, route)
+
+	// Newly sampled routes are saved
+	collector.Collect(
+		context.Background(),
+		http.MethodGet,
+		"/account/xyz",
+		"/account/{id}",
+		nil,
+		json.RawMessage(`{}`),
+		nil,
+	)
+
+	// No builders, so the event fails to build
+	<-collector.Responses()
+
+	routes, err := store.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, routes, 3)
+	assert.Equal(t, config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/account/:id",
+	}, routes[2])
+}
+
+func TestCollector_SamplesWhenStoreFailsToLoad(t *testing.T) {
+	store := &memorySampledRouteStore{
+		loadErr: errors.New("store outage"),
+	}
+	collector := newSampledRouteStoreCollector(t, store)
+
+	collector.routerLock.Lock()
+	route, err := collector.router.FindRoute(RouteTypeSample, http.MethodGet, "/person/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, route)
+}
+
+func TestFileSampledRouteStore_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sampled.json")
+
+	store := NewFileSampledRouteStore(path)
+	routes, err := store.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, routes)
+
+	route := config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/account/:id",
+	}
+	assert.NoError(t, store.Save(context.Background(), route))
+	// Saving a known route is a no-op
+	assert.NoError(t, store.Save(context.Background(), route))
+
+	// A new process seeds the collector from the file
+	collector := newSampledRouteStoreCollector(t, NewFileSampledRouteStore(path))
+
+	collector.routerLock.Lock()
+	found, err := collector.router.FindRoute(RouteTypeSample, http.MethodGet, "/account/xyz")
+	collector.routerLock.Unlock()
+	assert.NoError(t, err)
+	assert.NotNil(t, found)
+
+	routes, err = NewFileSampledRouteStore(path).Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Route{route}, routes)
+}
+
+func TestFileSampledRouteStore_FailsToLoadCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sampled.json")
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	_, err := NewFileSampledRouteStore(path).Load(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// sequencer stamps events with a monotonic sequence number scoped to an
+// instance ID, so a downstream system can order events sharing a timestamp
+// and detect dropped events.
+type sequencer struct {
+	instanceID string
+	sequence   uint64
+}
+
+// newSequencer creates a new sequencer with a new instance ID.
+// The sequence starts over for every instance.
+func newSequencer() *sequencer {
+	return &sequencer{
+		instanceID: newInstanceID(),
+	}
+}
+
+// stamp sets the instance ID and the next sequence number on the event
+func (s *sequencer) stamp(event *EventRaw) {
+	if s == nil {
+		return
+	}
+
+	event.InstanceID = s.instanceID
+	event.Sequence = atomic.AddUint64(&s.sequence, 1)
+}
+
+// newInstanceID generates a random ID identifying this process
+func newInstanceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back to the start time to stay unique enough
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package collect
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequencer(t *testing.T) {
+	s := newSequencer()
+	assert.Len(t, s.instanceID, 32)
+
+	var wg sync.WaitGroup
+	events := make([]*EventRaw, 100)
+	for i := range events {
+		events[i] = &EventRaw{}
+		wg.Add(1)
+		go func(event *EventRaw) {
+			defer wg.Done()
+			s.stamp(event)
+		}(events[i])
+	}
+	wg.Wait()
+
+	// Every event gets a unique sequence with no gaps
+	seen := map[uint64]bool{}
+	for _, event := range events {
+		assert.Equal(t, s.instanceID, event.InstanceID)
+		seen[event.Sequence] = true
+	}
+	for i := uint64(1); i <= uint64(len(events)); i++ {
+		assert.True(t, seen[i])
+	}
+
+	// A new instance starts a new sequence
+	other := newSequencer()
+	assert.NotEqual(t, s.instanceID, other.instanceID)
+
+	event := &EventRaw{}
+	other.stamp(event)
+	assert.Equal(t, other.instanceID, event.InstanceID)
+	assert.Equal(t, uint64(1), event.Sequence)
+
+	var nilSequencer *sequencer
+	nilSequencer.stamp(event)
+	assert.Equal(t, uint64(1), event.Sequence)
+}
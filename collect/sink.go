@@ -0,0 +1,311 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// SinkHTTP sends batches of events to the events API
+	SinkHTTP string = "http"
+
+	// SinkStdout writes each event as a single line JSON object to stdout,
+	// to be picked up by a logging sidecar such as Fluent Bit or Vector
+	SinkStdout string = "stdout"
+
+	// SinkFile appends each event as a single line JSON object to a file
+	SinkFile string = "file"
+)
+
+// Sink receives every batch of events, e.g. the events API for delivery
+// and a file for durability. Each event gets a response from every sink,
+// tagged with its name.
+type Sink interface {
+	// Name identifies the sink on the responses to its events
+	Name() string
+
+	// Write writes the batch of events
+	Write(ctx context.Context, events []*EventRaw) error
+}
+
+// batchSink is a sink responding to each event of the encoded batch,
+// such as the events API
+type batchSink interface {
+	Sink
+
+	// writeBatch writes the encoded batch of events and returns the
+	// response to each event, in the order of the events
+	writeBatch(ctx context.Context, payload []byte, events []*EventRaw) []Response
+}
+
+// WriterSink is a sink writing events as single line JSON objects
+// to a writer. Lines from concurrent batches are never interleaved.
+type WriterSink struct {
+	name string
+
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink creates a sink writing events as single line JSON objects
+// to the writer, e.g. os.Stdout
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{
+		name: name,
+		w:    w,
+	}
+}
+
+// Name identifies the sink on the responses to its events
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+// Write writes each event of the batch as a line
+func (s *WriterSink) Write(ctx context.Context, events []*EventRaw) error {
+	for _, e := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+
+		s.lock.Lock()
+		_, err = s.w.Write(line)
+		s.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FileSink is a sink appending events as single line JSON objects to a file
+type FileSink struct {
+	*WriterSink
+	file *os.File
+}
+
+// NewFileSink creates a sink appending events to the file at the path,
+// creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		WriterSink: NewWriterSink(SinkFile, f),
+		file:       f,
+	}, nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WithSinks writes every batch of events to the sinks in addition to
+// the event sink, the events API or stdout as configured
+func WithSinks(sinks ...Sink) PublisherOption {
+	return func(p *EventPublisher) error {
+		for _, sink := range sinks {
+			if sink == nil {
+				return errors.New("sink must not be nil")
+			}
+		}
+
+		p.sinks = append(p.sinks, sinks...)
+		return nil
+	}
+}
+
+// stdoutSink writes events to stdout, separate from the diagnostic logs
+// which go to stderr
+var stdoutSink Sink = NewWriterSink(SinkStdout, os.Stdout)
+
+// eventSink returns the configured event sink, the events API by default
+func (b *batchList) eventSink() Sink {
+	if strings.EqualFold(b.configuration.EventSink, SinkStdout) {
+		return stdoutSink
+	}
+
+	return &httpSink{
+		batches: b,
+	}
+}
+
+// httpSink sends batches of events to the events API of the batch list
+type httpSink struct {
+	batches *batchList
+}
+
+// Name identifies the sink on the responses to its events
+func (s *httpSink) Name() string {
+	return SinkHTTP
+}
+
+// Write encodes and sends the batch of events. Fails unless every event
+// is accepted.
+func (s *httpSink) Write(ctx context.Context, events []*EventRaw) error {
+	encoder := s.batches.encoder
+
+	encoded := getBuffer()
+	defer putBuffer(encoded)
+	batch := newEventBatch(encoder, encoded)
+	defer batch.release()
+	for _, e := range events {
+		if _, err := batch.encode(e); err != nil {
+			return err
+		}
+	}
+
+	payload := getBuffer()
+	defer putBuffer(payload)
+	encoder.Batch(payload, encoded.Bytes(), len(events))
+
+	for _, res := range s.writeBatch(ctx, payload.Bytes(), events) {
+		if res.Err != nil {
+			return res.Err
+		}
+	}
+
+	return nil
+}
+
+// writeBatch sends the encoded batch of events to the events API
+// and returns the response to each event
+func (s *httpSink) writeBatch(
+	ctx context.Context,
+	payload []byte,
+	events []*EventRaw,
+) []Response {
+	b := s.batches
+
+	body, contentEncoding := payload, ""
+	if compressesEvents(b.configuration) {
+		compressed := getBuffer()
+		defer putBuffer(compressed)
+		body, contentEncoding = compress(
+			compressed,
+			payload,
+			b.configuration.CompressionMinBytes,
+		)
+	}
+
+	if !b.breaker.allow() {
+		// Events endpoint has been failing. Fail fast until it recovers.
+		b.stats.batchShortCircuited()
+		return responsesFor(Response{Err: ErrCircuitOpen}, events)
+	}
+
+	method := http.MethodPost
+	var req *http.Request
+	var res *http.Response
+	var err error
+
+	// retry once in case of timeouts
+	for n := 0; n < 2; n++ {
+		eventsReader := ioutil.NopCloser(bytes.NewReader(body))
+
+		req, err = http.NewRequestWithContext(
+			ctx,
+			method,
+			b.eventsURL,
+			eventsReader,
+		)
+		if err != nil {
+			b.stats.batchFailed()
+			return responsesFor(Response{Err: err}, events)
+		}
+
+		req.Header.Set("Content-Type", b.encoder.ContentType())
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf("auditr-agent-go/%s", version))
+
+		res, err = b.client.Do(req)
+		if err != nil {
+			b.log.warnf("Retrying due to error posting: %+v", err)
+			continue
+		}
+
+		break
+	}
+
+	if err != nil {
+		b.stats.batchFailed()
+		b.breaker.failure()
+		return responsesFor(Response{Err: err}, events)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		b.breaker.failure()
+	} else {
+		b.breaker.success()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		errRes := Response{
+			Err: fmt.Errorf(
+				"Error sending %s %s: status %d",
+				method,
+				b.eventsURL,
+				res.StatusCode,
+			),
+			StatusCode: res.StatusCode,
+		}
+
+		b.stats.batchFailed()
+
+		if res.StatusCode == http.StatusBadRequest {
+			b.log.debugf("eventsJSON: %s", string(payload))
+		}
+
+		// todo: retry on 5xx
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			errRes.Err = err
+		} else {
+			errRes.Body = body
+		}
+
+		return responsesFor(errRes, events)
+	}
+
+	batchResponses, err := b.responseDecoder.Decode(res.Body)
+	if err != nil {
+		b.stats.batchFailed()
+		return responsesFor(Response{Err: err}, events)
+	}
+
+	b.stats.batchSent(len(events), len(payload), b.maxEventsPerBatch)
+
+	return batchResponses
+}
+
+// responsesFor returns the response for each of the events
+func responsesFor(res Response, events []*EventRaw) []Response {
+	responses := make([]Response, len(events))
+	for i := range responses {
+		responses[i] = res
+	}
+
+	return responses
+}
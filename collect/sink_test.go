@@ -0,0 +1,246 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend_WritesLinesToStdoutSink(t *testing.T) {
+	sink := stdoutSink
+	defer func() {
+		stdoutSink = sink
+	}()
+
+	var out bytes.Buffer
+	stdoutSink = NewWriterSink(SinkStdout, &out)
+
+	events := []*EventRaw{
+		{
+			Organization: &EventOrganization{ID: "org-1"},
+			Request: json.RawMessage(`{
+				"path": "/person/xyz"
+			}`),
+		},
+		{
+			Organization: &EventOrganization{ID: "org-2"},
+		},
+		{
+			Organization: &EventOrganization{ID: "org-3"},
+			enqueuedAt:   time.Now().Add(-time.Minute),
+		},
+	}
+
+	// The events API is never called
+	m := &test.MockTransport{}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		&config.Configuration{
+			EventSink:   SinkStdout,
+			MaxEventAge: time.Second,
+			GetEventsClient: func() *http.Client {
+				return &http.Client{Transport: m}
+			},
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	b.stats = newStatsAggregator()
+	b.breaker = newCircuitBreaker(0, 0)
+
+	b.send(events)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	for i, line := range lines {
+		var event EventRaw
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		assert.Equal(t, events[i].Organization, event.Organization)
+	}
+
+	// Expired events never reach the sink
+	assert.Equal(t, Response{Err: ErrEventExpired}, <-r)
+	assert.Equal(t, Response{StatusCode: http.StatusOK, Sink: SinkStdout}, <-r)
+	assert.Equal(t, Response{StatusCode: http.StatusOK, Sink: SinkStdout}, <-r)
+
+	stats := b.stats.snapshot()
+	assert.Equal(t, uint64(2), stats.EventsSent)
+	assert.Equal(t, uint64(1), stats.EventsExpired)
+	m.AssertNotCalled(t, "RoundTrip")
+}
+
+type failingSink struct{}
+
+func (failingSink) Name() string {
+	return "failing"
+}
+
+func (failingSink) Write(ctx context.Context, events []*EventRaw) error {
+	return errors.New("disk full")
+}
+
+func TestFire_WritesToEverySink(t *testing.T) {
+	var out bytes.Buffer
+	filePath := filepath.Join(t.TempDir(), "events.log")
+	fileSink, err := NewFileSink(filePath)
+	assert.NoError(t, err)
+	defer fileSink.Close()
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}, {"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		&config.Configuration{
+			GetEventsClient: func() *http.Client {
+				return &http.Client{Transport: m}
+			},
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		1,
+	)
+	b.sinks = append(
+		b.sinks,
+		NewWriterSink(SinkStdout, &out),
+		fileSink,
+		failingSink{},
+	)
+
+	b.Add(&EventRaw{Organization: &EventOrganization{ID: "org-1"}})
+	b.Add(&EventRaw{Organization: &EventOrganization{ID: "org-1"}})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	b.Fire(&wg)
+	close(r)
+
+	// Each event gets a response from every sink
+	responses := map[string][]Response{}
+	for res := range r {
+		responses[res.Sink] = append(responses[res.Sink], res)
+	}
+	assert.Len(t, responses, 4)
+	for _, sink := range []string{SinkHTTP, SinkStdout, SinkFile} {
+		assert.Len(t, responses[sink], 2)
+		for _, res := range responses[sink] {
+			assert.NoError(t, res.Err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+	}
+	assert.Len(t, responses["failing"], 2)
+	for _, res := range responses["failing"] {
+		assert.EqualError(t, res.Err, "disk full")
+	}
+
+	assert.Len(t, strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"), 2)
+
+	written, err := ioutil.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, out.String(), string(written))
+}
+
+type recordingSink struct {
+	lock   sync.Mutex
+	events []*EventRaw
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Write(ctx context.Context, events []*EventRaw) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestFire_WritesOnlyEncodedEventsToSinks(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		&config.Configuration{
+			MaxEventAge:   time.Second,
+			MaxEventBytes: 1000,
+			GetEventsClient: func() *http.Client {
+				return &http.Client{Transport: m}
+			},
+		},
+		r,
+		DefaultMaxEventsPerBatch,
+		1,
+	)
+	sink := &recordingSink{}
+	b.sinks = []Sink{sink}
+
+	encoded := &EventRaw{RequestID: "encoded"}
+	b.Add(encoded)
+	b.Add(&EventRaw{
+		RequestID:  "expired",
+		enqueuedAt: time.Now().Add(-time.Minute),
+	})
+	b.Add(&EventRaw{
+		RequestID: "oversized",
+		Request:   json.RawMessage(`"` + strings.Repeat("x", 2000) + `"`),
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	b.Fire(&wg)
+
+	assert.Equal(t, []*EventRaw{encoded}, sink.events)
+}
+
+func TestNewBatchList_WritesToEventSink(t *testing.T) {
+	newSinks := func(eventSink string) []Sink {
+		return newBatchList(
+			&config.Configuration{
+				EventSink: eventSink,
+				GetEventsClient: func() *http.Client {
+					return &http.Client{Transport: &test.MockTransport{}}
+				},
+			},
+			make(chan Response),
+			DefaultMaxEventsPerBatch,
+			DefaultMaxConcurrentBatches,
+		).sinks
+	}
+
+	sinks := newSinks("")
+	assert.Len(t, sinks, 1)
+	assert.Equal(t, SinkHTTP, sinks[0].Name())
+
+	sinks = newSinks("STDOUT")
+	assert.Len(t, sinks, 1)
+	assert.Equal(t, stdoutSink, sinks[0])
+}
@@ -0,0 +1,243 @@
+package collect
+
+import (
+	"sync"
+)
+
+// Stats is a snapshot of the publisher's batch send results
+type Stats struct {
+	// EventsQueued is the number of events added to the publish queue
+	EventsQueued uint64
+
+	// EventsSent is the number of events successfully sent
+	EventsSent uint64
+
+	// EventsDropped is the number of events dropped due to a full queue
+	EventsDropped uint64
+
+	// EventsExpired is the number of events dropped for exceeding
+	// the max event age
+	EventsExpired uint64
+
+	// EventsRateLimited is the number of events dropped because
+	// their org exceeded its rate limit
+	EventsRateLimited uint64
+
+	// EventsOversized is the number of events dropped for exceeding
+	// the max event bytes
+	EventsOversized uint64
+
+	// EventsOverflowed is the number of events reenqueued because
+	// their batch exceeded the max batch bytes
+	EventsOverflowed uint64
+
+	// EventsPaused is the number of events skipped while
+	// collection was paused
+	EventsPaused uint64
+
+	// ResponsesDiscarded is the number of unread responses discarded
+	// to make room for newer ones
+	ResponsesDiscarded uint64
+
+	// BytesSent is the number of encoded bytes successfully sent
+	BytesSent uint64
+
+	// BatchesSent is the number of batches successfully sent
+	BatchesSent uint64
+
+	// BatchFailures is the number of batches that failed to send
+	BatchFailures uint64
+
+	// BatchesShortCircuited is the number of batches not sent
+	// because the circuit breaker was open
+	BatchesShortCircuited uint64
+
+	// AverageBatchFill is the average ratio of events sent per batch
+	// to the max events allowed per batch, between 0 and 1
+	AverageBatchFill float64
+}
+
+// statsAggregator aggregates the results of batch sends
+type statsAggregator struct {
+	lock          sync.Mutex
+	stats         Stats
+	batchCapacity uint64
+
+	// metrics receives the counters as they change
+	metrics Metrics
+}
+
+// newStatsAggregator creates a new stats aggregator
+func newStatsAggregator() *statsAggregator {
+	return &statsAggregator{
+		metrics: noopMetrics{},
+	}
+}
+
+// count reports the change of the counter to the metrics.
+// Called without the lock held so slow metrics don't block the stats.
+func (s *statsAggregator) count(metric Metric, delta uint64) {
+	if s.metrics == nil {
+		return
+	}
+
+	s.metrics.Count(metric, delta)
+}
+
+// eventQueued records an event added to the publish queue
+func (s *statsAggregator) eventQueued() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsQueued++
+	s.lock.Unlock()
+
+	s.count(MetricEventsQueued, 1)
+}
+
+// batchSent records a successfully sent batch
+func (s *statsAggregator) batchSent(numEvents int, numBytes int, maxEventsPerBatch uint) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.BatchesSent++
+	s.stats.EventsSent += uint64(numEvents)
+	s.stats.BytesSent += uint64(numBytes)
+	s.batchCapacity += uint64(maxEventsPerBatch)
+	s.lock.Unlock()
+
+	s.count(MetricBatchesSent, 1)
+}
+
+// batchFailed records a batch that failed to send
+func (s *statsAggregator) batchFailed() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.BatchFailures++
+	s.lock.Unlock()
+
+	s.count(MetricBatchSendErrors, 1)
+}
+
+// batchShortCircuited records a batch not sent due to an open circuit
+func (s *statsAggregator) batchShortCircuited() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.BatchesShortCircuited++
+	s.lock.Unlock()
+
+	s.count(MetricBatchSendErrors, 1)
+}
+
+// eventDropped records an event dropped due to a full queue
+func (s *statsAggregator) eventDropped() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsDropped++
+	s.lock.Unlock()
+
+	s.count(MetricEventsDropped, 1)
+}
+
+// eventExpired records an event dropped for exceeding the max event age
+func (s *statsAggregator) eventExpired() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsExpired++
+	s.lock.Unlock()
+
+	s.count(MetricEventsDropped, 1)
+}
+
+// eventRateLimited records an event dropped due to its org's rate limit
+func (s *statsAggregator) eventRateLimited() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsRateLimited++
+	s.lock.Unlock()
+
+	s.count(MetricEventsDropped, 1)
+}
+
+// eventOversized records an event dropped for exceeding the max event bytes
+func (s *statsAggregator) eventOversized() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsOversized++
+	s.lock.Unlock()
+
+	s.count(MetricEventsDropped, 1)
+}
+
+// eventsOverflowed records events reenqueued because their batch
+// exceeded the max batch bytes
+func (s *statsAggregator) eventsOverflowed(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	s.stats.EventsOverflowed += uint64(n)
+	s.lock.Unlock()
+
+	s.count(MetricOverflowEvents, uint64(n))
+}
+
+// eventPaused records an event skipped while collection was paused
+func (s *statsAggregator) eventPaused() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stats.EventsPaused++
+}
+
+// responsesDiscarded records unread responses discarded to make room
+func (s *statsAggregator) responsesDiscarded(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stats.ResponsesDiscarded += uint64(n)
+}
+
+// snapshot returns a copy of the current stats
+func (s *statsAggregator) snapshot() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	if s.batchCapacity > 0 {
+		stats.AverageBatchFill = float64(stats.EventsSent) / float64(s.batchCapacity)
+	}
+
+	return stats
+}
@@ -0,0 +1,94 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsAggregator_Snapshot(t *testing.T) {
+	s := newStatsAggregator()
+	s.batchSent(5, 100, 10)
+	s.batchSent(10, 200, 10)
+	s.batchFailed()
+	s.eventDropped()
+
+	stats := s.snapshot()
+	assert.Equal(t, uint64(15), stats.EventsSent)
+	assert.Equal(t, uint64(300), stats.BytesSent)
+	assert.Equal(t, uint64(2), stats.BatchesSent)
+	assert.Equal(t, uint64(1), stats.BatchFailures)
+	assert.Equal(t, uint64(1), stats.EventsDropped)
+	assert.Equal(t, 0.75, stats.AverageBatchFill)
+}
+
+func TestSend_UpdatesStats(t *testing.T) {
+	statusCode := http.StatusOK
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
+				{
+					"status": 200
+				}
+			]`)))
+
+			return &http.Response{
+				StatusCode: statusCode,
+				Body:       r,
+			}, nil
+		},
+	}
+
+	configurer, _ := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": false
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+
+	configurer.Refresh(context.Background())
+
+	r := make(chan Response, DefaultPendingWorkCapacity*2)
+	b := newBatchList(
+		configurer.Configuration,
+		r,
+		DefaultMaxEventsPerBatch,
+		DefaultMaxConcurrentBatches,
+	)
+	b.stats = newStatsAggregator()
+
+	b.send([]*EventRaw{{}, {}})
+	stats := b.stats.snapshot()
+	assert.Equal(t, uint64(1), stats.BatchesSent)
+	assert.Equal(t, uint64(2), stats.EventsSent)
+	assert.Equal(t, 0.2, stats.AverageBatchFill)
+
+	statusCode = http.StatusInternalServerError
+	b.send([]*EventRaw{{}})
+	stats = b.stats.snapshot()
+	assert.Equal(t, uint64(1), stats.BatchesSent)
+	assert.Equal(t, uint64(1), stats.BatchFailures)
+}
@@ -0,0 +1,142 @@
+package collect
+
+import (
+	"encoding/json"
+
+	"github.com/tidwall/gjson"
+)
+
+// TruncatedMarker is appended to truncated field values
+const TruncatedMarker = "...[truncated]"
+
+// TruncateJSON truncates the fields of a JSON string at the gjson paths
+// to their max length, appending the truncated marker. Values that aren't
+// strings are truncated by their JSON encoding and kept as strings.
+// Other fields are left intact. Strings that aren't valid JSON are
+// returned untouched.
+func TruncateJSON(s string, limits map[string]int) string {
+	if s == "" || len(limits) == 0 || !gjson.Valid(s) {
+		return s
+	}
+
+	for path, max := range limits {
+		s = truncatePath(s, path, max)
+	}
+
+	return s
+}
+
+// TruncateJSONField truncates the fields of the JSON string held in the
+// given field of a JSON object. If the object or the field can't be
+// parsed, the original raw message is returned untouched.
+func TruncateJSONField(
+	raw json.RawMessage,
+	field string,
+	limits map[string]int,
+) json.RawMessage {
+	if len(limits) == 0 {
+		return raw
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return raw
+	}
+
+	fieldRaw, ok := obj[field]
+	if !ok {
+		return raw
+	}
+
+	var value string
+	if err := json.Unmarshal(fieldRaw, &value); err != nil {
+		return raw
+	}
+
+	truncated := TruncateJSON(value, limits)
+	if truncated == value {
+		return raw
+	}
+
+	fieldRaw, err := json.Marshal(truncated)
+	if err != nil {
+		return raw
+	}
+	obj[field] = fieldRaw
+
+	truncatedRaw, err := json.Marshal(obj)
+	if err != nil {
+		return raw
+	}
+
+	return truncatedRaw
+}
+
+// truncatePath truncates the values at the path, including each value
+// matched by a path query such as items.#.description
+func truncatePath(s string, path string, max int) string {
+	if max < 0 {
+		return s
+	}
+
+	result := gjson.Get(s, path)
+	if !result.Exists() {
+		return s
+	}
+
+	var values []gjson.Result
+	var indexes []int
+	switch {
+	case len(result.Indexes) > 0:
+		values = result.Array()
+		indexes = result.Indexes
+		if len(values) != len(indexes) {
+			return s
+		}
+	case result.Index > 0:
+		values = []gjson.Result{result}
+		indexes = []int{result.Index}
+	default:
+		// Position is unknown, e.g. for modifiers
+		return s
+	}
+
+	// Replace from the end so the earlier positions stay valid
+	for i := len(values) - 1; i >= 0; i-- {
+		truncated, ok := truncateValue(values[i], max)
+		if !ok {
+			continue
+		}
+
+		start := indexes[i]
+		end := start + len(values[i].Raw)
+		if end > len(s) || s[start:end] != values[i].Raw {
+			continue
+		}
+
+		s = s[:start] + truncated + s[end:]
+	}
+
+	return s
+}
+
+// truncateValue returns the value truncated to the max length as
+// a JSON string. Returns false if the value is within the max length.
+func truncateValue(value gjson.Result, max int) (string, bool) {
+	text := value.Raw
+	if value.Type == gjson.String {
+		text = value.String()
+	}
+
+	runes := []rune(text)
+	if len(runes) <= max {
+		return "", false
+	}
+
+	encoded, err := json.Marshal(string(runes[:max]) + TruncatedMarker)
+	if err != nil {
+		return "", false
+	}
+
+	return string(encoded), true
+}
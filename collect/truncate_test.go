@@ -0,0 +1,57 @@
+package collect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateJSON(t *testing.T) {
+	s := `{"id":"x1","description":"a very long description","tags":["a","b"],"items":[{"description":"first item"},{"description":"2nd"}]}`
+
+	truncated := TruncateJSON(s, map[string]int{
+		"description":         6,
+		"tags":                3,
+		"items.#.description": 5,
+		"missing":             1,
+	})
+	assert.JSONEq(t, `{
+		"id": "x1",
+		"description": "a very...[truncated]",
+		"tags": "[\"a...[truncated]",
+		"items": [
+			{"description": "first...[truncated]"},
+			{"description": "2nd"}
+		]
+	}`, truncated)
+
+	// Within the max length
+	assert.Equal(t, s, TruncateJSON(s, map[string]int{"id": 2}))
+
+	// Lengths count characters rather than bytes
+	assert.Equal(t, `{"name":"hé...[truncated]"}`, TruncateJSON(`{"name":"héllo"}`, map[string]int{"name": 2}))
+
+	assert.Equal(t, "not json", TruncateJSON("not json", map[string]int{"description": 1}))
+	assert.Equal(t, s, TruncateJSON(s, nil))
+}
+
+func TestTruncateJSONField(t *testing.T) {
+	raw := json.RawMessage(`{"body":"{\"description\":\"a very long description\"}","statusCode":200}`)
+	truncated := TruncateJSONField(raw, "body", map[string]int{"description": 6})
+
+	var res struct {
+		Body       string `json:"body"`
+		StatusCode int    `json:"statusCode"`
+	}
+	err := json.Unmarshal(truncated, &res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"description":"a very...[truncated]"}`, res.Body)
+	assert.Equal(t, 200, res.StatusCode)
+
+	notJSONBody := json.RawMessage(`{"body":"hi you"}`)
+	assert.Equal(t, notJSONBody, TruncateJSONField(notJSONBody, "body", map[string]int{"description": 1}))
+
+	notObject := json.RawMessage(`"hi"`)
+	assert.Equal(t, notObject, TruncateJSONField(notObject, "body", map[string]int{"description": 1}))
+}
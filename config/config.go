@@ -4,13 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/auditr-io/httpclient"
@@ -22,7 +26,11 @@ const (
 	ConfigPath = ConfigDir + "/auditr-config"
 )
 
-// Acquired configuration
+// Acquired configuration, set on every refresh.
+//
+// Deprecated: Reading these vars races with refreshes. Use
+// AcquiredSnapshot, TargetRoutesSnapshot and SampleRoutesSnapshot,
+// or the Configuration of the instance instead.
 var (
 	ParentOrgID          string
 	OrgIDField           string
@@ -40,40 +48,398 @@ var (
 	BlockOnResponse      bool
 )
 
+// acquiredLock guards the acquired configuration
+var acquiredLock sync.RWMutex
+
+// Acquired is a copy of the acquired configuration
+type Acquired struct {
+	ParentOrgID          string
+	OrgIDField           string
+	BaseURL              string
+	EventsURL            string
+	TargetRoutes         []Route
+	SampleRoutes         []Route
+	Flush                bool
+	CacheDuration        time.Duration
+	MaxEventsPerBatch    uint
+	MaxConcurrentBatches uint
+	PendingWorkCapacity  uint
+	SendInterval         time.Duration
+	BlockOnSend          bool
+	BlockOnResponse      bool
+}
+
+// AcquiredSnapshot returns a copy of the acquired configuration
+// that is safe to read while the configuration is refreshed
+func AcquiredSnapshot() Acquired {
+	acquiredLock.RLock()
+	defer acquiredLock.RUnlock()
+
+	return Acquired{
+		ParentOrgID:          ParentOrgID,
+		OrgIDField:           OrgIDField,
+		BaseURL:              BaseURL,
+		EventsURL:            EventsURL,
+		TargetRoutes:         copyRoutes(TargetRoutes),
+		SampleRoutes:         copyRoutes(SampleRoutes),
+		Flush:                Flush,
+		CacheDuration:        CacheDuration,
+		MaxEventsPerBatch:    MaxEventsPerBatch,
+		MaxConcurrentBatches: MaxConcurrentBatches,
+		PendingWorkCapacity:  PendingWorkCapacity,
+		SendInterval:         SendInterval,
+		BlockOnSend:          BlockOnSend,
+		BlockOnResponse:      BlockOnResponse,
+	}
+}
+
+// TargetRoutesSnapshot returns a copy of the acquired target routes
+func TargetRoutesSnapshot() []Route {
+	acquiredLock.RLock()
+	defer acquiredLock.RUnlock()
+
+	return copyRoutes(TargetRoutes)
+}
+
+// SampleRoutesSnapshot returns a copy of the acquired sample routes
+func SampleRoutesSnapshot() []Route {
+	acquiredLock.RLock()
+	defer acquiredLock.RUnlock()
+
+	return copyRoutes(SampleRoutes)
+}
+
+// copyRoutes returns a copy of the routes
+func copyRoutes(routes []Route) []Route {
+	if routes == nil {
+		return nil
+	}
+
+	copied := make([]Route, len(routes))
+	copy(copied, routes)
+	return copied
+}
+
+const (
+	// DefaultMaxEventBytes is the max bytes allowed per event
+	DefaultMaxEventBytes int = 25000 // 25kb
+
+	// DefaultMaxBatchBytes is the max bytes allowed per batch
+	DefaultMaxBatchBytes int = 5000000 - 2 - 199 // 5MB
+)
+
+var (
+	// DefaultRoleClaims are the claims holding the user's roles or groups
+	DefaultRoleClaims = []string{"cognito:groups", "roles"}
+
+	// DefaultScopeClaims are the claims holding the token's scopes
+	DefaultScopeClaims = []string{"scope", "scp"}
+
+	// UserFieldNames are the event user fields that can be mapped
+	// with user_fields and iam_user_fields
+	UserFieldNames = []string{"id", "email", "full_name", "name", "domain"}
+)
+
+// OverflowPolicy determines what happens when the event queue or
+// the response channel is full. The two are independent:
+//   - block_on_send applies to the event queue. When dropping, the event
+//     is dropped after waiting up to send_timeout if set.
+//   - block_on_response applies to the response channel.
+//
+// Routes may override either with their own policy.
+type OverflowPolicy string
+
+const (
+	// OverflowPolicyDefault defers to the global block_on_send or
+	// block_on_response setting
+	OverflowPolicyDefault OverflowPolicy = ""
+
+	// OverflowPolicyBlock waits for room in the queue or channel
+	OverflowPolicyBlock OverflowPolicy = "block"
+
+	// OverflowPolicyDrop drops the event or response
+	OverflowPolicyDrop OverflowPolicy = "drop"
+)
+
+// Blocks determines whether the policy blocks when full.
+// The default policy falls back to block.
+func (p OverflowPolicy) Blocks(block bool) bool {
+	switch p {
+	case OverflowPolicyBlock:
+		return true
+	case OverflowPolicyDrop:
+		return false
+	default:
+		return block
+	}
+}
+
 // Route is a route used for targeting or sampling
 type Route struct {
 	HTTPMethod string `json:"method"`
 	Path       string `json:"path"`
+	Name       string `json:"name,omitempty"`
+
+	// QueueFullPolicy overrides block_on_send for events of this route
+	QueueFullPolicy OverflowPolicy `json:"queue_full_policy,omitempty"`
+
+	// ResponseFullPolicy overrides block_on_response for responses
+	// to events of this route
+	ResponseFullPolicy OverflowPolicy `json:"response_full_policy,omitempty"`
+
+	// Rate overrides sample_rate for requests to this sample route
+	Rate *float64 `json:"rate,omitempty"`
+
+	// CaptureResponse set to false audits requests to this route without
+	// their response, e.g. exports or PII lookups. Captured by default.
+	CaptureResponse *bool `json:"capture_response,omitempty"`
+}
+
+// CapturesResponse determines whether the response of requests to
+// the route is captured. Routes capture responses by default.
+func (r *Route) CapturesResponse() bool {
+	return r == nil || r.CaptureResponse == nil || *r.CaptureResponse
 }
 
 // Configuration is used to unmarshal acquired configuration
 type Configuration struct {
-	ParentOrgID          string        `json:"parent_org_id"`
-	OrgIDField           string        `json:"org_id_field"`
-	BaseURL              string        `json:"base_url"`
-	EventsPath           string        `json:"events_path"`
-	EventsURL            string        `json:"-"`
-	TargetRoutes         []Route       `json:"target"`
-	SampleRoutes         []Route       `json:"sample"`
-	CacheDuration        time.Duration `json:"-"`
-	Flush                bool          `json:"flush"`
-	MaxEventsPerBatch    uint          `json:"max_events_per_batch"`
-	MaxConcurrentBatches uint          `json:"max_concurrent_batches"`
-	PendingWorkCapacity  uint          `json:"pending_work_capacity"`
-	SendInterval         time.Duration `json:"-"`
-	BlockOnSend          bool          `json:"block_on_send"`
-	BlockOnResponse      bool          `json:"block_on_response"`
+	ParentOrgID             string        `json:"parent_org_id"`
+	OrgIDField              string        `json:"-"`
+	BaseURL                 string        `json:"base_url"`
+	EventsPath              string        `json:"events_path"`
+	EventsURL               string        `json:"-"`
+	TargetRoutes            []Route       `json:"target"`
+	SampleRoutes            []Route       `json:"sample"`
+	CacheDuration           time.Duration `json:"-"`
+	Flush                   bool          `json:"flush"`
+	MaxEventsPerBatch       uint          `json:"max_events_per_batch"`
+	MaxConcurrentBatches    uint          `json:"max_concurrent_batches"`
+	PendingWorkCapacity     uint          `json:"pending_work_capacity"`
+	SendInterval            time.Duration `json:"-"`
+	BlockOnSend             bool          `json:"block_on_send"`
+	BlockOnResponse         bool          `json:"block_on_response"`
+	SendTimeout             time.Duration `json:"-"`
+	CircuitBreakerThreshold uint          `json:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `json:"-"`
+	IgnorePreflight         bool          `json:"-"`
+	MinifyJSON              bool          `json:"minify_json"`
+	RoleClaims              []string      `json:"role_claims"`
+	ScopeClaims             []string      `json:"scope_claims"`
+	EventEncoding           string        `json:"event_encoding"`
+	EventSink               string        `json:"event_sink"`
+	MaxEventAge             time.Duration `json:"-"`
+	CaptureTLS              bool          `json:"capture_tls"`
+	Warmup                  bool          `json:"warmup"`
+	SamplingEnabled         bool          `json:"-"`
+
+	// CaseSensitiveMethods matches route methods as arbitrary strings,
+	// such as gRPC full method names, rather than as HTTP verbs
+	CaseSensitiveMethods bool `json:"case_sensitive_methods"`
+
+	// SampleRate is the ratio, between 0 and 1, of requests to already
+	// sampled routes that are sampled again; 0 samples each route once.
+	// Sample routes may override it with their own rate.
+	SampleRate float64 `json:"sample_rate"`
+
+	// SampleWindow limits sample events to one per route within the
+	// window, e.g. for bursts of requests to a route sampled again at a
+	// high rate. 0 doesn't limit them.
+	SampleWindow time.Duration `json:"-"`
+
+	// ProxyRouteTemplate replaces the {proxy+} catch-all of sampled
+	// proxy integration resources, e.g. "*proxy". If empty, the request
+	// path is sampled instead.
+	ProxyRouteTemplate string `json:"proxy_route_template"`
+
+	// StripTrailingSlash matches and samples request paths without their
+	// trailing slash, so /person/1/ and /person/1 are the same route
+	StripTrailingSlash bool `json:"strip_trailing_slash"`
+
+	// EventsURLOverride is the events endpoint set by SetEventsURL.
+	// Refreshed configurations don't replace it.
+	EventsURLOverride string `json:"-"`
+
+	// MaskedPathParams are the names of route params, e.g. "email" of
+	// /users/:email, whose values are masked in the event's raw path
+	MaskedPathParams []string `json:"masked_path_params"`
+
+	// AuditCIDRs are client IP ranges that are always audited, even
+	// requests to routes that aren't targeted. SkipCIDRs are client IP
+	// ranges that are never audited, e.g. internal health checkers.
+	// Audited ranges win when a client IP is in both.
+	AuditCIDRs []string `json:"audit_cidrs"`
+	SkipCIDRs  []string `json:"skip_cidrs"`
+
+	// AuditNetworks and SkipNetworks are parsed from the CIDRs
+	AuditNetworks []*net.IPNet `json:"-"`
+	SkipNetworks  []*net.IPNet `json:"-"`
+
+	// TwoPhaseEvents also emits an event for requests to targeted routes
+	// as soon as they are received, before the handler runs. This doubles
+	// the event volume of long running handlers' routes.
+	TwoPhaseEvents bool `json:"two_phase_events"`
+
+	// FlushBackoff is the initial delay between flushes on each request
+	// once sends start failing. The delay doubles on every consecutive
+	// failure up to FlushBackoffMax. 0 uses the defaults.
+	FlushBackoff    time.Duration `json:"-"`
+	FlushBackoffMax time.Duration `json:"-"`
+
+	// DeliveryTimeout bounds the delivery of a batch to the events
+	// endpoint, including its retry. Batches are sent detached from the
+	// request that produced their events, so a canceled request doesn't
+	// cancel the send. 0 uses the default.
+	DeliveryTimeout time.Duration `json:"-"`
+
+	// MaxBatchAge flushes a batch once its oldest event has waited this
+	// long, regardless of the events added since, to bound the latency
+	// of each event under low but steady traffic. 0 disables it.
+	MaxBatchAge time.Duration `json:"-"`
+
+	// TruncatedFields are the gjson paths of request and response body
+	// fields, e.g. "items.#.description", mapped to their max length.
+	// Longer values are truncated and marked, leaving the rest intact.
+	TruncatedFields map[string]int `json:"truncated_fields"`
+
+	// DiscardOldestResponses caps the unread responses at the capacity
+	// of the responses channel. Once full, the oldest unread response is
+	// discarded and counted to make room, even if responses block, so a
+	// stalled reader doesn't back pressure sends.
+	DiscardOldestResponses bool `json:"discard_oldest_responses"`
+
+	// MaxEventBytes is the max encoded size of an event. Larger events
+	// are dropped. MaxBatchBytes is the max encoded size of a batch.
+	// Events that don't fit overflow to the next batch. 0 uses the
+	// defaults. The event max must not exceed the batch max.
+	MaxEventBytes int `json:"max_event_bytes"`
+	MaxBatchBytes int `json:"max_batch_bytes"`
+
+	// CompressEvents gzips batches before sending them to the events
+	// endpoint, keeping their content type
+	CompressEvents bool `json:"compress_events"`
+
+	// CompressionMinBytes sends batches whose encoded size doesn't exceed
+	// it uncompressed, as compressing them costs more than it saves.
+	// Setting it also enables compression.
+	CompressionMinBytes int `json:"compression_min_bytes"`
+
+	// ErrorStatusThreshold is the response status at or above which the
+	// response body is also captured as the event error; 0 disables it
+	ErrorStatusThreshold int `json:"error_status_threshold"`
+
+	// ResponseBodyStatusMin and ResponseBodyStatusMax is the inclusive
+	// range of response statuses to capture the response body for.
+	// Other responses only keep the status and headers. A max of 0 has
+	// no upper bound; both 0 captures all response bodies.
+	ResponseBodyStatusMin int `json:"response_body_status_min"`
+	ResponseBodyStatusMax int `json:"response_body_status_max"`
+
+	// ResponseCaptureLimit is the max bytes of a response body captured
+	// by the HTTP wrappers. The rest of the response is streamed to the
+	// client without being buffered. 0 uses the default.
+	ResponseCaptureLimit int `json:"response_capture_limit"`
+
+	// OrgRateLimit is the max events per second per org; 0 is unlimited.
+	// OrgRateLimits overrides the limit for specific org IDs.
+	OrgRateLimit  float64            `json:"org_rate_limit"`
+	OrgRateBurst  uint               `json:"org_rate_burst"`
+	OrgRateLimits map[string]float64 `json:"org_rate_limits"`
+
+	// IAMUserFields maps event user fields (id, email, full_name, name, domain)
+	// to dot separated paths in the authorizer context of IAM authenticated
+	// requests, e.g. {"name": "principalTags.username"}
+	IAMUserFields map[string]string `json:"iam_user_fields"`
+
+	// UserFields maps event user fields (id, email, full_name, name, domain)
+	// to request fields of HTTP requests, overriding the defaults, e.g.
+	// {"id": "request.cookie.session.jwt.sub"}
+	UserFields map[string]string `json:"user_fields"`
+
+	// JWKSURL is where the keys that sign JWTs read from request fields,
+	// e.g. request.cookie.session.jwt.sub, are published. If set, the
+	// JWTs are validated and claims of invalid JWTs are skipped.
+	JWKSURL string `json:"jwks_url"`
+
+	// JWTIssuers are the trusted issuers of JWTs read from request fields.
+	// Unless jwks_url is set, the JWTs are validated against the keys
+	// their issuer publishes at <iss>/.well-known/jwks.json, and JWTs of
+	// other issuers are invalid.
+	JWTIssuers []string `json:"jwt_issuers"`
+
+	// JWTAudiences are the accepted audiences of JWTs validated against
+	// jwks_url or jwt_issuers. If set, JWTs issued to other audiences
+	// are invalid.
+	JWTAudiences []string `json:"jwt_audiences"`
+
+	// DropInvalidJWT drops the event rather than skipping the claims
+	// of a JWT that fails validation
+	DropInvalidJWT bool `json:"drop_invalid_jwt"`
+
+	// AuthorizerContextFields maps event fields (org.id, user.id, user.email,
+	// user.full_name, user.name, user.domain or metadata.<key>) to dot
+	// separated paths in the custom authorizer context, e.g.
+	// {"org.id": "tenantId", "metadata.plan": "plan"}
+	AuthorizerContextFields map[string]string `json:"authorizer_context_fields"`
+
+	// OrgIDFields are candidate org ID sources tried in order.
+	// org_id_field may be a single field or a list of fields.
+	OrgIDFields []string `json:"-"`
 
 	Configurer      *Configurer `json:"-"`
 	GetEventsClient HTTPClientProvider
 }
 
+// SampleRateFor returns the sample rate of the route, falling back to
+// the global sample rate
+func (c *Configuration) SampleRateFor(route *Route) float64 {
+	if route != nil && route.Rate != nil {
+		return *route.Rate
+	}
+
+	return c.SampleRate
+}
+
+// SetEventsURL overrides the events endpoint derived from base_url and
+// events_path, e.g. to send events to a local mock server. The override
+// is kept across configuration refreshes.
+func (c *Configuration) SetEventsURL(eventsURL string) {
+	c.EventsURLOverride = eventsURL
+	c.EventsURL = eventsURL
+}
+
+// OrgIDSources returns the org ID fields to try in order
+func (c *Configuration) OrgIDSources() []string {
+	if len(c.OrgIDFields) > 0 {
+		return c.OrgIDFields
+	}
+
+	if c.OrgIDField != "" {
+		return []string{c.OrgIDField}
+	}
+
+	return nil
+}
+
 // UnmarshalJSON deserializes JSON into configuration
 func (c *Configuration) UnmarshalJSON(b []byte) error {
 	type configurationAlias Configuration
 	cfg := &struct {
-		CacheDurationRaw uint `json:"cache_duration"`
-		SendIntervalRaw  uint `json:"send_interval"`
+		CacheDurationRaw        int             `json:"cache_duration"`
+		SendIntervalRaw         int             `json:"send_interval"`
+		SendTimeoutRaw          int             `json:"send_timeout"`
+		CooldownRaw             int             `json:"circuit_breaker_cooldown"`
+		MaxEventAgeRaw          int             `json:"max_event_age"`
+		FlushBackoffRaw         int             `json:"flush_backoff"`
+		FlushBackoffMaxRaw      int             `json:"flush_backoff_max"`
+		DeliveryTimeoutRaw      int             `json:"delivery_timeout"`
+		MaxBatchAgeRaw          int             `json:"max_batch_age"`
+		SampleWindowRaw         int             `json:"sample_window"`
+		MaxEventsPerBatchRaw    *uint           `json:"max_events_per_batch"`
+		MaxConcurrentBatchesRaw *uint           `json:"max_concurrent_batches"`
+		IgnorePreflightRaw      *bool           `json:"ignore_preflight"`
+		OrgIDFieldRaw           json.RawMessage `json:"org_id_field"`
+		SamplingEnabledRaw      *bool           `json:"sampling_enabled"`
+		EventsURLRaw            string          `json:"events_url"`
 		*configurationAlias
 	}{
 		configurationAlias: (*configurationAlias)(c),
@@ -83,18 +449,255 @@ func (c *Configuration) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	url, err := url.Parse(c.BaseURL)
+	if err := c.setOrgIDFields(cfg.OrgIDFieldRaw); err != nil {
+		return err
+	}
+
+	durations := []struct {
+		name string
+		raw  int
+	}{
+		{"cache_duration", cfg.CacheDurationRaw},
+		{"send_interval", cfg.SendIntervalRaw},
+		{"send_timeout", cfg.SendTimeoutRaw},
+		{"circuit_breaker_cooldown", cfg.CooldownRaw},
+		{"max_event_age", cfg.MaxEventAgeRaw},
+		{"flush_backoff", cfg.FlushBackoffRaw},
+		{"flush_backoff_max", cfg.FlushBackoffMaxRaw},
+		{"delivery_timeout", cfg.DeliveryTimeoutRaw},
+		{"max_batch_age", cfg.MaxBatchAgeRaw},
+		{"sample_window", cfg.SampleWindowRaw},
+	}
+
+	for _, d := range durations {
+		if d.raw < 0 {
+			return fmt.Errorf("%s %d must not be negative", d.name, d.raw)
+		}
+	}
+
+	// Omitted batch sizes fall back to the publisher defaults,
+	// but an explicit 0 is a mistake
+	if cfg.MaxEventsPerBatchRaw != nil {
+		if *cfg.MaxEventsPerBatchRaw == 0 {
+			return errors.New("max_events_per_batch must be greater than 0")
+		}
+		c.MaxEventsPerBatch = *cfg.MaxEventsPerBatchRaw
+	} else {
+		c.MaxEventsPerBatch = 0
+	}
+
+	if cfg.MaxConcurrentBatchesRaw != nil {
+		if *cfg.MaxConcurrentBatchesRaw == 0 {
+			return errors.New("max_concurrent_batches must be greater than 0")
+		}
+		c.MaxConcurrentBatches = *cfg.MaxConcurrentBatchesRaw
+	} else {
+		c.MaxConcurrentBatches = 0
+	}
+
+	auditNetworks, err := ParseCIDRs(c.AuditCIDRs)
+	if err != nil {
+		return err
+	}
+	c.AuditNetworks = auditNetworks
+
+	skipNetworks, err := ParseCIDRs(c.SkipCIDRs)
+	if err != nil {
+		return err
+	}
+	c.SkipNetworks = skipNetworks
+
+	eventsURL, err := c.resolveEventsURL(cfg.EventsURLRaw)
 	if err != nil {
 		return err
 	}
-	url.Path = path.Join(url.Path, c.EventsPath)
-	c.EventsURL = url.String()
+	c.EventsURL = eventsURL
 
 	if cfg.CacheDurationRaw > 0 {
-		c.CacheDuration = time.Duration(cfg.CacheDurationRaw * uint(time.Second))
+		c.CacheDuration = time.Duration(cfg.CacheDurationRaw) * time.Second
+	}
+
+	c.SendInterval = time.Duration(cfg.SendIntervalRaw) * time.Millisecond
+	c.SendTimeout = time.Duration(cfg.SendTimeoutRaw) * time.Millisecond
+	c.CircuitBreakerCooldown = time.Duration(cfg.CooldownRaw) * time.Millisecond
+	c.MaxEventAge = time.Duration(cfg.MaxEventAgeRaw) * time.Millisecond
+	c.FlushBackoff = time.Duration(cfg.FlushBackoffRaw) * time.Millisecond
+	c.FlushBackoffMax = time.Duration(cfg.FlushBackoffMaxRaw) * time.Millisecond
+	c.DeliveryTimeout = time.Duration(cfg.DeliveryTimeoutRaw) * time.Millisecond
+	c.MaxBatchAge = time.Duration(cfg.MaxBatchAgeRaw) * time.Millisecond
+	c.SampleWindow = time.Duration(cfg.SampleWindowRaw) * time.Millisecond
+
+	if c.MaxEventBytes <= 0 {
+		c.MaxEventBytes = DefaultMaxEventBytes
+	}
+
+	if c.MaxBatchBytes <= 0 {
+		c.MaxBatchBytes = DefaultMaxBatchBytes
 	}
 
-	c.SendInterval = time.Duration(cfg.SendIntervalRaw * uint(time.Millisecond))
+	if c.MaxEventBytes > c.MaxBatchBytes {
+		return fmt.Errorf(
+			"max_event_bytes %d exceeds max_batch_bytes %d",
+			c.MaxEventBytes,
+			c.MaxBatchBytes,
+		)
+	}
+
+	if err := validateUserFields("user_fields", c.UserFields); err != nil {
+		return err
+	}
+
+	if err := validateUserFields("iam_user_fields", c.IAMUserFields); err != nil {
+		return err
+	}
+
+	if c.RoleClaims == nil {
+		c.RoleClaims = DefaultRoleClaims
+	}
+
+	if c.ScopeClaims == nil {
+		c.ScopeClaims = DefaultScopeClaims
+	}
+
+	// CORS preflight requests are ignored unless explicitly enabled
+	c.IgnorePreflight = cfg.IgnorePreflightRaw == nil || *cfg.IgnorePreflightRaw
+
+	// Unknown routes are sampled unless explicitly disabled
+	c.SamplingEnabled = cfg.SamplingEnabledRaw == nil || *cfg.SamplingEnabledRaw
+
+	return nil
+}
+
+// ParseCIDRs parses a list of CIDRs such as "10.0.0.0/8".
+// A single IP is parsed as a range of one IP.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// resolveEventsURL resolves the events endpoint. An override set with
+// SetEventsURL, AUDITR_EVENTS_URL or events_url take precedence in that
+// order, so events may be sent to a different host than base_url.
+// Otherwise, an absolute events_path is used as is and a relative
+// events_path is joined to base_url.
+func (c *Configuration) resolveEventsURL(eventsURL string) (string, error) {
+	static := staticEventsURL()
+	switch {
+	case c.EventsURLOverride != "":
+		return c.EventsURLOverride, nil
+	case static != "":
+		return static, nil
+	case eventsURL != "":
+		if _, err := url.Parse(eventsURL); err != nil {
+			return "", err
+		}
+
+		return eventsURL, nil
+	}
+
+	events, err := url.Parse(c.EventsPath)
+	if err != nil {
+		return "", err
+	}
+
+	if events.IsAbs() && events.Host != "" {
+		return c.EventsPath, nil
+	}
+
+	if c.BaseURL == "" {
+		return "", errors.New("base_url must not be empty unless events_url is set")
+	}
+
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base_url %s: %w", c.BaseURL, err)
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("invalid base_url %s: must be an absolute URL", c.BaseURL)
+	}
+	u.Path = path.Join(u.Path, c.EventsPath)
+
+	return u.String(), nil
+}
+
+// validateUserFields fails on unknown user fields, so a misspelled
+// field doesn't silently fall back to the default mapping
+func validateUserFields(name string, fields map[string]string) error {
+	for field := range fields {
+		known := false
+		for _, userField := range UserFieldNames {
+			if field == userField {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf(
+				"unknown user field %s in %s, expected one of %s",
+				field,
+				name,
+				strings.Join(UserFieldNames, ", "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// setOrgIDFields sets the org ID fields from either a single field
+// or a list of fields
+func (c *Configuration) setOrgIDFields(raw json.RawMessage) error {
+	c.OrgIDField = ""
+	c.OrgIDFields = nil
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+
+	var field string
+	if err := json.Unmarshal(raw, &field); err == nil {
+		c.OrgIDField = field
+		return nil
+	}
+
+	var fields []string
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("org_id_field must be a string or a list of strings: %w", err)
+	}
+
+	c.OrgIDFields = fields
+	if len(fields) > 0 {
+		c.OrgIDField = fields[0]
+	}
 
 	return nil
 }
@@ -173,11 +776,9 @@ func Init() error {
 // DefaultEventsClientProvider returns the default HTTP client with authorization parameters
 func DefaultEventsClientProvider() *http.Client {
 	client, err := httpclient.NewClient(
-		EventsURL,
+		AcquiredSnapshot().EventsURL,
 		nil,
-		http.Header{
-			"Authorization": []string{APIKey},
-		},
+		authHeaders(),
 	)
 	if err != nil {
 		log.Fatalf("Failed to create events HTTP client: %#v", err)
@@ -210,7 +811,14 @@ type Configurer struct {
 	getEventsClient HTTPClientProvider
 
 	cancelFunc    context.CancelFunc
+	cancelLock    sync.Mutex
 	lastRefreshed time.Time
+	configured    int32
+
+	// refreshing is the in-flight refresh, if any.
+	// refreshLock also guards lastRefreshed.
+	refreshing  *refreshCall
+	refreshLock sync.Mutex
 
 	configuredc chan Configuration
 
@@ -224,14 +832,20 @@ type Configurer struct {
 // NewConfigurer creates an instance of configurer
 func NewConfigurer(options ...ConfigurerOption) (*Configurer, error) {
 	configuration := &Configuration{
-		CacheDuration: 60 * time.Second,
+		CacheDuration:   60 * time.Second,
+		IgnorePreflight: true,
+		SamplingEnabled: true,
+		MaxEventBytes:   DefaultMaxEventBytes,
+		MaxBatchBytes:   DefaultMaxBatchBytes,
+		RoleClaims:      DefaultRoleClaims,
+		ScopeClaims:     DefaultScopeClaims,
 	}
 
 	c := &Configurer{
 		Configuration:    configuration,
 		lastRefreshed:    time.Now().Add(-configuration.CacheDuration),
 		configuredc:      make(chan Configuration),
-		watcherDonec:     make(chan struct{}),
+		watcherDonec:     make(chan struct{}, 1),
 		refreshListeners: []func(){},
 	}
 
@@ -249,13 +863,56 @@ func NewConfigurer(options ...ConfigurerOption) (*Configurer, error) {
 	return c, nil
 }
 
+// refreshCall is a refresh in flight that concurrent callers wait on
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
 // Refresh refreshes the configuration as the config file
-// is updated
+// is updated. Only one refresh runs at a time; concurrent callers
+// wait on the in-flight refresh and share its result. The context only
+// bounds the refresh; the config file is watched until Stop is called
+// or the next refresh, regardless of the context.
 func (c *Configurer) Refresh(ctx context.Context) error {
+	c.refreshLock.Lock()
+	if call := c.refreshing; call != nil {
+		c.refreshLock.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
 	if time.Since(c.lastRefreshed) < c.Configuration.CacheDuration {
+		c.refreshLock.Unlock()
 		return nil
 	}
 
+	call := &refreshCall{
+		done: make(chan struct{}),
+	}
+	c.refreshing = call
+	c.refreshLock.Unlock()
+
+	call.err = c.refresh(ctx)
+
+	c.refreshLock.Lock()
+	c.refreshing = nil
+	c.refreshLock.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// refresh configures and restarts the config file watcher
+func (c *Configurer) refresh(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := c.configure(); err != nil {
 		// ignore error if config file doesn't exist yet
 		if !errors.Is(err, os.ErrNotExist) {
@@ -263,25 +920,46 @@ func (c *Configurer) Refresh(ctx context.Context) error {
 		}
 	}
 
+	c.cancelLock.Lock()
+	defer c.cancelLock.Unlock()
+
 	// if watcher is already running, cancel it
 	if c.cancelFunc != nil {
 		c.cancelFunc()
 	}
 
-	ctx, c.cancelFunc = context.WithCancel(ctx)
-	if err := c.watchConfigFile(ctx); err != nil {
+	// The watcher outlives the caller, e.g. the request that triggered
+	// the refresh, so it's not derived from the caller's context
+	watchCtx, cancel := context.WithCancel(context.Background())
+	c.cancelFunc = cancel
+	if err := c.watchConfigFile(watchCtx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// Stop stops watching the config file. A later refresh watches it again.
+func (c *Configurer) Stop() {
+	if c == nil {
+		return
+	}
+
+	c.cancelLock.Lock()
+	defer c.cancelLock.Unlock()
+
+	if c.cancelFunc != nil {
+		c.cancelFunc()
+		c.cancelFunc = nil
+	}
+}
+
 // OnRefresh executes work upon configuration refresh
 // The caller goroutine blocks until the configuration is refreshed
 func (c *Configurer) OnRefresh(listener func()) {
 	c.refreshListenersLock.Lock()
 	c.refreshListeners = append(c.refreshListeners, listener)
-	log.Printf("refreshListeners %v", c.refreshListeners)
+	Debugf("refreshListeners %v", c.refreshListeners)
 	c.refreshListenersLock.Unlock()
 }
 
@@ -290,6 +968,11 @@ func (c *Configurer) Configured() <-chan Configuration {
 	return c.configuredc
 }
 
+// IsConfigured returns true once a configuration has been applied
+func (c *Configurer) IsConfigured() bool {
+	return atomic.LoadInt32(&c.configured) == 1
+}
+
 // configure reads the config file and applies the configuration
 func (c *Configurer) configure() error {
 	body, err := c.getConfig()
@@ -305,15 +988,21 @@ func (c *Configurer) configure() error {
 		return err
 	}
 
+	c.refreshLock.Lock()
 	c.lastRefreshed = time.Now()
+	c.refreshLock.Unlock()
+	atomic.StoreInt32(&c.configured, 1)
 
+	// Copy the configuration before handing it off, so the receiver
+	// doesn't read it while it's being changed
+	configured := *c.Configuration
 	go func() {
-		c.configuredc <- *c.Configuration
+		c.configuredc <- configured
 	}()
 
 	c.refreshListenersLock.RLock()
 	for _, listener := range c.refreshListeners {
-		log.Printf("listener %p", listener)
+		Debugf("listener %p", listener)
 		go listener()
 	}
 	c.refreshListenersLock.RUnlock()
@@ -358,8 +1047,11 @@ func (c *Configurer) watchConfigFile(ctx context.Context) error {
 		for {
 			select {
 			case <-ctx.Done():
-				// used for test assertion
-				c.watcherDonec <- struct{}{}
+				// used for test assertion; don't block once nobody's waiting
+				select {
+				case c.watcherDonec <- struct{}{}:
+				default:
+				}
 				return
 			case event, ok := <-c.fileEventc:
 				if !ok {
@@ -375,11 +1067,14 @@ func (c *Configurer) watchConfigFile(ctx context.Context) error {
 				}
 
 				// todo: emit to metrics chan
-				log.Printf("watcher config file found [%dms]", time.Since(c.lastRefreshed).Milliseconds())
+				c.refreshLock.Lock()
+				sinceRefreshed := time.Since(c.lastRefreshed)
+				c.refreshLock.Unlock()
+				Debugf("watcher config file found [%dms]", sinceRefreshed.Milliseconds())
 
 				if err := c.configure(); err != nil {
 					// todo: emit to debug chan
-					log.Printf("watcher error configuring: %+v", err)
+					Warnf("watcher error configuring: %+v", err)
 					continue
 				}
 			case err, ok := <-watcher.Errors:
@@ -387,7 +1082,7 @@ func (c *Configurer) watchConfigFile(ctx context.Context) error {
 					continue
 				}
 				// todo: emit to debug chan
-				log.Printf("error: %+v", err)
+				Warnf("watcher error: %+v", err)
 			}
 		}
 	}()
@@ -401,6 +1096,17 @@ func (c *Configurer) watchConfigFile(ctx context.Context) error {
 
 // setConfig applies the configuration from the file
 func (c *Configurer) setConfig(body []byte) error {
+	// Validate before applying, so an invalid config doesn't
+	// partially replace the current one
+	probe := &Configuration{}
+	if c.Configuration != nil {
+		probe.EventsURLOverride = c.Configuration.EventsURLOverride
+	}
+
+	if err := json.Unmarshal(body, probe); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
 	err := json.Unmarshal(body, &c.Configuration)
 	if err != nil {
 		return err
@@ -408,12 +1114,16 @@ func (c *Configurer) setConfig(body []byte) error {
 
 	c.Configuration.GetEventsClient = c.getEventsClient
 
+	acquiredLock.Lock()
+	defer acquiredLock.Unlock()
+
 	ParentOrgID = c.Configuration.ParentOrgID
 	OrgIDField = c.Configuration.OrgIDField
 	BaseURL = c.Configuration.BaseURL
 	EventsURL = c.Configuration.EventsURL
-	TargetRoutes = c.Configuration.TargetRoutes
-	SampleRoutes = c.Configuration.SampleRoutes
+	// Copy the routes, which the next refresh may unmarshal into
+	TargetRoutes = copyRoutes(c.Configuration.TargetRoutes)
+	SampleRoutes = copyRoutes(c.Configuration.SampleRoutes)
 	CacheDuration = c.Configuration.CacheDuration
 	Flush = c.Configuration.Flush
 	MaxEventsPerBatch = c.Configuration.MaxEventsPerBatch
@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -443,3 +445,585 @@ func TestRefresh_CancelsRunningWatcher(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestRefresh_WatchesBeyondCallerContext(t *testing.T) {
+	fileEventChan := make(chan fsnotify.Event)
+
+	var configures int32
+	c, err := NewConfigurer(
+		WithConfigProvider(
+			func() ([]byte, error) {
+				atomic.AddInt32(&configures, 1)
+				return []byte(`{
+					"base_url": "https://dev-api.auditr.io/v1",
+					"events_path": "/events"
+				}`), nil
+			},
+		),
+		WithFileEventChan(fileEventChan),
+	)
+	assert.NoError(t, err)
+	defer c.Stop()
+
+	// e.g. the context of the request that triggered the refresh
+	reqCtx, cancel := context.WithCancel(context.Background())
+	err = c.Refresh(reqCtx)
+	assert.NoError(t, err)
+	<-c.configuredc
+	cancel()
+
+	select {
+	case <-c.watcherDonec:
+		assert.Fail(t, "watcher stopped with the caller's context")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case fileEventChan <- fsnotify.Event{
+		Op:   fsnotify.Write,
+		Name: ConfigPath,
+	}:
+	case <-time.After(time.Second):
+		assert.Fail(t, "watcher not receiving file events")
+		return
+	}
+
+	select {
+	case <-c.configuredc:
+	case <-time.After(time.Second):
+		assert.Fail(t, "file write not reconfigured")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&configures))
+}
+
+func TestRefresh_ReturnsIfCallerContextDone(t *testing.T) {
+	c, err := NewConfigurer(
+		WithConfigProvider(
+			func() ([]byte, error) {
+				return nil, errors.New("should not be called")
+			},
+		),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.Refresh(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStop_CancelsWatcher(t *testing.T) {
+	c, err := NewConfigurer(
+		WithConfigProvider(
+			func() ([]byte, error) {
+				return nil, os.ErrNotExist
+			},
+		),
+	)
+	assert.NoError(t, err)
+
+	// Stopping before the watcher runs is a no-op
+	c.Stop()
+
+	err = c.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	c.Stop()
+	select {
+	case <-c.watcherDonec:
+	case <-time.After(time.Second):
+		assert.Fail(t, "watcher not stopped")
+	}
+
+	// Stopping again is a no-op
+	c.Stop()
+}
+
+func TestUnmarshalJSON_IgnorePreflight(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.True(t, cfg.IgnorePreflight)
+	assert.True(t, cfg.SamplingEnabled)
+
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"ignore_preflight": false
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.False(t, cfg.IgnorePreflight)
+}
+
+func TestUnmarshalJSON_OrgIDField(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"org_id_field": "request.header.x-org-id"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "request.header.x-org-id", cfg.OrgIDField)
+	assert.Equal(t, []string{"request.header.x-org-id"}, cfg.OrgIDSources())
+
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"org_id_field": ["request.header.x-org-id", "request.body.org_id"]
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "request.header.x-org-id", cfg.OrgIDField)
+	assert.Equal(t, []string{
+		"request.header.x-org-id",
+		"request.body.org_id",
+	}, cfg.OrgIDSources())
+
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.OrgIDSources())
+
+	err = json.Unmarshal([]byte(`{
+		"org_id_field": 1
+	}`), &cfg)
+	assert.Error(t, err)
+}
+
+func TestRefresh_SingleFlight(t *testing.T) {
+	var calls int32
+	c, err := NewConfigurer(
+		WithConfigProvider(
+			func() ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return []byte(`{
+					"base_url": "https://dev-api.auditr.io/v1",
+					"events_path": "/events",
+					"cache_duration": 60
+				}`), nil
+			},
+		),
+		WithFileEventChan(make(chan fsnotify.Event)),
+	)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, c.Refresh(ctx))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestUnmarshalJSON_RouteOverflowPolicies(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"block_on_send": true,
+		"block_on_response": false,
+		"target": [
+			{
+				"method": "POST",
+				"path": "/payments",
+				"queue_full_policy": "drop",
+				"response_full_policy": "block"
+			},
+			{
+				"method": "GET",
+				"path": "/person/:id"
+			}
+		]
+	}`), &cfg)
+	assert.NoError(t, err)
+
+	payments := cfg.TargetRoutes[0]
+	assert.False(t, payments.QueueFullPolicy.Blocks(cfg.BlockOnSend))
+	assert.True(t, payments.ResponseFullPolicy.Blocks(cfg.BlockOnResponse))
+
+	person := cfg.TargetRoutes[1]
+	assert.Equal(t, OverflowPolicyDefault, person.QueueFullPolicy)
+	assert.True(t, person.QueueFullPolicy.Blocks(cfg.BlockOnSend))
+	assert.False(t, person.ResponseFullPolicy.Blocks(cfg.BlockOnResponse))
+}
+
+func TestUnmarshalJSON_SampleRates(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"sample_rate": 0.01,
+		"sample": [
+			{
+				"method": "GET",
+				"path": "/person/:id",
+				"rate": 1
+			},
+			{
+				"method": "GET",
+				"path": "/health",
+				"rate": 0
+			},
+			{
+				"method": "GET",
+				"path": "/order/:id"
+			}
+		]
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, cfg.SampleRateFor(&cfg.SampleRoutes[0]))
+	assert.Equal(t, 0.0, cfg.SampleRateFor(&cfg.SampleRoutes[1]))
+	assert.Equal(t, 0.01, cfg.SampleRateFor(&cfg.SampleRoutes[2]))
+	assert.Equal(t, 0.01, cfg.SampleRateFor(nil))
+}
+
+func TestSetEventsURL_SurvivesRefresh(t *testing.T) {
+	cfg := &Configuration{}
+	cfg.SetEventsURL("http://localhost:8080/events")
+	assert.Equal(t, "http://localhost:8080/events", cfg.EventsURL)
+
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events"
+	}`), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/events", cfg.EventsURL)
+}
+
+func TestUnmarshalJSON_EventsURL(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev-api.auditr.io/v1/events", cfg.EventsURL)
+
+	// Events may be sent to a different host
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"events_url": "https://events.example.com/ingest"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://events.example.com/ingest", cfg.EventsURL)
+
+	staticEventsURL := StaticEventsURL
+	defer func() {
+		StaticEventsURL = staticEventsURL
+	}()
+
+	StaticEventsURL = "https://static.example.com/events"
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"events_url": "https://events.example.com/ingest"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://static.example.com/events", cfg.EventsURL)
+}
+
+func TestUnmarshalJSON_AbsoluteEventsPath(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "https://events.example.com/ingest"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://events.example.com/ingest", cfg.EventsURL)
+
+	// Relative paths are joined to the base URL
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "ingest/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev-api.auditr.io/v1/ingest/events", cfg.EventsURL)
+
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev-api.auditr.io/v1/events", cfg.EventsURL)
+}
+
+func TestUnmarshalJSON_MaxBytes(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultMaxEventBytes, cfg.MaxEventBytes)
+	assert.Equal(t, DefaultMaxBatchBytes, cfg.MaxBatchBytes)
+
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"max_event_bytes": 100000,
+		"max_batch_bytes": 1000000
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 100000, cfg.MaxEventBytes)
+	assert.Equal(t, 1000000, cfg.MaxBatchBytes)
+
+	// Events must fit in a batch, including the default batch size
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"max_event_bytes": 2000,
+		"max_batch_bytes": 1000
+	}`), &cfg)
+	assert.EqualError(t, err, "max_event_bytes 2000 exceeds max_batch_bytes 1000")
+
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"max_event_bytes": 10000000
+	}`), &cfg)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalJSON_UserFields(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"user_fields": {
+			"id": "request.header.x-account-id",
+			"full_name": "request.body.profile.name"
+		},
+		"iam_user_fields": {
+			"name": "principalTags.username"
+		}
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"id":        "request.header.x-account-id",
+		"full_name": "request.body.profile.name",
+	}, cfg.UserFields)
+
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"user_fields": {
+			"fullname": "request.body.profile.name"
+		}
+	}`), &cfg)
+	assert.EqualError(
+		t,
+		err,
+		"unknown user field fullname in user_fields, expected one of id, email, full_name, name, domain",
+	)
+
+	cfg = nil
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"iam_user_fields": {
+			"username": "principalTags.username"
+		}
+	}`), &cfg)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalJSON_Validates(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{
+			config: `{"events_path": "/events"}`,
+			err:    "base_url must not be empty unless events_url is set",
+		},
+		{
+			config: `{"base_url": "dev-api.auditr.io", "events_path": "/events"}`,
+			err:    "invalid base_url dev-api.auditr.io: must be an absolute URL",
+		},
+		{
+			config: `{"base_url": "https://dev-api.auditr.io/v1", "max_concurrent_batches": 0}`,
+			err:    "max_concurrent_batches must be greater than 0",
+		},
+		{
+			config: `{"base_url": "https://dev-api.auditr.io/v1", "max_events_per_batch": 0}`,
+			err:    "max_events_per_batch must be greater than 0",
+		},
+		{
+			config: `{"base_url": "https://dev-api.auditr.io/v1", "send_interval": -1}`,
+			err:    "send_interval -1 must not be negative",
+		},
+		{
+			config: `{"base_url": "https://dev-api.auditr.io/v1", "cache_duration": -60}`,
+			err:    "cache_duration -60 must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		var cfg *Configuration
+		err := json.Unmarshal([]byte(tt.config), &cfg)
+		assert.EqualError(t, err, tt.err, tt.config)
+	}
+
+	// Omitted batch sizes fall back to the defaults of the publisher,
+	// and base_url isn't needed with events_url
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"events_url": "https://events.auditr.io/events"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), cfg.MaxConcurrentBatches)
+	assert.Equal(t, uint(0), cfg.MaxEventsPerBatch)
+}
+
+func TestSetConfig_KeepsConfigurationOnInvalidConfig(t *testing.T) {
+	c := &Configurer{}
+	assert.NoError(t, c.setConfig([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"max_concurrent_batches": 5,
+		"send_interval": 20
+	}`)))
+
+	err := c.setConfig([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v2",
+		"events_path": "/events",
+		"max_concurrent_batches": 0,
+		"send_interval": 40
+	}`))
+	assert.EqualError(t, err, "invalid config: max_concurrent_batches must be greater than 0")
+
+	assert.Equal(t, "https://dev-api.auditr.io/v1", c.Configuration.BaseURL)
+	assert.Equal(t, uint(5), c.Configuration.MaxConcurrentBatches)
+	assert.Equal(t, 20*time.Millisecond, c.Configuration.SendInterval)
+}
+
+func TestUnmarshalJSON_CIDRs(t *testing.T) {
+	var cfg *Configuration
+	err := json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"audit_cidrs": ["0.0.0.0/0"],
+		"skip_cidrs": ["10.0.0.0/8", "2001:db8::1"]
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.AuditNetworks, 1)
+	if assert.Len(t, cfg.SkipNetworks, 2) {
+		assert.True(t, cfg.SkipNetworks[0].Contains(net.ParseIP("10.1.2.3")))
+		assert.True(t, cfg.SkipNetworks[1].Contains(net.ParseIP("2001:db8::1")))
+		assert.False(t, cfg.SkipNetworks[1].Contains(net.ParseIP("2001:db8::2")))
+	}
+
+	err = json.Unmarshal([]byte(`{
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"skip_cidrs": ["10.0.0.0/33"]
+	}`), &cfg)
+	assert.Error(t, err)
+}
+
+func TestAcquiredSnapshot_SafeDuringRefresh(t *testing.T) {
+	bodies := [][]byte{
+		[]byte(`{
+			"parent_org_id": "org-1",
+			"base_url": "https://dev-api.auditr.io/v1",
+			"events_path": "/events",
+			"target": [{"method": "GET", "path": "/person/:id"}],
+			"sample": []
+		}`),
+		[]byte(`{
+			"parent_org_id": "org-2",
+			"base_url": "https://dev-api.auditr.io/v1",
+			"events_path": "/events",
+			"target": [
+				{"method": "GET", "path": "/person/:id"},
+				{"method": "PUT", "path": "/person/:id"}
+			],
+			"sample": [{"method": "GET", "path": "/account/:id"}]
+		}`),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			c := &Configurer{}
+			for n := 0; n < 100; n++ {
+				assert.NoError(t, c.setConfig(bodies[(i+n)%len(bodies)]))
+			}
+		}(i)
+
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				acquired := AcquiredSnapshot()
+				targets := TargetRoutesSnapshot()
+				SampleRoutesSnapshot()
+
+				if acquired.ParentOrgID != "" {
+					assert.Contains(t, []string{"org-1", "org-2"}, acquired.ParentOrgID)
+				}
+
+				// Copies aren't affected by later refreshes
+				for j := range targets {
+					targets[j].Path = "/changed"
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, route := range TargetRoutesSnapshot() {
+		assert.Equal(t, "/person/:id", route.Path)
+	}
+}
+
+func TestDefaultEventsClientProvider_SafeDuringRefresh(t *testing.T) {
+	body := []byte(`{
+		"parent_org_id": "org-1",
+		"base_url": "https://dev-api.auditr.io/v1",
+		"events_path": "/events",
+		"target": [],
+		"sample": []
+	}`)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		c := &Configurer{}
+		for n := 0; n < 100; n++ {
+			assert.NoError(t, c.setConfig(body))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			assert.NotNil(t, DefaultEventsClientProvider())
+			(&Configuration{}).Targets()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		ensureSeedConfig()
+	}()
+	wg.Wait()
+}
@@ -2,10 +2,14 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/auditr-io/httpclient"
@@ -14,6 +18,22 @@ import (
 
 const (
 	MinInterval time.Duration = 60 * time.Second
+
+	// DefaultInitialRetries is the number of times a failed initial fetch
+	// is retried before falling back to the interval
+	DefaultInitialRetries int = 3
+
+	// DefaultInitialRetryBackoff is the delay before the first retry of
+	// the initial fetch. The delay doubles on every retry.
+	DefaultInitialRetryBackoff time.Duration = 500 * time.Millisecond
+
+	// DefaultMaxErrorBackoff caps the delay between fetches while the
+	// config endpoint keeps failing
+	DefaultMaxErrorBackoff time.Duration = 10 * time.Minute
+
+	// maxErrorBodyBytes is the max bytes of the body of a failed config
+	// response included in its error
+	maxErrorBodyBytes int64 = 256
 )
 
 // FetcherOptions allow override of defaults
@@ -23,6 +43,32 @@ type FetcherOptions struct {
 	Interval      time.Duration
 	HTTPTransport http.RoundTripper
 	WriteCache    func([]byte) error
+
+	// APIKey overrides AUDITR_API_KEY. The env vars aren't read if both
+	// ConfigURL and APIKey are set.
+	APIKey string
+
+	// InitialRetries overrides the number of retries of a failed initial
+	// fetch; negative disables the retries. InitialRetryBackoff overrides
+	// the delay before the first retry.
+	InitialRetries      int
+	InitialRetryBackoff time.Duration
+
+	// MaxErrorBackoff overrides the max delay between fetches while the
+	// config endpoint keeps failing
+	MaxErrorBackoff time.Duration
+}
+
+// FetcherStatus is a snapshot of the fetcher's state
+type FetcherStatus struct {
+	// LastFetchedAt is the last time a config was fetched and cached successfully
+	LastFetchedAt time.Time
+
+	// LastError is the error from the last fetch; nil if the last fetch succeeded
+	LastError error
+
+	// Interval is the current interval between fetches
+	Interval time.Duration
 }
 
 // Fetcher periodically fetches config and caches the config locally
@@ -34,15 +80,51 @@ type Fetcher struct {
 	httpTransport     http.RoundTripper
 	writeCache        func([]byte) error
 
+	initialRetries      int
+	initialRetryBackoff time.Duration
+
+	// failures counts the consecutive failed fetches on the interval,
+	// backing off up to maxErrorBackoff
+	failures        int
+	maxErrorBackoff time.Duration
+
 	httpClient *http.Client
 	refreshesc chan []byte
 	errc       chan error
 	ticker     *time.Ticker
+
+	// donec stops the refresh goroutine on Close, which waits for it
+	// with wg before closing the channels
+	closeLock sync.Mutex
+	closed    bool
+	donec     chan struct{}
+	wg        sync.WaitGroup
+
+	statusLock    sync.RWMutex
+	lastFetchedAt time.Time
+	lastErr       error
+
+	// etag and lastModified validate the last cached config, so an
+	// unchanged config isn't downloaded, cached and refreshed again
+	etag         string
+	lastModified string
+}
+
+// fetchedConfig is the config fetched from the config endpoint
+type fetchedConfig struct {
+	body         []byte
+	etag         string
+	lastModified string
+
+	// notModified is set if the config is unchanged since the last fetch
+	notModified bool
 }
 
 // NewFetcher creates a new fetcher with given options
 func NewFetcher(opts FetcherOptions) (*Fetcher, error) {
-	ensureSeedConfig()
+	if opts.ConfigURL == "" || opts.APIKey == "" {
+		ensureSeedConfig()
+	}
 
 	f := &Fetcher{
 		httpTransport:     opts.HTTPTransport,
@@ -51,6 +133,23 @@ func NewFetcher(opts FetcherOptions) (*Fetcher, error) {
 		intervalOverriden: false,
 		refreshesc:        make(chan []byte, 1),
 		errc:              make(chan error, 1),
+		donec:             make(chan struct{}),
+
+		initialRetries:      DefaultInitialRetries,
+		initialRetryBackoff: DefaultInitialRetryBackoff,
+		maxErrorBackoff:     DefaultMaxErrorBackoff,
+	}
+
+	if opts.InitialRetries != 0 {
+		f.initialRetries = opts.InitialRetries
+	}
+
+	if opts.InitialRetryBackoff > 0 {
+		f.initialRetryBackoff = opts.InitialRetryBackoff
+	}
+
+	if opts.MaxErrorBackoff > 0 {
+		f.maxErrorBackoff = opts.MaxErrorBackoff
 	}
 
 	f.setInterval(MinInterval)
@@ -76,9 +175,7 @@ func NewFetcher(opts FetcherOptions) (*Fetcher, error) {
 	c, err := httpclient.NewClient(
 		f.configURL,
 		f.httpTransport,
-		http.Header{
-			"Authorization": []string{APIKey},
-		},
+		authHeadersWithKey(opts.APIKey),
 	)
 	if err != nil {
 		return nil, err
@@ -95,11 +192,13 @@ func (f *Fetcher) setInterval(interval time.Duration) {
 
 	// set a random, slightly earlier interval
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	f.statusLock.Lock()
 	f.interval = interval - time.Duration(r.Intn(10))*time.Second
 	if f.interval <= 0 {
 		// shouldn't happen. just in case
 		f.interval = MinInterval + f.interval
 	}
+	f.statusLock.Unlock()
 
 	if f.ticker != nil {
 		f.ticker.Reset(f.interval)
@@ -108,66 +207,276 @@ func (f *Fetcher) setInterval(interval time.Duration) {
 
 // Refresh sets up the interval to fetch a fresh config
 func (f *Fetcher) Refresh(ctx context.Context) {
+	f.closeLock.Lock()
+	if f.closed {
+		f.closeLock.Unlock()
+		return
+	}
+	f.wg.Add(1)
+	f.closeLock.Unlock()
+
 	// don't wait for the first interval
-	f.fetchAndCache()
+	err := f.fetchAndCache()
 
 	f.ticker = time.NewTicker(f.interval)
 
 	go func() {
+		defer f.wg.Done()
+
+		if err != nil {
+			// Recover quickly from a transient failure on cold start
+			// rather than going without config for a whole interval
+			f.retryInitialFetch(ctx)
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
 				f.ticker.Stop()
 				return
 
+			case <-f.donec:
+				return
+
 			case <-f.ticker.C:
-				f.fetchAndCache()
+				f.backOff(f.fetchAndCache())
 			}
 		}
 	}()
 }
 
+// retryInitialFetch retries a failed initial fetch with backoff until
+// it succeeds or the retries run out
+func (f *Fetcher) retryInitialFetch(ctx context.Context) {
+	backoff := f.initialRetryBackoff
+	for i := 0; i < f.initialRetries; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.donec:
+			return
+		case <-time.After(backoff):
+		}
+
+		if err := f.fetchAndCache(); err == nil {
+			return
+		}
+
+		backoff *= 2
+	}
+}
+
+// backOff delays the next fetch exponentially on consecutive failures,
+// so a failing config endpoint isn't fetched every interval. The interval
+// is restored on the first success.
+func (f *Fetcher) backOff(err error) {
+	f.statusLock.RLock()
+	interval := f.interval
+	f.statusLock.RUnlock()
+
+	if err == nil {
+		if f.failures > 0 {
+			f.failures = 0
+			f.ticker.Reset(interval)
+		}
+		return
+	}
+
+	f.failures++
+	f.ticker.Reset(f.errorBackoff(interval, f.failures))
+}
+
+// errorBackoff returns the delay before the next fetch after the
+// consecutive failures: the interval doubled on every failure, capped at
+// the max error backoff, less up to 10% jitter so a fleet doesn't retry
+// in lockstep
+func (f *Fetcher) errorBackoff(interval time.Duration, failures int) time.Duration {
+	delay := f.maxErrorBackoff
+	if failures < 32 {
+		if d := interval << uint(failures); d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return delay - time.Duration(r.Int63n(int64(delay)/10+1))
+}
+
 // fetchAndCache fetches and caches config
-func (f *Fetcher) fetchAndCache() {
-	cfg, err := f.GetConfig()
+func (f *Fetcher) fetchAndCache() error {
+	fetched, err := f.fetch(true)
 	if err != nil {
-		f.errc <- err
-		return
+		f.setStatus(err)
+		f.sendError(err)
+		return err
 	}
 
+	if fetched.notModified {
+		// The cached config is still fresh
+		f.setStatus(nil)
+		return nil
+	}
+
+	cfg := fetched.body
 	if err := f.writeCache(cfg); err != nil {
-		f.errc <- err
-		return
+		f.setStatus(err)
+		f.sendError(err)
+		return err
 	}
 
-	f.refreshesc <- cfg
+	f.statusLock.Lock()
+	f.etag = fetched.etag
+	f.lastModified = fetched.lastModified
+	f.statusLock.Unlock()
+
+	f.setStatus(nil)
+	select {
+	case <-f.donec:
+	case f.refreshesc <- cfg:
+	}
 
 	cd := gjson.Get(string(cfg), "cache_duration")
 	f.setInterval(time.Duration(cd.Int() * int64(time.Second)))
+
+	return nil
+}
+
+// sendError sends the error to the stream of errors, unless the
+// fetcher is closed while waiting for it to be read
+func (f *Fetcher) sendError(err error) {
+	select {
+	case <-f.donec:
+	case f.errc <- err:
+	}
+}
+
+// Close stops refreshing, waits for the refresh goroutine to exit and
+// closes the streams of refreshed configs and errors. Safe to call more
+// than once.
+func (f *Fetcher) Close() {
+	f.closeLock.Lock()
+	if f.closed {
+		f.closeLock.Unlock()
+		return
+	}
+	f.closed = true
+	close(f.donec)
+	f.closeLock.Unlock()
+
+	f.wg.Wait()
+
+	if f.ticker != nil {
+		f.ticker.Stop()
+	}
+
+	close(f.refreshesc)
+	close(f.errc)
+}
+
+// setStatus records the outcome of a fetch
+func (f *Fetcher) setStatus(err error) {
+	f.statusLock.Lock()
+	defer f.statusLock.Unlock()
+
+	f.lastErr = err
+	if err == nil {
+		f.lastFetchedAt = time.Now()
+	}
 }
 
-// GetConfig gets a fresh config
+// Status returns a snapshot of the fetcher's state
+func (f *Fetcher) Status() FetcherStatus {
+	f.statusLock.RLock()
+	defer f.statusLock.RUnlock()
+
+	return FetcherStatus{
+		LastFetchedAt: f.lastFetchedAt,
+		LastError:     f.lastErr,
+		Interval:      f.interval,
+	}
+}
+
+// GetConfig gets a fresh config.
+// Responses other than 200 OK are returned as errors.
 func (f *Fetcher) GetConfig() ([]byte, error) {
-	res, err := f.httpClient.Get(f.configURL)
+	fetched, err := f.fetch(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return fetched.body, nil
+}
+
+// fetch fetches the config. If conditional, the config is only
+// downloaded if it changed since the last cached config.
+func (f *Fetcher) fetch(conditional bool) (*fetchedConfig, error) {
+	req, err := http.NewRequest(http.MethodGet, f.configURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if conditional {
+		f.statusLock.RLock()
+		if f.etag != "" {
+			req.Header.Set("If-None-Match", f.etag)
+		}
+		if f.lastModified != "" {
+			req.Header.Set("If-Modified-Since", f.lastModified)
+		}
+		f.statusLock.RUnlock()
+	}
+
+	res, err := f.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
+
+	if conditional && res.StatusCode == http.StatusNotModified {
+		return &fetchedConfig{
+			notModified: true,
+		}, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		// The body of errors, e.g. of an invalid API key, isn't config
+		snippet, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+		return nil, fmt.Errorf(
+			"error getting config from %s: status %d: %s",
+			f.configURL,
+			res.StatusCode,
+			strings.TrimSpace(string(snippet)),
+		)
+	}
+
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return body, nil
+	return &fetchedConfig{
+		body:         body,
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// ETag returns the entity tag of the last cached config.
+// Empty if the config endpoint doesn't tag its configs.
+func (f *Fetcher) ETag() string {
+	f.statusLock.RLock()
+	defer f.statusLock.RUnlock()
+
+	return f.etag
 }
 
 // Refreshes returns the stream of refreshed configs
-// Config may be nil if refresh failed
+// Config may be nil if refresh failed. Closed once the fetcher is closed.
 func (f *Fetcher) Refreshes() <-chan []byte {
 	return f.refreshesc
 }
 
-// Errors returns the stream of errors
+// Errors returns the stream of errors. Closed once the fetcher is closed.
 func (f *Fetcher) Errors() <-chan error {
 	return f.errc
 }
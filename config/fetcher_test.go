@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -62,6 +63,95 @@ func TestGetConfig(t *testing.T) {
 	assert.Equal(t, wantCfg, cfg)
 }
 
+func TestGetConfig_ReturnsErrorOnStatus(t *testing.T) {
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusForbidden,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"message":"invalid api key"}`)),
+			}, nil
+		},
+	}
+
+	cached := false
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		WriteCache: func(cfg []byte) error {
+			cached = true
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	cfg, err := f.GetConfig()
+	assert.Nil(t, cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "status 403")
+		assert.Contains(t, err.Error(), "invalid api key")
+	}
+
+	// The error is forwarded rather than the body cached
+	assert.Error(t, f.fetchAndCache())
+	assert.Contains(t, (<-f.Errors()).Error(), "status 403")
+	assert.False(t, cached)
+	assert.Error(t, f.Status().LastError)
+}
+
+func TestFetchAndCache_SkipsUnmodifiedConfig(t *testing.T) {
+	wantCfg := []byte(`{"cache_duration": 2}`)
+	fetches := 0
+
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			fetches++
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Body:       ioutil.NopCloser(bytes.NewBuffer(nil)),
+				}, nil
+			}
+
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Etag": []string{`"v1"`}},
+				Body:       ioutil.NopCloser(bytes.NewBuffer(wantCfg)),
+			}, nil
+		},
+	}
+
+	writes := 0
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		WriteCache: func(cfg []byte) error {
+			writes++
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, f.fetchAndCache())
+	assert.Equal(t, wantCfg, <-f.Refreshes())
+	assert.Equal(t, `"v1"`, f.ETag())
+
+	assert.NoError(t, f.fetchAndCache())
+	select {
+	case cfg := <-f.Refreshes():
+		assert.Fail(t, "unexpected refresh", "%s", cfg)
+	default:
+	}
+
+	assert.Equal(t, 2, fetches)
+	assert.Equal(t, 1, writes)
+	assert.NoError(t, f.Status().LastError)
+
+	// GetConfig always downloads the config
+	cfg, err := f.GetConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, wantCfg, cfg)
+}
+
 func TestRefresh(t *testing.T) {
 	wantRefreshes := 3
 	refreshes := 0
@@ -359,3 +449,237 @@ func TestRefreshWithErrors(t *testing.T) {
 	wg.Wait()
 	assert.Equal(t, wantRefreshes, refreshes)
 }
+
+func TestStatus(t *testing.T) {
+	wantErr := errors.New("error getting config")
+	fail := true
+
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			if fail {
+				return nil, wantErr
+			}
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(`{}`))),
+			}, nil
+		},
+	}
+
+	interval := 10 * time.Second
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		WriteCache: func(cfg []byte) error {
+			return nil
+		},
+		Interval: interval,
+	})
+	assert.NoError(t, err)
+
+	status := f.Status()
+	assert.True(t, status.LastFetchedAt.IsZero())
+	assert.NoError(t, status.LastError)
+	assert.Equal(t, interval, status.Interval)
+
+	f.fetchAndCache()
+	<-f.Errors()
+
+	status = f.Status()
+	assert.True(t, status.LastFetchedAt.IsZero())
+	if err, ok := status.LastError.(*url.Error); assert.True(t, ok) {
+		assert.Equal(t, wantErr, err.Err)
+	}
+
+	fail = false
+	f.fetchAndCache()
+	<-f.Refreshes()
+
+	status = f.Status()
+	assert.False(t, status.LastFetchedAt.IsZero())
+	assert.NoError(t, status.LastError)
+}
+
+func TestRefresh_RetriesInitialFetch(t *testing.T) {
+	wantErr := errors.New("error getting config")
+	var attempts int32
+
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				return nil, wantErr
+			}
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(`{}`))),
+			}, nil
+		},
+	}
+
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		WriteCache: func(cfg []byte) error {
+			return nil
+		},
+		// Long enough that only the retries could refresh
+		Interval:            time.Hour,
+		InitialRetryBackoff: time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f.Refresh(ctx)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-f.Errors():
+			if err, ok := err.(*url.Error); assert.True(t, ok) {
+				assert.Equal(t, wantErr, err.Err)
+			}
+		case <-time.After(time.Second):
+			assert.FailNow(t, "expected an error")
+		}
+	}
+
+	select {
+	case <-f.Refreshes():
+	case <-time.After(time.Second):
+		assert.FailNow(t, "expected the initial fetch to be retried")
+	}
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.NoError(t, f.Status().LastError)
+}
+
+func TestRefresh_BacksOffOnRepeatedErrors(t *testing.T) {
+	wantErr := errors.New("error getting config")
+	attemptsc := make(chan time.Time, 10)
+
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			attemptsc <- time.Now()
+			return nil, wantErr
+		},
+	}
+
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		WriteCache: func(cfg []byte) error {
+			return nil
+		},
+		Interval:        20 * time.Millisecond,
+		InitialRetries:  -1,
+		MaxErrorBackoff: time.Second,
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-f.Errors():
+			}
+		}
+	}()
+
+	f.Refresh(ctx)
+
+	var attempts []time.Time
+	for i := 0; i < 5; i++ {
+		select {
+		case at := <-attemptsc:
+			attempts = append(attempts, at)
+		case <-time.After(2 * time.Second):
+			assert.FailNow(t, "expected another attempt")
+		}
+	}
+
+	// The gap between attempts grows on every failure
+	for i := 2; i < len(attempts); i++ {
+		prevGap := attempts[i-1].Sub(attempts[i-2])
+		gap := attempts[i].Sub(attempts[i-1])
+		assert.Greater(t, int64(gap), int64(prevGap), "attempt %d", i)
+	}
+}
+
+func TestErrorBackoff_DoublesUpToMax(t *testing.T) {
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:       "https://" + t.Name() + ".auditr.io",
+		MaxErrorBackoff: 5 * time.Minute,
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		delay := f.errorBackoff(time.Minute, tt.failures)
+		assert.LessOrEqual(t, int64(delay), int64(tt.expected), "failures %d", tt.failures)
+		assert.GreaterOrEqual(t, int64(delay), int64(tt.expected*9/10), "failures %d", tt.failures)
+	}
+}
+
+func TestClose_StopsRefreshing(t *testing.T) {
+	var attempts int32
+	m := &testmock.MockTransport{
+		RoundTripFn: func(m *testmock.MockTransport, req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return nil, errors.New("error getting config")
+		},
+	}
+
+	f, err := NewFetcher(FetcherOptions{
+		ConfigURL:     "https://" + t.Name() + ".auditr.io",
+		HTTPTransport: m,
+		Interval:      time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	// Errors aren't read, so the refresh goroutine blocks sending them
+	f.Refresh(context.Background())
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.FailNow(t, "expected close to return")
+	}
+
+	// Buffered errors are drained before the stream ends
+	for range f.Errors() {
+	}
+	_, ok := <-f.Refreshes()
+	assert.False(t, ok)
+
+	closedAttempts := atomic.LoadInt32(&attempts)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, closedAttempts, atomic.LoadInt32(&attempts))
+
+	// Closing again or refreshing once closed is a no-op
+	f.Close()
+	f.Refresh(context.Background())
+	assert.Equal(t, closedAttempts, atomic.LoadInt32(&attempts))
+}
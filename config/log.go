@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel is the minimum level of messages logged by the agent
+type LogLevel int32
+
+const (
+	// LogLevelDebug logs everything, including per request details
+	LogLevelDebug LogLevel = iota
+
+	// LogLevelInfo logs informational messages and above
+	LogLevelInfo
+
+	// LogLevelWarn logs warnings and errors
+	LogLevelWarn
+
+	// LogLevelError logs errors only
+	LogLevelError
+
+	// LogLevelOff silences the agent
+	LogLevelOff
+)
+
+// DefaultLogLevel is quiet so production logs aren't flooded
+const DefaultLogLevel LogLevel = LogLevelWarn
+
+var logLevel int32 = int32(DefaultLogLevel)
+
+// Logger receives the messages of the agent that pass the log level,
+// e.g. to route them to zap or zerolog. Debug and info messages are
+// logged with Debugf, warnings and errors with Errorf.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// DefaultLogger writes to the standard logger
+var DefaultLogger Logger = stdLogger{}
+
+// NoopLogger discards every message
+var NoopLogger Logger = noopLogger{}
+
+// stdLogger writes to the standard logger
+type stdLogger struct{}
+
+// Debugf writes the message to the standard logger
+func (stdLogger) Debugf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// Errorf writes the message to the standard logger
+func (stdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// noopLogger discards the messages
+type noopLogger struct{}
+
+// Debugf discards the message
+func (noopLogger) Debugf(string, ...interface{}) {}
+
+// Errorf discards the message
+func (noopLogger) Errorf(string, ...interface{}) {}
+
+// loggerHolder holds the logger so any implementation can be stored
+// in the same atomic value
+type loggerHolder struct {
+	logger Logger
+}
+
+var logger atomic.Value
+
+// SetLogger routes the messages of the agent to the logger.
+// A nil logger restores the DefaultLogger.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = DefaultLogger
+	}
+
+	logger.Store(loggerHolder{logger: l})
+}
+
+// GetLogger returns the logger the messages of the agent are routed to
+func GetLogger() Logger {
+	if h, ok := logger.Load().(loggerHolder); ok {
+		return h.logger
+	}
+
+	return DefaultLogger
+}
+
+// WithLogger routes the messages of the agent to the logger, e.g.
+// NoopLogger to silence it. The logger is shared by the whole agent.
+func WithLogger(l Logger) ConfigurerOption {
+	return func(args ...interface{}) error {
+		if l == nil {
+			return errors.New("logger must not be nil")
+		}
+
+		SetLogger(l)
+		return nil
+	}
+}
+
+// SetLogLevel sets the minimum level of messages logged by the agent
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// GetLogLevel returns the minimum level of messages logged by the agent
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+// ParseLogLevel parses a log level name; debug, info, warn, error or off
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	case "off", "none":
+		return LogLevelOff, nil
+	}
+
+	return DefaultLogLevel, fmt.Errorf("invalid log level %s", name)
+}
+
+// Debugf logs a debug message
+func Debugf(format string, v ...interface{}) {
+	logf(LogLevelDebug, format, v...)
+}
+
+// Infof logs an informational message
+func Infof(format string, v ...interface{}) {
+	logf(LogLevelInfo, format, v...)
+}
+
+// Warnf logs a warning
+func Warnf(format string, v ...interface{}) {
+	logf(LogLevelWarn, format, v...)
+}
+
+// Errorf logs an error
+func Errorf(format string, v ...interface{}) {
+	logf(LogLevelError, format, v...)
+}
+
+// logf logs the message if the level is enabled
+func logf(level LogLevel, format string, v ...interface{}) {
+	Logf(nil, level, format, v...)
+}
+
+// Logf logs the message to the logger if the level is enabled.
+// A nil logger logs to the logger set with SetLogger.
+func Logf(l Logger, level LogLevel, format string, v ...interface{}) {
+	if level < GetLogLevel() {
+		return
+	}
+
+	if l == nil {
+		l = GetLogger()
+	}
+
+	if level < LogLevelWarn {
+		l.Debugf(format, v...)
+		return
+	}
+
+	l.Errorf(format, v...)
+}
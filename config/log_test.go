@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("DEBUG")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevelDebug, level)
+
+	level, err = ParseLogLevel("off")
+	assert.NoError(t, err)
+	assert.Equal(t, LogLevelOff, level)
+
+	level, err = ParseLogLevel("loud")
+	assert.Error(t, err)
+	assert.Equal(t, DefaultLogLevel, level)
+}
+
+func TestLogf_GatesByLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLogLevel(GetLogLevel())
+
+	SetLogLevel(DefaultLogLevel)
+	Debugf("debug")
+	Infof("info")
+	assert.Empty(t, buf.String())
+
+	Warnf("warn")
+	assert.Contains(t, buf.String(), "warn")
+
+	buf.Reset()
+	SetLogLevel(LogLevelDebug)
+	Debugf("debug")
+	assert.Contains(t, buf.String(), "debug")
+
+	buf.Reset()
+	SetLogLevel(LogLevelOff)
+	Errorf("error")
+	assert.Empty(t, buf.String())
+}
+
+// recordingLogger records the messages it receives by method
+type recordingLogger struct {
+	debugs []string
+	errors []string
+}
+
+func (l *recordingLogger) Debugf(format string, v ...interface{}) {
+	l.debugs = append(l.debugs, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Errorf(format string, v ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+
+func TestWithLogger_RoutesMessages(t *testing.T) {
+	defer SetLogger(nil)
+	defer SetLogLevel(GetLogLevel())
+
+	l := &recordingLogger{}
+	_, err := NewConfigurer(WithLogger(l))
+	assert.NoError(t, err)
+
+	SetLogLevel(LogLevelDebug)
+	Debugf("debug %d", 1)
+	Infof("info")
+	Warnf("warn")
+	Errorf("error")
+	assert.Equal(t, []string{"debug 1", "info"}, l.debugs)
+	assert.Equal(t, []string{"warn", "error"}, l.errors)
+
+	_, err = NewConfigurer(WithLogger(nil))
+	assert.Error(t, err)
+}
+
+func TestSetLogger_SilencesWithNoopLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLogger(nil)
+
+	SetLogger(NoopLogger)
+	Errorf("error")
+	assert.Empty(t, buf.String())
+
+	SetLogger(nil)
+	assert.Equal(t, DefaultLogger, GetLogger())
+	Errorf("error")
+	assert.Contains(t, buf.String(), "error")
+}
+
+func TestLogf_LogsToLogger(t *testing.T) {
+	defer SetLogger(nil)
+	defer SetLogLevel(GetLogLevel())
+
+	agent := &recordingLogger{}
+	SetLogger(agent)
+	SetLogLevel(LogLevelInfo)
+
+	l := &recordingLogger{}
+	Logf(l, LogLevelDebug, "debug")
+	Logf(l, LogLevelInfo, "info")
+	Logf(l, LogLevelWarn, "warn")
+	assert.Equal(t, []string{"info"}, l.debugs)
+	assert.Equal(t, []string{"warn"}, l.errors)
+
+	// A nil logger logs to the agent's logger
+	Logf(nil, LogLevelWarn, "agent")
+	assert.Equal(t, []string{"agent"}, agent.errors)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"sync"
 
@@ -12,21 +13,45 @@ var (
 	ConfigURL string = "https://config.auditr.io"
 	APIKey    string
 
+	// AuthHeader is the name of the header carrying the API key
+	AuthHeader string = "Authorization"
+
+	// AuthScheme is an optional scheme preceding the API key,
+	// e.g. Bearer
+	AuthScheme string
+
+	// StaticEventsURL overrides the events endpoint of the fetched
+	// configuration, so events may be sent to a different host than
+	// the config is fetched from. Set with AUDITR_EVENTS_URL.
+	StaticEventsURL string
+
 	seedOnce sync.Once
+
+	// seedLock guards the seed config while it's read from the env,
+	// e.g. by a fetcher created while events are sent
+	seedLock sync.RWMutex
 )
 
 func ensureSeedConfig() {
 	seedOnce.Do(func() {
+		seedLock.Lock()
+		defer seedLock.Unlock()
+
 		viper.SetConfigType("env")
 		viper.BindEnv("auditr_config_url")
 		viper.BindEnv("auditr_api_key")
+		viper.BindEnv("auditr_auth_header")
+		viper.BindEnv("auditr_auth_scheme")
+		viper.BindEnv("auditr_log_level")
+		viper.BindEnv("auditr_static_targets")
+		viper.BindEnv("auditr_events_url")
 
 		// If an env vars file is available, load the env vars in it
 		if configFile, ok := os.LookupEnv("ENV_PATH"); ok {
 			viper.SetConfigFile(configFile)
 
 			if err := viper.ReadInConfig(); err != nil {
-				log.Printf("Error reading env vars file: %v\n", err)
+				Warnf("Error reading env vars file: %v", err)
 			}
 		}
 
@@ -35,5 +60,71 @@ func ensureSeedConfig() {
 		if APIKey == "" {
 			log.Fatalf("AUDITR_API_KEY is not set")
 		}
+
+		if authHeader := viper.GetString("auditr_auth_header"); authHeader != "" {
+			AuthHeader = authHeader
+		}
+		AuthScheme = viper.GetString("auditr_auth_scheme")
+
+		if name := viper.GetString("auditr_log_level"); name != "" {
+			level, err := ParseLogLevel(name)
+			if err != nil {
+				Warnf("Error parsing AUDITR_LOG_LEVEL: %v", err)
+			}
+			SetLogLevel(level)
+		}
+
+		if eventsURL := viper.GetString("auditr_events_url"); eventsURL != "" {
+			StaticEventsURL = eventsURL
+		}
+
+		if targets := viper.GetString("auditr_static_targets"); targets != "" {
+			routes, err := ParseRoutes(targets)
+			if err != nil {
+				Warnf("Error parsing AUDITR_STATIC_TARGETS: %v", err)
+			}
+			StaticTargetRoutes = append(StaticTargetRoutes, routes...)
+		}
 	})
 }
+
+// authHeaders returns the headers authenticating requests with the API key
+func authHeaders() http.Header {
+	return authHeadersWithKey("")
+}
+
+// authHeadersWithKey returns the headers authenticating requests with
+// the given API key, or AUDITR_API_KEY if empty
+func authHeadersWithKey(apiKey string) http.Header {
+	seedLock.RLock()
+	defer seedLock.RUnlock()
+
+	if apiKey == "" {
+		apiKey = APIKey
+	}
+
+	value := apiKey
+	if AuthScheme != "" {
+		value = AuthScheme + " " + apiKey
+	}
+
+	header := http.Header{}
+	header.Set(AuthHeader, value)
+	return header
+}
+
+// staticEventsURL returns the events endpoint set with AUDITR_EVENTS_URL
+func staticEventsURL() string {
+	seedLock.RLock()
+	defer seedLock.RUnlock()
+
+	return StaticEventsURL
+}
+
+// staticTargetRoutes returns the static target routes
+func staticTargetRoutes() []Route {
+	seedLock.RLock()
+	defer seedLock.RUnlock()
+
+	return StaticTargetRoutes
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthHeaders(t *testing.T) {
+	apiKey, authHeader, authScheme := APIKey, AuthHeader, AuthScheme
+	defer func() {
+		APIKey, AuthHeader, AuthScheme = apiKey, authHeader, authScheme
+	}()
+
+	APIKey = "api-key"
+	AuthHeader = "Authorization"
+	AuthScheme = ""
+	assert.Equal(t, "api-key", authHeaders().Get("Authorization"))
+
+	AuthHeader = "x-api-key"
+	AuthScheme = "Bearer"
+	h := authHeaders()
+	assert.Equal(t, "Bearer api-key", h.Get("X-Api-Key"))
+	assert.Empty(t, h.Get("Authorization"))
+
+	h = authHeadersWithKey("other-key")
+	assert.Equal(t, "Bearer other-key", h.Get("X-Api-Key"))
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StaticTargetRoutes are always targeted regardless of the fetched
+// configuration, so the most sensitive routes are audited even when the
+// remote config is empty, stale or unavailable. Set before the agent is
+// created, or with AUDITR_STATIC_TARGETS, e.g. "POST /login,PUT /users/:id/roles".
+var StaticTargetRoutes []Route
+
+// ParseRoutes parses a comma separated list of routes,
+// each a method and path separated by a space
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Fields(entry)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid route %q; expected method and path", entry)
+		}
+
+		routes = append(routes, Route{
+			HTTPMethod: strings.ToUpper(parts[0]),
+			Path:       parts[1],
+		})
+	}
+
+	return routes, nil
+}
+
+// Targets returns the target routes merged with the static target routes.
+// Fetched routes take precedence so their names and policies apply.
+func (c *Configuration) Targets() []Route {
+	staticRoutes := staticTargetRoutes()
+	if len(staticRoutes) == 0 {
+		return c.TargetRoutes
+	}
+
+	routes := make([]Route, 0, len(c.TargetRoutes)+len(staticRoutes))
+	routes = append(routes, c.TargetRoutes...)
+	routes = append(routes, staticRoutes...)
+
+	merged := routes[:0]
+	seen := map[string]bool{}
+	for _, route := range routes {
+		key := strings.ToUpper(route.HTTPMethod) + " " + route.Path
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		merged = append(merged, route)
+	}
+
+	return merged
+}
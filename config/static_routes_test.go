@@ -0,0 +1,74 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("post /login, PUT /users/:id/roles,")
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{
+		{
+			HTTPMethod: http.MethodPost,
+			Path:       "/login",
+		},
+		{
+			HTTPMethod: http.MethodPut,
+			Path:       "/users/:id/roles",
+		},
+	}, routes)
+
+	routes, err = ParseRoutes("")
+	assert.NoError(t, err)
+	assert.Empty(t, routes)
+
+	_, err = ParseRoutes("/login")
+	assert.Error(t, err)
+}
+
+func TestTargets_MergesStaticTargetRoutes(t *testing.T) {
+	staticTargetRoutes := StaticTargetRoutes
+	defer func() {
+		StaticTargetRoutes = staticTargetRoutes
+	}()
+
+	cfg := &Configuration{}
+	StaticTargetRoutes = nil
+	assert.Empty(t, cfg.Targets())
+
+	StaticTargetRoutes = []Route{
+		{
+			HTTPMethod: http.MethodPost,
+			Path:       "/login",
+		},
+		{
+			HTTPMethod: http.MethodGet,
+			Path:       "/person/:id",
+		},
+	}
+
+	// Static routes apply even without a fetched config
+	assert.Equal(t, StaticTargetRoutes, cfg.Targets())
+
+	cfg.TargetRoutes = make([]Route, 1, 4)
+	cfg.TargetRoutes[0] = Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+		Name:       "get-person",
+	}
+	assert.Equal(t, []Route{
+		{
+			HTTPMethod: http.MethodGet,
+			Path:       "/person/:id",
+			Name:       "get-person",
+		},
+		{
+			HTTPMethod: http.MethodPost,
+			Path:       "/login",
+		},
+	}, cfg.Targets())
+	assert.Len(t, cfg.TargetRoutes, 1)
+}
@@ -3,36 +3,150 @@ package lambda
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
 	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/lambda/events"
 	"github.com/auditr-io/lambdahooks-go"
+	"github.com/tidwall/gjson"
 )
 
+// DefaultWarmupTimeout is the max duration to wait for the warmup request
+const DefaultWarmupTimeout time.Duration = 2 * time.Second
+
 // Agent is an auditr agent that collects and reports events
 type Agent struct {
 	collector *collect.Collector
 	hooksInit sync.Once
+
+	collectorOptions []collect.CollectorOption
+}
+
+// AgentOption is an option to override defaults
+type AgentOption func(a *Agent) error
+
+// WithAgentType overrides the agent type of the events, aws-lambda by default,
+// to attribute them to a custom integration
+func WithAgentType(agentType string) AgentOption {
+	return func(a *Agent) error {
+		if agentType == "" {
+			return errors.New("agent type must not be empty")
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithAgentType(agentType))
+		return nil
+	}
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder, e.g. for a self-hosted backend with its own response envelope
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return func(a *Agent) error {
+		if decoder == nil {
+			return errors.New("response decoder must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithResponseDecoder(decoder)),
+		)
+		return nil
+	}
+}
+
+// WithSink also writes every batch of events to the sink, e.g. a file
+// sink for durability alongside the events API for delivery
+func WithSink(sink collect.Sink) AgentOption {
+	return func(a *Agent) error {
+		if sink == nil {
+			return errors.New("sink must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithSinks(sink)),
+		)
+		return nil
+	}
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics, e.g. to export them to a metrics backend
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return func(a *Agent) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithMetrics(metrics)),
+		)
+		return nil
+	}
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store, reducing duplicate samples after cold starts
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return func(a *Agent) error {
+		if store == nil {
+			return errors.New("sampled route store must not be nil")
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithSampledRouteStore(store))
+		return nil
+	}
+}
+
+// WithEventBuilders builds events with the builders when the API Gateway
+// builders can't, e.g. &ALBEventBuilder{} for Lambdas behind a load balancer.
+// Non-HTTP event sources are only audited once their builders are added,
+// e.g. &SQSEventBuilder{} or &EventBridgeEventBuilder{}.
+func WithEventBuilders(builders ...collect.EventBuilder) AgentOption {
+	return func(a *Agent) error {
+		for _, b := range builders {
+			if b == nil {
+				return errors.New("event builder must not be nil")
+			}
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithEventBuilders(builders...))
+		return nil
+	}
 }
 
-func NewAgent() (*Agent, error) {
-	return NewAgentWithConfiguration(nil)
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	return NewAgentWithConfiguration(nil, options...)
 }
 
 func NewAgentWithConfiguration(
 	configuration *config.Configuration,
+	options ...AgentOption,
 ) (*Agent, error) {
-	a := &Agent{}
+	a := &Agent{
+		collectorOptions: []collect.CollectorOption{
+			collect.WithAgentType(collect.AgentTypeLambda),
+		},
+	}
+
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
 
 	c, err := collect.NewCollector(
 		[]collect.EventBuilder{
 			&APIGatewayEventBuilder{},
+			&APIGatewayV2EventBuilder{},
 		},
 		configuration,
+		a.collectorOptions...,
 	)
 	if err != nil {
 		return nil, err
@@ -40,6 +154,17 @@ func NewAgentWithConfiguration(
 
 	a.collector = c
 
+	if c.Configuration().Warmup {
+		// Warm the events connection during init so the first
+		// invocation's events aren't lost to a cold start
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultWarmupTimeout)
+		defer cancel()
+
+		if err := c.Warmup(ctx); err != nil {
+			config.Warnf("Error warming up events client: %v", err)
+		}
+	}
+
 	return a, nil
 }
 
@@ -47,6 +172,7 @@ func NewAgentWithConfiguration(
 func (a *Agent) Wrap(handler interface{}) interface{} {
 	a.hooksInit.Do(func() {
 		lambdahooks.Init(
+			lambdahooks.WithPreHooks(a),
 			lambdahooks.WithPostHooks(a),
 		)
 	})
@@ -54,8 +180,47 @@ func (a *Agent) Wrap(handler interface{}) interface{} {
 	return lambdahooks.Wrap(handler)
 }
 
+// BeforeExecution captures the request as soon as it is received
+// if two phase events are enabled. The payload is not modified.
+func (a *Agent) BeforeExecution(
+	ctx context.Context,
+	payload []byte,
+) (context.Context, []byte) {
+	a.CollectReceived(ctx, payload)
+	return ctx, payload
+}
+
+// CollectReceived captures the request before the handler runs
+// if two phase events are enabled. Only API Gateway, ALB, SQS and
+// EventBridge events are supported at this time.
+func (a *Agent) CollectReceived(
+	ctx context.Context,
+	payload json.RawMessage,
+) {
+	if !a.collector.Configuration().TwoPhaseEvents {
+		return
+	}
+
+	reqs, err := parseRequests(payload)
+	if err != nil {
+		config.Warnf("Error unmarshalling payload: %v", err)
+		config.Debugf("payload: %s", string(payload))
+		return
+	}
+
+	for _, req := range reqs {
+		a.collector.CollectReceived(
+			ctx,
+			req.method,
+			req.path,
+			req.request,
+		)
+	}
+}
+
 // AfterExecution captures the request as an audit event or a sample.
-// Only API Gateway events are supported at this time.
+// Only API Gateway, ALB, SQS and EventBridge events are supported at
+// this time.
 func (a *Agent) AfterExecution(
 	ctx context.Context,
 	payload []byte,
@@ -77,7 +242,9 @@ func (a *Agent) AfterExecution(
 }
 
 // Collect captures the request as an audit event or a sample.
-// Only API Gateway events are supported at this time.
+// Only API Gateway, ALB, SQS and EventBridge events are supported at
+// this time.
+// Each message of an SQS event is collected as its own event.
 func (a *Agent) Collect(
 	ctx context.Context,
 	payload json.RawMessage,
@@ -85,35 +252,185 @@ func (a *Agent) Collect(
 	response json.RawMessage,
 	errorValue json.RawMessage,
 ) {
-	// TODO: support HTTP API and Websockets
+	// TODO: support Websockets
 	if len(response) == 0 {
 		// API Gateway expects a non-nil response
 		return
 	}
 
-	var req events.APIGatewayProxyRequest
 	// We only care about the original request, not the modified request.
 	// So, we use payload here.
-	err := json.Unmarshal(payload, &req)
+	reqs, err := parseRequests(payload)
 	if err != nil {
-		log.Printf("Error unmarshalling payload: %s\n%v", string(payload), err)
+		config.Warnf("Error unmarshalling payload: %v", err)
+		config.Debugf("payload: %s", string(payload))
 		return
 	}
 
-	path := req.Path
-	if req.RequestContext.Stage != "" {
-		path = strings.TrimPrefix(path, "/"+req.RequestContext.Stage)
+	for _, req := range reqs {
+		a.collector.Collect(
+			ctx,
+			req.method,
+			req.path,
+			req.resource,
+			req.request,
+			response,
+			errorValue,
+		)
 	}
+}
 
-	a.collector.Collect(
-		ctx,
-		req.HTTPMethod,
-		path,
-		req.Resource,
-		req,
-		response,
-		errorValue,
-	)
+// parsedRequest is a request with the fields to route it
+type parsedRequest struct {
+	method   string
+	path     string
+	resource string
+	request  interface{}
+}
+
+// parseRequests unmarshals the payload into a request per message if the
+// payload is an SQS event, otherwise into a single request, e.g. of an
+// EventBridge event
+func parseRequests(payload json.RawMessage) ([]*parsedRequest, error) {
+	// A batch only holds records of a single event source, so the first
+	// record identifies the payload
+	if gjson.GetBytes(payload, "Records.0.eventSource").String() == sqsEventSource {
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+
+		reqs := make([]*parsedRequest, 0, len(event.Records))
+		for _, record := range event.Records {
+			path := sqsQueuePath(record.EventSourceARN)
+			reqs = append(reqs, &parsedRequest{
+				method:   SQSMethod,
+				path:     path,
+				resource: path,
+				request:  record,
+			})
+		}
+
+		return reqs, nil
+	}
+
+	if gjson.GetBytes(payload, "detail-type").Exists() &&
+		gjson.GetBytes(payload, "source").Exists() {
+		var event events.CloudWatchEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+
+		path := eventBridgePath(&event)
+		return []*parsedRequest{
+			{
+				method:   EventBridgeMethod,
+				path:     path,
+				resource: path,
+				request:  event,
+			},
+		}, nil
+	}
+
+	req, err := parseRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*parsedRequest{req}, nil
+}
+
+// parseRequest unmarshals the payload into a REST API request, an
+// HTTP API request if the payload is of format version 2.0, or an ALB
+// request if the payload is from a load balancer
+func parseRequest(payload json.RawMessage) (*parsedRequest, error) {
+	if gjson.GetBytes(payload, "requestContext.elb").Exists() {
+		var req events.ALBTargetGroupRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+
+		// ALB has no resources, only the path of the request
+		return &parsedRequest{
+			method:   req.HTTPMethod,
+			path:     req.Path,
+			resource: req.Path,
+			request:  req,
+		}, nil
+	}
+
+	if gjson.GetBytes(payload, "version").String() == "2.0" {
+		var req events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+
+		return &parsedRequest{
+			method:   req.RequestContext.HTTP.Method,
+			path:     requestPathV2(&req),
+			resource: routeResource(&req),
+			request:  req,
+		}, nil
+	}
+
+	var req events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+
+	return &parsedRequest{
+		method:   req.HTTPMethod,
+		path:     requestPath(&req),
+		resource: req.Resource,
+		request:  req,
+	}, nil
+}
+
+// requestPath returns the path of the request without the stage
+func requestPath(req *events.APIGatewayProxyRequest) string {
+	if req.RequestContext.Stage == "" {
+		return req.Path
+	}
+
+	return strings.TrimPrefix(req.Path, "/"+req.RequestContext.Stage)
+}
+
+// requestPathV2 returns the path of the HTTP API request without the stage.
+// The $default stage isn't part of the path.
+func requestPathV2(req *events.APIGatewayV2HTTPRequest) string {
+	stage := req.RequestContext.Stage
+	if stage == "" || stage == "$default" {
+		return req.RawPath
+	}
+
+	return strings.TrimPrefix(req.RawPath, "/"+stage)
+}
+
+// routeResource returns the resource of the HTTP API route,
+// e.g. /person/{id} of the route key "GET /person/{id}".
+// The $default route falls back to the request path.
+func routeResource(req *events.APIGatewayV2HTTPRequest) string {
+	routeKey := req.RouteKey
+	if routeKey == "" {
+		routeKey = req.RequestContext.RouteKey
+	}
+
+	if i := strings.Index(routeKey, " "); i >= 0 {
+		return routeKey[i+1:]
+	}
+
+	return requestPathV2(req)
+}
+
+// Pause pauses auditing. Invocations are still handled while paused,
+// but no events are generated.
+func (a *Agent) Pause() error {
+	return a.collector.Pause()
+}
+
+// Resume resumes auditing after a pause
+func (a *Agent) Resume() error {
+	return a.collector.Resume()
 }
 
 // Flush sends anything pending in queue
@@ -125,3 +442,14 @@ func (a *Agent) Flush() error {
 func (a *Agent) Responses() <-chan collect.Response {
 	return a.collector.Responses()
 }
+
+// Subscribe adds a subscriber that receives a copy of every response,
+// and optionally every published event, without taking from Responses
+func (a *Agent) Subscribe(options collect.SubscriptionOptions) *collect.Subscription {
+	return a.collector.Subscribe(options)
+}
+
+// Unsubscribe removes the subscriber and closes its channels
+func (a *Agent) Unsubscribe(s *collect.Subscription) {
+	a.collector.Unsubscribe(s)
+}
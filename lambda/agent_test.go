@@ -51,6 +51,51 @@ func TestNewAgent_ReturnsAgent(t *testing.T) {
 	assert.NotNil(t, a)
 }
 
+func TestNewAgent_WithEventBuilders(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+	)
+
+	configurer.Refresh(context.Background())
+
+	_, err = NewAgentWithConfiguration(configurer.Configuration, WithEventBuilders(nil))
+	assert.Error(t, err)
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithEventBuilders(&ALBEventBuilder{}),
+	)
+	assert.NoError(t, err)
+
+	event, err := a.collector.BuildEvent(
+		http.MethodGet,
+		"/person/xyz",
+		"/person/xyz",
+		events.ALBTargetGroupRequest{
+			HTTPMethod: http.MethodGet,
+			Path:       "/person/xyz",
+		},
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+	assert.Equal(t, "/person/:id", event.Route.Path)
+}
+
 func TestAfterExecution_SamplesAPIGatewayEvent(t *testing.T) {
 	id := "xyz"
 	req := events.APIGatewayProxyRequest{
@@ -81,6 +126,7 @@ func TestAfterExecution_SamplesAPIGatewayEvent(t *testing.T) {
 			assert.NoError(t, err)
 			event := eventBatch[0]
 			assert.Equal(t, collect.RouteTypeSample, event.Route.Type)
+			assert.Equal(t, collect.AgentTypeLambda, event.Agent.Type)
 
 			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
 				{
@@ -138,6 +184,8 @@ func TestAfterExecution_SamplesAPIGatewayEvent(t *testing.T) {
 
 		expectedResponse := collect.Response{
 			StatusCode: 200,
+			Sink:       collect.SinkHTTP,
+			Sequence:   1,
 		}
 		assert.Equal(t, expectedResponse, res)
 	}()
@@ -309,6 +357,8 @@ func TestAfterExecution_TargetsAPIGatewayEvent(t *testing.T) {
 
 		expectedResponse := collect.Response{
 			StatusCode: 200,
+			Sink:       collect.SinkHTTP,
+			Sequence:   1,
 		}
 		assert.Equal(t, expectedResponse, res)
 	}()
@@ -320,6 +370,280 @@ func TestAfterExecution_TargetsAPIGatewayEvent(t *testing.T) {
 	m.AssertExpectations(t)
 }
 
+func TestAfterExecution_TargetsAPIGatewayV2Event(t *testing.T) {
+	payload := []byte(`{
+		"version": "2.0",
+		"routeKey": "PUT /events/{id}",
+		"rawPath": "/events/xyz",
+		"headers": {
+			"host": "api.example.com"
+		},
+		"requestContext": {
+			"stage": "$default",
+			"requestId": "request-id",
+			"authorizer": {
+				"jwt": {
+					"claims": {
+						"sub": "user-id"
+					}
+				}
+			},
+			"http": {
+				"method": "PUT",
+				"path": "/events/xyz",
+				"sourceIp": "1.2.3.4"
+			}
+		}
+	}`)
+
+	res := events.APIGatewayV2HTTPResponse{
+		StatusCode: 200,
+		Body:       `{"id": "xyz"}`,
+	}
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			reqBody, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+
+			var eventBatch []*collect.EventRaw
+			err = json.Unmarshal(reqBody, &eventBatch)
+			assert.NoError(t, err)
+			event := eventBatch[0]
+			assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+			assert.Equal(t, http.MethodPut, event.Route.Method)
+			assert.Equal(t, "/events/:id", event.Route.Path)
+			assert.Equal(t, "/events/xyz", event.Route.RawPath)
+			assert.Equal(t, "user-id", event.User.ID)
+			assert.Equal(t, "1.2.3.4", event.Client.IP)
+			assert.Equal(t, "request-id", event.RequestID)
+
+			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
+				{
+					"status": 200
+				}
+			]`)))
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       r,
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil).Once()
+
+	mockClient := func() *http.Client {
+		return &http.Client{
+			Transport: m,
+		}
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "PUT",
+						"path": "/events/:id"
+					}
+				],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": true
+			}`), nil
+		}),
+		config.WithHTTPClient(mockClient),
+	)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res := <-a.Responses()
+		assert.Equal(t, 200, res.StatusCode)
+		assert.NoError(t, res.Err)
+	}()
+
+	a.AfterExecution(context.Background(), payload, payload, res, nil)
+
+	wg.Wait()
+
+	m.AssertExpectations(t)
+}
+
+func TestParseRequest_ParsesEventSources(t *testing.T) {
+	tests := []struct {
+		payload  string
+		method   string
+		path     string
+		resource string
+	}{
+		{
+			payload:  `{"httpMethod":"GET","resource":"/events/{id}","path":"/dev/events/xyz","requestContext":{"stage":"dev"}}`,
+			method:   http.MethodGet,
+			path:     "/events/xyz",
+			resource: "/events/{id}",
+		},
+		{
+			payload:  `{"version":"2.0","routeKey":"GET /events/{id}","rawPath":"/dev/events/xyz","requestContext":{"stage":"dev","http":{"method":"GET"}}}`,
+			method:   http.MethodGet,
+			path:     "/events/xyz",
+			resource: "/events/{id}",
+		},
+		{
+			payload:  `{"version":"2.0","routeKey":"$default","rawPath":"/events/xyz","requestContext":{"stage":"$default","http":{"method":"POST"}}}`,
+			method:   http.MethodPost,
+			path:     "/events/xyz",
+			resource: "/events/xyz",
+		},
+		{
+			payload:  `{"httpMethod":"DELETE","path":"/events/xyz","requestContext":{"elb":{"targetGroupArn":"arn"}}}`,
+			method:   http.MethodDelete,
+			path:     "/events/xyz",
+			resource: "/events/xyz",
+		},
+		{
+			payload:  `{"Records":[{"messageId":"1","eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-1:123456789012:orders"}]}`,
+			method:   SQSMethod,
+			path:     "/orders",
+			resource: "/orders",
+		},
+		{
+			payload:  `{"id":"1","detail-type":"Scheduled Event","source":"aws.events","detail":{}}`,
+			method:   EventBridgeMethod,
+			path:     "/aws.events/Scheduled Event",
+			resource: "/aws.events/Scheduled Event",
+		},
+	}
+
+	for _, tt := range tests {
+		reqs, err := parseRequests(json.RawMessage(tt.payload))
+		assert.NoError(t, err)
+		assert.Len(t, reqs, 1)
+		assert.Equal(t, tt.method, reqs[0].method)
+		assert.Equal(t, tt.path, reqs[0].path)
+		assert.Equal(t, tt.resource, reqs[0].resource)
+	}
+}
+
+func TestAfterExecution_TargetsEachSQSMessage(t *testing.T) {
+	arn := "arn:aws:sqs:us-east-1:123456789012:orders"
+	req := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "message-1", Body: `{"id":1}`, EventSource: "aws:sqs", EventSourceARN: arn},
+			{MessageId: "message-2", Body: `{"id":2}`, EventSource: "aws:sqs", EventSourceARN: arn},
+		},
+	}
+	payload, err := json.Marshal(req)
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	var requestIDs []string
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			reqBody, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+
+			var eventBatch []*collect.EventRaw
+			err = json.Unmarshal(reqBody, &eventBatch)
+			assert.NoError(t, err)
+			lock.Lock()
+			defer lock.Unlock()
+
+			statuses := make([]map[string]int, len(eventBatch))
+			for i, event := range eventBatch {
+				assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+				assert.Equal(t, SQSMethod, event.Route.Method)
+				assert.Equal(t, "/orders", event.Route.Path)
+				assert.Equal(t, arn, event.Route.RawPath)
+				requestIDs = append(requestIDs, event.RequestID)
+				statuses[i] = map[string]int{"status": 200}
+			}
+
+			resBody, err := json.Marshal(statuses)
+			assert.NoError(t, err)
+			r := ioutil.NopCloser(bytes.NewBuffer(resBody))
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       r,
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil)
+
+	mockClient := func() *http.Client {
+		return &http.Client{
+			Transport: m,
+		}
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "SQS",
+						"path": "/orders"
+					}
+				],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 2,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": true
+			}`), nil
+		}),
+		config.WithHTTPClient(mockClient),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithEventBuilders(&SQSEventBuilder{}),
+	)
+	assert.NoError(t, err)
+
+	a.AfterExecution(context.Background(), payload, payload, nil, nil)
+	a.Flush()
+
+	m.AssertExpectations(t)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ElementsMatch(t, []string{"message-1", "message-2"}, requestIDs)
+}
+
 func TestAfterExecution_TargetsAPIGatewayEventTwice(t *testing.T) {
 	expectedCalls := 2
 	id := "xyz"
@@ -413,8 +737,12 @@ func TestAfterExecution_TargetsAPIGatewayEventTwice(t *testing.T) {
 			// make sure to flush, else will block
 			res := <-a.Responses()
 
+			// Responses arrive in any order
+			assert.NotZero(t, res.Sequence)
 			expectedResponse := collect.Response{
 				StatusCode: 200,
+				Sink:       collect.SinkHTTP,
+				Sequence:   res.Sequence,
 			}
 			assert.Equal(t, expectedResponse, res)
 		}()
@@ -428,3 +756,50 @@ func TestAfterExecution_TargetsAPIGatewayEventTwice(t *testing.T) {
 
 	assert.GreaterOrEqual(t, len(m.Calls), expectedCalls)
 }
+
+func TestNewAgent_WarmsUpEventsClient(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte{})),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.MatchedBy(func(req *http.Request) bool {
+			return req.Method == http.MethodHead &&
+				req.URL.String() == "https://dev-api.auditr.io/v1/events"
+		})).
+		Return(mock.AnythingOfType("*http.Response"), nil).
+		Once()
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2,
+				"warmup": true
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+	m.AssertExpectations(t)
+}
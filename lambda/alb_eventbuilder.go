@@ -0,0 +1,247 @@
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// albOIDCIdentityHeader is the subject of the user authenticated
+	// by the load balancer
+	albOIDCIdentityHeader = "x-amzn-oidc-identity"
+
+	// albOIDCDataHeader is the JWT of the user claims, signed by the
+	// load balancer
+	albOIDCDataHeader = "x-amzn-oidc-data"
+)
+
+// ALBEventBuilder builds an event from Application Load Balancer
+// Lambda target request and response
+type ALBEventBuilder struct{}
+
+// Build builds an event from ALB request and response
+func (b *ALBEventBuilder) Build(
+	configuration *config.Configuration,
+	routeType collect.RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*collect.EventRaw, error) {
+	req, ok := request.(events.ALBTargetGroupRequest)
+	if !ok {
+		return nil, fmt.Errorf("request is not of type ALBTargetGroupRequest")
+	}
+
+	orgID, err := mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		b.orgIDSources(&req),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if collect.IsEmptyJSON(errorValue) && route.CapturesResponse() {
+		// Locate failed response bodies on the error consistently,
+		// unless the route's responses aren't captured
+		errorBody := collect.ErrorBody(
+			response,
+			"statusCode",
+			"body",
+			configuration.ErrorStatusThreshold,
+		)
+		if errorBody != nil {
+			errorValue = errorBody
+		}
+	}
+
+	// Only keep the body of responses in the configured status range
+	response = collect.StripResponseBody(
+		response,
+		"statusCode",
+		"body",
+		configuration.ResponseBodyStatusMin,
+		configuration.ResponseBodyStatusMax,
+	)
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := bodyBytes(req.Body, req.IsBase64Encoded)
+	responseBytes := bodyBytes(
+		gjson.GetBytes(response, "body").String(),
+		gjson.GetBytes(response, "isBase64Encoded").Bool(),
+	)
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+		response = collect.TruncateJSONField(response, "body", configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+		response = collect.MinifyJSONField(response, "body")
+	}
+
+	var clientIP string
+	if ip, ok := b.ClientIP(req); ok {
+		clientIP = ip.String()
+	}
+
+	// ALB requests carry no request ID. The trace ID the load balancer
+	// adds is the closest identifier of the request.
+	requestID, _ := b.header(&req, "x-amzn-trace-id")
+	host, _ := b.header(&req, "Host")
+
+	event := &collect.EventRaw{
+		Organization: &collect.EventOrganization{
+			ID: orgID,
+		},
+
+		Route: &collect.EventRoute{
+			Type:   routeType,
+			Method: route.HTTPMethod,
+			Path:   route.Path,
+			Name:   route.Name,
+			RawPath: collect.MaskPathParams(
+				route.Path,
+				req.Path,
+				configuration.MaskedPathParams,
+			),
+		},
+
+		Host: host,
+
+		User: b.mapUser(configuration, &req),
+
+		Client: &collect.EventClient{
+			IP:    clientIP,
+			Bytes: requestBytes,
+		},
+
+		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
+
+		Request:     req,
+		QueryParams: queryParams(req.QueryStringParameters, req.MultiValueQueryStringParameters),
+		Response:    response,
+		Error:       errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: requestID,
+	}
+
+	return event, nil
+}
+
+// ClientIP resolves the client IP of the request from the first
+// address of the X-Forwarded-For header the load balancer sets
+func (b *ALBEventBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	req, ok := request.(events.ALBTargetGroupRequest)
+	if !ok {
+		return nil, false
+	}
+
+	forwardedFor, ok := b.header(&req, "X-Forwarded-For")
+	if !ok {
+		return nil, false
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(strings.Split(forwardedFor, ",")[0]))
+	return ip, ip != nil
+}
+
+// header returns the first value of the header regardless of case.
+// Headers are multi-valued if multi-value headers are enabled on the
+// target group.
+func (b *ALBEventBuilder) header(
+	req *events.ALBTargetGroupRequest,
+	name string,
+) (string, bool) {
+	if val, ok := headerValue(req.Headers, name); ok {
+		return val, true
+	}
+
+	for k, v := range req.MultiValueHeaders {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0], true
+		}
+	}
+
+	return "", false
+}
+
+// orgIDSources reads org ID fields from the header, query string
+// or body of the request
+func (b *ALBEventBuilder) orgIDSources(req *events.ALBTargetGroupRequest) orgIDSources {
+	return orgIDSources{
+		header: func(name string) (string, bool) {
+			return b.header(req, name)
+		},
+		querystring: lookup(queryParams(
+			req.QueryStringParameters,
+			req.MultiValueQueryStringParameters,
+		)),
+		body: func(path string) gjson.Result {
+			return gjson.Get(req.Body, path)
+		},
+	}
+}
+
+// mapUser maps the user authenticated by the load balancer to user.
+// The claims are read from the OIDC data header the load balancer signed.
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/listener-authenticate-users.html
+func (b *ALBEventBuilder) mapUser(
+	configuration *config.Configuration,
+	req *events.ALBTargetGroupRequest,
+) *collect.EventUser {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+
+	identity, ok := b.header(req, albOIDCIdentityHeader)
+	if !ok {
+		return user
+	}
+
+	user.AuthType = collect.AuthTypeJWT
+	user.ID = identity
+
+	data, ok := b.header(req, albOIDCDataHeader)
+	if !ok {
+		return user
+	}
+
+	claims, err := collect.DecodeJWTClaims(data)
+	if err != nil {
+		config.Debugf("Error decoding ALB user claims: %v", err)
+		return user
+	}
+
+	user.AuthType = claimsAuthType(claims)
+	user.Email, _ = claimString(claims, "email")
+	user.FullName, _ = claimString(claims, "name")
+	user.Domain, _ = claimString(claims, "iss")
+
+	user.Name, _ = claimString(claims, "cognito:username")
+	if user.Name == "" {
+		user.Name, _ = claimString(claims, "username")
+	}
+
+	user.Roles = collect.ClaimValues(claims, configuration.RoleClaims)
+	user.Scopes = collect.ClaimValues(claims, configuration.ScopeClaims)
+
+	return user
+}
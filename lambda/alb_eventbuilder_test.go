@@ -0,0 +1,148 @@
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildALB(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+		Name:       "get-person",
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"sub":      "user-id",
+		"email":    "email",
+		"name":     "full-name",
+		"username": "username",
+		"iss":      "https://auth.example.com",
+	})
+	assert.NoError(t, err)
+
+	oidcData := "eyJhbGciOiJFUzI1NiJ9." +
+		base64.RawURLEncoding.EncodeToString(claims) +
+		".c2lnbmF0dXJl"
+
+	req := events.ALBTargetGroupRequest{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/xyz",
+		QueryStringParameters: map[string]string{
+			"page": "2",
+		},
+		Headers: map[string]string{
+			"host":                 "api.example.com",
+			"x-forwarded-for":      "1.2.3.4, 10.0.0.1",
+			"x-amzn-trace-id":      "Root=1-5bdb40ca-556d8b0c50dc66f0511bf520",
+			"x-org-id":             "ext-org-id",
+			"x-amzn-oidc-identity": "user-id",
+			"x-amzn-oidc-data":     oidcData,
+		},
+		RequestContext: events.ALBTargetGroupRequestContext{
+			ELB: events.ELBContext{
+				TargetGroupArn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda/abc",
+			},
+		},
+		Body: `{"name":"homer"}`,
+	}
+
+	res := json.RawMessage(`{"statusCode":200,"body":"ok"}`)
+
+	b := &ALBEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDField:  "request.header.X-Org-Id",
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, eventRaw)
+
+	assert.Equal(t, "ext-org-id", eventRaw.Organization.ID)
+
+	assert.Equal(t, &collect.EventRoute{
+		Type:    collect.RouteTypeTarget,
+		Method:  route.HTTPMethod,
+		Path:    route.Path,
+		Name:    route.Name,
+		RawPath: "/person/xyz",
+	}, eventRaw.Route)
+
+	assert.Equal(t, &collect.EventUser{
+		ID:       "user-id",
+		Email:    "email",
+		FullName: "full-name",
+		Name:     "username",
+		Domain:   "https://auth.example.com",
+		AuthType: collect.AuthTypeJWT,
+	}, eventRaw.User)
+
+	assert.Equal(t, &collect.EventClient{
+		IP:    "1.2.3.4",
+		Bytes: int64(len(req.Body)),
+	}, eventRaw.Client)
+
+	assert.Equal(t, "api.example.com", eventRaw.Host)
+	assert.Equal(t, map[string]string{"page": "2"}, eventRaw.QueryParams)
+	assert.Equal(t, "Root=1-5bdb40ca-556d8b0c50dc66f0511bf520", eventRaw.RequestID)
+	assert.Equal(t, int64(2), eventRaw.ResponseBytes)
+
+	assert.Equal(t, req, eventRaw.Request)
+	assert.Equal(t, res, eventRaw.Response)
+}
+
+func TestBuildALB_RejectsOtherRequests(t *testing.T) {
+	b := &ALBEventBuilder{}
+	_, err := b.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		events.APIGatewayProxyRequest{},
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestBuildALB_ReadsMultiValueHeaders(t *testing.T) {
+	req := events.ALBTargetGroupRequest{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/xyz",
+		MultiValueQueryStringParameters: map[string][]string{
+			"org": {"querystring-org-id", "other-org-id"},
+		},
+		MultiValueHeaders: map[string][]string{
+			"x-forwarded-for": {"1.2.3.4"},
+		},
+	}
+
+	b := &ALBEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			OrgIDField: "request.querystring.org",
+		},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "querystring-org-id", eventRaw.Organization.ID)
+	assert.Equal(t, "1.2.3.4", eventRaw.Client.IP)
+	assert.Equal(t, &collect.EventUser{AuthType: collect.AuthTypeNone}, eventRaw.User)
+}
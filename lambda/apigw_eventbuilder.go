@@ -1,14 +1,17 @@
 package lambda
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
 	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/tidwall/gjson"
 )
 
 // APIGatewayEventBuilder builds an event from APIGateway request and response
@@ -16,8 +19,7 @@ type APIGatewayEventBuilder struct{}
 
 // Build builds an event from APIGateway request and response
 func (b *APIGatewayEventBuilder) Build(
-	parentOrgID string,
-	orgIDField string,
+	configuration *config.Configuration,
 	routeType collect.RouteType,
 	route *config.Route,
 	request interface{},
@@ -29,16 +31,65 @@ func (b *APIGatewayEventBuilder) Build(
 		return nil, fmt.Errorf("request is not of type APIGatewayProxyRequest")
 	}
 
-	orgID, err := b.mapOrgID(parentOrgID, orgIDField, &req)
+	orgID, err := mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		b.orgIDSources(&req),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := b.mapUser(&req)
+	user, err := b.mapUser(configuration, &req)
 	if err != nil {
 		return nil, err
 	}
 
+	if collect.IsEmptyJSON(errorValue) && route.CapturesResponse() {
+		// Locate failed response bodies on the error consistently,
+		// unless the route's responses aren't captured
+		errorBody := collect.ErrorBody(
+			response,
+			"statusCode",
+			"body",
+			configuration.ErrorStatusThreshold,
+		)
+		if errorBody != nil {
+			errorValue = errorBody
+		}
+	}
+
+	// Only keep the body of responses in the configured status range
+	response = collect.StripResponseBody(
+		response,
+		"statusCode",
+		"body",
+		configuration.ResponseBodyStatusMin,
+		configuration.ResponseBodyStatusMax,
+	)
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := bodyBytes(req.Body, req.IsBase64Encoded)
+	responseBytes := bodyBytes(
+		gjson.GetBytes(response, "body").String(),
+		gjson.GetBytes(response, "isBase64Encoded").Bool(),
+	)
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+		response = collect.TruncateJSONField(response, "body", configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+		response = collect.MinifyJSONField(response, "body")
+	}
+
 	identity := req.RequestContext.Identity
 
 	event := &collect.EventRaw{
@@ -50,96 +101,106 @@ func (b *APIGatewayEventBuilder) Build(
 			Type:   routeType,
 			Method: route.HTTPMethod,
 			Path:   route.Path,
+			Name:   route.Name,
+			RawPath: collect.MaskPathParams(
+				route.Path,
+				requestPath(&req),
+				configuration.MaskedPathParams,
+			),
 		},
 
+		Host: b.mapHost(&req),
+
 		User: user,
 
 		Client: &collect.EventClient{
-			IP: identity.SourceIP,
+			IP:    identity.SourceIP,
+			Bytes: requestBytes,
 		},
 
 		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
 
-		Request:  req,
-		Response: response,
-		Error:    errorValue,
+		Request:     req,
+		QueryParams: queryParams(req.QueryStringParameters, nil),
+		Response:    response,
+		Error:       errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.RequestContext.RequestID,
 	}
 
 	if req.RequestContext.RequestTimeEpoch > 0 {
 		event.RequestedAt = req.RequestContext.RequestTimeEpoch
 	}
 
+	mapAuthorizerContext(
+		configuration.AuthorizerContextFields,
+		req.RequestContext.Authorizer,
+		event,
+	)
+
 	return event, nil
 }
 
-// mapOrgID maps the configured orgIDField to org ID
-func (b *APIGatewayEventBuilder) mapOrgID(
-	parentOrgID string,
-	orgIDField string,
-	req *events.APIGatewayProxyRequest,
-) (string, error) {
-	// todo: extract orgID from request based on mapping
-	// eg. from jwt, header, field in body
-	// Default org ID to root org ID
-	orgID := parentOrgID
-	if orgIDField == "" {
-		return orgID, nil
+// bodyBytes returns the size of the body, decoding base64 encoded
+// binary bodies to their original size
+func bodyBytes(body string, isBase64Encoded bool) int64 {
+	if !isBase64Encoded {
+		return int64(len(body))
 	}
 
-	fieldParts := strings.Split(orgIDField, ".")
-	if len(fieldParts) < 3 {
-		return "", fmt.Errorf("invalid org ID field %s", orgIDField)
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return int64(len(body))
 	}
 
-	// the first field part is always "request"
-	switch fieldParts[1] {
-	case "header":
-		val, ok := req.Headers[strings.Title(fieldParts[2])]
-		if !ok {
-			return "", fmt.Errorf("org ID field %s not found", orgIDField)
-		}
-		orgID = val
-		if len(fieldParts) == 5 {
-			if fieldParts[3] == "jwt" {
-				// decode jwt and set org id
-			}
-		}
-	case "querystring":
-		val, ok := req.QueryStringParameters[fieldParts[2]]
-		if !ok {
-			return "", fmt.Errorf("org ID field %s not found", orgIDField)
-		}
-		orgID = val
-		if len(fieldParts) == 5 {
-			if fieldParts[3] == "jwt" {
-				// decode jwt and set org id
-			}
-		}
-	case "body":
-		var body map[string]interface{}
-		if err := json.Unmarshal([]byte(req.Body), &body); err != nil {
-			return "", fmt.Errorf("error unmarshalling request body")
-		}
-		val, ok := body[fieldParts[2]]
-		if !ok {
-			return "", fmt.Errorf("org ID field %s not found", orgIDField)
-		}
-		orgID, ok = val.(string)
-		if !ok {
-			return "", fmt.Errorf("org ID field %s can't be converted to a string", orgIDField)
-		}
-		if len(fieldParts) == 5 {
-			if fieldParts[3] == "jwt" {
-				// decode jwt and set org id
-			}
+	return int64(len(decoded))
+}
+
+// ClientIP resolves the client IP of the request from its identity
+func (b *APIGatewayEventBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	req, ok := request.(events.APIGatewayProxyRequest)
+	if !ok {
+		return nil, false
+	}
+
+	ip := net.ParseIP(req.RequestContext.Identity.SourceIP)
+	return ip, ip != nil
+}
+
+// mapHost maps the host the request targeted.
+// Falls back to the API's domain name without a Host header.
+func (b *APIGatewayEventBuilder) mapHost(
+	req *events.APIGatewayProxyRequest,
+) string {
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, "Host") && v != "" {
+			return v
 		}
 	}
 
-	return orgID, nil
+	return req.RequestContext.DomainName
+}
+
+// orgIDSources reads org ID fields from the request
+func (b *APIGatewayEventBuilder) orgIDSources(
+	req *events.APIGatewayProxyRequest,
+) orgIDSources {
+	return orgIDSources{
+		header: func(name string) (string, bool) {
+			return headerValue(req.Headers, name)
+		},
+		querystring: lookup(req.QueryStringParameters),
+		body: func(path string) gjson.Result {
+			return gjson.Get(req.Body, path)
+		},
+	}
 }
 
 // mapUser maps user related fields to user
 func (b *APIGatewayEventBuilder) mapUser(
+	configuration *config.Configuration,
 	req *events.APIGatewayProxyRequest,
 ) (*collect.EventUser, error) {
 	// Map identity
@@ -147,8 +208,12 @@ func (b *APIGatewayEventBuilder) mapUser(
 	identity := req.RequestContext.Identity
 	authorizer := req.RequestContext.Authorizer
 
-	user := &collect.EventUser{}
-	if claims, ok := authorizer["claims"]; ok {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+	if claims, ok := authorizer["claims"].(map[string]interface{}); ok {
+		user.AuthType = claimsAuthType(claims)
+
 		// Default to cognito identity
 		// https://docs.aws.amazon.com/cognito/latest/developerguide/amazon-cognito-user-pools-using-tokens-with-identity-providers.html
 		//
@@ -158,61 +223,180 @@ func (b *APIGatewayEventBuilder) mapUser(
 		// get userinfo endpoint
 		// get userinfo w token
 		// populate fields
-		claims := claims.(map[string]interface{})
-
-		if subject, ok := claims["sub"]; ok {
-			user.ID = subject.(string)
+		if subject, ok := claimString(claims, "sub"); ok {
+			user.ID = subject
 		}
 
-		if tokenUse, ok := claims["token_use"]; ok {
+		if tokenUse, ok := claimString(claims, "token_use"); ok {
 			switch tokenUse {
-			case "id":
-				// ID token
-				if name, ok := claims["given_name"]; ok {
-					user.FullName = name.(string)
-				}
-
-				if email, ok := claims["email"]; ok {
-					user.Email = email.(string)
-				}
-
-				if username, ok := claims["cognito:username"]; ok {
-					user.Name = username.(string)
+			case "id", "access":
+				// ID or access token
+				if name, ok := claimString(claims, "given_name"); ok {
+					user.FullName = name
 				}
 
-				if issuer, ok := claims["iss"]; ok {
-					// User pool
-					user.Domain = issuer.(string)
-				}
-			case "access":
-				// Access token
-				if name, ok := claims["given_name"]; ok {
-					user.FullName = name.(string)
+				if email, ok := claimString(claims, "email"); ok {
+					user.Email = email
 				}
 
-				if email, ok := claims["email"]; ok {
-					user.Email = email.(string)
+				if username, ok := claimString(claims, "cognito:username"); ok {
+					user.Name = username
 				}
 
-				if username, ok := claims["cognito:username"]; ok {
-					user.Name = username.(string)
-				}
-
-				if issuer, ok := claims["iss"]; ok {
+				if issuer, ok := claimString(claims, "iss"); ok {
 					// User pool
-					user.Domain = issuer.(string)
+					user.Domain = issuer
 				}
 			}
 		}
-	} else if principalID, ok := authorizer["principalId"]; ok {
+
+		user.Roles = collect.ClaimValues(claims, configuration.RoleClaims)
+		user.Scopes = collect.ClaimValues(claims, configuration.ScopeClaims)
+	} else if principalID, ok := claimString(authorizer, "principalId"); ok {
 		// Custom authorizer principal
-		user.ID = principalID.(string)
-		user.Name = principalID.(string)
+		user.AuthType = collect.AuthTypeCustom
+		user.ID = principalID
+		user.Name = principalID
+
+		// Custom authorizer context is flattened into the authorizer
+		user.Roles = collect.ClaimValues(authorizer, configuration.RoleClaims)
+		user.Scopes = collect.ClaimValues(authorizer, configuration.ScopeClaims)
 	} else if identity.UserArn != "" {
 		// Finally, try IAM user
+		user.AuthType = collect.AuthTypeIAM
 		user.ID = identity.UserArn
 		user.Name = identity.User
+
+		// Attribute assumed roles to the person identified by session tags
+		b.mapIAMUserFields(configuration.IAMUserFields, authorizer, user)
 	}
 
 	return user, nil
 }
+
+// claimsAuthType returns cognito if the claims were issued by a Cognito
+// user pool, otherwise jwt
+func claimsAuthType(claims map[string]interface{}) collect.AuthType {
+	if _, ok := claimString(claims, "token_use"); ok {
+		return collect.AuthTypeCognito
+	}
+
+	if issuer, ok := claimString(claims, "iss"); ok &&
+		strings.Contains(issuer, "cognito-idp.") {
+		return collect.AuthTypeCognito
+	}
+
+	return collect.AuthTypeJWT
+}
+
+// mapIAMUserFields maps the configured authorizer context fields to user
+func (b *APIGatewayEventBuilder) mapIAMUserFields(
+	fields map[string]string,
+	authorizer map[string]interface{},
+	user *collect.EventUser,
+) {
+	for userField, path := range fields {
+		val, ok := authorizerValue(authorizer, path)
+		if !ok {
+			continue
+		}
+
+		setUserField(user, userField, val)
+	}
+}
+
+// mapAuthorizerContext maps the configured custom authorizer context
+// fields to the org, user or metadata of the event
+func mapAuthorizerContext(
+	fields map[string]string,
+	authorizer map[string]interface{},
+	event *collect.EventRaw,
+) {
+	for eventField, path := range fields {
+		val, ok := authorizerValue(authorizer, path)
+		if !ok {
+			continue
+		}
+
+		parts := strings.SplitN(eventField, ".", 2)
+		if len(parts) < 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "org":
+			if parts[1] == "id" {
+				event.Organization.ID = val
+			}
+		case "user":
+			setUserField(event.User, parts[1], val)
+		case "metadata":
+			if event.Metadata == nil {
+				event.Metadata = map[string]string{}
+			}
+			event.Metadata[parts[1]] = val
+		}
+	}
+}
+
+// claimString returns the named claim if it is present and a string.
+// Authorizer payloads vary across identity providers, so missing or
+// mis-typed claims are skipped rather than asserted.
+func claimString(claims map[string]interface{}, name string) (string, bool) {
+	s, ok := claims[name].(string)
+	return s, ok
+}
+
+// setUserField sets the user field by its JSON name
+func setUserField(user *collect.EventUser, field string, val string) {
+	switch field {
+	case "id":
+		user.ID = val
+	case "email":
+		user.Email = val
+	case "full_name":
+		user.FullName = val
+	case "name":
+		user.Name = val
+	case "domain":
+		user.Domain = val
+	}
+}
+
+// authorizerValue finds the value at a dot separated path in the
+// authorizer context. Values that are JSON encoded objects are decoded
+// to continue down the path. Non-string values are returned as JSON.
+func authorizerValue(authorizer map[string]interface{}, path string) (string, bool) {
+	var val interface{} = authorizer
+	for _, key := range strings.Split(path, ".") {
+		if s, ok := val.(string); ok {
+			// Authorizer context values can only be strings, numbers or
+			// booleans, so nested values are often JSON encoded
+			var m map[string]interface{}
+			if err := json.Unmarshal([]byte(s), &m); err == nil {
+				val = m
+			}
+		}
+
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+
+		val, ok = m[key]
+		if !ok || val == nil {
+			return "", false
+		}
+	}
+
+	if s, ok := val.(string); ok {
+		return s, s != ""
+	}
+
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return "", false
+	}
+
+	return string(encoded), true
+}
@@ -1,6 +1,7 @@
 package lambda
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"strings"
@@ -21,6 +22,7 @@ func TestBuild(t *testing.T) {
 	route := &config.Route{
 		HTTPMethod: http.MethodGet,
 		Path:       "/person/:id",
+		Name:       "get-person",
 	}
 
 	user := &collect.EventUser{
@@ -29,6 +31,7 @@ func TestBuild(t *testing.T) {
 		FullName: "full-name",
 		Name:     "username",
 		Domain:   "domain",
+		AuthType: collect.AuthTypeCognito,
 	}
 
 	client := &collect.EventClient{
@@ -64,8 +67,10 @@ func TestBuild(t *testing.T) {
 
 	a := &APIGatewayEventBuilder{}
 	eventRaw, err := a.Build(
-		parentOrgID,
-		orgIDField,
+		&config.Configuration{
+			ParentOrgID: parentOrgID,
+			OrgIDField:  orgIDField,
+		},
 		collect.RouteTypeTarget,
 		route,
 		req,
@@ -80,6 +85,7 @@ func TestBuild(t *testing.T) {
 	assert.Equal(t, collect.RouteTypeTarget, eventRaw.Route.Type)
 	assert.Equal(t, route.HTTPMethod, eventRaw.Route.Method)
 	assert.Equal(t, route.Path, eventRaw.Route.Path)
+	assert.Equal(t, route.Name, eventRaw.Route.Name)
 
 	assert.Equal(t, user, eventRaw.User)
 
@@ -91,3 +97,656 @@ func TestBuild(t *testing.T) {
 	assert.Equal(t, res, eventRaw.Response)
 	assert.Equal(t, errorValue, eventRaw.Error)
 }
+
+func TestBuild_MapsRolesAndScopes(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"sub":            "user-id",
+					"token_use":      "access",
+					"cognito:groups": "[admin users]",
+					"scope":          "read:person write:person",
+				},
+			},
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			RoleClaims:  config.DefaultRoleClaims,
+			ScopeClaims: config.DefaultScopeClaims,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "users"}, eventRaw.User.Roles)
+	assert.Equal(t, []string{"read:person", "write:person"}, eventRaw.User.Scopes)
+}
+
+func TestBuild_MapsIAMUserFields(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{
+				"principalTags": map[string]interface{}{
+					"userId": "jdoe-id",
+					"email":  "jdoe@example.com",
+				},
+			},
+			Identity: events.APIGatewayRequestIdentity{
+				UserArn: "arn:aws:sts::123456789012:assumed-role/admin/session",
+				User:    "AROAEXAMPLE:session",
+			},
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			IAMUserFields: map[string]string{
+				"id":    "principalTags.userId",
+				"email": "principalTags.email",
+				"name":  "principalTags.username",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "jdoe-id", eventRaw.User.ID)
+	assert.Equal(t, "jdoe@example.com", eventRaw.User.Email)
+	// missing tags fall back to the IAM identity
+	assert.Equal(t, "AROAEXAMPLE:session", eventRaw.User.Name)
+}
+
+func TestBuild_MapsQueryParams(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person",
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{
+			QueryStringParameters: map[string]string{
+				"name": "homer",
+			},
+		},
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name": "homer",
+	}, eventRaw.QueryParams)
+
+	eventRaw, err = a.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{},
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Nil(t, eventRaw.QueryParams)
+}
+
+func TestBuild_MapsFirstResolvingOrgIDField(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person",
+	}
+
+	configuration := &config.Configuration{
+		ParentOrgID: "parent-org-id",
+		OrgIDFields: []string{
+			"request.header.x-org-id",
+			"request.body.org_id",
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{
+			Body: `{"org_id":"body-org-id"}`,
+		},
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "body-org-id", eventRaw.Organization.ID)
+
+	eventRaw, err = a.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{
+			Headers: map[string]string{
+				"X-Org-Id": "header-org-id",
+			},
+			Body: `{"org_id":"body-org-id"}`,
+		},
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "header-org-id", eventRaw.Organization.ID)
+
+	_, err = a.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{
+			Body: `{}`,
+		},
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestBuild_MapsOrgIDFromJWT(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person",
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"sub":    "user-id",
+		"org_id": "jwt-org-id",
+		"tier":   3,
+	})
+	assert.NoError(t, err)
+
+	token := "eyJhbGciOiJSUzI1NiJ9." +
+		base64.RawURLEncoding.EncodeToString(claims) +
+		".c2lnbmF0dXJl"
+
+	req := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"Authorization": "Bearer " + token,
+			"X-Token":       "not-a-jwt",
+		},
+		QueryStringParameters: map[string]string{
+			"token": token,
+		},
+		Body: `{"token":"` + token + `"}`,
+	}
+
+	tests := []struct {
+		orgIDField string
+		expected   string
+		err        string
+	}{
+		{
+			orgIDField: "request.header.authorization.jwt.org_id",
+			expected:   "jwt-org-id",
+		},
+		{
+			orgIDField: "request.querystring.token.jwt.org_id",
+			expected:   "jwt-org-id",
+		},
+		{
+			orgIDField: "request.body.token.jwt.org_id",
+			expected:   "jwt-org-id",
+		},
+		{
+			orgIDField: "request.header.authorization.jwt.tenant_id",
+			err:        "org ID field request.header.authorization.jwt.tenant_id not found",
+		},
+		{
+			orgIDField: "request.header.authorization.jwt.tier",
+			err:        "org ID field request.header.authorization.jwt.tier can't be converted to a string",
+		},
+		{
+			orgIDField: "request.header.x-token.jwt.org_id",
+			err:        "org ID field request.header.x-token.jwt.org_id is not a valid jwt",
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	for _, tt := range tests {
+		eventRaw, err := a.Build(
+			&config.Configuration{
+				ParentOrgID: "parent-org-id",
+				OrgIDField:  tt.orgIDField,
+			},
+			collect.RouteTypeTarget,
+			route,
+			req,
+			json.RawMessage(`{}`),
+			nil,
+		)
+
+		if tt.err != "" {
+			assert.Error(t, err, tt.orgIDField)
+			assert.Contains(t, err.Error(), tt.err)
+			continue
+		}
+
+		assert.NoError(t, err, tt.orgIDField)
+		assert.Equal(t, tt.expected, eventRaw.Organization.ID, tt.orgIDField)
+	}
+}
+
+func TestBuild_MapsAuthorizerContext(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{
+				"principalId": "principal-id",
+				"tenantId":    "tenant-id",
+				"plan":        "pro",
+				"seats":       float64(5),
+				"profile":     `{"email":"jdoe@example.com"}`,
+			},
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			AuthorizerContextFields: map[string]string{
+				"org.id":         "tenantId",
+				"user.email":     "profile.email",
+				"metadata.plan":  "plan",
+				"metadata.seats": "seats",
+				"metadata.none":  "missing.key",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-id", eventRaw.Organization.ID)
+	assert.Equal(t, "principal-id", eventRaw.User.ID)
+	assert.Equal(t, "jdoe@example.com", eventRaw.User.Email)
+	assert.Equal(t, map[string]string{
+		"plan":  "pro",
+		"seats": "5",
+	}, eventRaw.Metadata)
+}
+
+func TestBuild_CapturesErrorBody(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	res := json.RawMessage(`{"statusCode":500,"body":"{\"message\":\"oops\"}"}`)
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			ErrorStatusThreshold: 400,
+		},
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{},
+		res,
+		json.RawMessage(`null`),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, res, eventRaw.Response)
+	assert.Equal(t, json.RawMessage(`{"message":"oops"}`), eventRaw.Error)
+
+	// Handler errors take precedence
+	errorValue := json.RawMessage(`{"errorMessage":"boom"}`)
+	eventRaw, err = a.Build(
+		&config.Configuration{
+			ErrorStatusThreshold: 400,
+		},
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{},
+		res,
+		errorValue,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, errorValue, eventRaw.Error)
+}
+
+func TestBuild_SkipsMistypedClaims(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	tests := []struct {
+		name       string
+		authorizer map[string]interface{}
+		user       *collect.EventUser
+	}{
+		{
+			name: "mistyped claims",
+			authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"sub":              "user-id",
+					"token_use":        "id",
+					"given_name":       123,
+					"email":            true,
+					"cognito:username": []interface{}{"username"},
+					"iss":              nil,
+				},
+			},
+			user: &collect.EventUser{
+				ID:       "user-id",
+				AuthType: collect.AuthTypeCognito,
+			},
+		},
+		{
+			name: "mistyped token use",
+			authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"sub":       42.0,
+					"token_use": 1,
+					"email":     "email",
+				},
+			},
+			user: &collect.EventUser{
+				AuthType: collect.AuthTypeJWT,
+			},
+		},
+		{
+			name: "claims not a map",
+			authorizer: map[string]interface{}{
+				"claims":      "sub=user-id",
+				"principalId": "principal-id",
+			},
+			user: &collect.EventUser{
+				ID:       "principal-id",
+				Name:     "principal-id",
+				AuthType: collect.AuthTypeCustom,
+			},
+		},
+		{
+			name: "mistyped principal",
+			authorizer: map[string]interface{}{
+				"principalId": 1234,
+			},
+			user: &collect.EventUser{
+				AuthType: collect.AuthTypeNone,
+			},
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: tt.authorizer,
+				},
+			}
+
+			var eventRaw *collect.EventRaw
+			var err error
+			assert.NotPanics(t, func() {
+				eventRaw, err = a.Build(
+					&config.Configuration{},
+					collect.RouteTypeTarget,
+					route,
+					req,
+					json.RawMessage(`{}`),
+					nil,
+				)
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.user, eventRaw.User)
+		})
+	}
+}
+
+func TestBuild_CapturesResponseBodyInStatusRange(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	configuration := &config.Configuration{
+		ResponseBodyStatusMin: 400,
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{},
+		json.RawMessage(`{"statusCode":200,"headers":{"X-Id":"1"},"body":"{\"id\":1}"}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`{"headers":{"X-Id":"1"},"statusCode":200}`), eventRaw.Response)
+
+	res := json.RawMessage(`{"statusCode":500,"body":"{\"message\":\"oops\"}"}`)
+	eventRaw, err = a.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		events.APIGatewayProxyRequest{},
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, res, eventRaw.Response)
+}
+
+func TestBuild_MapsHost(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"host": "api.example.com",
+		},
+		RequestContext: events.APIGatewayProxyRequestContext{
+			DomainName: "abc123.execute-api.us-west-2.amazonaws.com",
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "api.example.com", eventRaw.Host)
+
+	// Falls back to the domain name
+	req.Headers = nil
+	eventRaw, err = a.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123.execute-api.us-west-2.amazonaws.com", eventRaw.Host)
+}
+
+func TestBuild_MapsBodySizes(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		Body: `{ "name": "jdoe" }`,
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			MinifyJSON: true,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		// "hello" base64 encoded
+		json.RawMessage(`{"statusCode":200,"body":"aGVsbG8=","isBase64Encoded":true}`),
+		nil,
+	)
+	assert.NoError(t, err)
+
+	// Sizes are of the original bodies
+	assert.Equal(t, int64(len(req.Body)), eventRaw.Client.Bytes)
+	assert.Equal(t, int64(5), eventRaw.ResponseBytes)
+}
+
+func TestBuild_TruncatesFields(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person",
+	}
+
+	req := events.APIGatewayProxyRequest{
+		Body: `{"id":"jdoe","description":"a very long description"}`,
+	}
+
+	a := &APIGatewayEventBuilder{}
+	eventRaw, err := a.Build(
+		&config.Configuration{
+			TruncatedFields: map[string]int{
+				"description": 6,
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{"statusCode":200,"body":"{\"id\":\"jdoe\",\"description\":\"a very long description\"}"}`),
+		nil,
+	)
+	assert.NoError(t, err)
+
+	expectedBody := `{"id":"jdoe","description":"a very...[truncated]"}`
+	assert.Equal(t, expectedBody, eventRaw.Request.(events.APIGatewayProxyRequest).Body)
+
+	var res struct {
+		Body string `json:"body"`
+	}
+	assert.NoError(t, json.Unmarshal(eventRaw.Response.(json.RawMessage), &res))
+	assert.Equal(t, expectedBody, res.Body)
+
+	// Sizes are of the original bodies
+	assert.Equal(t, int64(len(req.Body)), eventRaw.Client.Bytes)
+}
+
+func TestBuild_RecordsAuthType(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	tests := []struct {
+		name       string
+		authorizer map[string]interface{}
+		identity   events.APIGatewayRequestIdentity
+		authType   collect.AuthType
+	}{
+		{
+			name: "cognito",
+			authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"sub": "user-id",
+					"iss": "https://cognito-idp.us-west-2.amazonaws.com/us-west-2_abc",
+				},
+			},
+			authType: collect.AuthTypeCognito,
+		},
+		{
+			name: "jwt",
+			authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"sub": "user-id",
+					"iss": "https://example.auth0.com/",
+				},
+			},
+			authType: collect.AuthTypeJWT,
+		},
+		{
+			name: "custom",
+			authorizer: map[string]interface{}{
+				"principalId": "principal-id",
+			},
+			authType: collect.AuthTypeCustom,
+		},
+		{
+			name: "iam",
+			identity: events.APIGatewayRequestIdentity{
+				UserArn: "arn:aws:iam::123456789012:user/jdoe",
+				User:    "jdoe",
+			},
+			authType: collect.AuthTypeIAM,
+		},
+		{
+			name:     "none",
+			authType: collect.AuthTypeNone,
+		},
+	}
+
+	a := &APIGatewayEventBuilder{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: tt.authorizer,
+					Identity:   tt.identity,
+				},
+			}
+
+			eventRaw, err := a.Build(
+				&config.Configuration{},
+				collect.RouteTypeTarget,
+				route,
+				req,
+				json.RawMessage(`{}`),
+				nil,
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.authType, eventRaw.User.AuthType)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/tidwall/gjson"
+)
+
+// APIGatewayV2EventBuilder builds an event from API Gateway HTTP API
+// (payload format 2.0) request and response
+type APIGatewayV2EventBuilder struct{}
+
+// Build builds an event from API Gateway HTTP API request and response
+func (b *APIGatewayV2EventBuilder) Build(
+	configuration *config.Configuration,
+	routeType collect.RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*collect.EventRaw, error) {
+	req, ok := request.(events.APIGatewayV2HTTPRequest)
+	if !ok {
+		return nil, fmt.Errorf("request is not of type APIGatewayV2HTTPRequest")
+	}
+
+	orgID, err := mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		b.orgIDSources(&req),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user := b.mapUser(configuration, &req)
+
+	if collect.IsEmptyJSON(errorValue) && route.CapturesResponse() {
+		// Locate failed response bodies on the error consistently,
+		// unless the route's responses aren't captured
+		errorBody := collect.ErrorBody(
+			response,
+			"statusCode",
+			"body",
+			configuration.ErrorStatusThreshold,
+		)
+		if errorBody != nil {
+			errorValue = errorBody
+		}
+	}
+
+	// Only keep the body of responses in the configured status range
+	response = collect.StripResponseBody(
+		response,
+		"statusCode",
+		"body",
+		configuration.ResponseBodyStatusMin,
+		configuration.ResponseBodyStatusMax,
+	)
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := bodyBytes(req.Body, req.IsBase64Encoded)
+	responseBytes := bodyBytes(
+		gjson.GetBytes(response, "body").String(),
+		gjson.GetBytes(response, "isBase64Encoded").Bool(),
+	)
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+		response = collect.TruncateJSONField(response, "body", configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+		response = collect.MinifyJSONField(response, "body")
+	}
+
+	event := &collect.EventRaw{
+		Organization: &collect.EventOrganization{
+			ID: orgID,
+		},
+
+		Route: &collect.EventRoute{
+			Type:   routeType,
+			Method: route.HTTPMethod,
+			Path:   route.Path,
+			Name:   route.Name,
+			RawPath: collect.MaskPathParams(
+				route.Path,
+				requestPathV2(&req),
+				configuration.MaskedPathParams,
+			),
+		},
+
+		Host: b.mapHost(&req),
+
+		User: user,
+
+		Client: &collect.EventClient{
+			IP:    req.RequestContext.HTTP.SourceIP,
+			Bytes: requestBytes,
+		},
+
+		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
+
+		Request:     req,
+		QueryParams: queryParams(req.QueryStringParameters, nil),
+		Response:    response,
+		Error:       errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.RequestContext.RequestID,
+	}
+
+	if req.RequestContext.TimeEpoch > 0 {
+		event.RequestedAt = req.RequestContext.TimeEpoch
+	}
+
+	if authorizer := req.RequestContext.Authorizer; authorizer != nil {
+		// Lambda authorizer context is the closest to a REST API's
+		// custom authorizer context
+		mapAuthorizerContext(
+			configuration.AuthorizerContextFields,
+			authorizer.Lambda,
+			event,
+		)
+	}
+
+	return event, nil
+}
+
+// ClientIP resolves the client IP of the request from its HTTP context
+func (b *APIGatewayV2EventBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	req, ok := request.(events.APIGatewayV2HTTPRequest)
+	if !ok {
+		return nil, false
+	}
+
+	ip := net.ParseIP(req.RequestContext.HTTP.SourceIP)
+	return ip, ip != nil
+}
+
+// mapHost maps the host the request targeted.
+// Falls back to the API's domain name without a Host header.
+func (b *APIGatewayV2EventBuilder) mapHost(
+	req *events.APIGatewayV2HTTPRequest,
+) string {
+	if host, ok := headerValue(req.Headers, "Host"); ok && host != "" {
+		return host
+	}
+
+	return req.RequestContext.DomainName
+}
+
+// orgIDSources reads org ID fields from the request. Besides the header,
+// query string and body of the request, the org ID can be read from the
+// authorizer, e.g. request.authorizer.jwt.claims.org_id
+func (b *APIGatewayV2EventBuilder) orgIDSources(
+	req *events.APIGatewayV2HTTPRequest,
+) orgIDSources {
+	return orgIDSources{
+		header: func(name string) (string, bool) {
+			// HTTP API headers are lower case
+			return headerValue(req.Headers, name)
+		},
+		querystring: lookup(req.QueryStringParameters),
+		body: func(path string) gjson.Result {
+			return gjson.Get(req.Body, path)
+		},
+		authorizer: func(path string) (string, bool) {
+			return authorizerValue(authorizerContext(req), path)
+		},
+	}
+}
+
+// mapUser maps user related fields to user
+func (b *APIGatewayV2EventBuilder) mapUser(
+	configuration *config.Configuration,
+	req *events.APIGatewayV2HTTPRequest,
+) *collect.EventUser {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+
+	authorizer := req.RequestContext.Authorizer
+	if authorizer == nil {
+		return user
+	}
+
+	if authorizer.JWT != nil {
+		// JWT authorizer claims are flattened into strings
+		// https://docs.aws.amazon.com/apigateway/latest/developerguide/http-api-jwt-authorizer.html
+		claims := make(map[string]interface{}, len(authorizer.JWT.Claims))
+		for k, v := range authorizer.JWT.Claims {
+			claims[k] = v
+		}
+
+		user.AuthType = claimsAuthType(claims)
+		user.ID = authorizer.JWT.Claims["sub"]
+		user.Email = authorizer.JWT.Claims["email"]
+		user.FullName = authorizer.JWT.Claims["given_name"]
+		user.Domain = authorizer.JWT.Claims["iss"]
+
+		user.Name = authorizer.JWT.Claims["cognito:username"]
+		if user.Name == "" {
+			user.Name = authorizer.JWT.Claims["username"]
+		}
+
+		user.Roles = collect.ClaimValues(claims, configuration.RoleClaims)
+		user.Scopes = authorizer.JWT.Scopes
+		if len(user.Scopes) == 0 {
+			user.Scopes = collect.ClaimValues(claims, configuration.ScopeClaims)
+		}
+	} else if authorizer.Lambda != nil {
+		// Lambda authorizer context
+		user.AuthType = collect.AuthTypeCustom
+		if principalID, ok := claimString(authorizer.Lambda, "principalId"); ok {
+			user.ID = principalID
+			user.Name = principalID
+		}
+
+		user.Roles = collect.ClaimValues(authorizer.Lambda, configuration.RoleClaims)
+		user.Scopes = collect.ClaimValues(authorizer.Lambda, configuration.ScopeClaims)
+	} else if authorizer.IAM != nil && authorizer.IAM.UserARN != "" {
+		user.AuthType = collect.AuthTypeIAM
+		user.ID = authorizer.IAM.UserARN
+		user.Name = authorizer.IAM.UserID
+	}
+
+	return user
+}
+
+// authorizerContext returns the authorizer of the request as a generic
+// map, to look up values by path
+func authorizerContext(req *events.APIGatewayV2HTTPRequest) map[string]interface{} {
+	if req.RequestContext.Authorizer == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(req.RequestContext.Authorizer)
+	if err != nil {
+		return nil
+	}
+
+	var authorizer map[string]interface{}
+	if err := json.Unmarshal(b, &authorizer); err != nil {
+		return nil
+	}
+
+	return authorizer
+}
+
+// headerValue returns the value of the header regardless of case
+func headerValue(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+
+	return "", false
+}
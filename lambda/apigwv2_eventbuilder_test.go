@@ -0,0 +1,235 @@
+package lambda
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildV2(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+		Name:       "get-person",
+	}
+
+	user := &collect.EventUser{
+		ID:       "user-id",
+		Email:    "email",
+		FullName: "full-name",
+		Name:     "username",
+		Domain:   "https://cognito-idp.us-west-2.amazonaws.com/pool",
+		AuthType: collect.AuthTypeCognito,
+	}
+
+	client := &collect.EventClient{
+		IP: "1.2.3.4",
+	}
+
+	requestedAt := int64(1583348638390)
+	req := events.APIGatewayV2HTTPRequest{
+		Version:  "2.0",
+		RouteKey: "GET /person/{id}",
+		RawPath:  "/dev/person/xyz",
+		Headers: map[string]string{
+			"host": "api.example.com",
+		},
+		QueryStringParameters: map[string]string{
+			"page": "2",
+		},
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Stage:     "dev",
+			RequestID: "request-id",
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: map[string]string{
+						"sub":              user.ID,
+						"given_name":       user.FullName,
+						"email":            user.Email,
+						"cognito:username": user.Name,
+						"iss":              user.Domain,
+						"org_id":           "ext-org-id",
+					},
+					Scopes: []string{"read:person"},
+				},
+			},
+			TimeEpoch: requestedAt,
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method:   http.MethodGet,
+				Path:     "/dev/person/xyz",
+				SourceIP: client.IP,
+			},
+		},
+	}
+
+	res := json.RawMessage(`{"statusCode":200}`)
+
+	b := &APIGatewayV2EventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDField:  "request.authorizer.jwt.claims.org_id",
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, eventRaw)
+
+	assert.Equal(t, "ext-org-id", eventRaw.Organization.ID)
+
+	assert.Equal(t, collect.RouteTypeTarget, eventRaw.Route.Type)
+	assert.Equal(t, route.HTTPMethod, eventRaw.Route.Method)
+	assert.Equal(t, route.Path, eventRaw.Route.Path)
+	assert.Equal(t, "/person/xyz", eventRaw.Route.RawPath)
+
+	user.Scopes = []string{"read:person"}
+	assert.Equal(t, user, eventRaw.User)
+
+	assert.Equal(t, client, eventRaw.Client)
+	assert.Equal(t, "api.example.com", eventRaw.Host)
+	assert.Equal(t, map[string]string{"page": "2"}, eventRaw.QueryParams)
+	assert.Equal(t, requestedAt, eventRaw.RequestedAt)
+	assert.Equal(t, "request-id", eventRaw.RequestID)
+
+	assert.Equal(t, req, eventRaw.Request)
+	assert.Equal(t, res, eventRaw.Response)
+}
+
+func TestBuildV2_RejectsOtherRequests(t *testing.T) {
+	b := &APIGatewayV2EventBuilder{}
+	_, err := b.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		events.APIGatewayProxyRequest{},
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestBuildV2_MapsOrgIDFields(t *testing.T) {
+	req := events.APIGatewayV2HTTPRequest{
+		Headers: map[string]string{
+			"x-org-id": "header-org-id",
+		},
+		QueryStringParameters: map[string]string{
+			"org": "querystring-org-id",
+		},
+		Body: `{"org":{"id":"body-org-id"}}`,
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{
+					"orgId": "lambda-org-id",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		orgIDField string
+		expected   string
+	}{
+		{"request.header.X-Org-Id", "header-org-id"},
+		{"request.querystring.org", "querystring-org-id"},
+		{"request.body.org.id", "body-org-id"},
+		{"request.authorizer.lambda.orgId", "lambda-org-id"},
+	}
+
+	b := &APIGatewayV2EventBuilder{}
+	for _, tt := range tests {
+		orgID, err := mapOrgIDField("parent-org-id", tt.orgIDField, b.orgIDSources(&req))
+		assert.NoError(t, err, tt.orgIDField)
+		assert.Equal(t, tt.expected, orgID, tt.orgIDField)
+	}
+
+	_, err := mapOrgIDField("parent-org-id", "request.authorizer.jwt.claims.org_id", b.orgIDSources(&req))
+	assert.Error(t, err)
+}
+
+func TestBuildV2_RecordsAuthType(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer *events.APIGatewayV2HTTPRequestContextAuthorizerDescription
+		expected   *collect.EventUser
+	}{
+		{
+			name:     "none",
+			expected: &collect.EventUser{AuthType: collect.AuthTypeNone},
+		},
+		{
+			name: "jwt",
+			authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+					Claims: map[string]string{
+						"sub":      "user-id",
+						"iss":      "https://auth.example.com",
+						"username": "username",
+					},
+				},
+			},
+			expected: &collect.EventUser{
+				ID:       "user-id",
+				Name:     "username",
+				Domain:   "https://auth.example.com",
+				AuthType: collect.AuthTypeJWT,
+			},
+		},
+		{
+			name: "custom",
+			authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{
+					"principalId": "principal-id",
+				},
+			},
+			expected: &collect.EventUser{
+				ID:       "principal-id",
+				Name:     "principal-id",
+				AuthType: collect.AuthTypeCustom,
+			},
+		},
+		{
+			name: "iam",
+			authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				IAM: &events.APIGatewayV2HTTPRequestContextAuthorizerIAMDescription{
+					UserARN: "arn:aws:iam::123456789012:user/username",
+					UserID:  "AIDAEXAMPLE",
+				},
+			},
+			expected: &collect.EventUser{
+				ID:       "arn:aws:iam::123456789012:user/username",
+				Name:     "AIDAEXAMPLE",
+				AuthType: collect.AuthTypeIAM,
+			},
+		},
+	}
+
+	b := &APIGatewayV2EventBuilder{}
+	for _, tt := range tests {
+		req := events.APIGatewayV2HTTPRequest{
+			RequestContext: events.APIGatewayV2HTTPRequestContext{
+				Authorizer: tt.authorizer,
+			},
+		}
+
+		eventRaw, err := b.Build(
+			&config.Configuration{},
+			collect.RouteTypeTarget,
+			&config.Route{},
+			req,
+			nil,
+			nil,
+		)
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expected, eventRaw.User, tt.name)
+	}
+}
@@ -0,0 +1,178 @@
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/tidwall/gjson"
+)
+
+// EventBridgeMethod is the method of the routes of EventBridge events.
+// Routes of an event are configured as EVENT /<source>/<detail-type>,
+// e.g. EVENT /aws.events/Scheduled Event.
+const EventBridgeMethod = "EVENT"
+
+// EventBridgeEventBuilder builds an event from an EventBridge, previously
+// CloudWatch Events, event
+type EventBridgeEventBuilder struct{}
+
+// Build builds an event from EventBridge event and response
+func (b *EventBridgeEventBuilder) Build(
+	configuration *config.Configuration,
+	routeType collect.RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*collect.EventRaw, error) {
+	req, ok := request.(events.CloudWatchEvent)
+	if !ok {
+		return nil, fmt.Errorf("request is not of type CloudWatchEvent")
+	}
+
+	orgID, err := mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		b.orgIDSources(&req),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user := b.mapUser(configuration, &req)
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := int64(len(req.Detail))
+	responseBytes := int64(len(response))
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	// The detail is the payload of the event, the rest is its envelope
+	detail := string(req.Detail)
+	if len(configuration.TruncatedFields) > 0 {
+		detail = collect.TruncateJSON(detail, configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		detail = collect.MinifyJSON(detail)
+	}
+
+	event := &collect.EventRaw{
+		Organization: &collect.EventOrganization{
+			ID: orgID,
+		},
+
+		Route: &collect.EventRoute{
+			Type:    routeType,
+			Method:  route.HTTPMethod,
+			Path:    route.Path,
+			Name:    route.Name,
+			RawPath: eventBridgePath(&req),
+		},
+
+		User: user,
+
+		Client: &collect.EventClient{
+			Bytes: requestBytes,
+		},
+
+		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
+
+		Request:  json.RawMessage(detail),
+		Response: response,
+		Error:    errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.ID,
+	}
+
+	if !req.Time.IsZero() {
+		event.RequestedAt = req.Time.UnixNano() / int64(time.Millisecond)
+	}
+
+	if len(req.Detail) == 0 {
+		// Scheduled events may have no detail
+		event.Request = nil
+	}
+
+	return event, nil
+}
+
+// orgIDSources reads org ID fields from the detail of the event, read as
+// request.detail.<path>. The detail is the body of the event, so
+// request.body.<path> is read from the detail as well.
+func (b *EventBridgeEventBuilder) orgIDSources(req *events.CloudWatchEvent) orgIDSources {
+	return orgIDSources{
+		body: func(path string) gjson.Result {
+			return gjson.GetBytes(req.Detail, path)
+		},
+	}
+}
+
+// mapUser maps the configured user fields to user
+func (b *EventBridgeEventBuilder) mapUser(
+	configuration *config.Configuration,
+	req *events.CloudWatchEvent,
+) *collect.EventUser {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+
+	for _, name := range config.UserFieldNames {
+		field, ok := configuration.UserFields[name]
+		if !ok {
+			continue
+		}
+
+		if val, err := b.mappedValue(req, field); err == nil {
+			setUserField(user, name, val)
+		}
+	}
+
+	return user
+}
+
+// mappedValue extracts the field value from the detail of the event,
+// read as request.detail.<path>. The detail is the body of the event,
+// so request.body.<path> is read from the detail as well.
+func (b *EventBridgeEventBuilder) mappedValue(
+	req *events.CloudWatchEvent,
+	field string,
+) (string, error) {
+	fieldParts := strings.SplitN(field, ".", 3)
+	if len(fieldParts) < 3 {
+		return "", fmt.Errorf("invalid field %s", field)
+	}
+
+	// the first field part is always "request"
+	switch fieldParts[1] {
+	case "detail", "body":
+		result := gjson.GetBytes(req.Detail, fieldParts[2])
+		if result.Type == gjson.String {
+			return result.String(), nil
+		}
+
+		if result.Exists() {
+			return "", fmt.Errorf("field %s can't be converted to a string", field)
+		}
+	default:
+		return "", fmt.Errorf("invalid field %s", field)
+	}
+
+	return "", fmt.Errorf("field %s not found", field)
+}
+
+// eventBridgePath returns the route path of the event,
+// e.g. /aws.events/Scheduled Event
+func eventBridgePath(req *events.CloudWatchEvent) string {
+	return "/" + req.Source + "/" + req.DetailType
+}
@@ -0,0 +1,101 @@
+package lambda
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildEventBridge(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: EventBridgeMethod,
+		Path:       "/com.example.orders/Order Placed",
+		Name:       "order-placed",
+	}
+
+	req := events.CloudWatchEvent{
+		ID:         "event-id",
+		DetailType: "Order Placed",
+		Source:     "com.example.orders",
+		Time:       time.Unix(1583348638, 0),
+		Detail:     json.RawMessage(`{"org":{"id":"ext-org-id"},"user":{"id":"user-id","email":"email"}}`),
+	}
+
+	b := &EventBridgeEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDField:  "request.detail.org.id",
+			UserFields: map[string]string{
+				"id":    "request.detail.user.id",
+				"email": "request.body.user.email",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`null`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, eventRaw)
+
+	assert.Equal(t, "ext-org-id", eventRaw.Organization.ID)
+
+	assert.Equal(t, &collect.EventRoute{
+		Type:    collect.RouteTypeTarget,
+		Method:  EventBridgeMethod,
+		Path:    "/com.example.orders/Order Placed",
+		Name:    "order-placed",
+		RawPath: "/com.example.orders/Order Placed",
+	}, eventRaw.Route)
+
+	assert.Equal(t, &collect.EventUser{
+		ID:       "user-id",
+		Email:    "email",
+		AuthType: collect.AuthTypeNone,
+	}, eventRaw.User)
+
+	assert.Equal(t, int64(1583348638000), eventRaw.RequestedAt)
+	assert.Equal(t, "event-id", eventRaw.RequestID)
+	assert.Equal(t, req.Detail, eventRaw.Request)
+}
+
+func TestBuildEventBridge_RejectsOtherRequests(t *testing.T) {
+	b := &EventBridgeEventBuilder{}
+	_, err := b.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		events.APIGatewayProxyRequest{},
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestBuildEventBridge_MapsOrgIDFields(t *testing.T) {
+	req := events.CloudWatchEvent{
+		Detail: json.RawMessage(`{"org":{"id":"detail-org-id","count":1}}`),
+	}
+
+	b := &EventBridgeEventBuilder{}
+	orgID, err := mapOrgID(
+		"parent-org-id",
+		[]string{"request.detail.org.missing", "request.detail.org.id"},
+		b.orgIDSources(&req),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "detail-org-id", orgID)
+
+	_, err = mapOrgID("parent-org-id", []string{"request.detail.org.count"}, b.orgIDSources(&req))
+	assert.Error(t, err)
+
+	_, err = mapOrgID("parent-org-id", []string{"request.header.x-org-id"}, b.orgIDSources(&req))
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package lambda
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/tidwall/gjson"
+)
+
+// orgIDSources read the values of org ID fields from a request.
+// Sources an event doesn't have are nil.
+type orgIDSources struct {
+	// header returns the value of the header regardless of case
+	header func(name string) (string, bool)
+
+	// querystring returns the value of the query string parameter
+	querystring func(name string) (string, bool)
+
+	// body returns the value at the path of the JSON body
+	body func(path string) gjson.Result
+
+	// authorizer returns the value at the path of the authorizer context
+	authorizer func(path string) (string, bool)
+}
+
+// mapOrgID maps the first resolving org ID field to org ID
+func mapOrgID(
+	parentOrgID string,
+	orgIDFields []string,
+	sources orgIDSources,
+) (string, error) {
+	if len(orgIDFields) == 0 {
+		// Default org ID to root org ID
+		return parentOrgID, nil
+	}
+
+	var err error
+	for _, orgIDField := range orgIDFields {
+		var orgID string
+		orgID, err = mapOrgIDField(parentOrgID, orgIDField, sources)
+		if err == nil {
+			return orgID, nil
+		}
+	}
+
+	return "", err
+}
+
+// mapOrgIDField maps the configured orgIDField to org ID, read as
+// request.<source>.<name>, e.g. request.header.x-org-id. The body,
+// or the detail of EventBridge events, is read by path, e.g.
+// request.body.org.id. The org ID can be a claim of a JWT in a header,
+// query string or body field, e.g. request.header.authorization.jwt.org_id
+func mapOrgIDField(
+	parentOrgID string,
+	orgIDField string,
+	sources orgIDSources,
+) (string, error) {
+	if orgIDField == "" {
+		return parentOrgID, nil
+	}
+
+	fieldParts := strings.SplitN(orgIDField, ".", 3)
+	if len(fieldParts) < 3 {
+		return "", fmt.Errorf("invalid org ID field %s", orgIDField)
+	}
+
+	source, name := fieldParts[1], fieldParts[2]
+
+	// the authorizer context is read by path, so jwt is part of the path
+	var claim string
+	if source != "authorizer" {
+		if nameParts := strings.SplitN(name, ".jwt.", 2); len(nameParts) == 2 {
+			name, claim = nameParts[0], nameParts[1]
+		}
+	}
+
+	var val string
+	var ok bool
+
+	// the first field part is always "request"
+	switch {
+	case source == "header" && sources.header != nil:
+		val, ok = sources.header(name)
+	case source == "querystring" && sources.querystring != nil:
+		val, ok = sources.querystring(name)
+	case (source == "body" || source == "detail") && sources.body != nil:
+		result := sources.body(name)
+		if result.Type != gjson.String {
+			if result.Exists() {
+				return "", fmt.Errorf("org ID field %s can't be converted to a string", orgIDField)
+			}
+
+			return "", fmt.Errorf("org ID field %s not found", orgIDField)
+		}
+		val, ok = result.String(), true
+	case source == "authorizer" && sources.authorizer != nil:
+		val, ok = sources.authorizer(name)
+	default:
+		return "", fmt.Errorf("invalid org ID field %s", orgIDField)
+	}
+
+	if !ok {
+		return "", fmt.Errorf("org ID field %s not found", orgIDField)
+	}
+
+	if claim != "" {
+		return jwtOrgID(orgIDField, val, claim)
+	}
+
+	return val, nil
+}
+
+// jwtOrgID returns the named claim of the JWT as org ID.
+// The JWT isn't validated as the authorizer already has. Malformed
+// tokens fail rather than attribute the event to the wrong org.
+func jwtOrgID(orgIDField string, token string, claim string) (string, error) {
+	claims, err := collect.DecodeJWTClaims(token)
+	if err != nil {
+		return "", fmt.Errorf("org ID field %s is not a valid jwt: %w", orgIDField, err)
+	}
+
+	val, ok := claims[claim]
+	if !ok {
+		return "", fmt.Errorf("org ID field %s not found", orgIDField)
+	}
+
+	orgID, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("org ID field %s can't be converted to a string", orgIDField)
+	}
+
+	return orgID, nil
+}
+
+// queryParams maps the query string parameters to a normalized map.
+// Only the first value of multi-value parameters is kept.
+func queryParams(
+	params map[string]string,
+	multiValueParams map[string][]string,
+) map[string]string {
+	if len(params) == 0 && len(multiValueParams) == 0 {
+		return nil
+	}
+
+	normalized := make(map[string]string, len(params))
+	for k, v := range params {
+		normalized[k] = v
+	}
+
+	for k, v := range multiValueParams {
+		if len(v) > 0 {
+			normalized[k] = v[0]
+		}
+	}
+
+	return normalized
+}
+
+// lookup returns a lookup of the values of the map
+func lookup(values map[string]string) func(name string) (string, bool) {
+	return func(name string) (string, bool) {
+		val, ok := values[name]
+		return val, ok
+	}
+}
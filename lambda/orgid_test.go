@@ -0,0 +1,84 @@
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapOrgIDField_RejectsMissingSources(t *testing.T) {
+	sources := orgIDSources{
+		header: lookup(map[string]string{"x-org-id": "header-org-id"}),
+	}
+
+	orgID, err := mapOrgIDField("parent-org-id", "request.header.x-org-id", sources)
+	assert.NoError(t, err)
+	assert.Equal(t, "header-org-id", orgID)
+
+	for _, orgIDField := range []string{
+		"request.querystring.org_id",
+		"request.body.org.id",
+		"request.authorizer.org_id",
+		"request.cookie.org_id",
+		"request.header",
+	} {
+		_, err := mapOrgIDField("parent-org-id", orgIDField, sources)
+		assert.EqualError(t, err, "invalid org ID field "+orgIDField)
+	}
+}
+
+func TestMapOrgID_MapsSQSMessageAttributeJWT(t *testing.T) {
+	claims, err := json.Marshal(map[string]interface{}{
+		"org_id": "jwt-org-id",
+	})
+	assert.NoError(t, err)
+
+	token := "eyJhbGciOiJSUzI1NiJ9." +
+		base64.RawURLEncoding.EncodeToString(claims) +
+		".c2lnbmF0dXJl"
+
+	req := events.SQSMessage{
+		MessageAttributes: map[string]events.SQSMessageAttribute{
+			"Token": {
+				StringValue: &token,
+			},
+		},
+		Body: `{"org":{"id":"body-org-id"}}`,
+	}
+
+	b := &SQSEventBuilder{}
+	orgID, err := mapOrgID(
+		"parent-org-id",
+		[]string{"request.header.token.jwt.org_id", "request.body.org.id"},
+		b.orgIDSources(&req),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "jwt-org-id", orgID)
+
+	orgID, err = mapOrgID(
+		"parent-org-id",
+		[]string{"request.header.missing.jwt.org_id", "request.body.org.id"},
+		b.orgIDSources(&req),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "body-org-id", orgID)
+}
+
+func TestQueryParams_KeepsFirstValue(t *testing.T) {
+	assert.Nil(t, queryParams(nil, nil))
+
+	assert.Equal(
+		t,
+		map[string]string{
+			"a": "1",
+			"b": "2",
+		},
+		queryParams(
+			map[string]string{"a": "1"},
+			map[string][]string{"b": {"2", "3"}, "c": {}},
+		),
+	)
+}
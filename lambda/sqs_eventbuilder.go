@@ -0,0 +1,210 @@
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// SQSMethod is the method of the routes of SQS messages. Routes of a
+	// queue are configured as SQS /<queue name>, e.g. SQS /orders.
+	SQSMethod = "SQS"
+
+	// sqsEventSource is the event source of SQS records
+	sqsEventSource = "aws:sqs"
+)
+
+// SQSEventBuilder builds an event from a message of an SQS event
+type SQSEventBuilder struct{}
+
+// Build builds an event from SQS message and the response to its batch
+func (b *SQSEventBuilder) Build(
+	configuration *config.Configuration,
+	routeType collect.RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*collect.EventRaw, error) {
+	req, ok := request.(events.SQSMessage)
+	if !ok {
+		return nil, fmt.Errorf("request is not of type SQSMessage")
+	}
+
+	orgID, err := mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		b.orgIDSources(&req),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := int64(len(req.Body))
+	responseBytes := int64(len(response))
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+	}
+
+	event := &collect.EventRaw{
+		Organization: &collect.EventOrganization{
+			ID: orgID,
+		},
+
+		// Messages have no path, the queue they were sent to is the
+		// closest to one
+		Route: &collect.EventRoute{
+			Type:    routeType,
+			Method:  route.HTTPMethod,
+			Path:    route.Path,
+			Name:    route.Name,
+			RawPath: req.EventSourceARN,
+		},
+
+		User: b.mapUser(configuration, &req),
+
+		Client: &collect.EventClient{
+			Bytes: requestBytes,
+		},
+
+		RequestedAt: b.mapSentAt(&req),
+
+		Request:  req,
+		Response: response,
+		Error:    errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.MessageId,
+	}
+
+	return event, nil
+}
+
+// mapSentAt maps the time the message was sent, in milliseconds since
+// epoch. Falls back to now without the SentTimestamp attribute.
+func (b *SQSEventBuilder) mapSentAt(req *events.SQSMessage) int64 {
+	if sentAt, err := strconv.ParseInt(req.Attributes["SentTimestamp"], 10, 64); err == nil {
+		return sentAt
+	}
+
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// orgIDSources reads org ID fields from the message. Message attributes
+// are read as request.header.<name>, the message body as request.body.<path>.
+func (b *SQSEventBuilder) orgIDSources(req *events.SQSMessage) orgIDSources {
+	return orgIDSources{
+		header: func(name string) (string, bool) {
+			return b.messageAttribute(req, name)
+		},
+		body: func(path string) gjson.Result {
+			return gjson.Get(req.Body, path)
+		},
+	}
+}
+
+// mapUser maps the configured user fields to user. Without a mapped
+// user ID, the message is attributed to the IAM principal that sent it.
+func (b *SQSEventBuilder) mapUser(
+	configuration *config.Configuration,
+	req *events.SQSMessage,
+) *collect.EventUser {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+
+	for _, name := range config.UserFieldNames {
+		field, ok := configuration.UserFields[name]
+		if !ok {
+			continue
+		}
+
+		if val, err := b.mappedValue(req, field); err == nil {
+			setUserField(user, name, val)
+		}
+	}
+
+	if user.ID == "" {
+		if senderID, ok := req.Attributes["SenderId"]; ok && senderID != "" {
+			user.AuthType = collect.AuthTypeIAM
+			user.ID = senderID
+		}
+	}
+
+	return user
+}
+
+// mappedValue extracts the field value from the message.
+// Message attributes are read as request.header.<name>, the message
+// body as request.body.<path>.
+func (b *SQSEventBuilder) mappedValue(
+	req *events.SQSMessage,
+	field string,
+) (string, error) {
+	fieldParts := strings.SplitN(field, ".", 3)
+	if len(fieldParts) < 3 {
+		return "", fmt.Errorf("invalid field %s", field)
+	}
+
+	// the first field part is always "request"
+	switch fieldParts[1] {
+	case "header":
+		if val, ok := b.messageAttribute(req, fieldParts[2]); ok {
+			return val, nil
+		}
+	case "body":
+		result := gjson.Get(req.Body, fieldParts[2])
+		if result.Type == gjson.String {
+			return result.String(), nil
+		}
+
+		if result.Exists() {
+			return "", fmt.Errorf("field %s can't be converted to a string", field)
+		}
+	default:
+		return "", fmt.Errorf("invalid field %s", field)
+	}
+
+	return "", fmt.Errorf("field %s not found", field)
+}
+
+// messageAttribute returns the string value of the message attribute
+// regardless of case
+func (b *SQSEventBuilder) messageAttribute(
+	req *events.SQSMessage,
+	name string,
+) (string, bool) {
+	for k, attr := range req.MessageAttributes {
+		if strings.EqualFold(k, name) && attr.StringValue != nil {
+			return *attr.StringValue, true
+		}
+	}
+
+	return "", false
+}
+
+// sqsQueuePath returns the route path of the queue of the ARN,
+// e.g. /orders of arn:aws:sqs:us-east-1:123456789012:orders
+func sqsQueuePath(arn string) string {
+	return "/" + arn[strings.LastIndex(arn, ":")+1:]
+}
@@ -0,0 +1,130 @@
+package lambda
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/lambda/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSQS(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: SQSMethod,
+		Path:       "/orders",
+		Name:       "order-queued",
+	}
+
+	orgID := "ext-org-id"
+	req := events.SQSMessage{
+		MessageId: "message-id",
+		Body:      `{"user":{"id":"user-id","email":"email"}}`,
+		Attributes: map[string]string{
+			"SenderId":      "AIDAEXAMPLE",
+			"SentTimestamp": "1583348638390",
+		},
+		MessageAttributes: map[string]events.SQSMessageAttribute{
+			"X-Org-Id": {
+				StringValue: &orgID,
+				DataType:    "String",
+			},
+		},
+		EventSourceARN: "arn:aws:sqs:us-east-1:123456789012:orders",
+		EventSource:    "aws:sqs",
+	}
+
+	res := json.RawMessage(`{"batchItemFailures":[]}`)
+
+	b := &SQSEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDField:  "request.header.x-org-id",
+			UserFields: map[string]string{
+				"id":    "request.body.user.id",
+				"email": "request.body.user.email",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, eventRaw)
+
+	assert.Equal(t, "ext-org-id", eventRaw.Organization.ID)
+
+	assert.Equal(t, &collect.EventRoute{
+		Type:    collect.RouteTypeTarget,
+		Method:  SQSMethod,
+		Path:    "/orders",
+		Name:    "order-queued",
+		RawPath: "arn:aws:sqs:us-east-1:123456789012:orders",
+	}, eventRaw.Route)
+
+	assert.Equal(t, &collect.EventUser{
+		ID:       "user-id",
+		Email:    "email",
+		AuthType: collect.AuthTypeNone,
+	}, eventRaw.User)
+
+	assert.Equal(t, int64(len(req.Body)), eventRaw.Client.Bytes)
+	assert.Equal(t, int64(1583348638390), eventRaw.RequestedAt)
+	assert.Equal(t, "message-id", eventRaw.RequestID)
+
+	assert.Equal(t, req, eventRaw.Request)
+	assert.Equal(t, res, eventRaw.Response)
+}
+
+func TestBuildSQS_RejectsOtherRequests(t *testing.T) {
+	b := &SQSEventBuilder{}
+	_, err := b.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		events.APIGatewayProxyRequest{},
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestBuildSQS_AttributesMessageToSender(t *testing.T) {
+	req := events.SQSMessage{
+		Body: `{"org":{"id":1}}`,
+		Attributes: map[string]string{
+			"SenderId": "AIDAEXAMPLE",
+		},
+	}
+
+	b := &SQSEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, &collect.EventUser{
+		ID:       "AIDAEXAMPLE",
+		AuthType: collect.AuthTypeIAM,
+	}, eventRaw.User)
+
+	_, err = b.Build(
+		&config.Configuration{
+			OrgIDField: "request.body.org.id",
+		},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		req,
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
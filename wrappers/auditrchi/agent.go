@@ -0,0 +1,128 @@
+package auditrchi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/wrappers/common"
+	"github.com/go-chi/chi/v5"
+)
+
+// Agent is an auditr agent that collects and reports events
+// Usage:
+//
+//	agent, err := auditrchi.NewAgent()
+type Agent struct {
+	*common.Agent
+}
+
+// AgentOption is an option to override defaults
+type AgentOption = common.AgentOption
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return common.WithEventsURL(eventsURL)
+}
+
+// WithAgentType overrides the agent type of the events, chi by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return common.WithAgentType(agentType)
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return common.WithResponseDecoder(decoder)
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return common.WithSink(sink)
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return common.WithMetrics(metrics)
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return common.WithSampledRouteStore(store)
+}
+
+// NewAgent creates a new agent with default configuration
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a, err := common.NewAgent(
+		collect.AgentTypeChi,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// NewAgentWithConfigurartion creates a new agent with overriden configuration
+func NewAgentWithConfiguration(
+	configuration *config.Configuration,
+	options ...AgentOption,
+) (*Agent, error) {
+	a, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeChi,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// Middleware audits HTTP handlers
+func (a *Agent) Middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.Audit(w, req, handler, routePattern)
+	})
+}
+
+// routePattern returns the route pattern chi matched, e.g. /hi/{id}.
+// chi only populates the route pattern while routing, so it's read once
+// the handler is done. Falls back to the request path if no route is matched.
+func routePattern(req *http.Request) string {
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+
+	return req.URL.Path
+}
+
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrchi.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
+}
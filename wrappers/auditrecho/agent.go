@@ -0,0 +1,142 @@
+package auditrecho
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/wrappers/common"
+	"github.com/labstack/echo/v4"
+)
+
+// Agent is an auditr agent that collects and reports events
+// Usage:
+//
+//	agent, err := auditrecho.NewAgent()
+type Agent struct {
+	*common.Agent
+}
+
+// AgentOption is an option to override defaults
+type AgentOption = common.AgentOption
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return common.WithEventsURL(eventsURL)
+}
+
+// WithAgentType overrides the agent type of the events, echo by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return common.WithAgentType(agentType)
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return common.WithResponseDecoder(decoder)
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return common.WithSink(sink)
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return common.WithMetrics(metrics)
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return common.WithSampledRouteStore(store)
+}
+
+// NewAgent creates a new agent with default configuration
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a, err := common.NewAgent(
+		collect.AgentTypeEcho,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// NewAgentWithConfigurartion creates a new agent with overriden configuration
+func NewAgentWithConfiguration(
+	configuration *config.Configuration,
+	options ...AgentOption,
+) (*Agent, error) {
+	a, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeEcho,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// Middleware audits echo handlers
+// Usage:
+//
+//	e.Use(agent.Middleware)
+func (a *Agent) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// echo's Response guards against writing headers twice once
+		// committed, so the copy writer sits beneath it
+		origWriter := c.Response().Writer
+
+		var handlerErr error
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			c.SetRequest(req)
+			c.Response().Writer = w
+			defer func() {
+				c.Response().Writer = origWriter
+			}()
+
+			if handlerErr = next(c); handlerErr != nil {
+				// Write the error response now to capture it. echo's
+				// error handler skips responses already committed.
+				c.Error(handlerErr)
+			}
+		})
+
+		a.Audit(origWriter, c.Request(), handler, func(*http.Request) string {
+			// the route template, e.g. /hi/:id, is empty for unmatched routes
+			return c.Path()
+		})
+
+		return handlerErr
+	}
+}
+
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrecho.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
+}
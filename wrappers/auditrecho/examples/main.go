@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/auditr-io/auditr-agent-go/wrappers/auditrecho"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// a sample implementation with labstack/echo
+// to run:
+//   AUDITR_CONFIG_URL=https://config.auditr.io AUDITR_API_KEY=prik_xxx go run .
+func main() {
+	a, err := auditrecho.NewAgent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(middleware.Recover(), a.Middleware)
+	e.GET("/hi/:name", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"hi": c.Param("name"),
+		})
+	})
+
+	log.Fatal(e.Start("127.0.0.1:8000"))
+}
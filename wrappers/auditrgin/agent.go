@@ -0,0 +1,158 @@
+package auditrgin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/wrappers/common"
+	"github.com/gin-gonic/gin"
+)
+
+// Agent is an auditr agent that collects and reports events
+// Usage:
+//
+//	agent, err := auditrgin.NewAgent()
+type Agent struct {
+	*common.Agent
+}
+
+// AgentOption is an option to override defaults
+type AgentOption = common.AgentOption
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return common.WithEventsURL(eventsURL)
+}
+
+// WithAgentType overrides the agent type of the events, gin by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return common.WithAgentType(agentType)
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return common.WithResponseDecoder(decoder)
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return common.WithSink(sink)
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return common.WithMetrics(metrics)
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return common.WithSampledRouteStore(store)
+}
+
+// NewAgent creates a new agent with default configuration
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a, err := common.NewAgent(
+		collect.AgentTypeGin,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// NewAgentWithConfigurartion creates a new agent with overriden configuration
+func NewAgentWithConfiguration(
+	configuration *config.Configuration,
+	options ...AgentOption,
+) (*Agent, error) {
+	a, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeGin,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// Middleware audits gin handlers
+// Usage:
+//
+//	router.Use(agent.Middleware())
+func (a *Agent) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origWriter := c.Writer
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			c.Request = req
+			c.Writer = &responseWriter{
+				ResponseWriter: origWriter,
+				copyWriter:     w,
+			}
+			defer func() {
+				c.Writer = origWriter
+			}()
+
+			c.Next()
+		})
+
+		a.Audit(origWriter, c.Request, next, func(*http.Request) string {
+			// the route template, e.g. /hi/:id, is empty for unmatched routes
+			return c.FullPath()
+		})
+	}
+}
+
+// responseWriter copies the response written by gin handlers while
+// keeping the rest of gin's ResponseWriter, e.g. Status and Size
+type responseWriter struct {
+	gin.ResponseWriter
+	copyWriter http.ResponseWriter
+}
+
+// WriteHeader writes the status code to the original and copy
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.copyWriter.WriteHeader(statusCode)
+}
+
+// Write writes to the original and copy
+func (w *responseWriter) Write(p []byte) (int, error) {
+	return w.copyWriter.Write(p)
+}
+
+// WriteString writes the string to the original and copy
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.copyWriter.Write([]byte(s))
+}
+
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrgin.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
+}
@@ -0,0 +1,156 @@
+package auditrgin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewAgent_ReturnsAgent(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"parent_org_id": "org_xxx",
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/person/:id"
+					}
+				],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": true
+			}`), nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+}
+
+func TestMiddleware(t *testing.T) {
+	wantResBodyBytes := []byte(`{
+		"id": 123,
+		"name": "homer"
+	}`)
+	wantResStatusCode := 200
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+			log.Printf("roundtrip %s", req.URL.String())
+
+			reqBody, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+
+			var eventBatch []*collect.EventRaw
+			err = json.Unmarshal(reqBody, &eventBatch)
+			assert.NoError(t, err)
+			event := eventBatch[0]
+			assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+			assert.Equal(t, collect.AgentTypeGin, event.Agent.Type)
+			assert.Equal(t, "/hi/:id", event.Route.Path)
+
+			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
+				{
+					"status": 200
+				}
+			]`)))
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       r,
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil).Once()
+
+	mockClient := func() *http.Client {
+		return &http.Client{
+			Transport: m,
+		}
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"parent_org_id": "org_xxx",
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "POST",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"flush": true,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": true
+			}`), nil
+		}),
+		config.WithHTTPClient(mockClient),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(a.Middleware())
+	router.POST("/hi/:id", func(c *gin.Context) {
+		c.Data(wantResStatusCode, "application/json", wantResBodyBytes)
+	})
+
+	reqBodyBytes := []byte(`{
+		"name": "homer"
+	}`)
+	req, _ := http.NewRequest("POST", "/hi/123", bytes.NewBuffer(reqBodyBytes))
+	rec := httptest.NewRecorder()
+
+	router.ServeHTTP(rec, req)
+
+	result := rec.Result()
+	assert.Equal(t, wantResStatusCode, result.StatusCode)
+
+	resBodyBytes, _ := ioutil.ReadAll(result.Body)
+	assert.Equal(t, wantResBodyBytes, resBodyBytes)
+
+	assert.NoError(t, a.Flush())
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/wrappers/auditrgin"
+	"github.com/gin-gonic/gin"
+)
+
+// a sample implementation with gin-gonic/gin
+// to run:
+//   AUDITR_CONFIG_URL=https://config.auditr.io AUDITR_API_KEY=prik_xxx go run .
+func main() {
+	a, err := auditrgin.NewAgent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery(), a.Middleware())
+	router.GET("/hi/:name", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"hi": c.Param("name"),
+		})
+	})
+
+	srv := &http.Server{
+		Handler:      router,
+		Addr:         "127.0.0.1:8000",
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+	log.Fatal(srv.ListenAndServe())
+}
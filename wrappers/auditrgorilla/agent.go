@@ -1,14 +1,8 @@
 package auditrgorilla
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"io"
-	"io/ioutil"
-	"log"
-	"net"
 	"net/http"
+	"time"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
 	"github.com/auditr-io/auditr-agent-go/config"
@@ -18,137 +12,122 @@ import (
 
 // Agent is an auditr agent that collects and reports events
 // Usage:
-//   agent, err := auditrhttp.NewAgent()
+//
+//	agent, err := auditrgorilla.NewAgent()
 type Agent struct {
-	collector *collect.Collector
-	fetcher   *config.Fetcher
+	*common.Agent
 }
 
-// NewAgent creates a new agent with default configuration
-func NewAgent() (*Agent, error) {
-	f, err := config.NewFetcher(config.FetcherOptions{})
-	if err != nil {
-		return nil, err
-	}
+// AgentOption is an option to override defaults
+type AgentOption = common.AgentOption
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return common.WithEventsURL(eventsURL)
+}
+
+// WithAgentType overrides the agent type of the events, gorilla by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return common.WithAgentType(agentType)
+}
 
-	f.Refresh(context.Background())
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return common.WithResponseDecoder(decoder)
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return common.WithSink(sink)
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return common.WithMetrics(metrics)
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return common.WithSampledRouteStore(store)
+}
 
-	a, err := NewAgentWithConfiguration(nil)
+// NewAgent creates a new agent with default configuration
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a, err := common.NewAgent(
+		collect.AgentTypeGorilla,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		options...,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	a.fetcher = f
-	return a, nil
+	return &Agent{
+		Agent: a,
+	}, nil
 }
 
 // NewAgentWithConfigurartion creates a new agent with overriden configuration
 func NewAgentWithConfiguration(
 	configuration *config.Configuration,
+	options ...AgentOption,
 ) (*Agent, error) {
-	a := &Agent{}
-
-	c, err := collect.NewCollector(
+	a, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeGorilla,
 		[]collect.EventBuilder{
 			&common.HTTPEventBuilder{},
 		},
-		configuration,
+		options...,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	a.collector = c
-	return a, nil
+	return &Agent{
+		Agent: a,
+	}, nil
 }
 
 // Middleware audits HTTP handlers
 func (a *Agent) Middleware(handler http.Handler) http.Handler {
-	wrappedHandler := func(w http.ResponseWriter, req *http.Request) {
-		cw := common.NewCopyWriter(w)
-
-		resource := ""
-		route := mux.CurrentRoute(req)
-		if route != nil {
-			r, err := route.GetPathTemplate()
-			if err != nil {
-				// despite the error, we'll still send what we got
-				log.Printf("resource path not defined")
-			} else {
-				resource = r
-			}
-		}
-
-		reqCopy := common.HTTPRequest{
-			Method:  req.Method,
-			URL:     req.URL,
-			Headers: req.Header.Clone(),
-		}
-
-		if reqCopy.Headers.Get("X-Forwarded-For") == "" {
-			if req.RemoteAddr != "" {
-				if ip, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-					reqCopy.Headers.Set("Remote-Address-Ip", ip)
-					reqCopy.Headers.Set("Remote-Address-Port", port)
-				}
-			}
-		}
-
-		if req.Body != nil {
-			reqBody, err := ioutil.ReadAll(req.Body)
-			if err != nil {
-				// despite the error, we'll still send what we got
-				log.Printf("error reading request body: %v", err)
-			}
-
-			// reset body for actual & copy
-			req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
-			reqCopy.Body = string(reqBody)
-		}
-
-		handler.ServeHTTP(cw, req)
-
-		result := cw.Response()
-
-		bodyBytes, err := io.ReadAll(result.Body)
-		if err != nil && err != io.ErrUnexpectedEOF {
-			// despite the error, we'll still send what we got
-			log.Printf("failed to read body")
-		}
-
-		res := common.HTTPResponse{
-			StatusCode: result.StatusCode,
-			Headers:    result.Header,
-			Body:       string(bodyBytes),
-		}
-
-		resBytes, err := json.Marshal(res)
-		if err != nil {
-			// despite the error, we'll still send what we got
-			log.Printf("failed to marshal response")
-		}
-
-		a.collector.Collect(
-			context.Background(),
-			reqCopy.Method,
-			reqCopy.URL.Path,
-			resource,
-			reqCopy,
-			resBytes,
-			nil,
-		)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.Audit(w, req, handler, pathTemplate)
+	})
+}
+
+// pathTemplate returns the path template of the route gorilla matched,
+// e.g. /hi/{id}. Empty if no route is matched.
+func pathTemplate(req *http.Request) string {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return ""
 	}
 
-	return http.HandlerFunc(wrappedHandler)
-}
+	resource, err := route.GetPathTemplate()
+	if err != nil {
+		// despite the error, we'll still send what we got
+		config.Warnf("resource path not defined")
+		return ""
+	}
 
-// Fetches returns the stream of refreshed configs
-// Config may be nil if refresh failed
-func (a *Agent) Fetches() <-chan []byte {
-	return a.fetcher.Refreshes()
+	return resource
 }
 
-// FetchErrors returns the stream of errors
-func (a *Agent) FetchErrors() <-chan error {
-	return a.fetcher.Errors()
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrgorilla.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
 }
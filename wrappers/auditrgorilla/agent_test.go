@@ -80,6 +80,7 @@ func TestMiddleware(t *testing.T) {
 			assert.NoError(t, err)
 			event := eventBatch[0]
 			assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+			assert.Equal(t, collect.AgentTypeGorilla, event.Agent.Type)
 
 			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
 				{
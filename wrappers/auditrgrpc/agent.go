@@ -0,0 +1,221 @@
+package auditrgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/wrappers/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Agent is an auditr agent that collects and reports events
+// Usage:
+//
+//	agent, err := auditrgrpc.NewAgent()
+type Agent struct {
+	*common.Agent
+}
+
+// AgentOption is an option to override defaults
+type AgentOption = common.AgentOption
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return common.WithEventsURL(eventsURL)
+}
+
+// WithAgentType overrides the agent type of the events, grpc by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return common.WithAgentType(agentType)
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return common.WithResponseDecoder(decoder)
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return common.WithSink(sink)
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return common.WithMetrics(metrics)
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return common.WithSampledRouteStore(store)
+}
+
+// NewAgent creates a new agent with default configuration
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a, err := common.NewAgent(
+		collect.AgentTypeGRPC,
+		[]collect.EventBuilder{
+			&GRPCEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// NewAgentWithConfigurartion creates a new agent with overriden configuration
+func NewAgentWithConfiguration(
+	configuration *config.Configuration,
+	options ...AgentOption,
+) (*Agent, error) {
+	a, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeGRPC,
+		[]collect.EventBuilder{
+			&GRPCEventBuilder{},
+		},
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Agent{
+		Agent: a,
+	}, nil
+}
+
+// UnaryServerInterceptor audits unary gRPC calls. Calls are routed as
+// POST requests to their full method, e.g. /pkg.Service/Method.
+// Usage:
+//
+//	grpc.NewServer(grpc.UnaryInterceptor(agent.UnaryServerInterceptor()))
+func (a *Agent) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		headers := newMetadataHeaders(md)
+
+		reqCopy := GRPCRequest{
+			Method:   info.FullMethod,
+			Metadata: headers,
+			ID:       common.RequestID(&http.Request{Header: headers}),
+		}
+
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			reqCopy.Peer = p.Addr.String()
+		}
+
+		reqCopy.Body, err = marshalMessage(req)
+		if err != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("failed to marshal request: %v", err)
+		}
+
+		a.Collector().CollectReceived(
+			ctx,
+			http.MethodPost,
+			info.FullMethod,
+			reqCopy,
+		)
+
+		var recovered *common.PanicError
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					recovered = &common.PanicError{
+						Value: v,
+						Stack: string(debug.Stack()),
+					}
+				}
+			}()
+
+			resp, err = handler(ctx, req)
+		}()
+
+		st := status.Convert(err)
+		res := GRPCResponse{
+			Code:    st.Code().String(),
+			Message: st.Message(),
+		}
+
+		if recovered != nil {
+			// gRPC servers don't recover panics, but interceptors may
+			// and respond with Internal
+			res.Code = "Internal"
+		}
+
+		var marshalErr error
+		res.Body, marshalErr = marshalMessage(resp)
+		if marshalErr != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("failed to marshal response: %v", marshalErr)
+		}
+
+		resBytes, marshalErr := json.Marshal(res)
+		if marshalErr != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("failed to marshal response")
+		}
+
+		var errorValue json.RawMessage
+		if recovered != nil {
+			errorValue, marshalErr = json.Marshal(recovered)
+		} else if err != nil {
+			errorValue, marshalErr = json.Marshal(res)
+		}
+		if marshalErr != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("failed to marshal error")
+		}
+
+		a.Collector().Collect(
+			ctx,
+			http.MethodPost,
+			info.FullMethod,
+			info.FullMethod,
+			reqCopy,
+			resBytes,
+			errorValue,
+		)
+
+		if recovered != nil {
+			// Let the server handle the panic as it would have
+			panic(recovered.Value)
+		}
+
+		return resp, err
+	}
+}
+
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrgrpc.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
+}
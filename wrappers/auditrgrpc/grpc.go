@@ -0,0 +1,80 @@
+package auditrgrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// GRPCRequest encapsulates gRPC request
+type GRPCRequest struct {
+	// Method is the full method of the call, e.g. /pkg.Service/Method
+	Method string `json:"method"`
+
+	// Metadata is the incoming metadata of the call, keyed like HTTP
+	// headers so they're mapped the same way
+	Metadata http.Header `json:"metadata"`
+
+	// Body is the request message serialized to JSON
+	Body string `json:"body"`
+
+	// Peer is the address of the client
+	Peer string `json:"peer,omitempty"`
+
+	// ID correlates the events of each phase of the call
+	ID string `json:"id,omitempty"`
+}
+
+// GRPCResponse encapsulates gRPC response
+type GRPCResponse struct {
+	// Code is the status code of the call, e.g. OK or NotFound
+	Code string `json:"code"`
+
+	// Message is the message of the status of a failed call
+	Message string `json:"message,omitempty"`
+
+	// Body is the response message serialized to JSON
+	Body string `json:"body"`
+}
+
+// newMetadataHeaders returns the metadata as headers.
+// Binary metadata is skipped.
+func newMetadataHeaders(md metadata.MD) http.Header {
+	headers := make(http.Header, len(md))
+	for k, vals := range md {
+		if isBinaryMetadata(k) {
+			continue
+		}
+
+		for _, v := range vals {
+			headers.Add(k, v)
+		}
+	}
+
+	return headers
+}
+
+// isBinaryMetadata returns true if the key is of binary metadata
+func isBinaryMetadata(key string) bool {
+	return strings.HasSuffix(key, "-bin")
+}
+
+// marshalMessage serializes the message to JSON.
+// Proto messages are serialized with their JSON mapping.
+func marshalMessage(msg interface{}) (string, error) {
+	if msg == nil {
+		return "", nil
+	}
+
+	if m, ok := msg.(proto.Message); ok {
+		b, err := protojson.Marshal(m)
+		return string(b), err
+	}
+
+	b, err := json.Marshal(msg)
+	return string(b), err
+}
@@ -0,0 +1,223 @@
+package auditrgrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/tidwall/gjson"
+)
+
+// GRPCEventBuilder maps gRPC requests to events
+type GRPCEventBuilder struct{}
+
+// Build builds an event from gRPC request and response
+func (b *GRPCEventBuilder) Build(
+	configuration *config.Configuration,
+	routeType collect.RouteType,
+	route *config.Route,
+	request interface{},
+	response json.RawMessage,
+	errorValue json.RawMessage,
+) (*collect.EventRaw, error) {
+	req, ok := request.(GRPCRequest)
+	if !ok {
+		return nil, fmt.Errorf("request is not of type GRPCRequest")
+	}
+
+	orgID, err := b.mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		req,
+	)
+	if err != nil {
+		// failed to map to an org ID
+		// safer to raise error and lose the event than to
+		// store the event under the wrong org ID
+		return nil, err
+	}
+
+	// Sizes are of the original messages, before they are altered
+	requestBytes := int64(len(req.Body))
+	responseBytes := int64(len(gjson.GetBytes(response, "body").String()))
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+		response = collect.TruncateJSONField(response, "body", configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+		response = collect.MinifyJSONField(response, "body")
+	}
+
+	var clientIP string
+	if ip, ok := b.ClientIP(req); ok {
+		clientIP = ip.String()
+	}
+
+	event := &collect.EventRaw{
+		Organization: &collect.EventOrganization{
+			ID: orgID,
+		},
+
+		Route: &collect.EventRoute{
+			Type:    routeType,
+			Method:  route.HTTPMethod,
+			Path:    route.Path,
+			Name:    route.Name,
+			RawPath: req.Method,
+		},
+
+		Host: req.Metadata.Get(":authority"),
+
+		User: b.mapUser(configuration, req),
+
+		Client: &collect.EventClient{
+			IP:    clientIP,
+			Bytes: requestBytes,
+		},
+
+		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
+
+		Request:  req,
+		Response: response,
+		Error:    errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.ID,
+	}
+
+	return event, nil
+}
+
+// ClientIP resolves the client IP of the request from the first
+// x-forwarded-for address, falling back to the peer address
+func (b *GRPCEventBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	req, ok := request.(GRPCRequest)
+	if !ok {
+		return nil, false
+	}
+
+	addr := strings.TrimSpace(strings.Split(req.Metadata.Get("X-Forwarded-For"), ",")[0])
+	if addr == "" {
+		addr = req.Peer
+		if host, _, err := net.SplitHostPort(req.Peer); err == nil {
+			addr = host
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	return ip, ip != nil
+}
+
+// mapOrgID maps the first resolving org ID field to org ID
+func (b *GRPCEventBuilder) mapOrgID(
+	parentOrgID string,
+	orgIDFields []string,
+	req GRPCRequest,
+) (string, error) {
+	if len(orgIDFields) == 0 {
+		// orgIDField not configured, default org ID to root org ID
+		return parentOrgID, nil
+	}
+
+	var err error
+	for _, orgIDField := range orgIDFields {
+		var orgID string
+		orgID, err = getMappedValue(req, orgIDField)
+		if err == nil {
+			return orgID, nil
+		}
+	}
+
+	return "", err
+}
+
+// mapUser maps user related fields to user
+func (b *GRPCEventBuilder) mapUser(
+	configuration *config.Configuration,
+	req GRPCRequest,
+) *collect.EventUser {
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+
+	fields := []struct {
+		field string
+		value *string
+	}{
+		{userField(configuration, "id", "request.header.x-user-id"), &user.ID},
+		{userField(configuration, "email", "request.body.email"), &user.Email},
+		{userField(configuration, "name", ""), &user.Name},
+		{userField(configuration, "full_name", ""), &user.FullName},
+		{userField(configuration, "domain", ""), &user.Domain},
+	}
+
+	for _, f := range fields {
+		if value, err := getMappedValue(req, f.field); err == nil {
+			*f.value = value
+		}
+	}
+
+	if authorization := req.Metadata.Get("Authorization"); authorization != "" {
+		// Roles and scopes are only available from a bearer token
+		if claims, err := collect.DecodeJWTClaims(authorization); err == nil {
+			user.AuthType = collect.AuthTypeJWT
+			user.Roles = collect.ClaimValues(claims, configuration.RoleClaims)
+			user.Scopes = collect.ClaimValues(claims, configuration.ScopeClaims)
+		}
+	}
+
+	return user
+}
+
+// userField returns the configured request field of the user field,
+// falling back to the default
+func userField(configuration *config.Configuration, name string, defaultField string) string {
+	if field, ok := configuration.UserFields[name]; ok {
+		return field
+	}
+
+	return defaultField
+}
+
+// getMappedValue extracts the field value from a GRPCRequest.
+// Metadata is read as request.header.<key>, the request message as
+// request.body.<path>.
+func getMappedValue(req GRPCRequest, fieldName string) (string, error) {
+	fieldParts := strings.SplitN(fieldName, ".", 3)
+	if len(fieldParts) < 3 {
+		return "", fmt.Errorf("invalid field %s", fieldName)
+	}
+
+	// the first field part is always "request"
+	switch fieldParts[1] {
+	case "header":
+		val := req.Metadata.Get(fieldParts[2])
+		if val == "" {
+			return "", fmt.Errorf("field %s not found", fieldName)
+		}
+
+		return val, nil
+	case "body":
+		result := gjson.Get(req.Body, fieldParts[2])
+		if !result.Exists() {
+			return "", fmt.Errorf("field %s not found", fieldName)
+		}
+
+		return result.String(), nil
+	}
+
+	return "", fmt.Errorf("invalid field %s", fieldName)
+}
@@ -0,0 +1,86 @@
+package auditrgrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestBuild(t *testing.T) {
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/pkg.Service/Method",
+	}
+
+	req := GRPCRequest{
+		Method: "/pkg.Service/Method",
+		Metadata: newMetadataHeaders(metadata.Pairs(
+			":authority", "api.example.com",
+			"x-org-id", "ext-org-id",
+			"x-user-id", "user-id",
+			"trace-bin", "binary",
+		)),
+		Body: `{"email":"homer@example.com"}`,
+		Peer: "1.2.3.4:5678",
+		ID:   "request-id",
+	}
+
+	res := json.RawMessage(`{"code":"OK","body":"{\"id\":123}"}`)
+
+	b := &GRPCEventBuilder{}
+	eventRaw, err := b.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDField:  "request.header.x-org-id",
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ext-org-id", eventRaw.Organization.ID)
+	assert.Equal(t, &collect.EventRoute{
+		Type:    collect.RouteTypeTarget,
+		Method:  http.MethodPost,
+		Path:    "/pkg.Service/Method",
+		RawPath: "/pkg.Service/Method",
+	}, eventRaw.Route)
+	assert.Equal(t, "api.example.com", eventRaw.Host)
+	assert.Equal(t, &collect.EventUser{
+		ID:       "user-id",
+		Email:    "homer@example.com",
+		AuthType: collect.AuthTypeNone,
+	}, eventRaw.User)
+	assert.Equal(t, &collect.EventClient{
+		IP:    "1.2.3.4",
+		Bytes: int64(len(req.Body)),
+	}, eventRaw.Client)
+	assert.Equal(t, int64(len(`{"id":123}`)), eventRaw.ResponseBytes)
+	assert.Equal(t, "request-id", eventRaw.RequestID)
+
+	// binary metadata isn't mapped
+	assert.Empty(t, req.Metadata.Get("trace-bin"))
+}
+
+func TestBuild_RejectsUnmappedOrgID(t *testing.T) {
+	b := &GRPCEventBuilder{}
+	_, err := b.Build(
+		&config.Configuration{
+			OrgIDField: "request.header.x-org-id",
+		},
+		collect.RouteTypeTarget,
+		&config.Route{},
+		GRPCRequest{Metadata: http.Header{}},
+		nil,
+		nil,
+	)
+	assert.Error(t, err)
+}
@@ -1,13 +1,9 @@
 package auditrhttp
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"io"
-	"io/ioutil"
-	"log"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
 	"github.com/auditr-io/auditr-agent-go/config"
@@ -16,103 +12,175 @@ import (
 
 // Agent is an auditr agent that collects and reports events
 // Usage:
-//   agent, err := auditrhttp.NewAgent()
+//
+//	agent, err := auditrhttp.NewAgent()
 type Agent struct {
-	collector *collect.Collector
+	*common.Agent
+
+	extractResource func(req *http.Request) string
+
+	// agentOptions are the options of the shared agent
+	agentOptions []common.AgentOption
+}
+
+// AgentOption is an option to override defaults
+type AgentOption func(a *Agent) error
+
+// WithResourceExtractor overrides how the matched resource template
+// (e.g. /person/{id}) is extracted from the request. Use this to
+// support routers other than http.ServeMux.
+func WithResourceExtractor(extract func(req *http.Request) string) AgentOption {
+	return func(a *Agent) error {
+		if extract == nil {
+			return errors.New("resource extractor must not be nil")
+		}
+
+		a.extractResource = extract
+		return nil
+	}
+}
+
+// WithEventsURL sends events to the given endpoint instead of the one
+// of the fetched configuration. See common.WithEventsURL.
+func WithEventsURL(eventsURL string) AgentOption {
+	return withAgentOption(common.WithEventsURL(eventsURL))
+}
+
+// WithAgentType overrides the agent type of the events, net-http by default.
+// See common.WithAgentType.
+func WithAgentType(agentType string) AgentOption {
+	return withAgentOption(common.WithAgentType(agentType))
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder. See common.WithResponseDecoder.
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return withAgentOption(common.WithResponseDecoder(decoder))
+}
+
+// WithSink also writes every batch of events to the sink.
+// See common.WithSink.
+func WithSink(sink collect.Sink) AgentOption {
+	return withAgentOption(common.WithSink(sink))
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics. See common.WithMetrics.
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return withAgentOption(common.WithMetrics(metrics))
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store. See common.WithSampledRouteStore.
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return withAgentOption(common.WithSampledRouteStore(store))
+}
+
+// withAgentOption applies the option of the shared agent once it's created
+func withAgentOption(option common.AgentOption) AgentOption {
+	return func(a *Agent) error {
+		a.agentOptions = append(a.agentOptions, option)
+		return nil
+	}
 }
 
 // NewAgent creates a new agent with default configuration
-func NewAgent() (*Agent, error) {
-	return NewAgentWithConfiguration(nil)
+func NewAgent(options ...AgentOption) (*Agent, error) {
+	a := &Agent{}
+	if err := a.applyOptions(options); err != nil {
+		return nil, err
+	}
+
+	agent, err := common.NewAgent(
+		collect.AgentTypeHTTP,
+		[]collect.EventBuilder{
+			&common.HTTPEventBuilder{},
+		},
+		a.agentOptions...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Agent = agent
+	return a, nil
 }
 
 // NewAgentWithConfigurartion creates a new agent with overriden configuration
 func NewAgentWithConfiguration(
 	configuration *config.Configuration,
+	options ...AgentOption,
 ) (*Agent, error) {
 	a := &Agent{}
+	if err := a.applyOptions(options); err != nil {
+		return nil, err
+	}
 
-	c, err := collect.NewCollector(
+	agent, err := common.NewAgentWithConfiguration(
+		configuration,
+		collect.AgentTypeHTTP,
 		[]collect.EventBuilder{
 			&common.HTTPEventBuilder{},
 		},
-		configuration,
+		a.agentOptions...,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	a.collector = c
+	a.Agent = agent
 	return a, nil
 }
 
-// WrapHandler wraps an HTTP Handler (e.g. http.ServeMux) to enable auditing
-func (a *Agent) WrapHandler(handler http.Handler) http.Handler {
-	wrappedHandler := func(w http.ResponseWriter, req *http.Request) {
-		cw := common.NewCopyWriter(w)
-
-		reqCopy := common.HTTPRequest{
-			Method:  req.Method,
-			URL:     req.URL,
-			Headers: req.Header,
-		}
-
-		if req.Body != nil {
-			reqBody, err := ioutil.ReadAll(req.Body)
-			if err != nil {
-				// despite the error, we'll still send what we got
-				log.Printf("error reading request body: %v", err)
-			}
-
-			// reset body for actual & copy
-			req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
-			reqCopy.Body = string(reqBody)
-		}
-
-		handler.ServeHTTP(cw, req)
-
-		resource := ""
-		mux, ok := handler.(*http.ServeMux)
-		if ok {
-			// http.ServeMux only matches on exact paths
-			// we can match on parameterized paths and will still
-			// achieve the results of filtering/aggregating events
-			// by the same matching parameterized path
-			_, rsrc := mux.Handler(req)
-			resource = rsrc
+// applyOptions applies the options to the agent
+func (a *Agent) applyOptions(options []AgentOption) error {
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return err
 		}
+	}
 
-		result := cw.Response()
+	return nil
+}
 
-		bodyBytes := make([]byte, 100000)
-		_, err := io.ReadFull(result.Body, bodyBytes)
-		if err != nil && err != io.ErrUnexpectedEOF {
-			// despite the error, we'll still send what we got
-			log.Printf("failed to read body")
-		}
+// WrapHandler wraps an HTTP Handler (e.g. http.ServeMux) to enable auditing
+func (a *Agent) WrapHandler(handler http.Handler) http.Handler {
+	resource := func(req *http.Request) string {
+		return a.resource(handler, req)
+	}
 
-		res := common.HTTPResponse{
-			StatusCode: result.StatusCode,
-			Headers:    result.Header,
-			Body:       string(bodyBytes),
-		}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.Audit(w, req, handler, resource)
+	})
+}
 
-		resBytes, err := json.Marshal(res)
-		if err != nil {
-			// despite the error, we'll still send what we got
-			log.Printf("failed to marshal response")
-		}
+// resource extracts the matched resource template from the request.
+// Falls back to the request path if no template is matched.
+func (a *Agent) resource(handler http.Handler, req *http.Request) string {
+	resource := ""
+	if a.extractResource != nil {
+		resource = a.extractResource(req)
+	} else if mux, ok := handler.(*http.ServeMux); ok {
+		// http.ServeMux only matches on exact paths
+		// we can match on parameterized paths and will still
+		// achieve the results of filtering/aggregating events
+		// by the same matching parameterized path
+		_, resource = mux.Handler(req)
+	}
 
-		a.collector.Collect(
-			context.Background(),
-			reqCopy.Method,
-			reqCopy.URL.Path,
-			resource,
-			reqCopy,
-			resBytes,
-			nil,
-		)
+	if resource == "" {
+		resource = req.URL.Path
 	}
 
-	return http.HandlerFunc(wrappedHandler)
+	return resource
+}
+
+// HandleShutdown closes the agent on SIGTERM or SIGINT, waiting up to
+// the timeout for pending events to be sent before the process exits.
+// Returns a function that removes the handler. A no-op in Lambda.
+// Usage:
+//
+//	defer auditrhttp.HandleShutdown(agent, 5*time.Second)()
+func HandleShutdown(agent *Agent, timeout time.Duration) (stop func()) {
+	return common.HandleShutdown(agent.Close, timeout)
 }
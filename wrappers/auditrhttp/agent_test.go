@@ -8,11 +8,13 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
 	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/auditr-io/auditr-agent-go/wrappers/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -93,6 +95,7 @@ func TestWrapHandler(t *testing.T) {
 			assert.NoError(t, err)
 			event := eventBatch[0]
 			assert.Equal(t, collect.RouteTypeTarget, event.Route.Type)
+			assert.Equal(t, collect.AgentTypeHTTP, event.Agent.Type)
 
 			r := ioutil.NopCloser(bytes.NewBuffer([]byte(`[
 				{
@@ -156,3 +159,478 @@ func TestWrapHandler(t *testing.T) {
 	actualBody, _ := ioutil.ReadAll(actual.Body)
 	assert.Equal(t, expectedBodyBuf, actualBody)
 }
+
+func TestWrapHandler_IgnoresPreflight(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer([]byte(`[]`))),
+			}, nil
+		},
+	}
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "OPTIONS",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"flush": true,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 20,
+				"block_on_send": false,
+				"block_on_response": false
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	handled := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/", func(w http.ResponseWriter, _ *http.Request) {
+		handled = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r, _ := http.NewRequest(http.MethodOptions, "/hi/123", nil)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+
+	a.WrapHandler(mux).ServeHTTP(w, r)
+
+	assert.True(t, handled)
+	assert.Equal(t, http.StatusNoContent, w.Result().StatusCode)
+	m.AssertNotCalled(t, "RoundTrip", mock.Anything)
+}
+
+func TestResource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/", func(w http.ResponseWriter, _ *http.Request) {})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+
+	r, _ := http.NewRequest(http.MethodGet, "/hi/123", nil)
+
+	a := &Agent{}
+	assert.Equal(t, "/hi/", a.resource(mux, r))
+	// falls back to the path
+	assert.Equal(t, "/hi/123", a.resource(handler, r))
+
+	err := WithResourceExtractor(func(req *http.Request) string {
+		return "/hi/{id}"
+	})(a)
+	assert.NoError(t, err)
+	assert.Equal(t, "/hi/{id}", a.resource(mux, r))
+	assert.Equal(t, "/hi/{id}", a.resource(handler, r))
+
+	assert.Error(t, WithResourceExtractor(nil)(a))
+}
+
+func TestNewAgent_WithEventsURL(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithEventsURL("http://localhost:8080/events"),
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+	assert.Equal(t, "https://dev-api.auditr.io/v1/events", configurer.Configuration.EventsURL)
+
+	_, err = NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithEventsURL("/events"),
+	)
+	assert.Error(t, err)
+}
+
+func TestNewAgent_WithAgentType(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithAgentType("chi"),
+	)
+	assert.NoError(t, err)
+	assert.NotNil(t, a)
+
+	_, err = NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithAgentType(""),
+	)
+	assert.Error(t, err)
+}
+
+func TestWrapHandler_AuditsPanic(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/hi/123", nil)
+	w := httptest.NewRecorder()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/", func(w http.ResponseWriter, _ *http.Request) {
+		panic("oh no")
+	})
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			reqBody, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+
+			var eventBatch []struct {
+				Response common.HTTPResponse `json:"response"`
+				Error    map[string]string   `json:"error"`
+			}
+			err = json.Unmarshal(reqBody, &eventBatch)
+			assert.NoError(t, err)
+			event := eventBatch[0]
+			assert.Equal(t, http.StatusInternalServerError, event.Response.StatusCode)
+			assert.Equal(t, "panic: oh no", event.Error["message"])
+			assert.NotEmpty(t, event.Error["stack"])
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[]`)),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil).Once()
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"flush": true,
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	// The panic is raised again for the server to handle
+	assert.PanicsWithValue(t, "oh no", func() {
+		a.WrapHandler(mux).ServeHTTP(w, r)
+	})
+
+	assert.True(t, m.AssertExpectations(t))
+}
+
+func TestClose_SendsPendingEvents(t *testing.T) {
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil).Once()
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 10,
+				"pending_work_capacity": 20,
+				"send_interval": 60000,
+				"block_on_send": false,
+				"block_on_response": false
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := a.WrapHandler(mux)
+
+	r, _ := http.NewRequest(http.MethodGet, "/hi/123", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// The event is pending until the agent is closed
+	m.AssertNotCalled(t, "RoundTrip", mock.AnythingOfType("*http.Request"))
+	assert.NoError(t, a.Close(context.Background()))
+	m.AssertExpectations(t)
+
+	// Requests are still served once closed, but not audited
+	w := httptest.NewRecorder()
+	r, _ = http.NewRequest(http.MethodGet, "/hi/456", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.AssertNumberOfCalls(t, "RoundTrip", 1)
+}
+
+func TestWrapHandler_CapturesExactResponseBody(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+
+	m := &test.MockTransport{
+		Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+			m.MethodCalled("RoundTrip", req)
+
+			reqBody, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+
+			var eventBatch []*collect.EventRaw
+			err = json.Unmarshal(reqBody, &eventBatch)
+			assert.NoError(t, err)
+
+			lock.Lock()
+			for _, event := range eventBatch {
+				res, ok := event.Response.(map[string]interface{})
+				assert.True(t, ok)
+				bodies = append(bodies, res["body"].(string))
+			}
+			lock.Unlock()
+
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+			}, nil
+		},
+	}
+
+	m.
+		On("RoundTrip", mock.AnythingOfType("*http.Request")).
+		Return(mock.AnythingOfType("*http.Response"), nil)
+
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"flush": false,
+				"cache_duration": 2,
+				"max_events_per_batch": 10,
+				"max_concurrent_batches": 1,
+				"pending_work_capacity": 20,
+				"send_interval": 60000,
+				"block_on_send": false,
+				"block_on_response": false,
+				"response_capture_limit": 8
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: m,
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(configurer.Configuration)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/short", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/hi/long", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("hello, "))
+		w.Write([]byte("world"))
+	})
+	handler := a.WrapHandler(mux)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/hi/short", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "hello", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest(http.MethodGet, "/hi/long", nil)
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "hello, world", w.Body.String())
+
+	assert.NoError(t, a.Close(context.Background()))
+
+	// Short bodies aren't padded and long bodies are cut at the limit,
+	// while the client still receives the entire body
+	lock.Lock()
+	defer lock.Unlock()
+	assert.ElementsMatch(t, []string{"hello", "hello, w"}, bodies)
+}
+
+// recordingSink records the events written to it
+type recordingSink struct {
+	lock   sync.Mutex
+	events []*collect.EventRaw
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Write(ctx context.Context, events []*collect.EventRaw) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestWrapHandler_CopiesRequestHeaders(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [
+					{
+						"method": "GET",
+						"path": "/hi/:id"
+					}
+				],
+				"sample": [],
+				"cache_duration": 2
+			}`), nil
+		}),
+		config.WithHTTPClient(func() *http.Client {
+			return &http.Client{
+				Transport: &test.MockTransport{
+					Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+						return &http.Response{
+							StatusCode: 200,
+							Body:       ioutil.NopCloser(bytes.NewBufferString(`[{"status": 200}]`)),
+						}, nil
+					},
+				},
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	sink := &recordingSink{}
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		WithSink(sink),
+	)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hi/", func(w http.ResponseWriter, req *http.Request) {
+		req.Header.Set("X-Handler", "edited")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r, _ := http.NewRequest(http.MethodGet, "/hi/123", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	a.WrapHandler(mux).ServeHTTP(httptest.NewRecorder(), r)
+	assert.NoError(t, a.Close(context.Background()))
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	assert.Len(t, sink.events, 1)
+	req, ok := sink.events[0].Request.(common.HTTPRequest)
+	assert.True(t, ok)
+	assert.Empty(t, req.Headers.Get("X-Handler"))
+	assert.Equal(t, "10.0.0.1", req.Headers.Get("Remote-Address-Ip"))
+	assert.Equal(t, "4321", req.Headers.Get("Remote-Address-Port"))
+}
@@ -0,0 +1,368 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// Agent is the auditr agent the wrappers are built on. It collects and
+// reports events, leaving the integration with a router to the wrapper.
+type Agent struct {
+	collector   *collect.Collector
+	fetcher     *config.Fetcher
+	stopFetcher context.CancelFunc
+
+	collectorOptions []collect.CollectorOption
+}
+
+// AgentOption is an option to override defaults
+type AgentOption func(a *Agent) error
+
+// WithEventsURL sends events to the given endpoint, e.g. a local mock
+// server for e2e tests, instead of the one derived from the fetched
+// configuration's base_url and events_path. Configuration refreshes
+// don't override it.
+func WithEventsURL(eventsURL string) AgentOption {
+	return func(a *Agent) error {
+		u, err := url.Parse(eventsURL)
+		if err != nil {
+			return err
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("events URL must be absolute: %s", eventsURL)
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithEventsURL(eventsURL))
+		return nil
+	}
+}
+
+// WithAgentType overrides the agent type of the events, the integration
+// of the wrapper by default, to attribute them to a custom integration
+func WithAgentType(agentType string) AgentOption {
+	return func(a *Agent) error {
+		if agentType == "" {
+			return errors.New("agent type must not be empty")
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithAgentType(agentType))
+		return nil
+	}
+}
+
+// WithResponseDecoder decodes the events endpoint's batch responses with
+// the decoder, e.g. for a self-hosted backend with its own response envelope
+func WithResponseDecoder(decoder collect.ResponseDecoder) AgentOption {
+	return func(a *Agent) error {
+		if decoder == nil {
+			return errors.New("response decoder must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithResponseDecoder(decoder)),
+		)
+		return nil
+	}
+}
+
+// WithSink also writes every batch of events to the sink, e.g. a file
+// sink for durability alongside the events API for delivery
+func WithSink(sink collect.Sink) AgentOption {
+	return func(a *Agent) error {
+		if sink == nil {
+			return errors.New("sink must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithSinks(sink)),
+		)
+		return nil
+	}
+}
+
+// WithMetrics reports the counters of queued, dropped and sent events
+// and batches to the metrics, e.g. to export them to a metrics backend
+func WithMetrics(metrics collect.Metrics) AgentOption {
+	return func(a *Agent) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		a.collectorOptions = append(
+			a.collectorOptions,
+			collect.WithPublisherOptions(collect.WithMetrics(metrics)),
+		)
+		return nil
+	}
+}
+
+// WithSampledRouteStore shares the sampled routes across instances
+// through the store, reducing duplicate samples after cold starts
+func WithSampledRouteStore(store collect.SampledRouteStore) AgentOption {
+	return func(a *Agent) error {
+		if store == nil {
+			return errors.New("sampled route store must not be nil")
+		}
+
+		a.collectorOptions = append(a.collectorOptions, collect.WithSampledRouteStore(store))
+		return nil
+	}
+}
+
+// NewAgent creates a new agent with default configuration, building
+// events of the agent type with the builders
+func NewAgent(
+	agentType string,
+	builders []collect.EventBuilder,
+	options ...AgentOption,
+) (*Agent, error) {
+	f, err := config.NewFetcher(config.FetcherOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.Refresh(ctx)
+
+	a, err := NewAgentWithConfiguration(nil, agentType, builders, options...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	a.fetcher = f
+	a.stopFetcher = cancel
+	return a, nil
+}
+
+// NewAgentWithConfiguration creates a new agent with overriden
+// configuration, building events of the agent type with the builders
+func NewAgentWithConfiguration(
+	configuration *config.Configuration,
+	agentType string,
+	builders []collect.EventBuilder,
+	options ...AgentOption,
+) (*Agent, error) {
+	a := &Agent{
+		collectorOptions: []collect.CollectorOption{
+			collect.WithAgentType(agentType),
+		},
+	}
+
+	for _, option := range options {
+		if err := option(a); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := collect.NewCollector(
+		builders,
+		configuration,
+		a.collectorOptions...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a.collector = c
+
+	return a, nil
+}
+
+// Collector returns the collector of the agent's events
+func (a *Agent) Collector() *collect.Collector {
+	return a.collector
+}
+
+// Audit serves the request with the handler and audits the request
+// and response. resource returns the route template the request matched,
+// e.g. /hi/{id}. It's called once the handler is done, since some routers
+// only match the route while serving. A panic of the handler is audited,
+// then raised again so the server's own recovery still runs.
+func (a *Agent) Audit(
+	w http.ResponseWriter,
+	req *http.Request,
+	handler http.Handler,
+	resource func(req *http.Request) string,
+) {
+	if a.collector.Configuration().IgnorePreflight && IsPreflight(req) {
+		handler.ServeHTTP(w, req)
+		return
+	}
+
+	cw := NewLimitedCopyWriter(
+		w,
+		ResponseCaptureLimit(a.collector.Configuration()),
+	)
+
+	reqCopy := HTTPRequest{
+		Method:  req.Method,
+		URL:     req.URL,
+		Host:    req.Host,
+		Headers: req.Header.Clone(),
+		TLS:     NewTLSInfo(req.TLS),
+		ID:      RequestID(req),
+	}
+
+	if reqCopy.Headers.Get("X-Forwarded-For") == "" {
+		if req.RemoteAddr != "" {
+			if ip, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				reqCopy.Headers.Set("Remote-Address-Ip", ip)
+				reqCopy.Headers.Set("Remote-Address-Port", port)
+			}
+		}
+	}
+
+	if req.Body != nil {
+		reqBody, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("error reading request body: %v", err)
+		}
+
+		// reset body for actual & copy
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+		reqCopy.Body = string(reqBody)
+	}
+
+	a.collector.CollectReceived(
+		req.Context(),
+		reqCopy.Method,
+		reqCopy.URL.Path,
+		reqCopy,
+	)
+
+	// Let the handler set a typed response object to record
+	// instead of the serialized bytes
+	served := CaptureResponseObject(req)
+	recovered := ServeRecovering(handler, cw, served)
+
+	result := cw.Response()
+
+	bodyBytes, err := io.ReadAll(result.Body)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		// despite the error, we'll still send what we got
+		config.Warnf("failed to read body")
+	}
+
+	res := HTTPResponse{
+		StatusCode: result.StatusCode,
+		Headers:    result.Header,
+		Body:       string(bodyBytes),
+		Bytes:      cw.Written(),
+		Object:     ResponseObject(served.Context()),
+	}
+
+	resBytes, err := json.Marshal(res)
+	if err != nil {
+		// despite the error, we'll still send what we got
+		config.Warnf("failed to marshal response")
+	}
+
+	var errorValue json.RawMessage
+	if recovered != nil {
+		errorValue, err = json.Marshal(recovered)
+		if err != nil {
+			// despite the error, we'll still send what we got
+			config.Warnf("failed to marshal panic")
+		}
+	}
+
+	a.collector.Collect(
+		served.Context(),
+		reqCopy.Method,
+		reqCopy.URL.Path,
+		resource(req),
+		reqCopy,
+		resBytes,
+		errorValue,
+	)
+
+	if recovered != nil {
+		// Let the server handle the panic as it would have
+		panic(recovered.Value)
+	}
+}
+
+// Pause pauses auditing. Requests are still served while paused,
+// but no events are generated.
+func (a *Agent) Pause() error {
+	return a.collector.Pause()
+}
+
+// Resume resumes auditing after a pause
+func (a *Agent) Resume() error {
+	return a.collector.Resume()
+}
+
+// Flush sends anything pending in queue
+func (a *Agent) Flush() error {
+	return a.collector.Flush()
+}
+
+// Shutdown sends anything pending in queue and stops auditing.
+// Requests are still served, but no events are generated.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	return a.collector.Shutdown(ctx)
+}
+
+// Close stops fetching and watching config, sends anything pending in
+// queue and stops auditing. Returns the context's error if the pending
+// events aren't sent before the context is done.
+func (a *Agent) Close(ctx context.Context) error {
+	if a.stopFetcher != nil {
+		a.stopFetcher()
+	}
+
+	if a.fetcher != nil {
+		// Wait for the fetcher to stop
+		a.fetcher.Close()
+	}
+
+	return a.collector.Close(ctx)
+}
+
+// Fetches returns the stream of refreshed configs
+// Config may be nil if refresh failed. The stream is nil, and never
+// receives, if the agent was created with an overriden configuration.
+func (a *Agent) Fetches() <-chan []byte {
+	if a.fetcher == nil {
+		return nil
+	}
+
+	return a.fetcher.Refreshes()
+}
+
+// FetchErrors returns the stream of errors. The stream is nil, and never
+// receives, if the agent was created with an overriden configuration.
+func (a *Agent) FetchErrors() <-chan error {
+	if a.fetcher == nil {
+		return nil
+	}
+
+	return a.fetcher.Errors()
+}
+
+// ConfigStatus returns the status of the config fetcher.
+// The status is empty if the agent was created with an overriden configuration.
+func (a *Agent) ConfigStatus() config.FetcherStatus {
+	if a.fetcher == nil {
+		return config.FetcherStatus{}
+	}
+
+	return a.fetcher.Status()
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/auditr-io/auditr-agent-go/collect"
+	"github.com/auditr-io/auditr-agent-go/config"
+	"github.com/auditr-io/auditr-agent-go/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClose_ClosesFetcher(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"parent_org_id": "org_xxx",
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": []
+			}`), nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	f, err := config.NewFetcher(config.FetcherOptions{
+		ConfigURL: "https://" + t.Name() + ".auditr.io",
+		APIKey:    "api-key",
+		HTTPTransport: &test.MockTransport{
+			Fn: func(m *test.MockTransport, req *http.Request) (*http.Response, error) {
+				return nil, errors.New("error getting config")
+			},
+		},
+		InitialRetries: -1,
+	})
+	assert.NoError(t, err)
+
+	f.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		collect.AgentTypeHTTP,
+		[]collect.EventBuilder{
+			&HTTPEventBuilder{},
+		},
+	)
+	assert.NoError(t, err)
+
+	a.fetcher = f
+
+	assert.NoError(t, a.Close(context.Background()))
+
+	for range a.FetchErrors() {
+	}
+	_, ok := <-a.Fetches()
+	assert.False(t, ok)
+}
+
+func TestFetches_NilWithoutFetcher(t *testing.T) {
+	configurer, err := config.NewConfigurer(
+		config.WithConfigProvider(func() ([]byte, error) {
+			return []byte(`{
+				"parent_org_id": "org_xxx",
+				"base_url": "https://dev-api.auditr.io/v1",
+				"events_path": "/events",
+				"target": [],
+				"sample": []
+			}`), nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	configurer.Refresh(context.Background())
+
+	a, err := NewAgentWithConfiguration(
+		configurer.Configuration,
+		collect.AgentTypeHTTP,
+		[]collect.EventBuilder{
+			&HTTPEventBuilder{},
+		},
+	)
+	assert.NoError(t, err)
+	defer a.Close(context.Background())
+
+	assert.Nil(t, a.Fetches())
+	assert.Nil(t, a.FetchErrors())
+	assert.Equal(t, config.FetcherStatus{}, a.ConfigStatus())
+}
@@ -1,30 +1,56 @@
 package common
 
 import (
-	"io"
 	"net/http"
 	"net/http/httptest"
+
+	"github.com/auditr-io/auditr-agent-go/config"
 )
 
-// CopyWriter copies writes to a http.ResponseWriter
+// DefaultResponseCaptureLimit is the default max bytes of a response body
+// captured by a CopyWriter
+const DefaultResponseCaptureLimit int = 100000
+
+// CopyWriter copies writes to a http.ResponseWriter.
+// Only up to the limit is copied so streaming responses aren't buffered
+// entirely; the rest passes straight through to the original.
 type CopyWriter struct {
 	origWriter http.ResponseWriter
 
-	bodyWriter io.Writer
-	recorder   *httptest.ResponseRecorder
+	recorder    *httptest.ResponseRecorder
+	limit       int
+	copied      int
+	written     int64
+	wroteHeader bool
 }
 
 // NewCopyWriter creates a CopyWriter for given ResponseWriter
+// that copies the entire response
 func NewCopyWriter(w http.ResponseWriter) *CopyWriter {
-	recorder := httptest.NewRecorder()
+	return NewLimitedCopyWriter(w, 0)
+}
 
+// NewLimitedCopyWriter creates a CopyWriter for given ResponseWriter
+// that copies up to limit bytes of the response body.
+// A limit of 0 or less copies the entire response.
+func NewLimitedCopyWriter(w http.ResponseWriter, limit int) *CopyWriter {
 	return &CopyWriter{
-		recorder:   recorder,
-		bodyWriter: io.MultiWriter(w, recorder),
+		recorder:   httptest.NewRecorder(),
 		origWriter: w,
+		limit:      limit,
 	}
 }
 
+// ResponseCaptureLimit returns the configured max bytes of a response
+// body to capture, defaulting to DefaultResponseCaptureLimit
+func ResponseCaptureLimit(configuration *config.Configuration) int {
+	if configuration.ResponseCaptureLimit > 0 {
+		return configuration.ResponseCaptureLimit
+	}
+
+	return DefaultResponseCaptureLimit
+}
+
 // Header returns the headers
 func (c *CopyWriter) Header() http.Header {
 	return c.origWriter.Header()
@@ -35,16 +61,67 @@ func (c *CopyWriter) Response() *http.Response {
 	return c.recorder.Result()
 }
 
-// Write dual writes to the original and its copy
+// Write writes to the original and copies up to the limit
 func (c *CopyWriter) Write(p []byte) (int, error) {
-	return c.bodyWriter.Write(p)
+	if !c.wroteHeader {
+		// Writing without a status implies 200 OK
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.limit <= 0 || c.copied < c.limit {
+		copyBytes := p
+		if c.limit > 0 && len(p) > c.limit-c.copied {
+			copyBytes = p[:c.limit-c.copied]
+		}
+
+		n, _ := c.recorder.Write(copyBytes)
+		c.copied += n
+	}
+
+	n, err := c.origWriter.Write(p)
+	c.written += int64(n)
+	return n, err
 }
 
-// WriteHeader writes headers and status code to original and copy
+// Written returns the number of body bytes written to the original
+func (c *CopyWriter) Written() int64 {
+	return c.written
+}
+
+// WriteHeader writes headers and status code to original and copy.
+// Headers set before the status, such as Location on redirects, are
+// copied with all their values.
 func (c *CopyWriter) WriteHeader(statusCode int) {
-	for k, v := range c.origWriter.Header() {
-		c.recorder.Header().Add(k, v[0])
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		for k, v := range c.origWriter.Header() {
+			c.recorder.Header()[k] = append([]string(nil), v...)
+		}
+		c.recorder.WriteHeader(statusCode)
 	}
+
 	c.origWriter.WriteHeader(statusCode)
-	c.recorder.WriteHeader(statusCode)
+}
+
+// RecordPanic records a 500 status if the handler panicked before
+// writing one. Nothing is written to the original, leaving the response
+// to the server's own panic handling.
+func (c *CopyWriter) RecordPanic() {
+	if c.wroteHeader {
+		return
+	}
+
+	c.wroteHeader = true
+	for k, v := range c.origWriter.Header() {
+		c.recorder.Header()[k] = append([]string(nil), v...)
+	}
+	c.recorder.WriteHeader(http.StatusInternalServerError)
+}
+
+// Flush sends any buffered data to the client so streamed
+// responses are received promptly
+func (c *CopyWriter) Flush() {
+	if f, ok := c.origWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/auditr-io/auditr-agent-go/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,3 +49,60 @@ func TestCopyWriter(t *testing.T) {
 	body, _ := ioutil.ReadAll(res.Body)
 	assert.Equal(t, expectedBodyBytes, body)
 }
+
+func TestLimitedCopyWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	cw := NewLimitedCopyWriter(w, 5)
+
+	cw.WriteHeader(http.StatusOK)
+	cw.Write([]byte("data: 1\n"))
+	cw.Flush()
+	assert.True(t, w.Flushed)
+	cw.Write([]byte("data: 2\n"))
+
+	// The client receives the full stream
+	assert.Equal(t, "data: 1\ndata: 2\n", w.Body.String())
+
+	// Only up to the limit is copied
+	body, _ := ioutil.ReadAll(cw.Response().Body)
+	assert.Equal(t, "data:", string(body))
+	assert.Equal(t, int64(16), cw.Written())
+}
+
+func TestCopyWriter_CapturesRedirect(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/logout", nil)
+	w := httptest.NewRecorder()
+	cw := NewCopyWriter(w)
+
+	cw.Header().Add("Set-Cookie", "session=; Max-Age=0")
+	cw.Header().Add("Set-Cookie", "csrf=; Max-Age=0")
+	http.Redirect(cw, r, "/login", http.StatusFound)
+
+	res := cw.Response()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/login", res.Header.Get("Location"))
+	assert.Equal(t, []string{"session=; Max-Age=0", "csrf=; Max-Age=0"}, res.Header.Values("Set-Cookie"))
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestCopyWriter_CapturesHeadersWithoutStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	cw := NewCopyWriter(w)
+
+	cw.Header().Set("Content-Type", "text/plain")
+	cw.Write([]byte("hi"))
+
+	res := cw.Response()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Equal(t, "hi", w.Body.String())
+}
+
+func TestResponseCaptureLimit(t *testing.T) {
+	assert.Equal(t, DefaultResponseCaptureLimit, ResponseCaptureLimit(&config.Configuration{}))
+	assert.Equal(t, 10, ResponseCaptureLimit(&config.Configuration{
+		ResponseCaptureLimit: 10,
+	}))
+}
@@ -1,21 +1,166 @@
 package common
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
+// RequestIDHeader is the header carrying the ID of the request
+const RequestIDHeader = "X-Request-Id"
+
 // HTTPResponse encapsulates HTTP response
 type HTTPResponse struct {
 	StatusCode int                 `json:"status_code"`
 	Headers    map[string][]string `json:"headers"`
 	Body       string              `json:"body"`
+
+	// Bytes is the size of the body written to the client,
+	// which may exceed the captured body
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Object is the typed response object set by the handler with
+	// SetResponseObject. If set, it's recorded as the body instead of
+	// the written bytes.
+	Object interface{} `json:"-"`
+}
+
+// MarshalJSON serializes the response, with the typed response object
+// as the body if set
+func (r HTTPResponse) MarshalJSON() ([]byte, error) {
+	type httpResponseAlias HTTPResponse
+	if r.Object == nil {
+		return json.Marshal(httpResponseAlias(r))
+	}
+
+	return json.Marshal(struct {
+		httpResponseAlias
+		Body interface{} `json:"body"`
+	}{
+		httpResponseAlias: httpResponseAlias(r),
+		Body:              r.Object,
+	})
+}
+
+// responseObjectKey is the context key of the typed response object
+type responseObjectKey struct{}
+
+// responseObject holds the typed response object set by the handler
+type responseObject struct {
+	value interface{}
+}
+
+// CaptureResponseObject returns a shallow copy of the request whose
+// context holds the typed response object set by the handler
+func CaptureResponseObject(req *http.Request) *http.Request {
+	ctx := context.WithValue(req.Context(), responseObjectKey{}, &responseObject{})
+	return req.WithContext(ctx)
+}
+
+// SetResponseObject sets the typed object the handler responds with,
+// e.g. from frameworks that serialize typed responses, so events record
+// the object rather than the serialized bytes. No-op if the request isn't
+// audited by a wrapper agent.
+func SetResponseObject(ctx context.Context, value interface{}) {
+	if holder, ok := ctx.Value(responseObjectKey{}).(*responseObject); ok {
+		holder.value = value
+	}
+}
+
+// ResponseObject returns the typed response object set by the handler
+func ResponseObject(ctx context.Context) interface{} {
+	if holder, ok := ctx.Value(responseObjectKey{}).(*responseObject); ok {
+		return holder.value
+	}
+
+	return nil
 }
 
 // HTTPRequest encapsulates HTTP request
 type HTTPRequest struct {
 	Method  string      `json:"method"`
 	URL     *url.URL    `json:"url"`
+	Host    string      `json:"host,omitempty"`
 	Headers http.Header `json:"headers"`
 	Body    string      `json:"body"`
+
+	// ID correlates the events of each phase of the request
+	ID string `json:"id,omitempty"`
+
+	// TLS is the TLS connection the request was received on.
+	// Nil for plain HTTP or behind a TLS terminating proxy.
+	TLS *TLSInfo `json:"-"`
+}
+
+// TLSInfo describes the TLS connection of a request
+type TLSInfo struct {
+	Version     string
+	CipherSuite string
+
+	// ClientCertSubject is the subject of the verified client
+	// certificate of a mutual TLS connection
+	ClientCertSubject string
+}
+
+// NewTLSInfo creates TLS info from the connection state.
+// Returns nil if state is nil.
+func NewTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		info.ClientCertSubject = state.VerifiedChains[0][0].Subject.String()
+	}
+
+	return info
+}
+
+// tlsVersionName returns the name of the TLS version
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
+
+// IsPreflight determines whether the request is a CORS preflight request
+func IsPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// RequestID returns the ID of the request from the X-Request-Id header,
+// generating one if the header isn't set
+func RequestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPreflight(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		want   bool
+	}{
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			header: http.Header{
+				"Access-Control-Request-Method": {http.MethodPost},
+			},
+			want: true,
+		},
+		{
+			name:   "options without request method",
+			method: http.MethodOptions,
+			header: http.Header{},
+			want:   false,
+		},
+		{
+			name:   "not options",
+			method: http.MethodPost,
+			header: http.Header{
+				"Access-Control-Request-Method": {http.MethodPost},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "/hi", nil)
+			req.Header = tt.header
+			assert.Equal(t, tt.want, IsPreflight(req))
+		})
+	}
+}
+
+func TestNewTLSInfo(t *testing.T) {
+	assert.Nil(t, NewTLSInfo(nil))
+
+	info := NewTLSInfo(&tls.ConnectionState{
+		Version:     tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	})
+	assert.Equal(t, &TLSInfo{
+		Version:     "TLS 1.2",
+		CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	}, info)
+
+	info = NewTLSInfo(&tls.ConnectionState{
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		VerifiedChains: [][]*x509.Certificate{
+			{
+				{
+					Subject: pkix.Name{
+						CommonName: "client",
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.Equal(t, "CN=client", info.ClientCertSubject)
+}
+
+func TestRequestID(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/person/xyz", nil)
+	assert.NoError(t, err)
+
+	// Generated IDs are unique per request
+	id := RequestID(req)
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, RequestID(req))
+
+	req.Header.Set(RequestIDHeader, "request-id")
+	assert.Equal(t, "request-id", RequestID(req))
+}
+
+func TestResponseObject(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/person/xyz", nil)
+	assert.NoError(t, err)
+
+	// No-op unless captured
+	SetResponseObject(req.Context(), "ignored")
+	assert.Nil(t, ResponseObject(req.Context()))
+
+	req = CaptureResponseObject(req)
+	assert.Nil(t, ResponseObject(req.Context()))
+
+	person := struct {
+		Name string `json:"name"`
+	}{
+		Name: "homer",
+	}
+	SetResponseObject(req.Context(), person)
+	assert.Equal(t, person, ResponseObject(req.Context()))
+
+	res, err := json.Marshal(HTTPResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"name":"serialized"}`,
+		Object:     ResponseObject(req.Context()),
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"status_code": 200,
+		"headers": null,
+		"body": {"name": "homer"}
+	}`, string(res))
+
+	res, err = json.Marshal(HTTPResponse{
+		StatusCode: http.StatusOK,
+		Body:       `{"name":"serialized"}`,
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"status_code": 200,
+		"headers": null,
+		"body": "{\"name\":\"serialized\"}"
+	}`, string(res))
+}
@@ -2,8 +2,12 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/auditr-io/auditr-agent-go/collect"
@@ -13,12 +17,18 @@ import (
 
 // HTTPEventBuilder maps custom HTTP requests to events
 // todo: move to central builders package
-type HTTPEventBuilder struct{}
+type HTTPEventBuilder struct {
+	// jwks validates JWTs read from request fields.
+	// Created on first use so the signing keys are cached across events.
+	jwksLock     sync.Mutex
+	jwks         *collect.JWKS
+	issuerJWKS   *collect.IssuerJWKS
+	jwtAudiences []string
+}
 
 // Build builds an event from HTTP request and response
 func (b *HTTPEventBuilder) Build(
-	parentOrgID string,
-	orgIDField string,
+	configuration *config.Configuration,
 	routeType collect.RouteType,
 	route *config.Route,
 	request interface{},
@@ -30,7 +40,12 @@ func (b *HTTPEventBuilder) Build(
 		return nil, fmt.Errorf("request is not of type HTTPRequest")
 	}
 
-	orgID, err := b.mapOrgID(parentOrgID, orgIDField, req)
+	orgID, err := b.mapOrgID(
+		configuration.ParentOrgID,
+		configuration.OrgIDSources(),
+		req,
+		b.jwtClaims(configuration),
+	)
 	if err != nil {
 		// failed to map to an org ID
 		// safer to raise error and lose the event than to
@@ -38,11 +53,53 @@ func (b *HTTPEventBuilder) Build(
 		return nil, err
 	}
 
-	user, err := b.mapUser(req)
+	user, err := b.mapUser(configuration, req)
 	if err != nil {
 		return nil, err
 	}
 
+	if collect.IsEmptyJSON(errorValue) && route.CapturesResponse() {
+		// Locate failed response bodies on the error consistently,
+		// unless the route's responses aren't captured
+		errorBody := collect.ErrorBody(
+			response,
+			"status_code",
+			"body",
+			configuration.ErrorStatusThreshold,
+		)
+		if errorBody != nil {
+			errorValue = errorBody
+		}
+	}
+
+	// Only keep the body of responses in the configured status range
+	response = collect.StripResponseBody(
+		response,
+		"status_code",
+		"body",
+		configuration.ResponseBodyStatusMin,
+		configuration.ResponseBodyStatusMax,
+	)
+
+	// Sizes are of the original bodies, before they are altered
+	requestBytes := int64(len(req.Body))
+	responseBytes := responseBodyBytes(response)
+
+	if !route.CapturesResponse() {
+		// Audit the request without the response
+		response = nil
+	}
+
+	if len(configuration.TruncatedFields) > 0 {
+		req.Body = collect.TruncateJSON(req.Body, configuration.TruncatedFields)
+		response = collect.TruncateJSONField(response, "body", configuration.TruncatedFields)
+	}
+
+	if configuration.MinifyJSON {
+		req.Body = collect.MinifyJSON(req.Body)
+		response = collect.MinifyJSONField(response, "body")
+	}
+
 	event := &collect.EventRaw{
 		Organization: &collect.EventOrganization{
 			ID: orgID,
@@ -52,47 +109,135 @@ func (b *HTTPEventBuilder) Build(
 			Type:   routeType,
 			Method: route.HTTPMethod,
 			Path:   route.Path,
+			Name:   route.Name,
+			RawPath: collect.MaskPathParams(
+				route.Path,
+				b.mapPath(req),
+				configuration.MaskedPathParams,
+			),
 		},
 
+		Host: b.mapHost(req),
+
 		User: user,
 
 		Client: &collect.EventClient{
-			IP: req.Headers.Get("X-Forwarded-For"),
+			IP:    req.Headers.Get("X-Forwarded-For"),
+			Bytes: requestBytes,
 		},
 
 		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
 
-		Request:  req,
-		Response: response,
-		Error:    errorValue,
+		Request:     req,
+		QueryParams: b.mapQueryParams(req),
+		Response:    response,
+		Error:       errorValue,
+
+		ResponseBytes: responseBytes,
+
+		RequestID: req.ID,
+	}
+
+	if configuration.CaptureTLS && req.TLS != nil {
+		event.Client.TLSVersion = req.TLS.Version
+		event.Client.TLSCipher = req.TLS.CipherSuite
+		event.Client.ClientCertSubject = req.TLS.ClientCertSubject
 	}
 
 	return event, nil
 }
 
-// mapOrgID maps the configured orgIDField to org ID
+// responseBodyBytes returns the size of the response body written to the
+// client. Falls back to the captured body when the size isn't recorded.
+func responseBodyBytes(response json.RawMessage) int64 {
+	if size := gjson.GetBytes(response, "bytes"); size.Exists() {
+		return size.Int()
+	}
+
+	return int64(len(gjson.GetBytes(response, "body").String()))
+}
+
+// ClientIP resolves the client IP of the request from the first
+// X-Forwarded-For address, falling back to the remote address
+func (b *HTTPEventBuilder) ClientIP(request interface{}) (net.IP, bool) {
+	req, ok := request.(HTTPRequest)
+	if !ok {
+		return nil, false
+	}
+
+	addr := strings.TrimSpace(strings.Split(req.Headers.Get("X-Forwarded-For"), ",")[0])
+	if addr == "" {
+		addr = req.Headers.Get("Remote-Address-Ip")
+	}
+
+	ip := net.ParseIP(addr)
+	return ip, ip != nil
+}
+
+// mapHost maps the host the request targeted.
+// Falls back to the host of an absolute request URL.
+func (b *HTTPEventBuilder) mapHost(req HTTPRequest) string {
+	if req.Host != "" {
+		return req.Host
+	}
+
+	if req.URL != nil {
+		return req.URL.Host
+	}
+
+	return ""
+}
+
+// mapQueryParams maps the query string parameters to a normalized map.
+// Only the first value of a repeated parameter is kept.
+func (b *HTTPEventBuilder) mapQueryParams(req HTTPRequest) map[string]string {
+	if req.URL == nil {
+		return nil
+	}
+
+	query := req.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string, len(query))
+	for k := range query {
+		params[k] = query.Get(k)
+	}
+
+	return params
+}
+
+// mapOrgID maps the first resolving org ID field to org ID
 // todo: extract this to a mapper.OrgID?
 func (b *HTTPEventBuilder) mapOrgID(
 	parentOrgID string,
-	orgIDField string,
+	orgIDFields []string,
 	req HTTPRequest,
+	decode claimsDecoder,
 ) (string, error) {
-	if orgIDField == "" {
+	if len(orgIDFields) == 0 {
 		// orgIDField not configured, default org ID to root org ID
 		return parentOrgID, nil
 	}
 
-	return getMappedValue(req, orgIDField)
+	var err error
+	for _, orgIDField := range orgIDFields {
+		var orgID string
+		orgID, err = getMappedValue(req, orgIDField, decode)
+		if err == nil {
+			return orgID, nil
+		}
+	}
+
+	return "", err
 }
 
 // mapUser maps user related fields to user
 func (b *HTTPEventBuilder) mapUser(
+	configuration *config.Configuration,
 	req HTTPRequest,
 ) (*collect.EventUser, error) {
-	// todo: config user fields
-	// gradual increase in value w more config
-	// min map user id, email, or username
-
 	type EventUserMapping struct {
 		ID       string `json:"id,omitempty"`
 		Email    string `json:"email,omitempty"`
@@ -102,40 +247,135 @@ func (b *HTTPEventBuilder) mapUser(
 	}
 
 	mapping := EventUserMapping{
-		ID:    "request.header.x-user-id",
-		Email: "request.body.email",
-		Name:  "request.querystring.username",
+		ID:       userField(configuration, "id", "request.header.x-user-id"),
+		Email:    userField(configuration, "email", "request.body.email"),
+		Name:     userField(configuration, "name", "request.querystring.username"),
+		FullName: userField(configuration, "full_name", ""),
+		Domain:   userField(configuration, "domain", ""),
+	}
+
+	user := &collect.EventUser{
+		AuthType: collect.AuthTypeNone,
+	}
+	decode := b.jwtClaims(configuration)
+
+	fields := []struct {
+		field string
+		value *string
+	}{
+		{mapping.ID, &user.ID},
+		{mapping.Email, &user.Email},
+		{mapping.Name, &user.Name},
+		{mapping.FullName, &user.FullName},
+		{mapping.Domain, &user.Domain},
 	}
 
-	user := &collect.EventUser{}
+	for _, f := range fields {
+		value, err := getMappedValue(req, f.field, decode)
+		if err == nil {
+			*f.value = value
+			if isJWTField(f.field) {
+				user.AuthType = collect.AuthTypeJWT
+			}
+			continue
+		}
 
-	if userID, err := getMappedValue(req, mapping.ID); err == nil {
-		user.ID = userID
+		if errors.Is(err, collect.ErrInvalidJWT) && configuration.DropInvalidJWT {
+			return nil, err
+		}
 	}
 
-	if email, err := getMappedValue(req, mapping.Email); err == nil {
-		user.Email = email
+	if authorization := req.Headers.Get("Authorization"); authorization != "" {
+		// Roles and scopes are only available from a bearer token,
+		// validated like JWTs of request fields
+		claims, err := decode(authorization)
+		if err == nil {
+			user.AuthType = collect.AuthTypeJWT
+			user.Roles = collect.ClaimValues(claims, configuration.RoleClaims)
+			user.Scopes = collect.ClaimValues(claims, configuration.ScopeClaims)
+		} else if errors.Is(err, collect.ErrInvalidJWT) && configuration.DropInvalidJWT {
+			return nil, err
+		}
 	}
 
-	if username, err := getMappedValue(req, mapping.Name); err == nil {
-		user.Name = username
+	return user, nil
+}
+
+// isJWTField returns true if the request field is a claim of a JWT,
+// e.g. request.cookie.<name>.jwt.<claim>
+func isJWTField(field string) bool {
+	return strings.Contains(field, ".jwt.")
+}
+
+// userField returns the configured request field of the user field,
+// falling back to the default
+func userField(configuration *config.Configuration, name string, defaultField string) string {
+	if field, ok := configuration.UserFields[name]; ok {
+		return field
 	}
 
-	if fullName, err := getMappedValue(req, mapping.FullName); err == nil {
-		user.FullName = fullName
+	return defaultField
+}
+
+// claimsDecoder decodes the claims of a JWT
+type claimsDecoder func(token string) (map[string]interface{}, error)
+
+// jwtClaims returns the decoder of JWTs read from request fields.
+// JWTs are validated against the signing keys at the JWKS URL if set,
+// or else the keys of their issuer if trusted issuers are set.
+func (b *HTTPEventBuilder) jwtClaims(configuration *config.Configuration) claimsDecoder {
+	if configuration.JWKSURL == "" && len(configuration.JWTIssuers) == 0 {
+		return collect.DecodeJWTClaims
 	}
 
-	if domain, err := getMappedValue(req, mapping.Domain); err == nil {
-		user.Domain = domain
+	b.jwksLock.Lock()
+	defer b.jwksLock.Unlock()
+
+	if !equalStrings(b.jwtAudiences, configuration.JWTAudiences) {
+		// Validators expect the audiences they were created with
+		b.jwks = nil
+		b.issuerJWKS = nil
+		b.jwtAudiences = configuration.JWTAudiences
 	}
 
-	return user, nil
+	if configuration.JWKSURL == "" {
+		if b.issuerJWKS == nil || !b.issuerJWKS.Trusts(configuration.JWTIssuers) {
+			b.issuerJWKS = collect.NewIssuerJWKS(
+				configuration.JWTIssuers,
+				configuration.JWTAudiences,
+			)
+		}
+
+		return b.issuerJWKS.Claims
+	}
+
+	if b.jwks == nil || b.jwks.URL() != configuration.JWKSURL {
+		b.jwks = collect.NewJWKS(configuration.JWKSURL, configuration.JWTAudiences)
+	}
+
+	return b.jwks.Claims
+}
+
+// equalStrings returns true if the slices hold the same strings in order
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
 }
 
 // getMappedValue extracts the field value from a HTTPRequest
 func getMappedValue(
 	req HTTPRequest,
 	fieldName string,
+	decode claimsDecoder,
 ) (string, error) {
 	if fieldName == "" {
 		return "", fmt.Errorf("invalid field %s", fieldName)
@@ -149,27 +389,24 @@ func getMappedValue(
 	// the first field part is always "request"
 	switch fieldParts[1] {
 	case "header":
-		val := req.Headers.Get(fieldParts[2])
+		// request.header.<name> or request.header.<name>.jwt.<claim>
+		headerParts := strings.SplitN(fieldParts[2], ".", 3)
+		val := req.Headers.Get(headerParts[0])
 		if val == "" {
 			return "", fmt.Errorf("field %s not found", fieldName)
 		}
 
-		if len(val) == 0 {
-			// fieldName is present but empty
-			return "", fmt.Errorf("field %s is empty", fieldName)
-		}
-
-		lastParts := strings.Split(fieldParts[2], ".")
-		if len(lastParts) == 1 {
+		if len(headerParts) == 1 {
 			return val, nil
 		}
 
-		if len(lastParts) == 3 && lastParts[2] == "jwt" {
-			// todo: decode jwt and set org id
+		if len(headerParts) == 3 && headerParts[1] == "jwt" {
+			return jwtClaim(val, headerParts[2], decode)
 		}
 	case "querystring":
-		q := req.URL.Query()
-		val, ok := q[fieldParts[2]]
+		// request.querystring.<name> or request.querystring.<name>.jwt.<claim>
+		queryParts := strings.SplitN(fieldParts[2], ".", 3)
+		val, ok := req.URL.Query()[queryParts[0]]
 		if !ok {
 			return "", fmt.Errorf("field %s not found", fieldName)
 		}
@@ -179,13 +416,31 @@ func getMappedValue(
 			return "", fmt.Errorf("field %s is empty", fieldName)
 		}
 
-		lastParts := strings.Split(fieldParts[2], ".")
-		if len(lastParts) == 1 {
+		if len(queryParts) == 1 {
 			return val[0], nil
 		}
 
-		if len(lastParts) == 3 && lastParts[2] == "jwt" {
-			// todo: decode jwt and set org id
+		if len(queryParts) == 3 && queryParts[1] == "jwt" {
+			return jwtClaim(val[0], queryParts[2], decode)
+		}
+	case "cookie":
+		// request.cookie.<name> or request.cookie.<name>.jwt.<claim>
+		cookieParts := strings.SplitN(fieldParts[2], ".", 3)
+		cookie, err := (&http.Request{Header: req.Headers}).Cookie(cookieParts[0])
+		if err != nil {
+			return "", fmt.Errorf("field %s not found", fieldName)
+		}
+
+		if cookie.Value == "" {
+			return "", fmt.Errorf("field %s is empty", fieldName)
+		}
+
+		if len(cookieParts) == 1 {
+			return cookie.Value, nil
+		}
+
+		if len(cookieParts) == 3 && cookieParts[1] == "jwt" {
+			return jwtClaim(cookie.Value, cookieParts[2], decode)
 		}
 	case "body":
 		result := gjson.Get(req.Body, fieldParts[2])
@@ -198,3 +453,33 @@ func getMappedValue(
 
 	return "", fmt.Errorf("invalid field %s", fieldName)
 }
+
+// jwtClaim returns the value of the claim of the JWT
+func jwtClaim(token string, name string, decode claimsDecoder) (string, error) {
+	claims, err := decode(token)
+	if err != nil {
+		return "", err
+	}
+
+	switch claim := claims[name].(type) {
+	case nil:
+		return "", fmt.Errorf("claim %s not found", name)
+	case string:
+		if claim == "" {
+			return "", fmt.Errorf("claim %s is empty", name)
+		}
+
+		return claim, nil
+	default:
+		return fmt.Sprint(claim), nil
+	}
+}
+
+// mapPath returns the path of the request
+func (b *HTTPEventBuilder) mapPath(req HTTPRequest) string {
+	if req.URL == nil {
+		return ""
+	}
+
+	return req.URL.Path
+}
@@ -1,8 +1,10 @@
 package common
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -56,17 +58,20 @@ func TestBuild(t *testing.T) {
 		},
 
 		Route: &collect.EventRoute{
-			Type:   collect.RouteTypeSample,
-			Method: http.MethodPost,
-			Path:   "/person/:id",
+			Type:    collect.RouteTypeSample,
+			Method:  http.MethodPost,
+			Path:    "/person/:id",
+			RawPath: "/person/123",
 		},
 
 		User: &collect.EventUser{
-			ID: "user-id",
+			ID:       "user-id",
+			AuthType: collect.AuthTypeNone,
 		},
 
 		Client: &collect.EventClient{
-			IP: "1.2.3.4",
+			IP:    "1.2.3.4",
+			Bytes: int64(len(reqBodyBytes)),
 		},
 
 		RequestedAt: time.Now().UnixNano() / int64(time.Millisecond),
@@ -83,8 +88,10 @@ func TestBuild(t *testing.T) {
 	h := &HTTPEventBuilder{}
 
 	evt, err := h.Build(
-		parentOrgID,
-		orgIDField,
+		&config.Configuration{
+			ParentOrgID: parentOrgID,
+			OrgIDField:  orgIDField,
+		},
 		collect.RouteTypeSample,
 		route,
 		req,
@@ -101,3 +108,790 @@ func TestBuild(t *testing.T) {
 	assert.Equal(t, wantEvt.Response, evt.Response)
 	assert.Equal(t, wantEvt.Error, evt.Error)
 }
+
+func TestBuild_MinifiesJSON(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method:  http.MethodPost,
+		URL:     reqURL,
+		Headers: http.Header{},
+		Body: `{
+			"name": "homer"
+		}`,
+	}
+
+	res, _ := json.Marshal(HTTPResponse{
+		StatusCode: 200,
+		Body: `{
+			"id": 123
+		}`,
+	})
+
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			MinifyJSON:  true,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"homer"}`, evt.Request.(HTTPRequest).Body)
+
+	var evtRes HTTPResponse
+	err = json.Unmarshal(evt.Response.(json.RawMessage), &evtRes)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":123}`, evtRes.Body)
+	assert.Equal(t, 200, evtRes.StatusCode)
+}
+
+func TestBuild_MapsRolesAndScopesFromBearerToken(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{
+		"sub": "user-id",
+		"roles": ["admin", "auditor"],
+		"scope": "read:person"
+	}`))
+
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method: http.MethodGet,
+		URL:    reqURL,
+		Headers: http.Header{
+			"Authorization": []string{"Bearer " + header + "." + payload + ".sig"},
+		},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			RoleClaims:  config.DefaultRoleClaims,
+			ScopeClaims: config.DefaultScopeClaims,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "auditor"}, evt.User.Roles)
+	assert.Equal(t, []string{"read:person"}, evt.User.Scopes)
+	assert.Equal(t, collect.AuthTypeJWT, evt.User.AuthType)
+
+	// Roles and scopes of unsigned tokens are skipped once a JWKS URL is set
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer server.Close()
+
+	configuration := &config.Configuration{
+		ParentOrgID: "parent-org-id",
+		RoleClaims:  config.DefaultRoleClaims,
+		ScopeClaims: config.DefaultScopeClaims,
+		JWKSURL:     server.URL,
+	}
+	evt, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, evt.User.Roles)
+	assert.Empty(t, evt.User.Scopes)
+	assert.Equal(t, collect.AuthTypeNone, evt.User.AuthType)
+
+	// Unless configured to drop the event
+	configuration.DropInvalidJWT = true
+	_, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.ErrorIs(t, err, collect.ErrInvalidJWT)
+}
+
+func TestBuild_CapturesTLS(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+		TLS: &TLSInfo{
+			Version:           "TLS 1.3",
+			CipherSuite:       "TLS_AES_128_GCM_SHA256",
+			ClientCertSubject: "CN=client",
+		},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, evt.Client.TLSVersion)
+
+	evt, err = h.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+			CaptureTLS:  true,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "TLS 1.3", evt.Client.TLSVersion)
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", evt.Client.TLSCipher)
+	assert.Equal(t, "CN=client", evt.Client.ClientCertSubject)
+}
+
+func TestBuild_MapsQueryParams(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person?name=homer&tag=a&tag=b")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID: "parent-org-id",
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name": "homer",
+		"tag":  "a",
+	}, evt.QueryParams)
+}
+
+func TestBuild_MapsFirstResolvingOrgIDField(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person?org_id=query-org-id")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person",
+	}
+
+	configuration := &config.Configuration{
+		ParentOrgID: "parent-org-id",
+		OrgIDFields: []string{
+			"request.header.x-org-id",
+			"request.querystring.org_id",
+		},
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "query-org-id", evt.Organization.ID)
+
+	req.Headers.Set("X-Org-Id", "header-org-id")
+	evt, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "header-org-id", evt.Organization.ID)
+}
+
+func TestBuild_CapturesErrorBody(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+	}
+
+	res, _ := json.Marshal(HTTPResponse{
+		StatusCode: 404,
+		Body:       "not found",
+	})
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID:          "parent-org-id",
+			ErrorStatusThreshold: 400,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`"not found"`), evt.Error)
+}
+
+func TestBuild_OmitsResponseOfRoute(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/export/123")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+	}
+
+	res, _ := json.Marshal(HTTPResponse{
+		StatusCode: 500,
+		Body:       "ssn 123-45-6789 is invalid",
+	})
+
+	captureResponse := false
+	route := &config.Route{
+		HTTPMethod:      http.MethodGet,
+		Path:            "/export/:id",
+		CaptureResponse: &captureResponse,
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			ParentOrgID:          "parent-org-id",
+			ErrorStatusThreshold: 400,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Nil(t, evt.Response)
+	assert.Nil(t, evt.Error)
+	assert.Equal(t, req, evt.Request)
+
+	// Sizes are of the original bodies
+	assert.Equal(t, int64(len("ssn 123-45-6789 is invalid")), evt.ResponseBytes)
+}
+
+func TestBuild_CapturesResponseBodyInStatusRange(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Headers: http.Header{},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	configuration := &config.Configuration{
+		ParentOrgID:           "parent-org-id",
+		ResponseBodyStatusMin: 400,
+		ResponseBodyStatusMax: 599,
+	}
+
+	h := &HTTPEventBuilder{}
+	for _, tt := range []struct {
+		statusCode int
+		wantBody   bool
+	}{
+		{statusCode: 200, wantBody: false},
+		{statusCode: 404, wantBody: true},
+		{statusCode: 500, wantBody: true},
+	} {
+		res, _ := json.Marshal(HTTPResponse{
+			StatusCode: tt.statusCode,
+			Headers: map[string][]string{
+				"Content-Type": {"text/plain"},
+			},
+			Body: "bla",
+		})
+
+		evt, err := h.Build(
+			configuration,
+			collect.RouteTypeTarget,
+			route,
+			req,
+			res,
+			nil,
+		)
+		assert.NoError(t, err)
+
+		var got HTTPResponse
+		err = json.Unmarshal(evt.Response.(json.RawMessage), &got)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.statusCode, got.StatusCode)
+		assert.Equal(t, "text/plain", got.Headers["Content-Type"][0])
+		if tt.wantBody {
+			assert.Equal(t, "bla", got.Body)
+		} else {
+			assert.Empty(t, got.Body)
+		}
+	}
+}
+
+func TestBuild_MapsHost(t *testing.T) {
+	reqURL, _ := url.Parse("https://api.example.com/person/123")
+	req := HTTPRequest{
+		Method:  http.MethodGet,
+		URL:     reqURL,
+		Host:    "tenant.example.com",
+		Headers: http.Header{},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant.example.com", evt.Host)
+
+	// Falls back to the URL host
+	req.Host = ""
+	evt, err = h.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		json.RawMessage(`{}`),
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "api.example.com", evt.Host)
+}
+
+func TestBuild_MapsBodySizes(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person")
+	req := HTTPRequest{
+		Method:  http.MethodPost,
+		URL:     reqURL,
+		Headers: http.Header{},
+		Body:    `{ "name": "jdoe" }`,
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person",
+	}
+
+	h := &HTTPEventBuilder{}
+
+	// Captured body was truncated
+	res, _ := json.Marshal(HTTPResponse{
+		StatusCode: 200,
+		Body:       "bla",
+		Bytes:      1000,
+	})
+	evt, err := h.Build(
+		&config.Configuration{
+			MinifyJSON: true,
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(req.Body)), evt.Client.Bytes)
+	assert.Equal(t, int64(1000), evt.ResponseBytes)
+
+	res, _ = json.Marshal(HTTPResponse{
+		StatusCode: 200,
+		Body:       "bla",
+	})
+	evt, err = h.Build(
+		&config.Configuration{},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		res,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), evt.ResponseBytes)
+}
+
+func TestBuild_MapsFromJWTCookie(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{
+		"sub": "user-id",
+		"org_id": "org-id"
+	}`))
+
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method: http.MethodGet,
+		URL:    reqURL,
+		Headers: http.Header{
+			"Cookie": []string{
+				"session=" + header + "." + payload + ".sig; email=homer@auditr.io",
+			},
+		},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	configuration := &config.Configuration{
+		ParentOrgID: "parent-org-id",
+		OrgIDFields: []string{"request.cookie.session.jwt.org_id"},
+		UserFields: map[string]string{
+			"id":    "request.cookie.session.jwt.sub",
+			"email": "request.cookie.email",
+		},
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "org-id", evt.Organization.ID)
+	assert.Equal(t, "user-id", evt.User.ID)
+	assert.Equal(t, "homer@auditr.io", evt.User.Email)
+	assert.Equal(t, collect.AuthTypeJWT, evt.User.AuthType)
+
+	// Unsigned tokens fail validation once a JWKS URL is set
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer server.Close()
+
+	configuration.JWKSURL = server.URL
+	_, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.ErrorIs(t, err, collect.ErrInvalidJWT)
+
+	// Invalid user claims are skipped
+	configuration.OrgIDFields = nil
+	evt, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, evt.User.ID)
+	assert.Equal(t, "homer@auditr.io", evt.User.Email)
+	assert.Equal(t, collect.AuthTypeNone, evt.User.AuthType)
+
+	// Unless configured to drop the event
+	configuration.DropInvalidJWT = true
+	_, err = h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.ErrorIs(t, err, collect.ErrInvalidJWT)
+}
+
+func TestBuild_MapsFromJWTHeaderAndQueryString(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{
+		"sub": "user-id",
+		"org_id": "org-id"
+	}`))
+	token := header + "." + payload + ".sig"
+
+	reqURL, _ := url.Parse("https://localhost/person/123?token=" + token)
+	req := HTTPRequest{
+		Method: http.MethodGet,
+		URL:    reqURL,
+		Headers: http.Header{
+			"Authorization": []string{"Bearer " + token},
+			"X-Token":       []string{"not-a-jwt"},
+		},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	tests := []struct {
+		orgIDField string
+		userID     string
+		expected   string
+		err        string
+	}{
+		{
+			orgIDField: "request.header.authorization.jwt.org_id",
+			userID:     "request.header.authorization.jwt.sub",
+			expected:   "org-id",
+		},
+		{
+			orgIDField: "request.querystring.token.jwt.org_id",
+			userID:     "request.querystring.token.jwt.sub",
+			expected:   "org-id",
+		},
+		{
+			orgIDField: "request.header.authorization.jwt.tenant_id",
+			err:        "claim tenant_id not found",
+		},
+		{
+			orgIDField: "request.header.x-token.jwt.org_id",
+			err:        "malformed jwt",
+		},
+		{
+			orgIDField: "request.querystring.missing.jwt.org_id",
+			err:        "field request.querystring.missing.jwt.org_id not found",
+		},
+		{
+			orgIDField: "request.header.authorization.token.org_id",
+			err:        "invalid field request.header.authorization.token.org_id",
+		},
+	}
+
+	h := &HTTPEventBuilder{}
+	for _, tt := range tests {
+		configuration := &config.Configuration{
+			ParentOrgID: "parent-org-id",
+			OrgIDFields: []string{tt.orgIDField},
+			UserFields: map[string]string{
+				"id": tt.userID,
+			},
+		}
+
+		evt, err := h.Build(
+			configuration,
+			collect.RouteTypeTarget,
+			route,
+			req,
+			nil,
+			nil,
+		)
+
+		if tt.err != "" {
+			assert.Error(t, err, tt.orgIDField)
+			assert.Contains(t, err.Error(), tt.err, tt.orgIDField)
+			continue
+		}
+
+		assert.NoError(t, err, tt.orgIDField)
+		assert.Equal(t, tt.expected, evt.Organization.ID, tt.orgIDField)
+		assert.Equal(t, "user-id", evt.User.ID, tt.orgIDField)
+		assert.Equal(t, collect.AuthTypeJWT, evt.User.AuthType, tt.orgIDField)
+	}
+}
+
+func TestBuild_ValidatesJWTOfTrustedIssuers(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{
+		"sub": "user-id",
+		"iss": "https://auth.example.com"
+	}`))
+
+	reqURL, _ := url.Parse("https://localhost/person/123")
+	req := HTTPRequest{
+		Method: http.MethodGet,
+		URL:    reqURL,
+		Headers: http.Header{
+			"Cookie": []string{"session=" + header + "." + payload + ".sig"},
+		},
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodGet,
+		Path:       "/person/:id",
+	}
+
+	configuration := &config.Configuration{
+		UserFields: map[string]string{
+			"id": "request.cookie.session.jwt.sub",
+		},
+		JWTIssuers:     []string{"https://cognito-idp.us-west-2.amazonaws.com/us-west-2_abc"},
+		DropInvalidJWT: true,
+	}
+
+	// Tokens of untrusted issuers are invalid without fetching their keys
+	h := &HTTPEventBuilder{}
+	_, err := h.Build(
+		configuration,
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.ErrorIs(t, err, collect.ErrInvalidJWT)
+	assert.Contains(t, err.Error(), "untrusted issuer https://auth.example.com")
+}
+
+func TestBuild_MapsConfiguredUserFields(t *testing.T) {
+	reqURL, _ := url.Parse("https://localhost/person/123?username=homer")
+	req := HTTPRequest{
+		Method: http.MethodPost,
+		URL:    reqURL,
+		Headers: http.Header{
+			"X-User-Id":    []string{"default-user-id"},
+			"X-Account-Id": []string{"account-id"},
+		},
+		Body: `{"email":"homer@auditr.io","profile":{"name":"Homer Simpson"}}`,
+	}
+
+	route := &config.Route{
+		HTTPMethod: http.MethodPost,
+		Path:       "/person/:id",
+	}
+
+	h := &HTTPEventBuilder{}
+	evt, err := h.Build(
+		&config.Configuration{
+			UserFields: map[string]string{
+				"id":        "request.header.x-account-id",
+				"full_name": "request.body.profile.name",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+
+	// Fields that aren't configured fall back to the defaults
+	assert.Equal(t, &collect.EventUser{
+		ID:       "account-id",
+		Email:    "homer@auditr.io",
+		Name:     "homer",
+		FullName: "Homer Simpson",
+		AuthType: collect.AuthTypeNone,
+	}, evt.User)
+
+	// An empty field disables the default
+	evt, err = h.Build(
+		&config.Configuration{
+			UserFields: map[string]string{
+				"email": "",
+			},
+		},
+		collect.RouteTypeTarget,
+		route,
+		req,
+		nil,
+		nil,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "default-user-id", evt.User.ID)
+	assert.Empty(t, evt.User.Email)
+}
+
+func TestClientIP(t *testing.T) {
+	h := &HTTPEventBuilder{}
+
+	ip, ok := h.ClientIP(HTTPRequest{
+		Headers: http.Header{
+			"X-Forwarded-For": []string{"203.0.113.7, 10.0.0.1"},
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.7", ip.String())
+
+	ip, ok = h.ClientIP(HTTPRequest{
+		Headers: http.Header{
+			"Remote-Address-Ip": []string{"10.0.0.1"},
+		},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", ip.String())
+
+	_, ok = h.ClientIP(HTTPRequest{
+		Headers: http.Header{},
+	})
+	assert.False(t, ok)
+
+	_, ok = h.ClientIP("not a request")
+	assert.False(t, ok)
+}
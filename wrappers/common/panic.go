@@ -0,0 +1,58 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from a wrapped handler
+type PanicError struct {
+	// Value is the value the handler panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the panic
+	Stack string
+}
+
+// Error returns the panic message
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// MarshalJSON serializes the panic as the event error
+func (e *PanicError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Message string `json:"message"`
+		Panic   string `json:"panic"`
+		Stack   string `json:"stack"`
+	}{
+		Message: e.Error(),
+		Panic:   fmt.Sprint(e.Value),
+		Stack:   e.Stack,
+	})
+}
+
+// ServeRecovering serves the request with the handler, recovering a
+// panic so it can be audited. If the handler panics before writing a
+// status, the copy writer records a 500. The caller should panic again
+// with the recovered value so the server's own recovery still runs.
+func ServeRecovering(
+	handler http.Handler,
+	w *CopyWriter,
+	req *http.Request,
+) (recovered *PanicError) {
+	defer func() {
+		if v := recover(); v != nil {
+			w.RecordPanic()
+			recovered = &PanicError{
+				Value: v,
+				Stack: string(debug.Stack()),
+			}
+		}
+	}()
+
+	handler.ServeHTTP(w, req)
+	return nil
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeRecovering_RecordsPanic(t *testing.T) {
+	w := httptest.NewRecorder()
+	cw := NewCopyWriter(w)
+	r, _ := http.NewRequest(http.MethodGet, "/hi", nil)
+
+	recovered := ServeRecovering(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Trace", "abc")
+		panic("oh no")
+	}), cw, r)
+
+	assert.NotNil(t, recovered)
+	assert.Equal(t, "oh no", recovered.Value)
+	assert.Contains(t, recovered.Stack, "TestServeRecovering_RecordsPanic")
+
+	// A 500 is recorded but not written to the client
+	res := cw.Response()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Equal(t, "abc", res.Header.Get("X-Trace"))
+	assert.False(t, w.Flushed)
+	assert.Equal(t, 0, w.Body.Len())
+
+	var errorValue map[string]string
+	b, err := json.Marshal(recovered)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(b, &errorValue))
+	assert.Equal(t, "panic: oh no", errorValue["message"])
+	assert.Equal(t, "oh no", errorValue["panic"])
+	assert.Equal(t, recovered.Stack, errorValue["stack"])
+}
+
+func TestServeRecovering_KeepsWrittenStatus(t *testing.T) {
+	cw := NewCopyWriter(httptest.NewRecorder())
+	r, _ := http.NewRequest(http.MethodGet, "/hi", nil)
+
+	recovered := ServeRecovering(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("oh no")
+	}), cw, r)
+
+	assert.NotNil(t, recovered)
+
+	res := cw.Response()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "partial", string(body))
+}
+
+func TestServeRecovering_ReturnsNilWithoutPanic(t *testing.T) {
+	cw := NewCopyWriter(httptest.NewRecorder())
+	r, _ := http.NewRequest(http.MethodGet, "/hi", nil)
+
+	recovered := ServeRecovering(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("hi"))
+	}), cw, r)
+
+	assert.Nil(t, recovered)
+	assert.Equal(t, http.StatusOK, cw.Response().StatusCode)
+}
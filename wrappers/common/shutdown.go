@@ -0,0 +1,69 @@
+package common
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/auditr-io/auditr-agent-go/config"
+)
+
+// lambdaFunctionNameEnv is set by the Lambda runtime
+const lambdaFunctionNameEnv = "AWS_LAMBDA_FUNCTION_NAME"
+
+// raiseSignal sends the signal to the process
+var raiseSignal = func(sig os.Signal) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+
+	return p.Signal(sig)
+}
+
+// HandleShutdown installs a SIGTERM and SIGINT handler that calls shutdown
+// with a context bounded by the timeout, then raises the signal again so
+// the process exits as it would have. Returns a function that removes
+// the handler. Apps handling these signals themselves should shut down
+// the agent in their own handler instead. A no-op in Lambda, where the
+// runtime controls the process.
+func HandleShutdown(
+	shutdown func(ctx context.Context) error,
+	timeout time.Duration,
+) (stop func()) {
+	if os.Getenv(lambdaFunctionNameEnv) != "" {
+		return func() {}
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			if err := shutdown(ctx); err != nil {
+				config.Warnf("error shutting down on %s: %v", sig, err)
+			}
+			cancel()
+
+			signal.Stop(signals)
+			if err := raiseSignal(sig); err != nil {
+				os.Exit(1)
+			}
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleShutdown_ShutsDownOnSignal(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer func(raise func(sig os.Signal) error) {
+		raiseSignal = raise
+	}(raiseSignal)
+	raiseSignal = func(sig os.Signal) error {
+		raised <- sig
+		return nil
+	}
+
+	shutdown := make(chan time.Time, 1)
+	stop := HandleShutdown(func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		shutdown <- deadline
+		return nil
+	}, time.Second)
+	defer stop()
+
+	p, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+	assert.NoError(t, p.Signal(syscall.SIGTERM))
+
+	select {
+	case deadline := <-shutdown:
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "agent wasn't shut down")
+	}
+
+	// The signal is raised again so the process exits as it would have
+	select {
+	case sig := <-raised:
+		assert.Equal(t, syscall.SIGTERM, sig)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "signal wasn't raised again")
+	}
+}
+
+func TestHandleShutdown_NoopInLambda(t *testing.T) {
+	t.Setenv(lambdaFunctionNameEnv, "fn")
+
+	stop := HandleShutdown(func(ctx context.Context) error {
+		assert.Fail(t, "shutdown shouldn't be called")
+		return nil
+	}, time.Second)
+	assert.NotNil(t, stop)
+	stop()
+}
+
+func TestHandleShutdown_StopRemovesHandler(t *testing.T) {
+	stop := HandleShutdown(func(ctx context.Context) error {
+		assert.Fail(t, "shutdown shouldn't be called")
+		return nil
+	}, time.Second)
+
+	// Safe to call more than once
+	stop()
+	stop()
+}